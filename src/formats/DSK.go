@@ -0,0 +1,497 @@
+package formats
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Floppy disk images (DSK), in the standard ("MV - CPCEMU") and the extended
+// ("EXTENDED CPC DSK File") format.
+//
+// The file starts with a 256-byte disk information block, followed by the tracks.
+// Each track starts with a 256-byte track information block holding the ID fields
+// of the sectors (C, H, R, N) and the FDC status registers ST1 and ST2 recorded
+// when the sector was read. In the standard format all the tracks have the same size,
+// in the extended format the size of each track is stored in the disk information block
+// and each sector records the actual length of its data.
+const (
+	DSK_INFO_LEN       = 256
+	DSK_TRACK_INFO_LEN = 256
+	DSK_MAX_SECTORS    = (DSK_TRACK_INFO_LEN - 0x18) / 8
+	DSK_MAX_TRACKS     = 204 // Tracks on both sides
+	DSK_FILLER         = 0xe5
+
+	dsk_standardSignature = "MV - CPC"
+	dsk_extendedSignature = "EXTENDED CPC DSK File\r\nDisk-Info\r\n"
+	dsk_trackSignature    = "Track-Info\r\n"
+)
+
+type DSKSector struct {
+	// The ID field
+	C, H, R, N byte
+
+	ST1, ST2 byte
+	Data     []byte
+}
+
+type DSKTrack struct {
+	Track, Side    byte
+	SectorSizeCode byte
+	Gap3, Filler   byte
+	Sectors        []*DSKSector
+}
+
+type DSK struct {
+	Extended bool
+	Creator  string
+	Sides    int
+
+	// The tracks in the order they appear in the file (track 0 side 0, track 0 side 1, ...).
+	// An unformatted track is nil.
+	Tracks []*DSKTrack
+}
+
+// A file listed in the disk directory
+type DSKFile struct {
+	User     byte
+	Name     string
+	Size     int
+	ReadOnly bool
+	System   bool
+}
+
+// Decodes a disk image
+func NewDSK(data []byte) (*DSK, error) {
+	if len(data) < DSK_INFO_LEN {
+		return nil, errors.New("invalid DSK file length")
+	}
+
+	dsk := &DSK{}
+	switch {
+	case bytes.HasPrefix(data, []byte(dsk_extendedSignature)):
+		dsk.Extended = true
+	case bytes.HasPrefix(data, []byte(dsk_standardSignature)):
+		dsk.Extended = false
+	default:
+		return nil, errors.New("invalid DSK signature")
+	}
+
+	dsk.Creator = strings.TrimRight(string(data[0x22:0x30]), "\x00 ")
+
+	numTracks := int(data[0x30])
+	dsk.Sides = int(data[0x31])
+	if (dsk.Sides < 1) || (dsk.Sides > 2) || (numTracks*dsk.Sides > DSK_MAX_TRACKS) {
+		return nil, errors.New("invalid DSK geometry")
+	}
+	standardTrackSize := int(data[0x32]) | int(data[0x33])<<8
+
+	dsk.Tracks = make([]*DSKTrack, numTracks*dsk.Sides)
+
+	offset := DSK_INFO_LEN
+	for i := range dsk.Tracks {
+		var trackSize int
+		if dsk.Extended {
+			trackSize = int(data[0x34+i]) << 8
+		} else {
+			trackSize = standardTrackSize
+		}
+		if trackSize == 0 {
+			// Unformatted track
+			continue
+		}
+		if offset+trackSize > len(data) {
+			return nil, fmt.Errorf("DSK track %d is truncated", i)
+		}
+
+		track, err := dsk.decodeTrack(data[offset : offset+trackSize])
+		if err != nil {
+			return nil, fmt.Errorf("DSK track %d: %s", i, err)
+		}
+		dsk.Tracks[i] = track
+		offset += trackSize
+	}
+
+	return dsk, nil
+}
+
+func (dsk *DSK) decodeTrack(data []byte) (*DSKTrack, error) {
+	if (len(data) < DSK_TRACK_INFO_LEN) || !bytes.HasPrefix(data, []byte(dsk_trackSignature)) {
+		return nil, errors.New("invalid track information block")
+	}
+
+	track := &DSKTrack{
+		Track:          data[0x10],
+		Side:           data[0x11],
+		SectorSizeCode: data[0x14],
+		Gap3:           data[0x16],
+		Filler:         data[0x17],
+	}
+
+	numSectors := int(data[0x15])
+	if numSectors > DSK_MAX_SECTORS {
+		return nil, errors.New("too many sectors")
+	}
+
+	offset := DSK_TRACK_INFO_LEN
+	for i := 0; i < numSectors; i++ {
+		info := data[0x18+8*i:]
+		sector := &DSKSector{
+			C:   info[0],
+			H:   info[1],
+			R:   info[2],
+			N:   info[3],
+			ST1: info[4],
+			ST2: info[5],
+		}
+
+		var length int
+		if dsk.Extended {
+			length = int(info[6]) | int(info[7])<<8
+		} else {
+			length = DSKSectorSize(track.SectorSizeCode)
+		}
+		if offset+length > len(data) {
+			return nil, errors.New("sector data is truncated")
+		}
+
+		sector.Data = make([]byte, length)
+		copy(sector.Data, data[offset:])
+		offset += length
+
+		track.Sectors = append(track.Sectors, sector)
+	}
+
+	return track, nil
+}
+
+// Returns a disk image with the specified number of tracks per side, with all tracks unformatted.
+// The image is in the extended format, because the standard format cannot represent
+// unformatted tracks.
+func NewBlankDSK(numTracks, sides int) *DSK {
+	return &DSK{
+		Extended: true,
+		Creator:  "GoSpeccy",
+		Sides:    sides,
+		Tracks:   make([]*DSKTrack, numTracks*sides),
+	}
+}
+
+// Returns the length of the sector data for the size code N
+func DSKSectorSize(n byte) int {
+	if n > 6 {
+		n = 6
+	}
+	return 128 << n
+}
+
+// Returns the number of tracks per side
+func (dsk *DSK) NumTracks() int {
+	return len(dsk.Tracks) / dsk.Sides
+}
+
+// Returns the specified track, or nil if the track is unformatted or does not exist
+func (dsk *DSK) Track(track, side int) *DSKTrack {
+	if (side < 0) || (side >= dsk.Sides) || (track < 0) || (track >= dsk.NumTracks()) {
+		return nil
+	}
+	return dsk.Tracks[track*dsk.Sides+side]
+}
+
+// Replaces the specified track, adding tracks to the image if needed.
+// Returns an error if the track cannot be stored in the image.
+func (dsk *DSK) SetTrack(track, side int, t *DSKTrack) error {
+	if (side < 0) || (side >= dsk.Sides) || (track < 0) || ((track+1)*dsk.Sides > DSK_MAX_TRACKS) {
+		return errors.New("invalid track")
+	}
+	if (t != nil) && (len(t.Sectors) > DSK_MAX_SECTORS) {
+		return errors.New("too many sectors")
+	}
+
+	for track >= dsk.NumTracks() {
+		dsk.Tracks = append(dsk.Tracks, make([]*DSKTrack, dsk.Sides)...)
+	}
+
+	dsk.Tracks[track*dsk.Sides+side] = t
+	return nil
+}
+
+// Returns the sector with the specified ID, or nil
+func (track *DSKTrack) Sector(r byte) *DSKSector {
+	for _, sector := range track.Sectors {
+		if sector.R == r {
+			return sector
+		}
+	}
+	return nil
+}
+
+func (track *DSKTrack) encode(extended bool) []byte {
+	data := make([]byte, DSK_TRACK_INFO_LEN)
+	copy(data, dsk_trackSignature)
+	data[0x10] = track.Track
+	data[0x11] = track.Side
+	data[0x14] = track.SectorSizeCode
+	data[0x15] = byte(len(track.Sectors))
+	data[0x16] = track.Gap3
+	data[0x17] = track.Filler
+
+	for i, sector := range track.Sectors {
+		info := data[0x18+8*i:]
+		info[0] = sector.C
+		info[1] = sector.H
+		info[2] = sector.R
+		info[3] = sector.N
+		info[4] = sector.ST1
+		info[5] = sector.ST2
+
+		sectorData := sector.Data
+		if extended {
+			info[6] = byte(len(sectorData))
+			info[7] = byte(len(sectorData) >> 8)
+		} else {
+			// All the sectors have the size specified in the track information block
+			sectorData = make([]byte, DSKSectorSize(track.SectorSizeCode))
+			copy(sectorData, sector.Data)
+		}
+		data = append(data, sectorData...)
+	}
+
+	return data
+}
+
+// Returns the file contents, in the format of the decoded file.
+// An image with unformatted tracks is always encoded in the extended format.
+func (dsk *DSK) Encode() []byte {
+	extended := dsk.Extended
+	for _, track := range dsk.Tracks {
+		if track == nil {
+			extended = true
+		}
+	}
+
+	tracks := make([][]byte, len(dsk.Tracks))
+	maxSize := 0
+	for i, track := range dsk.Tracks {
+		if track == nil {
+			continue
+		}
+		tracks[i] = track.encode(extended)
+		if extended {
+			// Extended tracks are stored in multiples of 256 bytes
+			for len(tracks[i])%256 != 0 {
+				tracks[i] = append(tracks[i], 0)
+			}
+		}
+		if len(tracks[i]) > maxSize {
+			maxSize = len(tracks[i])
+		}
+	}
+
+	info := make([]byte, DSK_INFO_LEN)
+	if extended {
+		copy(info, dsk_extendedSignature)
+	} else {
+		copy(info, "MV - CPCEMU Disk-File\r\nDisk-Info\r\n")
+	}
+	creator := dsk.Creator
+	if len(creator) > 14 {
+		creator = creator[0:14]
+	}
+	copy(info[0x22:0x30], creator)
+	info[0x30] = byte(dsk.NumTracks())
+	info[0x31] = byte(dsk.Sides)
+
+	if extended {
+		for i := range tracks {
+			info[0x34+i] = byte(len(tracks[i]) >> 8)
+		}
+	} else {
+		info[0x32] = byte(maxSize)
+		info[0x33] = byte(maxSize >> 8)
+	}
+
+	data := info
+	for i := range tracks {
+		data = append(data, tracks[i]...)
+		if !extended {
+			data = append(data, make([]byte, maxSize-len(tracks[i]))...)
+		}
+	}
+
+	return data
+}
+
+// The disk specification of a +3DOS (CP/M) disk
+type dskSpec struct {
+	sides           int
+	tracks          int
+	sectorsPerTrack int
+	sectorSize      int
+	reservedTracks  int
+	blockSize       int
+	dirBlocks       int
+	firstSectorID   byte
+}
+
+// Returns the disk specification, read from the first sector of the disk
+// or determined from the sector IDs
+func (dsk *DSK) spec() (*dskSpec, error) {
+	track := dsk.Track(0, 0)
+	if (track == nil) || (len(track.Sectors) == 0) {
+		return nil, errors.New("the disk is not formatted")
+	}
+
+	firstID := track.Sectors[0].R
+	for _, sector := range track.Sectors {
+		if sector.R < firstID {
+			firstID = sector.R
+		}
+	}
+
+	switch firstID {
+	case 0x41:
+		// Amstrad CPC system format
+		return &dskSpec{1, 40, 9, 512, 2, 1024, 2, 0x41}, nil
+	case 0xc1:
+		// Amstrad CPC data format
+		return &dskSpec{1, 40, 9, 512, 0, 1024, 2, 0xc1}, nil
+	}
+
+	// Spectrum +3 format, with the defaults used when the first sector holds no specification
+	spec := &dskSpec{1, 40, 9, 512, 1, 1024, 2, firstID}
+
+	boot := track.Sector(firstID)
+	if (boot != nil) && (len(boot.Data) >= 10) && ((boot.Data[0] == 0) || (boot.Data[0] == 3)) {
+		b := boot.Data
+		spec.sides = int(b[1]&0x03) + 1
+		spec.tracks = int(b[2])
+		spec.sectorsPerTrack = int(b[3])
+		spec.sectorSize = 128 << (b[4] & 0x07)
+		spec.reservedTracks = int(b[5])
+		spec.blockSize = 128 << (b[6] & 0x07)
+		spec.dirBlocks = int(b[7])
+		if spec.sides > 2 {
+			spec.sides = 2
+		}
+	}
+
+	if (spec.sectorsPerTrack == 0) || (spec.tracks == 0) || (spec.dirBlocks == 0) {
+		return nil, errors.New("invalid disk specification")
+	}
+
+	return spec, nil
+}
+
+// Returns the contents of the specified logical sector
+func (dsk *DSK) logicalSector(spec *dskSpec, n int) ([]byte, error) {
+	logicalTrack := n / spec.sectorsPerTrack
+	index := n % spec.sectorsPerTrack
+
+	var cylinder, side int
+	if spec.sides == 2 {
+		cylinder, side = logicalTrack/2, logicalTrack%2
+	} else {
+		cylinder, side = logicalTrack, 0
+	}
+
+	track := dsk.Track(cylinder, side)
+	if track == nil {
+		return nil, fmt.Errorf("track %d is not formatted", cylinder)
+	}
+	sector := track.Sector(spec.firstSectorID + byte(index))
+	if sector == nil {
+		return nil, fmt.Errorf("sector %d not found on track %d", spec.firstSectorID+byte(index), cylinder)
+	}
+	return sector.Data, nil
+}
+
+// Reads the directory of a +3DOS (or CP/M) disk.
+// The files are sorted by name.
+func (dsk *DSK) Catalogue() ([]DSKFile, error) {
+	spec, err := dsk.spec()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the directory blocks
+	var dir []byte
+	{
+		first := spec.reservedTracks * spec.sectorsPerTrack
+		n := spec.dirBlocks * spec.blockSize / spec.sectorSize
+		for i := 0; i < n; i++ {
+			data, err := dsk.logicalSector(spec, first+i)
+			if err != nil {
+				return nil, err
+			}
+			dir = append(dir, data...)
+		}
+	}
+
+	type key struct {
+		user byte
+		name string
+	}
+	files := make(map[key]*DSKFile)
+	extents := make(map[key]int)
+
+	for offset := 0; offset+32 <= len(dir); offset += 32 {
+		entry := dir[offset : offset+32]
+		user := entry[0]
+		if user > 15 {
+			// Deleted entry, disk label, or timestamps
+			continue
+		}
+
+		var name [11]byte
+		for i := range name {
+			name[i] = entry[1+i] & 0x7f
+		}
+		base := strings.TrimRight(string(name[0:8]), " ")
+		ext := strings.TrimRight(string(name[8:11]), " ")
+		if ext != "" {
+			base += "." + ext
+		}
+
+		k := key{user, base}
+		file, ok := files[k]
+		if !ok {
+			file = &DSKFile{
+				User:     user,
+				Name:     base,
+				ReadOnly: (entry[9] & 0x80) != 0,
+				System:   (entry[10] & 0x80) != 0,
+			}
+			files[k] = file
+			extents[k] = -1
+		}
+
+		// The size is determined by the last logical extent (16K each) and its record count
+		extent := int(entry[14]&0x3f)<<5 | int(entry[12]&0x1f)
+		if extent > extents[k] {
+			extents[k] = extent
+			file.Size = extent*16384 + int(entry[15])*128
+		}
+	}
+
+	list := make([]DSKFile, 0, len(files))
+	for _, file := range files {
+		list = append(list, *file)
+	}
+	sort.Sort(dskFilesByName(list))
+
+	return list, nil
+}
+
+type dskFilesByName []DSKFile
+
+func (l dskFilesByName) Len() int      { return len(l) }
+func (l dskFilesByName) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l dskFilesByName) Less(i, j int) bool {
+	if l[i].Name != l[j].Name {
+		return l[i].Name < l[j].Name
+	}
+	return l[i].User < l[j].User
+}
@@ -0,0 +1,90 @@
+package formats
+
+// Returns a track formatted as on the Spectrum +3 (9 sectors of 512 bytes, IDs 1-9)
+func plus3Track(track byte) *DSKTrack {
+	t := &DSKTrack{Track: track, SectorSizeCode: 2, Gap3: 0x2a, Filler: DSK_FILLER}
+	for r := byte(1); r <= 9; r++ {
+		data := make([]byte, 512)
+		for i := range data {
+			data[i] = DSK_FILLER
+		}
+		t.Sectors = append(t.Sectors, &DSKSector{C: track, R: r, N: 2, Data: data})
+	}
+	return t
+}
+
+func (t *testSuite) TestDSKEncodeDecode() {
+	for _, extended := range []bool{false, true} {
+		dsk := NewBlankDSK(40, 1)
+		dsk.Extended = extended
+		for i := 0; i < 40; i++ {
+			t.Nil(dsk.SetTrack(i, 0, plus3Track(byte(i))))
+		}
+		dsk.Track(3, 0).Sector(5).Data[0] = 0x42
+		dsk.Track(3, 0).Sector(5).ST2 = 0x40
+
+		decoded, err := NewDSK(dsk.Encode())
+		t.Nil(err)
+		t.Equal(extended, decoded.Extended)
+		t.Equal(40, decoded.NumTracks())
+		t.Equal(1, decoded.Sides)
+		t.Equal(9, len(decoded.Track(39, 0).Sectors))
+		t.Equal(byte(0x42), decoded.Track(3, 0).Sector(5).Data[0])
+		t.Equal(byte(0x40), decoded.Track(3, 0).Sector(5).ST2)
+		t.Equal(512, len(decoded.Track(3, 0).Sector(9).Data))
+	}
+}
+
+func (t *testSuite) TestDSKUnformattedTracks() {
+	dsk := NewBlankDSK(40, 2)
+	t.Nil(dsk.SetTrack(1, 1, plus3Track(1)))
+
+	decoded, err := NewDSK(dsk.Encode())
+	t.Nil(err)
+	t.True(decoded.Extended)
+	t.Equal(2, decoded.Sides)
+	t.Nil(decoded.Track(0, 0))
+	t.NotNil(decoded.Track(1, 1))
+	t.Nil(decoded.Track(40, 0))
+}
+
+func (t *testSuite) TestDSKInvalid() {
+	_, err := NewDSK(make([]byte, 100))
+	t.NotNil(err)
+
+	_, err = NewDSK(make([]byte, DSK_INFO_LEN))
+	t.NotNil(err)
+}
+
+func (t *testSuite) TestDSKCatalogue() {
+	dsk := NewBlankDSK(40, 1)
+	for i := 0; i < 40; i++ {
+		dsk.SetTrack(i, 0, plus3Track(byte(i)))
+	}
+
+	// The directory starts at track 1 (one reserved track)
+	dir := dsk.Track(1, 0).Sector(1).Data
+	entry := func(index int, name string, extent, records byte) {
+		e := dir[32*index : 32*index+32]
+		for i := range e {
+			e[i] = 0
+		}
+		copy(e[1:12], name)
+		e[12] = extent
+		e[15] = records
+	}
+	entry(0, "LOADER  BAS", 0, 3)
+	entry(1, "GAME    BIN", 0, 0x80)
+	entry(2, "GAME    BIN", 1, 0x10)
+
+	files, err := dsk.Catalogue()
+	t.Nil(err)
+	t.Equal(2, len(files))
+	t.Equal("GAME.BIN", files[0].Name)
+	t.Equal(16384+16*128, files[0].Size)
+	t.Equal("LOADER.BAS", files[1].Name)
+	t.Equal(3*128, files[1].Size)
+
+	_, err = NewBlankDSK(40, 1).Catalogue()
+	t.NotNil(err)
+}
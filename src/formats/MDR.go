@@ -0,0 +1,69 @@
+package formats
+
+import (
+	"errors"
+)
+
+// Microdrive cartridge images (MDR).
+//
+// The file contains the sectors of the cartridge followed by a write-protect byte.
+// Each sector consists of a 15-byte header block and a 528-byte data block
+// (a 15-byte record descriptor, 512 bytes of data and a checksum).
+const (
+	MDR_HEADER_LEN    = 15
+	MDR_DATA_LEN      = 528
+	MDR_SECTOR_LEN    = MDR_HEADER_LEN + MDR_DATA_LEN
+	MDR_MAX_SECTORS   = 254
+	MDR_BLANK_FILLER  = 0xfc
+	mdr_maxFileLength = MDR_MAX_SECTORS*MDR_SECTOR_LEN + 1
+)
+
+type MDR struct {
+	Sectors        [][MDR_SECTOR_LEN]byte
+	WriteProtected bool
+}
+
+// Decodes a cartridge image
+func NewMDR(data []byte) (*MDR, error) {
+	if (len(data) < MDR_SECTOR_LEN) || (len(data) > mdr_maxFileLength) {
+		return nil, errors.New("invalid MDR file length")
+	}
+
+	n := len(data) / MDR_SECTOR_LEN
+	mdr := &MDR{Sectors: make([][MDR_SECTOR_LEN]byte, n)}
+	for i := 0; i < n; i++ {
+		copy(mdr.Sectors[i][:], data[i*MDR_SECTOR_LEN:])
+	}
+
+	// The write-protect byte is optional
+	if len(data) > n*MDR_SECTOR_LEN {
+		mdr.WriteProtected = (data[n*MDR_SECTOR_LEN] != 0)
+	}
+
+	return mdr, nil
+}
+
+// Returns an unformatted cartridge with the maximum number of sectors
+func NewBlankMDR() *MDR {
+	mdr := &MDR{Sectors: make([][MDR_SECTOR_LEN]byte, MDR_MAX_SECTORS)}
+	for i := range mdr.Sectors {
+		for j := range mdr.Sectors[i] {
+			mdr.Sectors[i][j] = MDR_BLANK_FILLER
+		}
+	}
+	return mdr
+}
+
+// Returns the file contents
+func (mdr *MDR) Encode() []byte {
+	data := make([]byte, 0, len(mdr.Sectors)*MDR_SECTOR_LEN+1)
+	for i := range mdr.Sectors {
+		data = append(data, mdr.Sectors[i][:]...)
+	}
+	if mdr.WriteProtected {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	return data
+}
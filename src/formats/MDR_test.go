@@ -0,0 +1,36 @@
+package formats
+
+func (t *testSuite) TestMDREncodeDecode() {
+	mdr := NewBlankMDR()
+	t.Equal(MDR_MAX_SECTORS, len(mdr.Sectors))
+
+	mdr.Sectors[3][0] = 0x01
+	mdr.Sectors[3][MDR_SECTOR_LEN-1] = 0x42
+	mdr.WriteProtected = true
+
+	data := mdr.Encode()
+	t.Equal(MDR_MAX_SECTORS*MDR_SECTOR_LEN+1, len(data))
+
+	decoded, err := NewMDR(data)
+	t.Nil(err)
+	t.Equal(MDR_MAX_SECTORS, len(decoded.Sectors))
+	t.Equal(byte(0x01), decoded.Sectors[3][0])
+	t.Equal(byte(0x42), decoded.Sectors[3][MDR_SECTOR_LEN-1])
+	t.Equal(byte(MDR_BLANK_FILLER), decoded.Sectors[4][0])
+	t.True(decoded.WriteProtected)
+}
+
+func (t *testSuite) TestMDRWithoutWriteProtectByte() {
+	mdr, err := NewMDR(make([]byte, 10*MDR_SECTOR_LEN))
+	t.Nil(err)
+	t.Equal(10, len(mdr.Sectors))
+	t.False(mdr.WriteProtected)
+}
+
+func (t *testSuite) TestMDRInvalidLength() {
+	_, err := NewMDR(make([]byte, 100))
+	t.NotNil(err)
+
+	_, err = NewMDR(make([]byte, (MDR_MAX_SECTORS+1)*MDR_SECTOR_LEN))
+	t.NotNil(err)
+}
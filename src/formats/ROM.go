@@ -0,0 +1,30 @@
+package formats
+
+import (
+	"errors"
+)
+
+// Interface 2 ROM cartridge images.
+//
+// The file contains the contents of the cartridge ROM, which replaces the system ROM.
+// A cartridge smaller than 16K is padded with 0xFF (unconnected data lines).
+const CARTRIDGE_SIZE = 0x4000
+
+type Cartridge struct {
+	ROM [CARTRIDGE_SIZE]byte
+}
+
+// Decodes a cartridge image
+func NewCartridge(data []byte) (*Cartridge, error) {
+	if (len(data) == 0) || (len(data) > CARTRIDGE_SIZE) {
+		return nil, errors.New("invalid ROM cartridge file length")
+	}
+
+	cartridge := &Cartridge{}
+	for i := range cartridge.ROM {
+		cartridge.ROM[i] = 0xff
+	}
+	copy(cartridge.ROM[:], data)
+
+	return cartridge, nil
+}
@@ -0,0 +1,22 @@
+package formats
+
+func (t *testSuite) TestCartridge() {
+	cartridge, err := NewCartridge([]byte{0xf3, 0xaf})
+	t.Nil(err)
+	t.Equal(byte(0xf3), cartridge.ROM[0])
+	t.Equal(byte(0xaf), cartridge.ROM[1])
+	t.Equal(byte(0xff), cartridge.ROM[2])
+	t.Equal(byte(0xff), cartridge.ROM[CARTRIDGE_SIZE-1])
+
+	_, err = NewCartridge(nil)
+	t.NotNil(err)
+
+	_, err = NewCartridge(make([]byte, CARTRIDGE_SIZE+1))
+	t.NotNil(err)
+}
+
+func (t *testSuite) TestDetectCartridgeFormat() {
+	format, err := DetectFormat("jetpac.ROM")
+	t.Nil(err)
+	t.Equal(FORMAT_ROM, format.Format)
+}
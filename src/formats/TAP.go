@@ -101,6 +101,30 @@ func (tap *TAP) GetBlock(pos int) tapBlock {
 	return tap.blocks[pos]
 }
 
+func (tap *TAP) NumBlocks() int {
+	return len(tap.blocks)
+}
+
+// Returns the position of the first byte of the specified block
+func (tap *TAP) BlockStart(pos int) uint {
+	start := uint(0)
+	for _, blk := range tap.blocks[:pos] {
+		start += uint(blk.Len())
+	}
+	return start
+}
+
+// Turn the tape into binary data (TAP format)
+func (tap *TAP) Encode() []byte {
+	var data []byte
+	for _, blk := range tap.blocks {
+		h, l := splitWord(uint16(blk.Len()))
+		data = append(data, l, h)
+		data = append(data, blk.Data()...)
+	}
+	return data
+}
+
 func readBlock_header(data []byte) *tapBlockHeader {
 	header := new(tapBlockHeader)
 
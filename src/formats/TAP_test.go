@@ -152,3 +152,17 @@ func (t *testSuite) TestTAPGetBlock() {
 func (t *testSuite) TestTAPBlockLen() {
 	t.Equal(19, tap.GetBlock(0).Len())
 }
+
+func (t *testSuite) TestEncodeTAP() {
+	data, err := ioutil.ReadFile(tapProgramFn)
+	t.Nil(err)
+	tap, err := NewTAP(data)
+	t.Nil(err)
+
+	if !t.Failed() {
+		t.Equal(2, tap.NumBlocks())
+		t.Equal(uint(0), tap.BlockStart(0))
+		t.Equal(uint(tap.GetBlock(0).Len()), tap.BlockStart(1))
+		t.True(bytes.Equal(data, tap.Encode()))
+	}
+}
@@ -0,0 +1,153 @@
+package formats
+
+import (
+	"bytes"
+	"errors"
+)
+
+// TR-DOS disk images (TRD), and the SCL archives of TR-DOS files.
+//
+// A TRD file contains the sectors of the disk in order: 16 sectors of 256 bytes per track,
+// the tracks of both sides interleaved (track 0 side 0, track 0 side 1, ...).
+// Track 0 holds the directory (sectors 0-7, 16-byte entries) and the system sector (sector 8).
+//
+// An SCL file contains the 14-byte directory entries of the files followed by their sectors.
+// It is converted to a TRD image, placing the files one after another from track 1.
+const (
+	TRD_SECTOR_SIZE       = 256
+	TRD_SECTORS_PER_TRACK = 16
+	TRD_TRACK_SIZE        = TRD_SECTORS_PER_TRACK * TRD_SECTOR_SIZE
+	TRD_MAX_TRACKS        = 86 // Per side
+	TRD_MAX_FILES         = 128
+
+	// Disk types stored in the system sector
+	TRD_TYPE_80_DS = 0x16
+	TRD_TYPE_40_DS = 0x17
+	TRD_TYPE_80_SS = 0x18
+	TRD_TYPE_40_SS = 0x19
+
+	trd_systemSector = 8 * TRD_SECTOR_SIZE
+	trd_dirEntryLen  = 16
+	scl_dirEntryLen  = 14
+	scl_signature    = "SINCLAIR"
+)
+
+type TRD struct {
+	Tracks int // Per side
+	Sides  int
+	Data   []byte
+}
+
+// Decodes a TRD image. A short image (with the unused tracks omitted)
+// is extended to the size given by the disk type.
+func NewTRD(data []byte) (*TRD, error) {
+	if (len(data) < TRD_TRACK_SIZE) || (len(data)%TRD_SECTOR_SIZE != 0) {
+		return nil, errors.New("invalid TRD file length")
+	}
+
+	trd := &TRD{Tracks: 80, Sides: 2}
+	switch data[trd_systemSector+0xe3] {
+	case TRD_TYPE_40_DS:
+		trd.Tracks, trd.Sides = 40, 2
+	case TRD_TYPE_80_SS:
+		trd.Tracks, trd.Sides = 80, 1
+	case TRD_TYPE_40_SS:
+		trd.Tracks, trd.Sides = 40, 1
+	}
+
+	for trd.Tracks*trd.Sides*TRD_TRACK_SIZE < len(data) {
+		trd.Tracks++
+	}
+	if trd.Tracks > TRD_MAX_TRACKS {
+		return nil, errors.New("invalid TRD file length")
+	}
+
+	trd.Data = make([]byte, trd.Tracks*trd.Sides*TRD_TRACK_SIZE)
+	copy(trd.Data, data)
+
+	return trd, nil
+}
+
+// Returns an unformatted 80-track double-sided disk
+func NewBlankTRD() *TRD {
+	return &TRD{Tracks: 80, Sides: 2, Data: make([]byte, 80*2*TRD_TRACK_SIZE)}
+}
+
+// Converts an SCL archive to a TRD image (80 tracks, double-sided)
+func NewTRDFromSCL(data []byte) (*TRD, error) {
+	if (len(data) < len(scl_signature)+1) || !bytes.HasPrefix(data, []byte(scl_signature)) {
+		return nil, errors.New("invalid SCL signature")
+	}
+
+	numFiles := int(data[len(scl_signature)])
+	if numFiles > TRD_MAX_FILES {
+		return nil, errors.New("too many files in the SCL archive")
+	}
+
+	dirOffset := len(scl_signature) + 1
+	dataOffset := dirOffset + numFiles*scl_dirEntryLen
+	if dataOffset > len(data) {
+		return nil, errors.New("the SCL directory is truncated")
+	}
+
+	trd := NewBlankTRD()
+	totalSectors := trd.Tracks * trd.Sides * TRD_SECTORS_PER_TRACK
+
+	// The first free sector (logical sector number, 16 sectors per track)
+	free := TRD_SECTORS_PER_TRACK
+
+	for i := 0; i < numFiles; i++ {
+		entry := data[dirOffset+i*scl_dirEntryLen : dirOffset+(i+1)*scl_dirEntryLen]
+		numSectors := int(entry[13])
+
+		if free+numSectors > totalSectors {
+			return nil, errors.New("the SCL archive does not fit on a disk")
+		}
+		if dataOffset+numSectors*TRD_SECTOR_SIZE > len(data) {
+			return nil, errors.New("the SCL file data is truncated")
+		}
+
+		dir := trd.Data[i*trd_dirEntryLen : (i+1)*trd_dirEntryLen]
+		copy(dir, entry)
+		dir[14] = byte(free % TRD_SECTORS_PER_TRACK)
+		dir[15] = byte(free / TRD_SECTORS_PER_TRACK)
+
+		copy(trd.Data[free*TRD_SECTOR_SIZE:], data[dataOffset:dataOffset+numSectors*TRD_SECTOR_SIZE])
+
+		dataOffset += numSectors * TRD_SECTOR_SIZE
+		free += numSectors
+	}
+
+	// The system sector
+	system := trd.Data[trd_systemSector : trd_systemSector+TRD_SECTOR_SIZE]
+	system[0xe1] = byte(free % TRD_SECTORS_PER_TRACK)
+	system[0xe2] = byte(free / TRD_SECTORS_PER_TRACK)
+	system[0xe3] = TRD_TYPE_80_DS
+	system[0xe4] = byte(numFiles)
+	system[0xe5] = byte(totalSectors - free)
+	system[0xe6] = byte((totalSectors - free) >> 8)
+	system[0xe7] = 0x10 // TR-DOS disk ID
+	for i := 0xea; i <= 0xf2; i++ {
+		system[i] = ' '
+	}
+	for i := 0xf5; i <= 0xfc; i++ {
+		system[i] = ' '
+	}
+
+	return trd, nil
+}
+
+// Returns the specified sector (0-15), or nil if it is outside of the disk
+func (trd *TRD) Sector(track, side, sector int) []byte {
+	if (track < 0) || (track >= trd.Tracks) || (side < 0) || (side >= trd.Sides) ||
+		(sector < 0) || (sector >= TRD_SECTORS_PER_TRACK) {
+		return nil
+	}
+	offset := ((track*trd.Sides+side)*TRD_SECTORS_PER_TRACK + sector) * TRD_SECTOR_SIZE
+	return trd.Data[offset : offset+TRD_SECTOR_SIZE]
+}
+
+// Returns the file contents
+func (trd *TRD) Encode() []byte {
+	return trd.Data
+}
@@ -0,0 +1,67 @@
+package formats
+
+func (t *testSuite) TestTRDShortImage() {
+	data := make([]byte, 3*TRD_TRACK_SIZE)
+	data[trd_systemSector+0xe3] = TRD_TYPE_40_SS
+	data[2*TRD_TRACK_SIZE+5] = 0x42
+
+	trd, err := NewTRD(data)
+	t.Nil(err)
+	t.Equal(40, trd.Tracks)
+	t.Equal(1, trd.Sides)
+	t.Equal(40*TRD_TRACK_SIZE, len(trd.Encode()))
+	t.Equal(byte(0x42), trd.Sector(2, 0, 0)[5])
+	t.Nil(trd.Sector(40, 0, 0))
+	t.Nil(trd.Sector(0, 1, 0))
+}
+
+func (t *testSuite) TestTRDInvalid() {
+	_, err := NewTRD(make([]byte, 100))
+	t.NotNil(err)
+
+	_, err = NewTRD(make([]byte, (TRD_MAX_TRACKS+1)*2*TRD_TRACK_SIZE))
+	t.NotNil(err)
+}
+
+func (t *testSuite) TestSCLToTRD() {
+	scl := []byte("SINCLAIR")
+	scl = append(scl, 2)
+	scl = append(scl, []byte("boot    B\x10\x00\x10\x00\x01")...)
+	scl = append(scl, []byte("game    C\x00\x80\x00\x02\x02")...)
+	for i := 0; i < 3; i++ {
+		sector := make([]byte, TRD_SECTOR_SIZE)
+		sector[0] = byte(i + 1)
+		scl = append(scl, sector...)
+	}
+	scl = append(scl, 0, 0, 0, 0) // Checksum
+
+	trd, err := NewTRDFromSCL(scl)
+	t.Nil(err)
+	t.Equal(80, trd.Tracks)
+	t.Equal(2, trd.Sides)
+
+	// The directory
+	t.Equal("boot    B", string(trd.Data[0:9]))
+	t.Equal(byte(0), trd.Data[14])
+	t.Equal(byte(1), trd.Data[15])
+	t.Equal("game    C", string(trd.Data[16:25]))
+	t.Equal(byte(1), trd.Data[16+14])
+	t.Equal(byte(1), trd.Data[16+15])
+
+	// The file data, from track 1 (logical track: track 0 side 1)
+	t.Equal(byte(1), trd.Sector(0, 1, 0)[0])
+	t.Equal(byte(2), trd.Sector(0, 1, 1)[0])
+	t.Equal(byte(3), trd.Sector(0, 1, 2)[0])
+
+	// The system sector
+	system := trd.Sector(0, 0, 8)
+	t.Equal(byte(3), system[0xe1])
+	t.Equal(byte(1), system[0xe2])
+	t.Equal(byte(TRD_TYPE_80_DS), system[0xe3])
+	t.Equal(byte(2), system[0xe4])
+	t.Equal(2560-19, int(system[0xe5])|int(system[0xe6])<<8)
+	t.Equal(byte(0x10), system[0xe7])
+
+	_, err = NewTRDFromSCL([]byte("SINCLAIR\x01"))
+	t.NotNil(err)
+}
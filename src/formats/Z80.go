@@ -12,6 +12,11 @@ type Z80 struct {
 	doubleInterruptFrequency bool
 	videoSynchronization     byte // 0..3
 	joystick                 byte // 0..3
+
+	// The T-state counter of version 3.0x snapshots (see 'TstateCounter')
+	hasTstateCounter bool
+	tstateQuarter    uint
+	tstateCountdown  uint
 }
 
 const (
@@ -265,10 +270,14 @@ func (data SnapshotData) decodeZ80_v3() (*Z80, error) {
 	// data[38]: ignored
 	// data[39..54]: ignored
 
+	// The low T-state counter counts down to 0 during each quarter of the frame,
+	// the high T-state counter is incremented (modulo 4) at the end of each quarter.
+	// The length of a quarter depends on the model which is going to load the snapshot.
 	tstate_low := uint(data[55]) | (uint(data[56]) << 8)
 	tstate_hi := uint(data[57] & 0x03)
-	const T4 = TStatesPerFrame / 4
-	s.cpu.Tstate = ((tstate_hi-3)%4)*T4 + (T4 - (tstate_low % T4) - 1)
+	s.hasTstateCounter = true
+	s.tstateQuarter = (tstate_hi + 1) % 4
+	s.tstateCountdown = tstate_low
 
 	// data[58]: always ignored
 
@@ -441,3 +450,15 @@ func (s *Z80) UlaState() UlaState {
 func (s *Z80) Memory() *[48 * 1024]byte {
 	return &s.mem
 }
+
+// Returns the position of the CPU within the frame, as stored by version 3.0x snapshots:
+// the quarter of the frame (0..3), and the number of T-states remaining until the end
+// of the quarter minus 1. The position in T-states depends on the length of the frame:
+//
+//	T4 = TStatesPerFrame/4
+//	Tstate = quarter*T4 + (T4 - countdown%T4 - 1)
+//
+// Returns ok=false if the snapshot does not store the position (versions 1 and 2.01).
+func (s *Z80) TstateCounter() (quarter, countdown uint, ok bool) {
+	return s.tstateQuarter, s.tstateCountdown, s.hasTstateCounter
+}
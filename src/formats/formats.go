@@ -8,10 +8,7 @@ import (
 	"strings"
 )
 
-const (
-	TStatesPerFrame = 69888
-	InterruptLength = 32
-)
+const InterruptLength = 32
 
 type CpuState struct {
 	A, F, B, C, D, E, H, L         byte
@@ -63,6 +60,7 @@ const (
 	FORMAT_SNA = iota
 	FORMAT_Z80
 	FORMAT_TAP
+	FORMAT_ROM // Interface 2 ROM cartridge
 )
 
 const (
@@ -95,6 +93,9 @@ func detectFormat(filePath string, encapsulation int, allowEncapsulation bool) (
 	case ".tap":
 		return &FormatInfo{FORMAT_TAP, encapsulation}, nil
 
+	case ".rom":
+		return &FormatInfo{FORMAT_ROM, encapsulation}, nil
+
 	case ".zip":
 		if (encapsulation == ENCAPSULATION_NONE) && allowEncapsulation {
 			archive, err := ReadZipFile(filePath)
@@ -177,8 +178,11 @@ func readZIP(filePath string) (interface{}, error) {
 		return nil, err
 	}
 
-	if embeddedFile_format.Format == FORMAT_TAP {
+	switch embeddedFile_format.Format {
+	case FORMAT_TAP:
 		return NewTAP(data)
+	case FORMAT_ROM:
+		return NewCartridge(data)
 	}
 
 	return SnapshotData(data).Decode(embeddedFile_format.Format)
@@ -206,8 +210,11 @@ func ReadProgram(filePath string) (interface{}, error) {
 		return nil, err
 	}
 
-	if format.Format == FORMAT_TAP {
+	switch format.Format {
+	case FORMAT_TAP:
 		return NewTAP(data)
+	case FORMAT_ROM:
+		return NewCartridge(data)
 	}
 
 	return SnapshotData(data).Decode(format.Format)
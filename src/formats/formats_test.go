@@ -26,6 +26,17 @@ func (t *testSuite) TestReadProgram_Z80() {
 	t.True(ok)
 }
 
+func (t *testSuite) TestZ80_TstateCounter() {
+	program, err := ReadProgram("testdata/fire.z80")
+	t.Nil(err)
+
+	// Version 3.0 snapshot, low counter 223, high counter 2
+	quarter, countdown, ok := program.(*Z80).TstateCounter()
+	t.True(ok)
+	t.Equal(uint(3), quarter)
+	t.Equal(uint(223), countdown)
+}
+
 func (t *testSuite) TestReadProgram_TAP() {
 	program, err := ReadProgram("testdata/fire.tap")
 	_, ok := program.(*TAP)
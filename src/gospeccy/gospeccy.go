@@ -71,6 +71,15 @@ func (h *handler_SIGTERM) HandleSignal(s os.Signal) {
 func newApplication(verbose bool) *spectrum.Application {
 	app := spectrum.NewApplication()
 	app.Verbose = verbose
+
+	// The display backends are using the palette from the moment they start
+	palette, err := spectrum.GetPalette(*paletteName)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+	} else {
+		app.SetPalette(palette)
+	}
+
 	env.Publish(app)
 	return app
 }
@@ -162,12 +171,320 @@ func exit(app *spectrum.Application) {
 var (
 	help            = flag.Bool("help", false, "Show usage")
 	acceleratedLoad = flag.Bool("accelerated-load", false, "Accelerated tape loading")
-	fps             = flag.Float64("fps", spectrum.DefaultFPS, "Frames per second")
+	fps             = flag.Float64("fps", 0, "Frames per second (default: the frame rate of the model)")
 	verbose         = flag.Bool("verbose", false, "Enable debugging messages")
 	cpuProfile      = flag.String("hostcpu-profile", "", "Write host-CPU profile to the specified file (for 'pprof')")
 	wos             = flag.String("wos", "", "Download from WorldOfSpectrum; you must provide a query regex (ex: -wos=jetsetwilly)")
+	inputProfiles   = flag.String("input-profiles", pathutil.Join(spectrum.DefaultUserDir, "input-profiles.conf"), "Read keyboard and gamepad mapping profiles from the specified file")
+	inputProfile    = flag.String("input-profile", spectrum.DEFAULT_INPUT_PROFILE, "The keyboard and gamepad mapping profile to use")
+	joysticks       = flag.String("joystick", "kempston", "Joystick interfaces emulated by the gamepads, separated by commas (kempston, sinclair1, sinclair2, cursor, fuller)")
+	if1             = flag.Bool("if1", false, "Connect the ZX Interface 1")
+	if1ROM          = flag.String("if1-rom", "if1-2.rom", "The Interface 1 ROM")
+	mdr             = flag.String("mdr", "", "Microdrive cartridges inserted into drives 1, 2, ..., separated by commas (implies -if1)")
+	if1RS232        = flag.String("if1-rs232", "", "Bridge the Interface 1 RS-232 port to a host pty (pty) or to a TCP socket on localhost (tcp:PORT)")
+	divmmc          = flag.String("divmmc", "", "Connect a DivMMC with an SD card backed by the specified raw disk image")
+	divide          = flag.String("divide", "", "Connect a DivIDE with a hard disk backed by the specified raw disk image")
+	divROM          = flag.String("div-rom", "", "The DivMMC/DivIDE EEPROM contents (default: esxmmc.bin or esxide.bin)")
+	esxdos          = flag.String("esxdos", "", "Serve the esxDOS API (RST 8) from the specified host directory")
+	model           = flag.String("model", "48k", "The emulated model (16k, 48k, 48k-ntsc, pentagon, plus2a, plus3)")
+	issue2          = flag.Bool("issue2", false, "Emulate the keyboard port of an Issue 2 Spectrum (default: Issue 3)")
+	diskA           = flag.String("diska", "", "Insert the DSK disk image into drive A: of the +3")
+	diskB           = flag.String("diskb", "", "Insert the DSK disk image into drive B: of the +3")
+	beta128         = flag.Bool("beta128", false, "Connect the Beta 128 disk interface")
+	trdosROM        = flag.String("trdos-rom", "trdos.rom", "The TR-DOS ROM of the Beta 128")
+	trd             = flag.String("trd", "", "TRD or SCL disk images inserted into drives A:, B:, ..., separated by commas (implies -beta128)")
+	multiface       = flag.String("multiface", "", "Connect a Multiface (one, 128, 3)")
+	multifaceROM    = flag.String("multiface-rom", "", "The Multiface ROM (default: mf1.rom, mf128.rom or mf3.rom)")
+	printer         = flag.String("printer", "", "Connect a printer (zx, alphacom)")
+	uspeech         = flag.Bool("uspeech", false, "Connect the Currah µSpeech")
+	uspeechROM      = flag.String("uspeech-rom", "", "The Currah µSpeech ROM (optional)")
+	specdrum        = flag.Bool("specdrum", false, "Connect the SpecDrum")
+	covox           = flag.String("covox", "", "Connect a Covox to the specified port (fb, dd)")
+	rewind          = flag.Uint("rewind", 30, "The length of the rewind buffer in seconds (0 disables rewinding)")
+	netplayHost     = flag.String("netplay-host", "", "Wait for another player to connect to the specified TCP address (ex: -netplay-host=:7000)")
+	netplayJoin     = flag.String("netplay-join", "", "Connect to another player at the specified TCP address (ex: -netplay-join=localhost:7000)")
+	netplayDelay    = flag.Uint("netplay-delay", spectrum.NETPLAY_DEFAULT_DELAY, "The netplay input delay in frames (set by the host)")
+	paletteName     = flag.String("palette", "default", "Color palette: default, bright, greyscale, green, or the name of a palette file")
+	autosave        = flag.Bool("autosave", false, "Save the session on exit, and restore it on the next start if no program is specified")
 )
 
+// Switches to the selected model, selects the keyboard issue and inserts the disks
+func setupModel(speccy *spectrum.Spectrum48k) error {
+	m, ok := spectrum.ModelNames[strings.ToLower(*model)]
+	if !ok {
+		return fmt.Errorf("unknown model \"%s\"", *model)
+	}
+
+	if *issue2 {
+		speccy.CommandChannel <- spectrum.Cmd_SetIssue2{true}
+	}
+
+	if m != spectrum.MODEL_48K {
+		romPath, err := spectrum.SystemRomPath(m.ROMFile())
+		if err != nil {
+			return err
+		}
+
+		roms, err := spectrum.ReadModelROMs(m, romPath)
+		if err != nil {
+			return err
+		}
+
+		err = speccy.SetModel(m, roms)
+		if err != nil {
+			return err
+		}
+	}
+
+	for drive, path := range []string{*diskA, *diskB} {
+		if path != "" {
+			err := speccy.InsertDisk(uint(drive), path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Connects the Interface 1 and inserts the Microdrive cartridges
+func setupInterface1(app *spectrum.Application, speccy *spectrum.Spectrum48k) error {
+	if !*if1 && (*mdr == "") && (*if1RS232 == "") {
+		return nil
+	}
+
+	romPath, err := spectrum.SystemRomPath(*if1ROM)
+	if err != nil {
+		return err
+	}
+
+	rom, err := spectrum.ReadInterface1ROM(romPath)
+	if err != nil {
+		return err
+	}
+
+	device := spectrum.NewInterface1(rom)
+
+	if *mdr != "" {
+		for i, path := range strings.Split(*mdr, ",") {
+			err = device.InsertCartridge(uint(i+1), strings.TrimSpace(path))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if *if1RS232 != "" {
+		msg, err := device.ConnectRS232(*if1RS232)
+		if err != nil {
+			return err
+		}
+		app.PrintfMsg("%s", msg)
+	}
+
+	return speccy.AttachPeripheral(device)
+}
+
+// Serves the esxDOS API from the host directory
+func mountEsxDOS(speccy *spectrum.Spectrum48k) error {
+	esx, err := spectrum.NewEsxDOS(*esxdos)
+	if err != nil {
+		return err
+	}
+	return speccy.AttachPeripheral(esx)
+}
+
+// Connects the DivMMC or the DivIDE
+func setupDiv(speccy *spectrum.Spectrum48k) error {
+	if (*divmmc == "") && (*divide == "") {
+		return nil
+	}
+	if (*divmmc != "") && (*divide != "") {
+		return errors.New("the DivMMC and the DivIDE cannot be connected at the same time")
+	}
+
+	romName := *divROM
+	if romName == "" {
+		if *divmmc != "" {
+			romName = "esxmmc.bin"
+		} else {
+			romName = "esxide.bin"
+		}
+	}
+
+	romPath, err := spectrum.SystemRomPath(romName)
+	if err != nil {
+		return err
+	}
+
+	rom, err := spectrum.ReadDivROM(romPath)
+	if err != nil {
+		return err
+	}
+
+	var device *spectrum.DivMMC
+	if *divmmc != "" {
+		device, err = spectrum.NewDivMMC(rom, *divmmc)
+	} else {
+		device, err = spectrum.NewDivIDE(rom, *divide)
+	}
+	if err != nil {
+		return err
+	}
+
+	return speccy.AttachPeripheral(device)
+}
+
+// Connects the Beta 128 and inserts the disks
+func setupBeta128(speccy *spectrum.Spectrum48k) error {
+	if !*beta128 && (*trd == "") {
+		return nil
+	}
+
+	romPath, err := spectrum.SystemRomPath(*trdosROM)
+	if err != nil {
+		return err
+	}
+
+	rom, err := spectrum.ReadBetaROM(romPath)
+	if err != nil {
+		return err
+	}
+
+	device, err := spectrum.NewBeta128(rom)
+	if err != nil {
+		return err
+	}
+
+	if *trd != "" {
+		for i, path := range strings.Split(*trd, ",") {
+			err = device.InsertDisk(uint(i), strings.TrimSpace(path))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return speccy.AttachPeripheral(device)
+}
+
+// Connects the Multiface
+func setupMultiface(speccy *spectrum.Spectrum48k) error {
+	if *multiface == "" {
+		return nil
+	}
+
+	model, ok := spectrum.MultifaceModelNames[strings.ToLower(*multiface)]
+	if !ok {
+		return fmt.Errorf("unknown Multiface model \"%s\"", *multiface)
+	}
+
+	romName := *multifaceROM
+	if romName == "" {
+		romName = model.ROMFile()
+	}
+
+	romPath, err := spectrum.SystemRomPath(romName)
+	if err != nil {
+		return err
+	}
+
+	rom, err := spectrum.ReadMultifaceROM(romPath)
+	if err != nil {
+		return err
+	}
+
+	device, err := spectrum.NewMultiface(model, rom)
+	if err != nil {
+		return err
+	}
+
+	return speccy.AttachPeripheral(device)
+}
+
+// Connects the sound devices
+func setupSound(speccy *spectrum.Spectrum48k) error {
+	if *uspeech || (*uspeechROM != "") {
+		var rom []byte
+		if *uspeechROM != "" {
+			romPath, err := spectrum.SystemRomPath(*uspeechROM)
+			if err != nil {
+				return err
+			}
+			rom, err = spectrum.ReadUSpeechROM(romPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		device, err := spectrum.NewUSpeech(rom)
+		if err != nil {
+			return err
+		}
+		err = speccy.AttachPeripheral(device)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *specdrum {
+		err := speccy.AttachPeripheral(spectrum.NewSpecDrum())
+		if err != nil {
+			return err
+		}
+	}
+
+	if *covox != "" {
+		port, ok := spectrum.CovoxPortNames[strings.ToLower(*covox)]
+		if !ok {
+			return fmt.Errorf("unknown Covox port \"%s\"", *covox)
+		}
+		err := speccy.AttachPeripheral(spectrum.NewCovox(port))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Selects the joystick interfaces emulated by the gamepads
+func selectJoysticks(speccy *spectrum.Spectrum48k) error {
+	for i, name := range strings.Split(*joysticks, ",") {
+		iface, ok := spectrum.JoystickInterfaceNames[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown joystick interface \"%s\"", name)
+		}
+		speccy.Input.SetJoystickInterface(i, iface)
+	}
+	return nil
+}
+
+// Reads the keyboard and gamepad mapping profiles.
+// A missing file is an error only if the user specified it explicitly.
+func loadInputProfiles(app *spectrum.Application, speccy *spectrum.Spectrum48k) error {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "input-profiles" {
+			explicit = true
+		}
+	})
+
+	err := speccy.Input.ReadProfiles(*inputProfiles)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			err = nil
+		} else {
+			return err
+		}
+	} else if app.Verbose {
+		app.PrintfMsg("read input profiles from \"%s\"", *inputProfiles)
+	}
+
+	return speccy.Input.SetProfile(*inputProfile)
+}
+
 func main() {
 	var init_waitGroup sync.WaitGroup
 	env.PublishName("init WaitGroup", &init_waitGroup)
@@ -235,6 +552,87 @@ func main() {
 		return
 	}
 
+	err = loadInputProfiles(app, speccy)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		exit(app)
+		return
+	}
+
+	err = selectJoysticks(speccy)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		exit(app)
+		return
+	}
+
+	err = setupModel(speccy)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		exit(app)
+		return
+	}
+
+	err = setupInterface1(app, speccy)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		exit(app)
+		return
+	}
+
+	err = setupDiv(speccy)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		exit(app)
+		return
+	}
+
+	err = setupBeta128(speccy)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		exit(app)
+		return
+	}
+
+	err = setupMultiface(speccy)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		exit(app)
+		return
+	}
+
+	if *printer != "" {
+		model, ok := spectrum.PrinterModelNames[strings.ToLower(*printer)]
+		if !ok {
+			app.PrintfMsg("unknown printer \"%s\"", *printer)
+			exit(app)
+			return
+		}
+
+		err = speccy.AttachPeripheral(spectrum.NewPrinter(model))
+		if err != nil {
+			app.PrintfMsg("%s", err)
+			exit(app)
+			return
+		}
+	}
+
+	err = setupSound(speccy)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		exit(app)
+		return
+	}
+
+	if *esxdos != "" {
+		err = mountEsxDOS(speccy)
+		if err != nil {
+			app.PrintfMsg("%s", err)
+			exit(app)
+			return
+		}
+	}
+
 	// Run startup scripts.
 	// The startup scripts may change the display settings or enable/disable the audio.
 	// They may also terminate the program.
@@ -337,6 +735,19 @@ func main() {
 	// Set the FPS
 	speccy.CommandChannel <- spectrum.Cmd_SetFPS{float32(*fps), nil}
 
+	speccy.SetRewindBuffer(*rewind)
+
+	// Optional: Restore the session saved on the last exit
+	if *autosave {
+		if program_orNil == nil {
+			err := speccy.LoadAutosave()
+			if (err != nil) && !os.IsNotExist(err) {
+				app.PrintfMsg("autosave: %s", err)
+			}
+		}
+		speccy.SetAutosave(true)
+	}
+
 	// Optional: Load the program specified on the command-line
 	if program_orNil != nil {
 		program := program_orNil
@@ -357,5 +768,23 @@ func main() {
 		}
 	}
 
+	// Optional: Start netplay
+	if *netplayHost != "" {
+		go func() {
+			app.PrintfMsg("netplay: waiting for the other player on %s", *netplayHost)
+			err := speccy.NetplayHost(*netplayHost, *netplayDelay)
+			if err != nil {
+				app.PrintfMsg("netplay: %s", err)
+			}
+		}()
+	} else if *netplayJoin != "" {
+		err := speccy.NetplayJoin(*netplayJoin)
+		if err != nil {
+			app.PrintfMsg("netplay: %s", err)
+			exit(app)
+			return
+		}
+	}
+
 	wait(app)
 }
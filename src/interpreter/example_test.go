@@ -0,0 +1,59 @@
+package interpreter_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/remogatto/gospeccy/src/interpreter"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"sync"
+	"time"
+)
+
+// Runs two machines side by side, each scripted by its own interpreter
+func ExampleNewInterpreter() {
+	rom, err := spectrum.ReadROM("../../roms/48.rom")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	programs := []string{"../formats/testdata/fire.sna", "../formats/testdata/fire.z80"}
+	outputs := make([]bytes.Buffer, len(programs))
+
+	var waitGroup sync.WaitGroup
+	for i, program := range programs {
+		waitGroup.Add(1)
+		go func(i int, program string) {
+			defer waitGroup.Done()
+
+			app := spectrum.NewApplication()
+			speccy := spectrum.NewSpectrum48k(app, *rom)
+			intp := interpreter.NewInterpreter(app, "", speccy)
+			intp.SetStdout(&outputs[i])
+
+			intp.Run(fmt.Sprintf("var program string = %q", program))
+			intp.Run("load(program)")
+
+			// Emulate one second
+			for frame := 0; frame < 50; frame++ {
+				completionTime := make(chan time.Time)
+				speccy.CommandChannel <- spectrum.Cmd_RenderFrame{completionTime}
+				<-completionTime
+			}
+
+			intp.Run(`puts(program + " finished\n")`)
+
+			app.RequestExit()
+			<-app.HasTerminated
+		}(i, program)
+	}
+	waitGroup.Wait()
+
+	for _, output := range outputs {
+		fmt.Print(output.String())
+	}
+
+	// Output:
+	// ../formats/testdata/fire.sna finished
+	// ../formats/testdata/fire.z80 finished
+}
@@ -2,48 +2,97 @@ package interpreter
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/remogatto/gospeccy/src/formats"
 	"github.com/remogatto/gospeccy/src/spectrum"
 	"github.com/sbinet/go-eval"
 	"io/ioutil"
 	"net/url"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"time"
 )
 
-// Contains the names of all defined functions
-var definedFunctions = make(map[string]byte)
-
-func defineFunction(name string, t *eval.FuncType, f eval.FuncValue) {
-	w.DefineVar(name, t, f)
-	definedFunctions[name] = 0
+func (intp *Interpreter) defineFunction(name string, t *eval.FuncType, f eval.FuncValue) {
+	intp.w.DefineVar(name, t, f)
+	intp.definedFunctions[name] = 0
 }
 
+// A function implemented in Go. 'intp' is the interpreter running the script which called the function.
+type NativeFunction func(intp *Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value)
+
 type Function struct {
 	Name       string         // Name of the variable used to access the function
-	Type       *eval.FuncType // Function type
-	Value      eval.FuncValue // The function itself
+	Signature  interface{}    // A value of the Go function type, for example (func(uint))(nil)
+	Value      NativeFunction // The function itself
 	Help_key   string         // Help
 	Help_value string
 }
 
-var functionsToAdd []Function
+func (f *Function) check() error {
+	if f.Name == "" {
+		return errors.New("the function has no name")
+	}
+	if f.Value == nil {
+		return fmt.Errorf("function %s is nil", f.Name)
+	}
+	if (f.Signature == nil) || (reflect.TypeOf(f.Signature).Kind() != reflect.Func) {
+		return fmt.Errorf("function %s has an invalid signature", f.Name)
+	}
+	return nil
+}
+
+// The functions defined by the optional modules, available in all interpreters
+var extensionFunctions []Function
+
+// Defines a function in the interpreter created by 'Init', and in the interpreters created later
+func DefineFunction(f Function) error {
+	if err := f.check(); err != nil {
+		return err
+	}
 
-func DefineFunction(f Function) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	if w == nil {
-		// Postpone the function definition until interpreter initialization
-		functionsToAdd = append(functionsToAdd, f)
-	} else {
-		defineFunction(f.Name, f.Type, f.Value)
+	extensionFunctions = append(extensionFunctions, f)
+	if defaultInterpreter != nil {
+		defaultInterpreter.defineExtension(f)
+	}
+	return nil
+}
 
-		if (f.Help_key != "") && (f.Help_value != "") {
-			help_keys = append(help_keys, f.Help_key)
-			help_vals = append(help_vals, f.Help_value)
-		}
+// Defines a function in this interpreter only
+func (intp *Interpreter) DefineFunction(f Function) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	intp.defineExtension(f)
+	return nil
+}
+
+// Binds the function to this interpreter, and defines it.
+// Called while holding the mutex.
+func (intp *Interpreter) defineExtension(f Function) {
+	native := f.Value
+	wrapper := func(t *eval.Thread, in []eval.Value, out []eval.Value) {
+		native(intp, t, in, out)
+	}
+	funcType, funcValue := eval.FuncFromNativeTyped(wrapper, f.Signature)
+
+	intp.mutex.Lock()
+	defer intp.mutex.Unlock()
+
+	intp.defineFunction(f.Name, funcType, funcValue)
+
+	if (f.Help_key != "") && (f.Help_value != "") {
+		intp.help_keys = append(intp.help_keys, f.Help_key)
+		intp.help_vals = append(intp.help_vals, f.Help_value)
 	}
 }
 
@@ -51,31 +100,28 @@ func DefineFunction(f Function) {
 // Various commands
 // ================
 
-var help_keys []string
-var help_vals []string
-
 // Signature: func help()
-func wrapper_help(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	fmt.Fprintf(stdout, "\nAvailable commands:\n")
+func (intp *Interpreter) wrapper_help(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	fmt.Fprintf(intp.stdout, "\nAvailable commands:\n")
 
 	maxKeyLen := 1
-	for i := 0; i < len(help_keys); i++ {
-		if len(help_keys[i]) > maxKeyLen {
-			maxKeyLen = len(help_keys[i])
+	for i := 0; i < len(intp.help_keys); i++ {
+		if len(intp.help_keys[i]) > maxKeyLen {
+			maxKeyLen = len(intp.help_keys[i])
 		}
 	}
 
-	for i := 0; i < len(help_keys); i++ {
-		fmt.Fprintf(stdout, "  %s", help_keys[i])
-		for j := len(help_keys[i]); j < maxKeyLen; j++ {
-			fmt.Fprintf(stdout, " ")
+	for i := 0; i < len(intp.help_keys); i++ {
+		fmt.Fprintf(intp.stdout, "  %s", intp.help_keys[i])
+		for j := len(intp.help_keys[i]); j < maxKeyLen; j++ {
+			fmt.Fprintf(intp.stdout, " ")
 		}
-		fmt.Fprintf(stdout, "  %s\n", help_vals[i])
+		fmt.Fprintf(intp.stdout, "  %s\n", intp.help_vals[i])
 	}
 }
 
 // Signature: func exit()
-func wrapper_exit(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_exit(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	// Implementation note:
 	//   The following test has to be there only in cases in which something can go wrong.
 	//   For example if the user tried to execute "exit(); audio(false)" then GoSpeccy would panic.
@@ -86,14 +132,14 @@ func wrapper_exit(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	//   since it is potentially possible for the statement "audio(false)" to be hidden in a defer statement.
 	//   So, the best option (until somebody implements a better one) is to convert the problematic commands
 	//   into statements that are doing nothing while the application is in the process of being exited.
-	if app.TerminationInProgress() || app.Terminated() {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
-	app.RequestExit()
+	intp.app.RequestExit()
 }
 
 // Signature: func vars() []string
-func wrapper_vars(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_vars(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	vars := make([]eval.Value, 0, len(intp.vars))
 
 	for varName, _ := range intp.vars {
@@ -111,61 +157,69 @@ func wrapper_vars(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func definedFunction(name string) bool
-func wrapper_definedFunction(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_definedFunction(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	name := in[0].(eval.StringValue).Get(t)
-	_, defined := definedFunctions[name]
+	_, defined := intp.definedFunctions[name]
 	out[0].(eval.BoolValue).Set(t, defined)
 }
 
 // Signature: func reset()
-func wrapper_reset(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_reset(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 	romLoaded := make(chan (<-chan bool))
-	speccy.CommandChannel <- spectrum.Cmd_Reset{romLoaded}
+	intp.speccy.CommandChannel <- spectrum.Cmd_Reset{romLoaded}
 	<-(<-romLoaded)
 }
 
+// Signature: func nmi()
+func (intp *Interpreter) wrapper_nmi(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+	intp.speccy.NMI()
+}
+
 // Signature: func addSearchPath(path string)
-func wrapper_addSearchPath(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_addSearchPath(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	path := in[0].(eval.StringValue).Get(t)
 	spectrum.AddCustomSearchPath(path)
 }
 
 // Signature: func setDownloadPath(path string)
-func wrapper_setDownloadPath(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_setDownloadPath(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	path := in[0].(eval.StringValue).Get(t)
 	spectrum.SetDownloadPath(path)
 }
 
-func load(path string) {
+func (intp *Interpreter) load(path string) {
 	var program interface{}
 	program, err := formats.ReadProgram(path)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 
 	if _, isTAP := program.(*formats.TAP); isTAP {
 		romLoaded := make(chan (<-chan bool))
-		speccy.CommandChannel <- spectrum.Cmd_Reset{romLoaded}
+		intp.speccy.CommandChannel <- spectrum.Cmd_Reset{romLoaded}
 		<-(<-romLoaded)
 	}
 
 	errChan := make(chan error)
-	speccy.CommandChannel <- spectrum.Cmd_Load{path, program, errChan}
+	intp.speccy.CommandChannel <- spectrum.Cmd_Load{path, program, errChan}
 
 	err = <-errChan
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 }
 
 // Signature: func load(path string)
-func wrapper_load(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_load(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
@@ -174,70 +228,83 @@ func wrapper_load(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	var err error
 	path, err = spectrum.ProgramPath(path)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	intp.load(path)
+}
+
+// Signature: func cartridgeEject()
+func (intp *Interpreter) wrapper_cartridgeEject(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
-	load(path)
+	err := intp.speccy.EjectCartridge()
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
 }
 
 // Signature: func cmdLineArg() string
-func wrapper_cmdLineArg(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	out[0].(eval.StringValue).Set(t, cmdLineArg)
+func (intp *Interpreter) wrapper_cmdLineArg(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	out[0].(eval.StringValue).Set(t, intp.cmdLineArg)
 }
 
 // Signature: func save(path string)
-func wrapper_save(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_save(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	path := in[0].(eval.StringValue).Get(t)
 
 	ch := make(chan *formats.FullSnapshot)
-	speccy.CommandChannel <- spectrum.Cmd_MakeSnapshot{ch}
+	intp.speccy.CommandChannel <- spectrum.Cmd_MakeSnapshot{ch}
 
 	fullSnapshot := <-ch
 
 	data, err := fullSnapshot.EncodeSNA()
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 
 	err = ioutil.WriteFile(path, data, 0600)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 	}
 
-	if app.Verbose {
-		fmt.Fprintf(stdout, "wrote SNA snapshot \"%s\"", path)
+	if intp.app.Verbose {
+		fmt.Fprintf(intp.stdout, "wrote SNA snapshot \"%s\"", path)
 	}
 }
 
 // Signature: func fps(n float32)
-func wrapper_fps(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_fps(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	fps := in[0].(eval.FloatValue).Get(t)
-	speccy.CommandChannel <- spectrum.Cmd_SetFPS{float32(fps), nil}
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetFPS{float32(fps), nil}
 }
 
 // Signature: func ula_accuracy(accurateEmulation bool)
-func wrapper_ulaAccuracy(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_ulaAccuracy(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	accurateEmulation := in[0].(eval.BoolValue).Get(t)
-	speccy.CommandChannel <- spectrum.Cmd_SetUlaEmulationAccuracy{accurateEmulation}
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetUlaEmulationAccuracy{accurateEmulation}
 }
 
 // Signature: func wait(milliseconds uint)
-func wrapper_wait(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_wait(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
@@ -246,9 +313,9 @@ func wrapper_wait(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func script(scriptName string)
-func wrapper_script(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_script(t *eval.Thread, in []eval.Value, out []eval.Value) {
 
-	if app.TerminationInProgress() || app.Terminated() {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
@@ -257,66 +324,677 @@ func wrapper_script(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	var err error
 	path, err = spectrum.ScriptPath(path)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 
-	err = runScript(w, path, false /*optional*/)
+	err = intp.runScript(path, false /*optional*/)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 }
 
 // Signature: func optionalScript(scriptName string)
-func wrapper_optionalScript(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_optionalScript(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	scriptName := in[0].(eval.StringValue).Get(t)
 
-	err := runScript(w, scriptName, true /*optional*/)
+	err := intp.runScript(scriptName, true /*optional*/)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 		return
 	}
 }
 
 // Signature: func screenshot(screenshotName string)
-func wrapper_screenshot(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_screenshot(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	path := in[0].(eval.StringValue).Get(t)
 
 	ch := make(chan []byte)
-	speccy.CommandChannel <- spectrum.Cmd_MakeVideoMemoryDump{ch}
+	intp.speccy.CommandChannel <- spectrum.Cmd_MakeVideoMemoryDump{ch}
 
 	data := <-ch
 
 	err := ioutil.WriteFile(path, data, 0600)
 
 	if err != nil {
-		fmt.Fprintf(stdout, "%s\n", err)
+		fmt.Fprintf(intp.stdout, "%s\n", err)
 	}
 
-	if app.Verbose {
-		fmt.Fprintf(stdout, "wrote screenshot \"%s\"", path)
+	if intp.app.Verbose {
+		fmt.Fprintf(intp.stdout, "wrote screenshot \"%s\"", path)
 	}
 }
 
 // Signature: func puts(str string)
-func wrapper_puts(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func (intp *Interpreter) wrapper_puts(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	str := in[0].(eval.StringValue).Get(t)
-	fmt.Fprintf(stdout, "%s", str)
+	fmt.Fprintf(intp.stdout, "%s", str)
 }
 
 // Signature: func acceleratedLoad(on bool)
-func wrapper_acceleratedLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_acceleratedLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	enable := in[0].(eval.BoolValue).Get(t)
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetAcceleratedLoad{enable}
+}
+
+// Signature: func issue2(on bool)
+func (intp *Interpreter) wrapper_issue2(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	enable := in[0].(eval.BoolValue).Get(t)
+	intp.speccy.CommandChannel <- spectrum.Cmd_SetIssue2{enable}
+}
+
+// Signature: func rewind(seconds float32)
+func (intp *Interpreter) wrapper_rewind(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	seconds := in[0].(eval.FloatValue).Get(t)
+	err := intp.speccy.Rewind(float32(seconds))
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
+}
+
+// Signature: func forward(seconds float32)
+func (intp *Interpreter) wrapper_forward(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	seconds := in[0].(eval.FloatValue).Get(t)
+	err := intp.speccy.Forward(float32(seconds))
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
+}
+
+// Signature: func rewindBuffer(seconds uint)
+func (intp *Interpreter) wrapper_rewindBuffer(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	seconds := in[0].(eval.UintValue).Get(t)
+	intp.speccy.SetRewindBuffer(uint(seconds))
+}
+
+// Signature: func quickSave(slot uint)
+func (intp *Interpreter) wrapper_quickSave(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	slot := in[0].(eval.UintValue).Get(t)
+	_, err := intp.speccy.QuickSave(uint(slot))
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	if intp.app.Verbose {
+		fmt.Fprintf(intp.stdout, "saved quick-save slot %d", slot)
+	}
+}
+
+// Signature: func quickLoad(slot uint)
+func (intp *Interpreter) wrapper_quickLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	slot := in[0].(eval.UintValue).Get(t)
+	err := intp.speccy.QuickLoad(uint(slot))
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
+}
+
+// Signature: func quickSaves()
+func (intp *Interpreter) wrapper_quickSaves(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	slots, err := intp.speccy.QuickSaves()
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	if len(slots) == 0 {
+		fmt.Fprintf(intp.stdout, "all quick-save slots are empty\n")
+	}
+	for _, q := range slots {
+		fmt.Fprintf(intp.stdout, "%d: %s\n", q.Slot, q.Time.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// Signature: func autosave(on bool)
+func (intp *Interpreter) wrapper_autosave(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	enable := in[0].(eval.BoolValue).Get(t)
-	speccy.CommandChannel <- spectrum.Cmd_SetAcceleratedLoad{enable}
+	intp.speccy.SetAutosave(enable)
+}
+
+// Signature: func netplayHost(address string, delay uint)
+func (intp *Interpreter) wrapper_netplayHost(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	address := in[0].(eval.StringValue).Get(t)
+	delay := in[1].(eval.UintValue).Get(t)
+
+	// Waiting for the other player would block the interpreter
+	go func() {
+		err := intp.speccy.NetplayHost(address, uint(delay))
+		if err != nil {
+			intp.app.PrintfMsg("netplay: %s", err)
+		} else if intp.app.Verbose {
+			intp.app.PrintfMsg("netplay: started")
+		}
+	}()
+}
+
+// Signature: func netplayJoin(address string)
+func (intp *Interpreter) wrapper_netplayJoin(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	address := in[0].(eval.StringValue).Get(t)
+	err := intp.speccy.NetplayJoin(address)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+	}
+}
+
+// Signature: func netplayStop()
+func (intp *Interpreter) wrapper_netplayStop(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	intp.speccy.NetplayStop()
+}
+
+// Signature: func typeText(text string)
+func (intp *Interpreter) wrapper_typeText(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	text := in[0].(eval.StringValue).Get(t)
+
+	// Typing a long text takes a while, do not block the interpreter
+	go intp.speccy.Keyboard.TypeText(text, true /*basic*/)
+}
+
+// Signature: func loadInputProfiles(path string)
+func (intp *Interpreter) wrapper_loadInputProfiles(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	path := in[0].(eval.StringValue).Get(t)
+
+	err := intp.speccy.Input.ReadProfiles(path)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func inputProfile(name string)
+func (intp *Interpreter) wrapper_inputProfile(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	name := in[0].(eval.StringValue).Get(t)
+
+	err := intp.speccy.Input.SetProfile(name)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func inputProfiles()
+func (intp *Interpreter) wrapper_inputProfiles(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	active := intp.speccy.Input.ActiveProfile()
+	for _, name := range intp.speccy.Input.ProfileNames() {
+		if name == active {
+			fmt.Fprintf(intp.stdout, "* %s\n", name)
+		} else {
+			fmt.Fprintf(intp.stdout, "  %s\n", name)
+		}
+	}
+}
+
+// Signature: func bind(input string, actions string)
+func (intp *Interpreter) wrapper_bind(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	input := in[0].(eval.StringValue).Get(t)
+	actions := in[1].(eval.StringValue).Get(t)
+
+	err := intp.speccy.Input.Bind(input, actions)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func unbind(input string)
+func (intp *Interpreter) wrapper_unbind(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	input := in[0].(eval.StringValue).Get(t)
+
+	err := intp.speccy.Input.Unbind(input)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func joystick(gamepad uint, iface string)
+func (intp *Interpreter) wrapper_joystick(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	gamepad := in[0].(eval.UintValue).Get(t)
+	name := in[1].(eval.StringValue).Get(t)
+
+	iface, ok := spectrum.JoystickInterfaceNames[name]
+	if !ok {
+		fmt.Fprintf(intp.stdout, "unknown joystick interface \"%s\"\n", name)
+		return
+	}
+
+	err := intp.speccy.Input.SetJoystickInterface(int(gamepad), iface)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func mouseSensitivity(s float32)
+func (intp *Interpreter) wrapper_mouseSensitivity(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	sensitivity := in[0].(eval.FloatValue).Get(t)
+
+	err := intp.speccy.Mouse.SetSensitivity(float32(sensitivity))
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func palette(name string)
+func (intp *Interpreter) wrapper_palette(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	name := in[0].(eval.StringValue).Get(t)
+
+	palette, err := spectrum.GetPalette(name)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	intp.app.SetPalette(palette)
+}
+
+// Returns the connected Interface 1
+func (intp *Interpreter) interface1() (*spectrum.Interface1, error) {
+	if1, ok := intp.speccy.Peripheral("if1").(*spectrum.Interface1)
+	if !ok {
+		return nil, errors.New("the Interface 1 is not connected")
+	}
+	return if1, nil
+}
+
+// Signature: func mdrInsert(drive uint, path string)
+func (intp *Interpreter) wrapper_mdrInsert(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	drive := in[0].(eval.UintValue).Get(t)
+	path := in[1].(eval.StringValue).Get(t)
+
+	if1, err := intp.interface1()
+	if err == nil {
+		err = if1.InsertCartridge(uint(drive), path)
+	}
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func mdrEject(drive uint)
+func (intp *Interpreter) wrapper_mdrEject(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	drive := in[0].(eval.UintValue).Get(t)
+
+	if1, err := intp.interface1()
+	if err == nil {
+		err = if1.EjectCartridge(uint(drive))
+	}
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func mdrWriteProtect(drive uint, protect bool)
+func (intp *Interpreter) wrapper_mdrWriteProtect(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	drive := in[0].(eval.UintValue).Get(t)
+	protect := in[1].(eval.BoolValue).Get(t)
+
+	if1, err := intp.interface1()
+	if err == nil {
+		err = if1.SetWriteProtect(uint(drive), protect)
+	}
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func esxdosMount(dir string)
+func (intp *Interpreter) wrapper_esxdosMount(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	dir := in[0].(eval.StringValue).Get(t)
+
+	esx, err := spectrum.NewEsxDOS(dir)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	if intp.speccy.Peripheral(esx.Name()) != nil {
+		intp.speccy.DetachPeripheral(esx.Name())
+	}
+
+	err = intp.speccy.AttachPeripheral(esx)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func esxdosUnmount()
+func (intp *Interpreter) wrapper_esxdosUnmount(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	err := intp.speccy.DetachPeripheral("esxdos")
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func model(name string)
+func (intp *Interpreter) wrapper_model(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	name := in[0].(eval.StringValue).Get(t)
+
+	model, ok := spectrum.ModelNames[strings.ToLower(name)]
+	if !ok {
+		fmt.Fprintf(intp.stdout, "unknown model \"%s\"\n", name)
+		return
+	}
+
+	romPath, err := spectrum.SystemRomPath(model.ROMFile())
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	roms, err := spectrum.ReadModelROMs(model, romPath)
+	if err == nil {
+		err = intp.speccy.SetModel(model, roms)
+	}
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Converts the name of a disk drive ("a", "b:", ...) to its number
+func diskDrive(name string) (uint, error) {
+	switch strings.TrimSuffix(strings.ToLower(name), ":") {
+	case "a":
+		return 0, nil
+	case "b":
+		return 1, nil
+	case "c":
+		return 2, nil
+	case "d":
+		return 3, nil
+	}
+	return 0, fmt.Errorf("invalid disk drive \"%s\"", name)
+}
+
+// Signature: func diskInsert(drive string, path string)
+func (intp *Interpreter) wrapper_diskInsert(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	name := in[0].(eval.StringValue).Get(t)
+	path := in[1].(eval.StringValue).Get(t)
+
+	drive, err := diskDrive(name)
+	if err == nil {
+		err = intp.speccy.InsertDisk(drive, path)
+	}
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func diskEject(drive string)
+func (intp *Interpreter) wrapper_diskEject(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	name := in[0].(eval.StringValue).Get(t)
+
+	drive, err := diskDrive(name)
+	if err == nil {
+		err = intp.speccy.EjectDisk(drive)
+	}
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Returns the connected Beta 128
+func (intp *Interpreter) beta128() (*spectrum.Beta128, error) {
+	beta, ok := intp.speccy.Peripheral("beta128").(*spectrum.Beta128)
+	if !ok {
+		return nil, errors.New("the Beta 128 is not connected")
+	}
+	return beta, nil
+}
+
+// Signature: func trdInsert(drive string, path string)
+func (intp *Interpreter) wrapper_trdInsert(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	name := in[0].(eval.StringValue).Get(t)
+	path := in[1].(eval.StringValue).Get(t)
+
+	beta, err := intp.beta128()
+	if err == nil {
+		var drive uint
+		drive, err = diskDrive(name)
+		if err == nil {
+			err = beta.InsertDisk(drive, path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func trdEject(drive string)
+func (intp *Interpreter) wrapper_trdEject(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	name := in[0].(eval.StringValue).Get(t)
+
+	beta, err := intp.beta128()
+	if err == nil {
+		var drive uint
+		drive, err = diskDrive(name)
+		if err == nil {
+			err = beta.EjectDisk(drive)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Returns the connected printer
+func (intp *Interpreter) printer() (*spectrum.Printer, error) {
+	p, ok := intp.speccy.Peripheral("printer").(*spectrum.Printer)
+	if !ok {
+		return nil, errors.New("no printer is connected")
+	}
+	return p, nil
+}
+
+// Signature: func printerSave(path string)
+func (intp *Interpreter) wrapper_printerSave(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	path := in[0].(eval.StringValue).Get(t)
+
+	p, err := intp.printer()
+	if err == nil {
+		if strings.ToLower(filepath.Ext(path)) == ".txt" {
+			err = ioutil.WriteFile(path, []byte(p.Text()+"\n"), 0644)
+		} else {
+			err = p.SavePNG(path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+}
+
+// Signature: func printerClear()
+func (intp *Interpreter) wrapper_printerClear(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
+		return
+	}
+
+	p, err := intp.printer()
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+	p.Clear()
+}
+
+// Signature: func dskCatalogue(path string)
+func (intp *Interpreter) wrapper_dskCatalogue(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	path := in[0].(eval.StringValue).Get(t)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s\n", err)
+		return
+	}
+
+	dsk, err := formats.NewDSK(data)
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s: %s\n", path, err)
+		return
+	}
+
+	files, err := dsk.Catalogue()
+	if err != nil {
+		fmt.Fprintf(intp.stdout, "%s: %s\n", path, err)
+		return
+	}
+
+	for _, file := range files {
+		var flags string
+		if file.ReadOnly {
+			flags += " (read-only)"
+		}
+		if file.System {
+			flags += " (system)"
+		}
+		fmt.Fprintf(intp.stdout, "%2d %-12s %7d%s\n", file.User, file.Name, file.Size, flags)
+	}
+	fmt.Fprintf(intp.stdout, "%d files\n", len(files))
 }
 
 type WOS struct {
@@ -354,8 +1032,8 @@ func url_printer(URL eval.Value) string {
 }
 
 // Signature: func wosFind(pattern string) []WOS
-func wrapper_wosFind(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_wosFind(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
@@ -363,9 +1041,9 @@ func wrapper_wosFind(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	pattern = strings.Replace(pattern, " ", "*", -1)
 
 	var records []spectrum.WosRecord
-	records, err := spectrum.WosQuery(app, "regexp="+url.QueryEscape(pattern))
+	records, err := spectrum.WosQuery(intp.app, "regexp="+url.QueryEscape(pattern))
 	if err != nil {
-		fmt.Fprintf(stdout, "%s", err)
+		fmt.Fprintf(intp.stdout, "%s", err)
 
 		var emptySlice eval.Slice
 		out[0].(eval.SliceValue).Set(t, emptySlice)
@@ -401,15 +1079,15 @@ func wrapper_wosFind(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func wosDownload(wos WOS) string
-func wrapper_wosDownload(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_wosDownload(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	var url string = in[0].(eval.StructValue).Field(t, 0).(eval.StringValue).Get(t)
-	filePath, err := spectrum.WosGet(app, stdout, url)
+	filePath, err := spectrum.WosGet(intp.app, intp.stdout, url)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s", err)
+		fmt.Fprintf(intp.stdout, "%s", err)
 		out[0].(eval.StringValue).Set(t, "")
 		return
 	}
@@ -418,181 +1096,421 @@ func wrapper_wosDownload(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func wosLoad(wos WOS)
-func wrapper_wosLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
-	if app.TerminationInProgress() || app.Terminated() {
+func (intp *Interpreter) wrapper_wosLoad(t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if intp.app.TerminationInProgress() || intp.app.Terminated() {
 		return
 	}
 
 	var url string = in[0].(eval.StructValue).Field(t, 0).(eval.StringValue).Get(t)
-	filePath, err := spectrum.WosGet(app, stdout, url)
+	filePath, err := spectrum.WosGet(intp.app, intp.stdout, url)
 	if err != nil {
-		fmt.Fprintf(stdout, "%s", err)
+		fmt.Fprintf(intp.stdout, "%s", err)
 		return
 	}
 
-	load(filePath)
+	intp.load(filePath)
 }
 
 // ==============
 // Initialization
 // ==============
 
-func defineFunctions(w *eval.World) {
+// Called while holding the mutex
+func (intp *Interpreter) defineFunctions() {
 	{
 		var functionSignature func()
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_help, functionSignature)
-		defineFunction("help", funcType, funcValue)
-		help_keys = append(help_keys, "help()")
-		help_vals = append(help_vals, "This help")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_help, functionSignature)
+		intp.defineFunction("help", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "help()")
+		intp.help_vals = append(intp.help_vals, "This help")
 	}
 	{
 		var functionSignature func()
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_exit, functionSignature)
-		defineFunction("exit", funcType, funcValue)
-		help_keys = append(help_keys, "exit()")
-		help_vals = append(help_vals, "Terminate this program")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_exit, functionSignature)
+		intp.defineFunction("exit", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "exit()")
+		intp.help_vals = append(intp.help_vals, "Terminate this program")
 	}
 	{
 		var functionSignature func() []string
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_vars, functionSignature)
-		defineFunction("vars", funcType, funcValue)
-		help_keys = append(help_keys, "vars()")
-		help_vals = append(help_vals, "Get the names of all variables")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_vars, functionSignature)
+		intp.defineFunction("vars", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "vars()")
+		intp.help_vals = append(intp.help_vals, "Get the names of all variables")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_reset, functionSignature)
+		intp.defineFunction("reset", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "reset()")
+		intp.help_vals = append(intp.help_vals, "Reset the emulated machine")
 	}
 	{
 		var functionSignature func()
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_reset, functionSignature)
-		defineFunction("reset", funcType, funcValue)
-		help_keys = append(help_keys, "reset()")
-		help_vals = append(help_vals, "Reset the emulated machine")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_nmi, functionSignature)
+		intp.defineFunction("nmi", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "nmi()")
+		intp.help_vals = append(intp.help_vals, "Generate a non-maskable interrupt (the Multiface button)")
 	}
 	{
 		var functionSignature func(string) bool
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_definedFunction, functionSignature)
-		defineFunction("definedFunction", funcType, funcValue)
-		help_keys = append(help_keys, "definedFunction(name string) bool")
-		help_vals = append(help_vals, "Returns whether a Go function exists")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_definedFunction, functionSignature)
+		intp.defineFunction("definedFunction", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "definedFunction(name string) bool")
+		intp.help_vals = append(intp.help_vals, "Returns whether a Go function exists")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_addSearchPath, functionSignature)
-		defineFunction("addSearchPath", funcType, funcValue)
-		help_keys = append(help_keys, "addSearchPath(path string)")
-		help_vals = append(help_vals, "Append to the paths searched when loading snapshots, scripts, etc")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_addSearchPath, functionSignature)
+		intp.defineFunction("addSearchPath", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "addSearchPath(path string)")
+		intp.help_vals = append(intp.help_vals, "Append to the paths searched when loading snapshots, scripts, etc")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_setDownloadPath, functionSignature)
-		defineFunction("setDownloadPath", funcType, funcValue)
-		help_keys = append(help_keys, "setDownloadPath(path string)")
-		help_vals = append(help_vals, `Set path where to download files (""=default path)`)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_setDownloadPath, functionSignature)
+		intp.defineFunction("setDownloadPath", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "setDownloadPath(path string)")
+		intp.help_vals = append(intp.help_vals, `Set path where to download files (""=default path)`)
 	}
 	{
 		var functionSignature func() string
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_cmdLineArg, functionSignature)
-		defineFunction("cmdLineArg", funcType, funcValue)
-		help_keys = append(help_keys, "cmdLineArg() string)")
-		help_vals = append(help_vals, "The 1st non-flag command-line argument, or an empty string")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_cmdLineArg, functionSignature)
+		intp.defineFunction("cmdLineArg", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "cmdLineArg() string)")
+		intp.help_vals = append(intp.help_vals, "The 1st non-flag command-line argument, or an empty string")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_load, functionSignature)
-		defineFunction("load", funcType, funcValue)
-		help_keys = append(help_keys, "load(path string)")
-		help_vals = append(help_vals, "Load state from file (.SNA, .Z80, .Z80.ZIP, etc)")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_load, functionSignature)
+		intp.defineFunction("load", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "load(path string)")
+		intp.help_vals = append(intp.help_vals, "Load state from file (.SNA, .Z80, .Z80.ZIP, etc)")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_cartridgeEject, functionSignature)
+		intp.defineFunction("cartridgeEject", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "cartridgeEject()")
+		intp.help_vals = append(intp.help_vals, "Eject the Interface 2 ROM cartridge (loaded from a .ROM file) and reset")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_save, functionSignature)
-		defineFunction("save", funcType, funcValue)
-		help_keys = append(help_keys, "save(path string)")
-		help_vals = append(help_vals, "Save state to file (SNA format)")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_save, functionSignature)
+		intp.defineFunction("save", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "save(path string)")
+		intp.help_vals = append(intp.help_vals, "Save state to file (SNA format)")
 	}
 	{
 		var functionSignature func(float32)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_fps, functionSignature)
-		defineFunction("fps", funcType, funcValue)
-		help_keys = append(help_keys, "fps(n float32)")
-		help_vals = append(help_vals, "Change the display refresh frequency (0=default FPS)")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_fps, functionSignature)
+		intp.defineFunction("fps", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "fps(n float32)")
+		intp.help_vals = append(intp.help_vals, "Change the display refresh frequency (0=the frame rate of the model)")
 	}
 	{
 		var functionSignature func(bool)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_ulaAccuracy, functionSignature)
-		defineFunction("ula", funcType, funcValue)
-		help_keys = append(help_keys, "ula(accurateEmulation bool)")
-		help_vals = append(help_vals, "Enable/disable accurate ULA emulation")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_ulaAccuracy, functionSignature)
+		intp.defineFunction("ula", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "ula(accurateEmulation bool)")
+		intp.help_vals = append(intp.help_vals, "Enable/disable accurate ULA emulation")
 	}
 	{
 		var functionSignature func(uint)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_wait, functionSignature)
-		defineFunction("wait", funcType, funcValue)
-		help_keys = append(help_keys, "wait(milliseconds uint)")
-		help_vals = append(help_vals, "Wait before executing the next command")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_wait, functionSignature)
+		intp.defineFunction("wait", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "wait(milliseconds uint)")
+		intp.help_vals = append(intp.help_vals, "Wait before executing the next command")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_script, functionSignature)
-		defineFunction("script", funcType, funcValue)
-		help_keys = append(help_keys, "script(scriptName string)")
-		help_vals = append(help_vals, "Load and evaluate the specified Go script")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_script, functionSignature)
+		intp.defineFunction("script", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "script(scriptName string)")
+		intp.help_vals = append(intp.help_vals, "Load and evaluate the specified Go script")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_optionalScript, functionSignature)
-		defineFunction("optionalScript", funcType, funcValue)
-		help_keys = append(help_keys, "optionalScript(scriptName string)")
-		help_vals = append(help_vals, "Load (if found) and evaluate the specified Go script")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_optionalScript, functionSignature)
+		intp.defineFunction("optionalScript", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "optionalScript(scriptName string)")
+		intp.help_vals = append(intp.help_vals, "Load (if found) and evaluate the specified Go script")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_screenshot, functionSignature)
-		defineFunction("screenshot", funcType, funcValue)
-		help_keys = append(help_keys, "screenshot(screenshotName string)")
-		help_vals = append(help_vals, "Take a screenshot of the current display")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_screenshot, functionSignature)
+		intp.defineFunction("screenshot", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "screenshot(screenshotName string)")
+		intp.help_vals = append(intp.help_vals, "Take a screenshot of the current display")
 	}
 	{
 		var functionSignature func(string)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_puts, functionSignature)
-		defineFunction("puts", funcType, funcValue)
-		help_keys = append(help_keys, "puts(str string)")
-		help_vals = append(help_vals, "Print the given string")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_puts, functionSignature)
+		intp.defineFunction("puts", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "puts(str string)")
+		intp.help_vals = append(intp.help_vals, "Print the given string")
+	}
+	{
+		var functionSignature func(bool)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_acceleratedLoad, functionSignature)
+		intp.defineFunction("acceleratedLoad", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "acceleratedLoad(on bool)")
+		intp.help_vals = append(intp.help_vals, "Set accelerated tape load on/off")
+	}
+	{
+		var functionSignature func(bool)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_issue2, functionSignature)
+		intp.defineFunction("issue2", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "issue2(on bool)")
+		intp.help_vals = append(intp.help_vals, "Emulate the keyboard port of an Issue 2 (on) or Issue 3 (off) Spectrum")
+	}
+	{
+		var functionSignature func(float32)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_rewind, functionSignature)
+		intp.defineFunction("rewind", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "rewind(seconds float32)")
+		intp.help_vals = append(intp.help_vals, "Return to the state the emulation had the specified time ago")
+	}
+	{
+		var functionSignature func(float32)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_forward, functionSignature)
+		intp.defineFunction("forward", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "forward(seconds float32)")
+		intp.help_vals = append(intp.help_vals, "Undo rewinding by the specified time")
+	}
+	{
+		var functionSignature func(uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_rewindBuffer, functionSignature)
+		intp.defineFunction("rewindBuffer", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "rewindBuffer(seconds uint)")
+		intp.help_vals = append(intp.help_vals, "Set the length of the rewind buffer (0 disables rewinding)")
+	}
+	{
+		var functionSignature func(uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_quickSave, functionSignature)
+		intp.defineFunction("quickSave", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "quickSave(slot uint)")
+		intp.help_vals = append(intp.help_vals, "Save the state of the emulation into a quick-save slot (1-9)")
+	}
+	{
+		var functionSignature func(uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_quickLoad, functionSignature)
+		intp.defineFunction("quickLoad", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "quickLoad(slot uint)")
+		intp.help_vals = append(intp.help_vals, "Restore the state saved in a quick-save slot")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_quickSaves, functionSignature)
+		intp.defineFunction("quickSaves", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "quickSaves()")
+		intp.help_vals = append(intp.help_vals, "List the quick-save slots and the times they were saved")
 	}
 	{
 		var functionSignature func(bool)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_acceleratedLoad, functionSignature)
-		defineFunction("acceleratedLoad", funcType, funcValue)
-		help_keys = append(help_keys, "acceleratedLoad(on bool)")
-		help_vals = append(help_vals, "Set accelerated tape load on/off")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_autosave, functionSignature)
+		intp.defineFunction("autosave", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "autosave(on bool)")
+		intp.help_vals = append(intp.help_vals, "Save the session on exit, it is restored on the next start")
+	}
+	{
+		var functionSignature func(string, uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_netplayHost, functionSignature)
+		intp.defineFunction("netplayHost", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "netplayHost(address string, delay uint)")
+		intp.help_vals = append(intp.help_vals, "Wait for another player to connect (e.g. \":7000\"), with the input delay in frames")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_netplayJoin, functionSignature)
+		intp.defineFunction("netplayJoin", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "netplayJoin(address string)")
+		intp.help_vals = append(intp.help_vals, "Connect to another player (e.g. \"localhost:7000\")")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_netplayStop, functionSignature)
+		intp.defineFunction("netplayStop", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "netplayStop()")
+		intp.help_vals = append(intp.help_vals, "Disconnect from the other player")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_typeText, functionSignature)
+		intp.defineFunction("typeText", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "typeText(text string)")
+		intp.help_vals = append(intp.help_vals, "Type the text (for example a BASIC listing) on the Spectrum keyboard")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_loadInputProfiles, functionSignature)
+		intp.defineFunction("loadInputProfiles", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "loadInputProfiles(path string)")
+		intp.help_vals = append(intp.help_vals, "Read keyboard and gamepad mapping profiles from the file")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_inputProfile, functionSignature)
+		intp.defineFunction("inputProfile", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "inputProfile(name string)")
+		intp.help_vals = append(intp.help_vals, "Switch to the keyboard and gamepad mapping profile")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_inputProfiles, functionSignature)
+		intp.defineFunction("inputProfiles", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "inputProfiles()")
+		intp.help_vals = append(intp.help_vals, "List the mapping profiles, the active one is marked with '*'")
+	}
+	{
+		var functionSignature func(string, string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_bind, functionSignature)
+		intp.defineFunction("bind", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "bind(input string, actions string)")
+		intp.help_vals = append(intp.help_vals, "Bind a host key or gamepad input in the active profile (ex: bind(\"joy0:button1\", \"space\"))")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_unbind, functionSignature)
+		intp.defineFunction("unbind", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "unbind(input string)")
+		intp.help_vals = append(intp.help_vals, "Remove the binding from the active profile")
+	}
+	{
+		var functionSignature func(uint, string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_joystick, functionSignature)
+		intp.defineFunction("joystick", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "joystick(gamepad uint, iface string)")
+		intp.help_vals = append(intp.help_vals, "Select the joystick emulated by the gamepad: kempston, sinclair1, sinclair2, cursor or fuller")
+	}
+	{
+		var functionSignature func(float32)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_mouseSensitivity, functionSignature)
+		intp.defineFunction("mouseSensitivity", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "mouseSensitivity(s float32)")
+		intp.help_vals = append(intp.help_vals, "Set the Kempston mouse sensitivity (mouse units per Spectrum pixel)")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_palette, functionSignature)
+		intp.defineFunction("palette", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "palette(name string)")
+		intp.help_vals = append(intp.help_vals, "Change the color palette (default, bright, greyscale, green, or a palette file)")
+	}
+	{
+		var functionSignature func(uint, string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_mdrInsert, functionSignature)
+		intp.defineFunction("mdrInsert", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "mdrInsert(drive uint, path string)")
+		intp.help_vals = append(intp.help_vals, "Insert a Microdrive cartridge into the Interface 1 drive (1..8)")
+	}
+	{
+		var functionSignature func(uint)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_mdrEject, functionSignature)
+		intp.defineFunction("mdrEject", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "mdrEject(drive uint)")
+		intp.help_vals = append(intp.help_vals, "Eject the Microdrive cartridge, saving it if it has been modified")
+	}
+	{
+		var functionSignature func(uint, bool)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_mdrWriteProtect, functionSignature)
+		intp.defineFunction("mdrWriteProtect", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "mdrWriteProtect(drive uint, protect bool)")
+		intp.help_vals = append(intp.help_vals, "Set the write-protect tab of the Microdrive cartridge")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_esxdosMount, functionSignature)
+		intp.defineFunction("esxdosMount", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "esxdosMount(dir string)")
+		intp.help_vals = append(intp.help_vals, "Serve the esxDOS API (RST 8) from the host directory")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_esxdosUnmount, functionSignature)
+		intp.defineFunction("esxdosUnmount", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "esxdosUnmount()")
+		intp.help_vals = append(intp.help_vals, "Stop serving the esxDOS API from the host directory")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_model, functionSignature)
+		intp.defineFunction("model", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "model(name string)")
+		intp.help_vals = append(intp.help_vals, "Switch to the Spectrum model (16k, 48k, 48k-ntsc, pentagon, plus2a, plus3) and reset")
+	}
+	{
+		var functionSignature func(string, string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_diskInsert, functionSignature)
+		intp.defineFunction("diskInsert", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "diskInsert(drive string, path string)")
+		intp.help_vals = append(intp.help_vals, "Insert a DSK disk image into the +3 drive (a, b)")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_diskEject, functionSignature)
+		intp.defineFunction("diskEject", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "diskEject(drive string)")
+		intp.help_vals = append(intp.help_vals, "Eject the disk from the +3 drive (a, b)")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_dskCatalogue, functionSignature)
+		intp.defineFunction("dskCatalogue", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "dskCatalogue(path string)")
+		intp.help_vals = append(intp.help_vals, "List the files on a +3DOS disk image")
+	}
+	{
+		var functionSignature func(string, string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_trdInsert, functionSignature)
+		intp.defineFunction("trdInsert", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "trdInsert(drive string, path string)")
+		intp.help_vals = append(intp.help_vals, "Insert a TRD disk image or an SCL archive into the Beta 128 drive (a..d)")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_trdEject, functionSignature)
+		intp.defineFunction("trdEject", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "trdEject(drive string)")
+		intp.help_vals = append(intp.help_vals, "Eject the disk from the Beta 128 drive (a..d)")
+	}
+	{
+		var functionSignature func(string)
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_printerSave, functionSignature)
+		intp.defineFunction("printerSave", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "printerSave(path string)")
+		intp.help_vals = append(intp.help_vals, "Save the printed output as a PNG image, or as text if the file name ends with .txt")
+	}
+	{
+		var functionSignature func()
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_printerClear, functionSignature)
+		intp.defineFunction("printerClear", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "printerClear()")
+		intp.help_vals = append(intp.help_vals, "Discard the printed output")
 	}
 	{
 		var functionSignature func(string) []WOS
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_wosFind, functionSignature)
-		defineFunction("wosFind", funcType, funcValue)
-		help_keys = append(help_keys, "wosFind(pattern string) []WOS")
-		help_vals = append(help_vals, "Find tapes and snapshots on worldofspectrum.org")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_wosFind, functionSignature)
+		intp.defineFunction("wosFind", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "wosFind(pattern string) []WOS")
+		intp.help_vals = append(intp.help_vals, "Find tapes and snapshots on worldofspectrum.org")
 	}
 	{
 		var functionSignature func(WOS) string
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_wosDownload, functionSignature)
-		defineFunction("wosDownload", funcType, funcValue)
-		help_keys = append(help_keys, "wosDownload(wos WOS) string")
-		help_vals = append(help_vals, "Download from worldofspectrum.org")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_wosDownload, functionSignature)
+		intp.defineFunction("wosDownload", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "wosDownload(wos WOS) string")
+		intp.help_vals = append(intp.help_vals, "Download from worldofspectrum.org")
 	}
 	{
 		var functionSignature func(WOS)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_wosLoad, functionSignature)
-		defineFunction("wosLoad", funcType, funcValue)
-		help_keys = append(help_keys, "wosLoad(wos WOS)")
-		help_vals = append(help_vals, "Same as load(wosDownload(wos))")
+		funcType, funcValue := eval.FuncFromNativeTyped(intp.wrapper_wosLoad, functionSignature)
+		intp.defineFunction("wosLoad", funcType, funcValue)
+		intp.help_keys = append(intp.help_keys, "wosLoad(wos WOS)")
+		intp.help_vals = append(intp.help_vals, "Same as load(wosDownload(wos))")
 	}
 
-	for _, f := range functionsToAdd {
-		defineFunction(f.Name, f.Type, f.Value)
-
-		if (f.Help_key != "") && (f.Help_value != "") {
-			help_keys = append(help_keys, f.Help_key)
-			help_vals = append(help_vals, f.Help_value)
-		}
+	for _, f := range extensionFunctions {
+		intp.defineExtension(f)
 	}
-	functionsToAdd = nil
 }
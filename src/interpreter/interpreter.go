@@ -15,22 +15,13 @@ import (
 	"sync"
 )
 
-// ==============
-// Some variables
-// ==============
-
-// These variables are set only once, before starting new goroutines,
-// so there is no need for controlling concurrent access via a sync.Mutex
-var (
-	app                 *spectrum.Application
-	cmdLineArg          string // The 1st non-flag command-line argument, or empty string
-	speccy              *spectrum.Spectrum48k
-	w                   *eval.World
-	intp                *Interpreter = newInterpreter()
-	stdout              io.Writer    = os.Stdout
-	IgnoreStartupScript              = false
-)
+// The interpreter created by 'Init', used by the front-ends
+var defaultInterpreter *Interpreter
+
+// Whether 'Init' ignores a missing startup script
+var IgnoreStartupScript = false
 
+// Protects 'defaultInterpreter', 'extensionFunctions' and the go-eval compiler
 var mutex sync.Mutex
 
 const (
@@ -38,35 +29,76 @@ const (
 	STARTUP_SCRIPT   = "startup"
 )
 
+// An interpreter controlling one emulated machine. Each interpreter has its own
+// set of variables, so several machines can be scripted side by side.
 type Interpreter struct {
+	app        *spectrum.Application
+	cmdLineArg string // The 1st non-flag command-line argument, or empty string
+	speccy     *spectrum.Spectrum48k
+	w          *eval.World
+
 	// The set of top-level Go variables.
 	// (This is a set, the values associated with the keys are pointless.)
 	vars map[string]bool
+
+	// Contains the names of all defined functions
+	definedFunctions map[string]byte
+
+	help_keys []string
+	help_vals []string
+
+	stdout io.Writer
+	mutex  sync.Mutex
 }
 
-func newInterpreter() *Interpreter {
-	return &Interpreter{
-		vars: make(map[string]bool),
+// Creates an interpreter controlling the specified machine.
+// The startup script is not run.
+func NewInterpreter(app *spectrum.Application, cmdLineArg string, speccy *spectrum.Spectrum48k) *Interpreter {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return newInterpreter(app, cmdLineArg, speccy)
+}
+
+// Called while holding the mutex
+func newInterpreter(app *spectrum.Application, cmdLineArg string, speccy *spectrum.Spectrum48k) *Interpreter {
+	intp := &Interpreter{
+		app:              app,
+		cmdLineArg:       cmdLineArg,
+		speccy:           speccy,
+		w:                eval.NewWorld(),
+		vars:             make(map[string]bool),
+		definedFunctions: make(map[string]byte),
+		stdout:           os.Stdout,
 	}
+	intp.defineFunctions()
+	return intp
 }
 
 // Returns the previous stdout
-func (i *Interpreter) SetStdout(newStdout io.Writer) io.Writer {
-	mutex.Lock()
-	defer mutex.Unlock()
+func (intp *Interpreter) SetStdout(newStdout io.Writer) io.Writer {
+	intp.mutex.Lock()
+	defer intp.mutex.Unlock()
 
-	old := stdout
-	stdout = newStdout
+	old := intp.stdout
+	intp.stdout = newStdout
 	return old
 }
 
-func (i *Interpreter) Run(sourceCode string) error {
+// Returns the writer to which the interpreter is currently printing
+func (intp *Interpreter) Stdout() io.Writer {
+	intp.mutex.Lock()
+	defer intp.mutex.Unlock()
+
+	return intp.stdout
+}
+
+func (intp *Interpreter) Run(sourceCode string) error {
 	sourceCode = strings.TrimSpace(sourceCode)
 	if sourceCode == "" {
 		sourceCode = "help()"
 	}
 
-	err := i.run(w, "", sourceCode)
+	err := intp.run("", sourceCode)
 
 	return err
 }
@@ -166,7 +198,7 @@ func parseDeclList(fset *token.FileSet, src string) ([]ast.Decl, error) {
 // The output parameter 'vars' contains the names of new top-level
 // variables potentially defined by the source code.
 // 'vars' may contain some elements even if an error occurred.
-func (i *Interpreter) compile(w *eval.World, fileSet *token.FileSet, sourceCode string) (code eval.Code, vars []string, err error) {
+func (intp *Interpreter) compile(fileSet *token.FileSet, sourceCode string) (code eval.Code, vars []string, err error) {
 	var statements []ast.Stmt
 	var declarations []ast.Decl
 
@@ -182,7 +214,7 @@ func (i *Interpreter) compile(w *eval.World, fileSet *token.FileSet, sourceCode
 			vars = append(vars, varName)
 		}
 
-		code, err = w.CompileStmtList(fileSet, statements)
+		code, err = intp.w.CompileStmtList(fileSet, statements)
 
 		return code, vars, err
 	}
@@ -197,7 +229,7 @@ func (i *Interpreter) compile(w *eval.World, fileSet *token.FileSet, sourceCode
 			vars = append(vars, varName)
 		}
 
-		code, err = w.CompileDeclList(fileSet, declarations)
+		code, err = intp.w.CompileDeclList(fileSet, declarations)
 
 		return code, vars, err
 	}
@@ -205,22 +237,22 @@ func (i *Interpreter) compile(w *eval.World, fileSet *token.FileSet, sourceCode
 	return nil, nil, err1
 }
 
-// Examines whether 'w' has values for the variables in 'vars'.
-// For each successfully found/verified variable, the variable's name is added to 'i.vars'.
-func (i *Interpreter) tryToAddVars(w *eval.World, fileSet *token.FileSet, vars []string) {
+// Examines whether 'intp.w' has values for the variables in 'vars'.
+// For each successfully found/verified variable, the variable's name is added to 'intp.vars'.
+func (intp *Interpreter) tryToAddVars(fileSet *token.FileSet, vars []string) {
 	for _, name := range vars {
-		_, err := w.Compile(fileSet, name /*sourceCode*/)
+		_, err := intp.w.Compile(fileSet, name /*sourceCode*/)
 		if err == nil {
-			// The variable exists, add its name to 'i.vars'
-			i.vars[name] = true
+			// The variable exists, add its name to 'intp.vars'
+			intp.vars[name] = true
 		} else {
 			// Ignore the error. Conclude that no such variable exists.
 		}
 	}
 }
 
-// Runs the specified Go source code in the context of 'w'
-func (i *Interpreter) run(w *eval.World, path_orEmpty string, sourceCode string) error {
+// Runs the specified Go source code in the context of 'intp.w'
+func (intp *Interpreter) run(path_orEmpty string, sourceCode string) error {
 	var code eval.Code
 	var vars []string
 	var err error
@@ -230,8 +262,12 @@ func (i *Interpreter) run(w *eval.World, path_orEmpty string, sourceCode string)
 		fileSet.AddFile(path_orEmpty, fileSet.Base(), len(sourceCode))
 	}
 
-	code, vars, err = i.compile(w, fileSet, sourceCode)
-	i.tryToAddVars(w, fileSet, vars)
+	// The compiler of go-eval caches types in package-level maps,
+	// so only one interpreter at a time can compile
+	mutex.Lock()
+	code, vars, err = intp.compile(fileSet, sourceCode)
+	intp.tryToAddVars(fileSet, vars)
+	mutex.Unlock()
 	if err != nil {
 		return err
 	}
@@ -242,14 +278,14 @@ func (i *Interpreter) run(w *eval.World, path_orEmpty string, sourceCode string)
 	}
 
 	if result != nil {
-		fmt.Fprintf(stdout, "%s\n", result)
+		fmt.Fprintf(intp.stdout, "%s\n", result)
 	}
 
 	return nil
 }
 
 // Loads and evaluates the specified Go script
-func runScript(w *eval.World, scriptName string, optional bool) error {
+func (intp *Interpreter) runScript(scriptName string, optional bool) error {
 	fileName := scriptName + ".go"
 
 	path, err := spectrum.ScriptPath(fileName)
@@ -266,32 +302,40 @@ func runScript(w *eval.World, scriptName string, optional bool) error {
 		}
 	}
 
-	err = intp.run(w, fileName, string(scriptData))
+	err = intp.run(fileName, string(scriptData))
 	return err
 }
 
-func Init(_app *spectrum.Application, _cmdLineArg string, _speccy *spectrum.Spectrum48k) {
-	app = _app
-	cmdLineArg = _cmdLineArg
-	speccy = _speccy
-
-	if w == nil {
-		w = eval.NewWorld()
-		defineFunctions(w)
-
-		// Run the startup script
-		var err error
-		err = runScript(w, STARTUP_SCRIPT, IgnoreStartupScript /*optional*/)
-		if err != nil {
-			app.PrintfMsg("%s", err)
-			app.RequestExit()
-			return
-		}
+// Creates the interpreter used by the front-ends, and runs the startup script.
+// Calling 'Init' again only switches the interpreter to another machine.
+func Init(app *spectrum.Application, cmdLineArg string, speccy *spectrum.Spectrum48k) {
+	mutex.Lock()
+	intp := defaultInterpreter
+	if intp != nil {
+		intp.app = app
+		intp.cmdLineArg = cmdLineArg
+		intp.speccy = speccy
+		mutex.Unlock()
+		return
+	}
+	intp = newInterpreter(app, cmdLineArg, speccy)
+	defaultInterpreter = intp
+	mutex.Unlock()
+
+	// Run the startup script
+	err := intp.runScript(STARTUP_SCRIPT, IgnoreStartupScript /*optional*/)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		app.RequestExit()
+		return
 	}
 }
 
+// Returns the interpreter created by 'Init'
 func GetInterpreter() *Interpreter {
-	return intp
+	mutex.Lock()
+	defer mutex.Unlock()
+	return defaultInterpreter
 }
 
 // Lines below will be uncommented when/if the keypress console
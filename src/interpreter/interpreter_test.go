@@ -0,0 +1,200 @@
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/remogatto/gospeccy/src/formats"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"github.com/sbinet/go-eval"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	test_romPath      = "../../roms/48.rom"
+	test_snapshotPath = "../formats/testdata/fire.sna"
+	test_numMachines  = 4
+)
+
+type test_machine struct {
+	app    *spectrum.Application
+	speccy *spectrum.Spectrum48k
+	intp   *Interpreter
+	stdout bytes.Buffer
+}
+
+func newTestMachine(rom *[0x4000]byte) *test_machine {
+	m := new(test_machine)
+	m.app = spectrum.NewApplication()
+	m.speccy = spectrum.NewSpectrum48k(m.app, *rom)
+	m.intp = NewInterpreter(m.app, "", m.speccy)
+	m.intp.SetStdout(&m.stdout)
+	return m
+}
+
+func (m *test_machine) renderFrames(n int) {
+	for i := 0; i < n; i++ {
+		completionTime := make(chan time.Time)
+		m.speccy.CommandChannel <- spectrum.Cmd_RenderFrame{completionTime}
+		<-completionTime
+	}
+}
+
+func (m *test_machine) snapshot() *formats.FullSnapshot {
+	ch := make(chan *formats.FullSnapshot)
+	m.speccy.CommandChannel <- spectrum.Cmd_MakeSnapshot{ch}
+	return <-ch
+}
+
+func (m *test_machine) exit() {
+	m.app.RequestExit()
+	<-m.app.HasTerminated
+}
+
+// Loads the test snapshot, runs 'numFrames' frames and prints the machine's name,
+// which is stored in a variable of the machine's interpreter
+func (m *test_machine) run(name string, numFrames int) (*formats.FullSnapshot, error) {
+	err := m.intp.Run(fmt.Sprintf("load(%q)", test_snapshotPath))
+	if err != nil {
+		return nil, err
+	}
+	err = m.intp.Run(fmt.Sprintf("var name string = %q", name))
+	if err != nil {
+		return nil, err
+	}
+
+	m.renderFrames(numFrames)
+
+	err = m.intp.Run("puts(name)")
+	if err != nil {
+		return nil, err
+	}
+
+	return m.snapshot(), nil
+}
+
+func TestConcurrentMachines(t *testing.T) {
+	rom, err := spectrum.ReadROM(test_romPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		machines  [test_numMachines]*test_machine
+		snapshots [test_numMachines]*formats.FullSnapshot
+		errors    [test_numMachines]error
+	)
+
+	var waitGroup sync.WaitGroup
+	for i := range machines {
+		machines[i] = newTestMachine(rom)
+		defer machines[i].exit()
+
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			snapshots[i], errors[i] = machines[i].run(fmt.Sprintf("machine%d", i), 10*(i+1))
+		}(i)
+	}
+	waitGroup.Wait()
+
+	for i, m := range machines {
+		if errors[i] != nil {
+			t.Fatalf("machine %d: %s", i, errors[i])
+		}
+
+		expectedName := fmt.Sprintf("machine%d", i)
+		if m.stdout.String() != expectedName {
+			t.Errorf("machine %d printed %q, expected %q", i, m.stdout.String(), expectedName)
+		}
+
+		// Each machine must end up in the same state as a machine running alone
+		reference := newTestMachine(rom)
+		expected, err := reference.run(expectedName, 10*(i+1))
+		reference.exit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *snapshots[i] != *expected {
+			t.Errorf("machine %d: the state differs from a machine running alone", i)
+		}
+	}
+}
+
+// Signature: func hello(name string)
+func test_hello(intp *Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
+	name := in[0].(eval.StringValue).Get(t)
+	fmt.Fprintf(intp.Stdout(), "hello %s", name)
+}
+
+func TestDefineFunction(t *testing.T) {
+	rom, err := spectrum.ReadROM(test_romPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hello := Function{
+		Name:      "hello",
+		Signature: (func(string))(nil),
+		Value:     test_hello,
+	}
+
+	var machines [2]*test_machine
+	for i := range machines {
+		machines[i] = newTestMachine(rom)
+		defer machines[i].exit()
+	}
+
+	// Each interpreter calls the function with its own output
+	for i, m := range machines {
+		if err := m.intp.DefineFunction(hello); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.intp.Run(fmt.Sprintf("hello(%q)", fmt.Sprintf("machine%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, m := range machines {
+		expected := fmt.Sprintf("hello machine%d", i)
+		if m.stdout.String() != expected {
+			t.Errorf("machine %d printed %q, expected %q", i, m.stdout.String(), expected)
+		}
+	}
+
+	other := newTestMachine(rom)
+	defer other.exit()
+
+	if _, defined := other.intp.definedFunctions["hello"]; defined {
+		t.Error("a function defined in one interpreter leaked into another interpreter")
+	}
+}
+
+func TestDefineInvalidFunction(t *testing.T) {
+	rom, err := spectrum.ReadROM(test_romPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestMachine(rom)
+	defer m.exit()
+
+	invalid := []Function{
+		{Signature: (func(string))(nil), Value: test_hello},
+		{Name: "test"},
+		{Name: "test", Value: test_hello},
+		{Name: "test", Signature: 1, Value: test_hello},
+		{Name: "test", Signature: (func(string))(nil)},
+	}
+	for _, f := range invalid {
+		if err := m.intp.DefineFunction(f); err == nil {
+			t.Errorf("the function %+v was accepted", f)
+		}
+		if err := DefineFunction(f); err == nil {
+			t.Errorf("the function %+v was accepted", f)
+		}
+	}
+	if _, defined := m.intp.definedFunctions["test"]; defined {
+		t.Error("an invalid function was defined")
+	}
+}
@@ -18,8 +18,6 @@ import (
 	"sync"
 )
 
-const DEFAULT_JOYSTICK_ID = 0
-
 var (
 	// Synchronizes the shutdown of SDL event loops.
 	// When all SDL event loops terminate, we can call 'sdl.Quit()'.
@@ -31,11 +29,19 @@ var (
 	// The application renderer
 	r *SDLRenderer
 
-	joystick *sdl.Joystick
+	// The opened joysticks and gamepads
+	joysticks []*sdl.Joystick
 
 	composer *SDLSurfaceComposer
 )
 
+// SDL mouse buttons -> Kempston mouse buttons
+var mouseButtons = map[uint8]uint{
+	sdl.BUTTON_LEFT:   spectrum.MOUSE_LEFT,
+	sdl.BUTTON_RIGHT:  spectrum.MOUSE_RIGHT,
+	sdl.BUTTON_MIDDLE: spectrum.MOUSE_MIDDLE,
+}
+
 type SDLSurfaceAccessor interface {
 	UpdatedRectsCh() <-chan []sdl.Rect
 	GetSurface() *sdl.Surface
@@ -64,7 +70,8 @@ type cmd_newSlider struct {
 type SDLRenderer struct {
 	app                           *spectrum.Application
 	speccy                        *spectrum.Spectrum48k
-	scale2x, fullscreen           bool
+	scale                         uint
+	fullscreen                    bool
 	consoleY                      int16
 	width, height                 int
 	appSurface, speccySurface     SDLSurfaceAccessor
@@ -78,6 +85,12 @@ type SDLRenderer struct {
 	audio     bool
 	audioFreq uint
 	hqAudio   bool
+
+	scaler Scaler
+	filter Filter
+
+	// Whether the mouse pointer is grabbed by the window (Kempston mouse)
+	mouseGrab bool
 }
 
 type wrapSurface struct {
@@ -92,30 +105,26 @@ func (s *wrapSurface) UpdatedRectsCh() <-chan []sdl.Rect {
 	return nil
 }
 
-func width(scale2x, fullscreen bool) int {
-	if fullscreen {
-		scale2x = true
+// Returns the scale factor of the display.
+// Fullscreen mode implies at least a 2x scale.
+func effectiveScale(scale uint, fullscreen bool) uint {
+	if fullscreen && (scale < 2) {
+		return 2
 	}
-	if scale2x {
-		return spectrum.TotalScreenWidth * 2
-	}
-	return spectrum.TotalScreenWidth
+	return scale
 }
 
-func height(scale2x, fullscreen bool) int {
-	if fullscreen {
-		scale2x = true
-	}
-	if scale2x {
-		return spectrum.TotalScreenHeight * 2
-	}
-	return spectrum.TotalScreenHeight
+func width(scale uint, fullscreen bool) int {
+	return int(effectiveScale(scale, fullscreen)) * spectrum.TotalScreenWidth
+}
+
+func height(scale uint, fullscreen bool) int {
+	return int(effectiveScale(scale, fullscreen)) * spectrum.TotalScreenHeight
 }
 
-func newAppSurface(app *spectrum.Application, scale2x, fullscreen bool) SDLSurfaceAccessor {
+func newAppSurface(app *spectrum.Application, scale uint, fullscreen bool) SDLSurfaceAccessor {
 	var sdlMode int64
 	if fullscreen {
-		scale2x = true
 		sdlMode |= sdl.FULLSCREEN
 		sdl.ShowCursor(sdl.DISABLE)
 	} else {
@@ -125,7 +134,7 @@ func newAppSurface(app *spectrum.Application, scale2x, fullscreen bool) SDLSurfa
 
 	<-composer.ReplaceOutputSurface(nil)
 
-	surface := sdl.SetVideoMode(int(width(scale2x, fullscreen)), int(height(scale2x, fullscreen)), 32, uint32(sdlMode))
+	surface := sdl.SetVideoMode(width(scale, fullscreen), height(scale, fullscreen), 32, uint32(sdlMode))
 	if app.Verbose {
 		app.PrintfMsg("video surface resolution: %dx%d", surface.W, surface.H)
 	}
@@ -135,39 +144,26 @@ func newAppSurface(app *spectrum.Application, scale2x, fullscreen bool) SDLSurfa
 	return &wrapSurface{surface}
 }
 
-func newSpeccySurface(app *spectrum.Application, speccy *spectrum.Spectrum48k, scale2x, fullscreen bool) SDLSurfaceAccessor {
-	var speccySurface SDLSurfaceAccessor
-	if fullscreen {
-		scale2x = true
-	}
-	if scale2x {
-		sdlScreen := NewSDLScreen2x(app)
-		speccy.CommandChannel <- spectrum.Cmd_AddDisplay{sdlScreen}
-		speccySurface = sdlScreen
-	} else {
-		sdlScreen := NewSDLScreen(app)
-		speccy.CommandChannel <- spectrum.Cmd_AddDisplay{sdlScreen}
-		speccySurface = sdlScreen
-	}
-	return speccySurface
+func newSpeccySurface(app *spectrum.Application, speccy *spectrum.Spectrum48k, scale uint, fullscreen bool, scaler Scaler, filter Filter) SDLSurfaceAccessor {
+	sdlScreen := newSDLScreen(app, effectiveScale(scale, fullscreen), scaler, filter)
+	speccy.CommandChannel <- spectrum.Cmd_AddDisplay{sdlScreen}
+	return sdlScreen
 }
 
-func newCLISurface(scale2x, fullscreen bool) *clingon.SDLRenderer {
+func newCLISurface(scale uint, fullscreen bool) *clingon.SDLRenderer {
 	cliSurface := clingon.NewSDLRenderer(
 		sdl.CreateRGBSurface(
 			sdl.SRCALPHA,
-			width(scale2x, fullscreen),
-			height(scale2x, fullscreen)/2, 32, 0, 0, 0, 0),
-		newFont(scale2x, fullscreen),
+			width(scale, fullscreen),
+			height(scale, fullscreen)/2, 32, 0, 0, 0, 0),
+		newFont(scale, fullscreen),
 	)
 	cliSurface.GetSurface().SetAlpha(sdl.SRCALPHA, 0xdd)
 	return cliSurface
 }
 
-func newFont(scale2x, fullscreen bool) *ttf.Font {
-	if fullscreen {
-		scale2x = true
-	}
+func newFont(scale uint, fullscreen bool) *ttf.Font {
+	scale = effectiveScale(scale, fullscreen)
 
 	var font *ttf.Font
 	{
@@ -175,10 +171,10 @@ func newFont(scale2x, fullscreen bool) *ttf.Font {
 		if err != nil {
 			panic(err.Error())
 		}
-		if scale2x {
-			font = ttf.OpenFont(path, 12)
-		} else {
+		if scale == 1 {
 			font = ttf.OpenFont(path, 10)
+		} else {
+			font = ttf.OpenFont(path, 6*int(scale))
 		}
 		if font == nil {
 			panic(sdl.GetError())
@@ -188,19 +184,19 @@ func newFont(scale2x, fullscreen bool) *ttf.Font {
 	return font
 }
 
-func NewSDLRenderer(app *spectrum.Application, speccy *spectrum.Spectrum48k, scale2x, fullscreen bool, audio, hqAudio bool, audioFreq uint) *SDLRenderer {
-	width := width(scale2x, fullscreen)
-	height := height(scale2x, fullscreen)
+func NewSDLRenderer(app *spectrum.Application, speccy *spectrum.Spectrum48k, scale uint, fullscreen bool, scaler Scaler, audio, hqAudio bool, audioFreq uint, filter Filter) *SDLRenderer {
+	width := width(scale, fullscreen)
+	height := height(scale, fullscreen)
 	r := &SDLRenderer{
 		app:              app,
 		speccy:           speccy,
-		scale2x:          scale2x,
+		scale:            scale,
 		fullscreen:       fullscreen,
 		appSurfaceCh:     make(chan cmd_newSurface),
 		speccySurfaceCh:  make(chan cmd_newSurface),
 		cliSurfaceCh:     make(chan cmd_newCliSurface),
-		appSurface:       newAppSurface(app, scale2x, fullscreen),
-		speccySurface:    newSpeccySurface(app, speccy, scale2x, fullscreen),
+		appSurface:       newAppSurface(app, scale, fullscreen),
+		speccySurface:    newSpeccySurface(app, speccy, scale, fullscreen, scaler, filter),
 		cliSurface_orNil: nil,
 		width:            width,
 		height:           height,
@@ -209,6 +205,8 @@ func NewSDLRenderer(app *spectrum.Application, speccy *spectrum.Spectrum48k, sca
 		audio:            audio,
 		audioFreq:        audioFreq,
 		hqAudio:          hqAudio,
+		scaler:           scaler,
+		filter:           filter,
 	}
 
 	composer.AddInputSurface(r.speccySurface.GetSurface(), 0, 0, r.speccySurface.UpdatedRectsCh())
@@ -221,41 +219,100 @@ func (r *SDLRenderer) Terminated() bool {
 	return r.app.TerminationInProgress() || r.app.Terminated()
 }
 
-func (r *SDLRenderer) ResizeVideo(scale2x, fullscreen bool) {
+func (r *SDLRenderer) ResizeVideo(scale uint, fullscreen bool) {
 	finished := make(chan byte)
 	r.speccy.CommandChannel <- spectrum.Cmd_CloseAllDisplays{finished}
 	<-finished
 
-	if r.scale2x != scale2x {
-		if scale2x {
-			// 1x --> 2x
-			y := int16(r.height) - r.consoleY
-			r.consoleY = int16(2*r.height) - 2*y
-		} else {
-			// 2x --> 1x
-			y := int16(r.height) - r.consoleY
-			r.consoleY = int16(r.height/2) - y/2
-		}
+	oldScale := effectiveScale(r.scale, r.fullscreen)
+	newScale := effectiveScale(scale, fullscreen)
+	if oldScale != newScale {
+		// Keep the visible part of the console proportional to the screen height
+		y := int(r.height) - int(r.consoleY)
+		r.consoleY = int16(height(scale, fullscreen) - y*int(newScale)/int(oldScale))
 	}
 
-	r.width = width(scale2x, fullscreen)
-	r.height = height(scale2x, fullscreen)
-	r.scale2x = scale2x
+	r.width = width(scale, fullscreen)
+	r.height = height(scale, fullscreen)
+	r.scale = scale
 	r.fullscreen = fullscreen
 
 	done := make(chan bool)
-	r.appSurfaceCh <- cmd_newSurface{newAppSurface(r.app, scale2x, fullscreen), done}
+	r.appSurfaceCh <- cmd_newSurface{newAppSurface(r.app, scale, fullscreen), done}
 	<-done
+	r.SetMouseGrab(r.mouseGrab)
 
-	r.speccySurfaceCh <- cmd_newSurface{newSpeccySurface(r.app, r.speccy, scale2x, fullscreen), done}
+	r.speccySurfaceCh <- cmd_newSurface{newSpeccySurface(r.app, r.speccy, scale, fullscreen, r.scaler, r.filter), done}
 	<-done
 
 	if r.cliSurface_orNil != nil {
-		r.cliSurfaceCh <- cmd_newCliSurface{newCLISurface(scale2x, fullscreen), done}
+		r.cliSurfaceCh <- cmd_newCliSurface{newCLISurface(scale, fullscreen), done}
 		<-done
 	}
 }
 
+// Replaces the Spectrum display with a new one,
+// rendered using the current filters
+func (r *SDLRenderer) replaceSpeccySurface() {
+	finished := make(chan byte)
+	r.speccy.CommandChannel <- spectrum.Cmd_CloseAllDisplays{finished}
+	<-finished
+
+	done := make(chan bool)
+	r.speccySurfaceCh <- cmd_newSurface{newSpeccySurface(r.app, r.speccy, r.scale, r.fullscreen, r.scaler, r.filter), done}
+	<-done
+}
+
+// Changes the scaler. If the scaler requires a particular scale factor,
+// the display is resized accordingly.
+func (r *SDLRenderer) SetScaler(name string) error {
+	scaler, err := ParseScaler(name)
+	if err != nil {
+		return err
+	}
+
+	r.scaler = scaler
+	if (scaler.factor() != 0) && (scaler.factor() != effectiveScale(r.scale, r.fullscreen)) {
+		r.ResizeVideo(scaler.factor(), r.fullscreen)
+	} else {
+		r.replaceSpeccySurface()
+	}
+	return nil
+}
+
+func (r *SDLRenderer) SetFilter(name string) error {
+	filter, err := ParseFilter(name)
+	if err != nil {
+		return err
+	}
+
+	if r.filter != filter {
+		r.filter = filter
+		r.replaceSpeccySurface()
+	}
+	return nil
+}
+
+// Grabs the mouse pointer, or releases it.
+// While the pointer is grabbed, it is hidden and all mouse motion goes to the Kempston mouse.
+func (r *SDLRenderer) SetMouseGrab(enable bool) {
+	r.mouseGrab = enable
+	if enable {
+		sdl.WM_GrabInput(sdl.GRAB_ON)
+		sdl.ShowCursor(sdl.DISABLE)
+	} else {
+		sdl.WM_GrabInput(sdl.GRAB_OFF)
+		if !r.fullscreen {
+			sdl.ShowCursor(sdl.ENABLE)
+		}
+	}
+}
+
+// Returns the number of host pixels per Spectrum pixel
+func (r *SDLRenderer) effectiveScale() uint {
+	return effectiveScale(r.scale, r.fullscreen)
+}
+
 func (r *SDLRenderer) ShowPaintedRegions(enable bool) {
 	composer.ShowPaintedRegions(enable)
 }
@@ -472,6 +529,11 @@ GoSpeccy Command Line Interface (CLI)
 -------------------------------------
 Available keys:
 * F10 toggle/untoggle the CLI
+* Insert pastes the clipboard into the emulated machine
+* F11 grabs/releases the mouse pointer (Kempston mouse)
+* F12 generates an NMI (the Multiface button)
+* F8/F9 rewind/forward the emulation by one second
+* F5/F6 quick-save/quick-load the selected slot, F7 selects the next slot
 * Up/Down for history browsing
 * PageUp/PageDown for scrolling
 `)
@@ -484,6 +546,12 @@ func sdlEventLoop(app *spectrum.Application, speccy *spectrum.Spectrum48k, verbo
 
 	consoleIsVisible := false
 
+	// Symbols being typed on the host keyboard
+	symbols := make(map[string][]spectrum.KeyChord)
+
+	// The quick-save slot used by F5 and F6
+	quickSaveSlot := uint(1)
+
 	shutdown.Add(1)
 	for {
 		select {
@@ -509,37 +577,37 @@ func sdlEventLoop(app *spectrum.Application, speccy *spectrum.Spectrum48k, verbo
 
 			case sdl.JoyAxisEvent:
 				if verboseInput {
-					app.PrintfMsg("[Joystick] Axis: %d, Value: %d", e.Axis, e.Value)
-				}
-				if e.Axis == 0 {
-					if e.Value > 0 {
-						speccy.Joystick.KempstonDown(spectrum.KEMPSTON_RIGHT)
-					} else if e.Value < 0 {
-						speccy.Joystick.KempstonDown(spectrum.KEMPSTON_LEFT)
-					} else {
-						speccy.Joystick.KempstonUp(spectrum.KEMPSTON_RIGHT)
-						speccy.Joystick.KempstonUp(spectrum.KEMPSTON_LEFT)
-					}
-				} else if e.Axis == 1 {
-					if e.Value > 0 {
-						speccy.Joystick.KempstonDown(spectrum.KEMPSTON_UP)
-					} else if e.Value < 0 {
-						speccy.Joystick.KempstonDown(spectrum.KEMPSTON_DOWN)
-					} else {
-						speccy.Joystick.KempstonUp(spectrum.KEMPSTON_UP)
-						speccy.Joystick.KempstonUp(spectrum.KEMPSTON_DOWN)
-					}
+					app.PrintfMsg("[Joystick %d] Axis: %d, Value: %d", e.Which, e.Axis, e.Value)
 				}
+				speccy.Input.JoystickAxis(int(e.Which), int(e.Axis), e.Value)
 
 			case sdl.JoyButtonEvent:
 				if verboseInput {
-					app.PrintfMsg("[Joystick] Button: %d, State: %d", e.Button, e.State)
+					app.PrintfMsg("[Joystick %d] Button: %d, State: %d", e.Which, e.Button, e.State)
+				}
+				speccy.Input.JoystickButton(int(e.Which), int(e.Button), e.State > 0)
+
+			case sdl.JoyHatEvent:
+				if verboseInput {
+					app.PrintfMsg("[Joystick %d] Hat: %d, Value: %d", e.Which, e.Hat, e.Value)
+				}
+				speccy.Input.JoystickHat(int(e.Which), int(e.Hat), e.Value)
+
+			case sdl.MouseMotionEvent:
+				if !consoleIsVisible {
+					scale := float32(r.effectiveScale())
+					speccy.Mouse.Move(float32(e.Xrel)/scale, float32(e.Yrel)/scale)
+				}
+
+			case sdl.MouseButtonEvent:
+				if verboseInput {
+					app.PrintfMsg("[Mouse] Button: %d, State: %d", e.Button, e.State)
 				}
-				if e.Button == 0 {
-					if e.State > 0 {
-						speccy.Joystick.KempstonDown(spectrum.KEMPSTON_FIRE)
+				if button, ok := mouseButtons[e.Button]; ok && !consoleIsVisible {
+					if e.Type == sdl.MOUSEBUTTONDOWN {
+						speccy.Mouse.ButtonDown(button)
 					} else {
-						speccy.Joystick.KempstonUp(spectrum.KEMPSTON_FIRE)
+						speccy.Mouse.ButtonUp(button)
 					}
 				}
 
@@ -559,6 +627,40 @@ func sdlEventLoop(app *spectrum.Application, speccy *spectrum.Spectrum48k, verbo
 					}
 					app.RequestExit()
 
+				} else if (keyName == "f11") && (e.Type == sdl.KEYDOWN) {
+					mutex.Lock()
+					r.SetMouseGrab(!r.mouseGrab)
+					mutex.Unlock()
+
+				} else if (keyName == "f12") && (e.Type == sdl.KEYDOWN) {
+					speccy.NMI()
+
+				} else if (keyName == "f8") && (e.Type == sdl.KEYDOWN) {
+					speccy.CommandChannel <- spectrum.Cmd_Rewind{1, nil}
+
+				} else if (keyName == "f9") && (e.Type == sdl.KEYDOWN) {
+					speccy.CommandChannel <- spectrum.Cmd_Forward{1, nil}
+
+				} else if (keyName == "f5") && (e.Type == sdl.KEYDOWN) {
+					go func(slot uint) {
+						if _, err := speccy.QuickSave(slot); err != nil {
+							app.PrintfMsg("%s", err)
+						} else {
+							app.PrintfMsg("saved quick-save slot %d", slot)
+						}
+					}(quickSaveSlot)
+
+				} else if (keyName == "f6") && (e.Type == sdl.KEYDOWN) {
+					go func(slot uint) {
+						if err := speccy.QuickLoad(slot); err != nil {
+							app.PrintfMsg("%s", err)
+						}
+					}(quickSaveSlot)
+
+				} else if (keyName == "f7") && (e.Type == sdl.KEYDOWN) {
+					quickSaveSlot = quickSaveSlot%spectrum.QUICKSAVE_NUM_SLOTS + 1
+					app.PrintfMsg("quick-save slot %d", quickSaveSlot)
+
 				} else if (keyName == "f10") && (e.Type == sdl.KEYDOWN) {
 					//if app.Verbose {
 					//	app.PrintfMsg("f10 key -> toggle console")
@@ -568,7 +670,7 @@ func sdlEventLoop(app *spectrum.Application, speccy *spectrum.Spectrum48k, verbo
 
 						if r.cliSurface_orNil == nil {
 							done := make(chan bool)
-							r.cliSurfaceCh <- cmd_newCliSurface{newCLISurface(r.scale2x, r.fullscreen), done}
+							r.cliSurfaceCh <- cmd_newCliSurface{newCLISurface(r.scale, r.fullscreen), done}
 							<-done
 						}
 
@@ -608,23 +710,14 @@ func sdlEventLoop(app *spectrum.Application, speccy *spectrum.Spectrum48k, verbo
 								cli.PutUnicode(unicode)
 							}
 						}
-					} else {
-						sequence, haveMapping := spectrum.SDL_KeyMap[keyName]
-
-						if haveMapping {
-							switch e.Type {
-							case sdl.KEYDOWN:
-								// Normal order
-								for i := 0; i < len(sequence); i++ {
-									speccy.Keyboard.KeyDown(sequence[i])
-								}
-							case sdl.KEYUP:
-								// Reverse order
-								for i := len(sequence) - 1; i >= 0; i-- {
-									speccy.Keyboard.KeyUp(sequence[i])
-								}
-							}
+					} else if keyName == "insert" {
+						// Insert or Shift+Insert: paste the clipboard
+						if e.Type == sdl.KEYDOWN {
+							speccy.Keyboard.KeyUp(spectrum.KEY_CapsShift)
+							go pasteClipboard(app, speccy)
 						}
+					} else {
+						speccyKeyEvent(speccy, &e, keyName, symbols)
 					}
 				}
 			}
@@ -639,19 +732,19 @@ func initSDLSubSystems(app *spectrum.Application) error {
 	if ttf.Init() != 0 {
 		return errors.New(sdl.GetError())
 	}
-	if sdl.NumJoysticks() > 0 {
-		// Open joystick
-		joystick = sdl.JoystickOpen(DEFAULT_JOYSTICK_ID)
-		if joystick != nil {
-			if app.Verbose {
-				app.PrintfMsg("Opened Joystick %d", DEFAULT_JOYSTICK_ID)
-				app.PrintfMsg("Name: %s", sdl.JoystickName(DEFAULT_JOYSTICK_ID))
-				app.PrintfMsg("Number of Axes: %d", joystick.NumAxes())
-				app.PrintfMsg("Number of Buttons: %d", joystick.NumButtons())
-				app.PrintfMsg("Number of Balls: %d", joystick.NumBalls())
-			}
-		} else {
-			return errors.New("Couldn't open Joystick!")
+	for i := 0; i < sdl.NumJoysticks(); i++ {
+		joystick := sdl.JoystickOpen(i)
+		if joystick == nil {
+			return fmt.Errorf("Couldn't open Joystick %d!", i)
+		}
+		joysticks = append(joysticks, joystick)
+		if app.Verbose {
+			app.PrintfMsg("Opened Joystick %d", i)
+			app.PrintfMsg("Name: %s", sdl.JoystickName(i))
+			app.PrintfMsg("Number of Axes: %d", joystick.NumAxes())
+			app.PrintfMsg("Number of Buttons: %d", joystick.NumButtons())
+			app.PrintfMsg("Number of Hats: %d", joystick.NumHats())
+			app.PrintfMsg("Number of Balls: %d", joystick.NumBalls())
 		}
 	}
 	sdl.WM_SetCaption("GoSpeccy - ZX Spectrum Emulator", "")
@@ -661,24 +754,66 @@ func initSDLSubSystems(app *spectrum.Application) error {
 
 var (
 	enableSDL          = flag.Bool("enable-sdl", true, "Enable SDL user interface")
-	Scale2x            = flag.Bool("2x", false, "2x display scaler")
+	Scale2x            = flag.Bool("2x", false, "2x display scaler (same as -scale=2)")
+	Scale              = flag.Uint("scale", 1, "Display scale (1...4)")
+	ScalerName         = flag.String("scaler", "none", "Display scaler: none, scale2x, scale3x, smooth2x")
 	Fullscreen         = flag.Bool("fullscreen", false, "Fullscreen (enable 2x scaler by default)")
 	Audio              = flag.Bool("audio", true, "Enable or disable audio")
 	AudioFreq          = flag.Uint("audio-freq", PLAYBACK_FREQUENCY, "Audio playback frequency (units: Hz)")
 	HQAudio            = flag.Bool("audio-hq", true, "Enable or disable higher-quality audio")
 	ShowPaintedRegions = flag.Bool("show-paint", false, "Show painted display regions")
+	FilterName         = flag.String("filter", "none", "Display filters: none, scanlines, pal, crt, or a combination such as \"pal+scanlines\"")
+	MouseGrab          = flag.Bool("mouse-grab", false, "Grab the mouse pointer (Kempston mouse). F11 toggles the grab.")
+	MouseSensitivity   = flag.Float64("mouse-sensitivity", spectrum.DEFAULT_MOUSE_SENSITIVITY, "Kempston mouse units per Spectrum pixel")
 	verboseInput       = flag.Bool("verbose-input", false, "Enable debugging messages (input device events)")
 )
 
 func init() {
 	uiSettings = &InitialSettings{
 		scale2x:            Scale2x,
+		scale:              Scale,
+		scaler:             ScalerName,
 		fullscreen:         Fullscreen,
 		showPaintedRegions: ShowPaintedRegions,
 		audio:              Audio,
 		audioFreq:          AudioFreq,
 		hqAudio:            HQAudio,
+		filter:             FilterName,
+		mouseGrab:          MouseGrab,
+	}
+}
+
+// Set by a module which replaces the SDL window and keyboard input,
+// such as the terminal user interface. If the function returns true,
+// the SDL window is not opened and SDL is used only for playing the audio.
+// The function is called after the command-line has been parsed.
+var WindowReplaced func() bool
+
+// Plays the audio via SDL, without opening the SDL window
+func audioOnlyMain(app *spectrum.Application, speccy *spectrum.Spectrum48k, init_waitGroup *sync.WaitGroup) {
+	if !*Audio {
+		init_waitGroup.Done()
+		return
+	}
+
+	if sdl.Init(sdl.INIT_AUDIO) != 0 {
+		app.PrintfMsg("%s", sdl.GetError())
+		init_waitGroup.Done()
+		return
 	}
+
+	audio, err := NewSDLAudio(app, *AudioFreq, *HQAudio)
+	if err == nil {
+		speccy.CommandChannel <- spectrum.Cmd_AddAudioReceiver{audio}
+	} else {
+		app.PrintfMsg("%s", err)
+	}
+
+	init_waitGroup.Done()
+
+	// Wait for the audio to terminate, and then call 'sdl.Quit()'
+	shutdown.Wait()
+	sdl.Quit()
 }
 
 func Main() {
@@ -693,16 +828,25 @@ func Main() {
 	speccy = env.Wait(reflect.TypeOf(speccy)).(*spectrum.Spectrum48k)
 
 	if !*enableSDL {
+		init_waitGroup.Done()
+		return
+	}
+	if (WindowReplaced != nil) && WindowReplaced() {
+		audioOnlyMain(app, speccy, init_waitGroup)
 		return
 	}
 
 	uiSettings = &InitialSettings{
 		scale2x:            Scale2x,
+		scale:              Scale,
+		scaler:             ScalerName,
 		fullscreen:         Fullscreen,
 		showPaintedRegions: ShowPaintedRegions,
 		audio:              Audio,
 		audioFreq:          AudioFreq,
 		hqAudio:            HQAudio,
+		filter:             FilterName,
+		mouseGrab:          MouseGrab,
 	}
 
 	composer = NewSDLSurfaceComposer(app)
@@ -716,8 +860,34 @@ func Main() {
 	}
 
 	// Setup the display
-	r = NewSDLRenderer(app, speccy, *Scale2x, *Fullscreen, *Audio, *HQAudio, *AudioFreq)
+	filter, err := ParseFilter(*FilterName)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		filter = FILTER_NONE
+	}
+	scaler, err := ParseScaler(*ScalerName)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		scaler = SCALER_NONE
+	}
+	scale := *Scale
+	if *Scale2x && (scale < 2) {
+		scale = 2
+	}
+	if scaler.factor() != 0 {
+		scale = scaler.factor()
+	}
+	if (scale < 1) || (scale > MAX_SCALE) {
+		app.PrintfMsg("invalid display scale: %d", scale)
+		scale = 1
+	}
+	r = NewSDLRenderer(app, speccy, scale, *Fullscreen, scaler, *Audio, *HQAudio, *AudioFreq, filter)
+	r.SetMouseGrab(*MouseGrab)
 	setUI(r)
+
+	if err := speccy.Mouse.SetSensitivity(float32(*MouseSensitivity)); err != nil {
+		app.PrintfMsg("%s", err)
+	}
 	initCLI()
 
 	// Setup the audio
@@ -150,6 +150,7 @@ func screenRenderLoop(evtLoop *spectrum.EventLoop, screenChannel <-chan *spectru
 // SDLScreen
 // =========
 
+// Renders the Spectrum screen to an SDL surface, scaled by an integer factor
 type SDLScreen struct {
 	// Channel for receiving display changes
 	screenChannel chan *spectrum.DisplayData
@@ -162,6 +163,14 @@ type SDLScreen struct {
 
 	updatedRectsCh chan []sdl.Rect
 
+	// The scale factor (1...MAX_SCALE) and the scaler
+	scale  uint
+	scaler Scaler
+
+	// The colors used for rendering (follows 'app.Palette()'), and the post-processing filters
+	palette *[16]uint32
+	filter  Filter
+
 	app *spectrum.Application
 }
 
@@ -169,12 +178,28 @@ type screen_renderer_t interface {
 	render(screen *spectrum.DisplayData)
 }
 
+// Create an unscaled screen
 func NewSDLScreen(app *spectrum.Application) *SDLScreen {
+	return newSDLScreen(app, 1, SCALER_NONE, FILTER_NONE)
+}
+
+// Create a 2x scaled screen
+func NewSDLScreen2x(app *spectrum.Application) *SDLScreen {
+	return newSDLScreen(app, 2, SCALER_NONE, FILTER_NONE)
+}
+
+func newSDLScreen(app *spectrum.Application, scale uint, scaler Scaler, filter Filter) *SDLScreen {
+	spectrum.Assert((scale >= 1) && (scale <= MAX_SCALE))
+
 	SDL_screen := &SDLScreen{
 		screenChannel:   make(chan *spectrum.DisplayData),
-		screenSurface:   NewSDLSurface(app),
+		screenSurface:   newSDLSurface(app, int(scale)*spectrum.TotalScreenWidth, int(scale)*spectrum.TotalScreenHeight),
 		unscaledDisplay: newUnscaledDisplay(),
 		updatedRectsCh:  make(chan []sdl.Rect),
+		scale:           scale,
+		scaler:          scaler.at(scale),
+		palette:         app.Palette(),
+		filter:          filter,
 		app:             app,
 	}
 
@@ -206,23 +231,32 @@ func (display *SDLScreen) render(screen *spectrum.DisplayData) {
 	unscaledDisplay.newFrame()
 	unscaledDisplay.render(screen)
 
+	// After a change of the palette, the whole screen has to be repainted
+	if palette := display.app.Palette(); palette != display.palette {
+		display.palette = palette
+		unscaledDisplay.changedRegions.add(0, 0, spectrum.TotalScreenWidth, spectrum.TotalScreenHeight)
+	}
+
 	surface := display.screenSurface
-	bpp := surface.Bpp()
-	pixels := &unscaledDisplay.pixels
+	scale := display.scale
 
 	surface.surface.Lock()
-	for _, r := range *unscaledDisplay.changedRegions {
-		end_x := uint(r.X) + uint(r.W)
-		end_y := uint(r.Y) + uint(r.H)
-
-		for y := uint(r.Y); y < end_y; y++ {
-			wy := spectrum.TotalScreenWidth * y
-			addr := surface.addrXY(uint(r.X), y)
-			for x := uint(r.X); x < end_x; x++ {
-				*(*uint32)(unsafe.Pointer(addr)) = spectrum.Palette[pixels[wy+x]]
-				addr += uintptr(bpp)
-			}
-		}
+	switch {
+	case (display.filter != FILTER_NONE) || (display.scaler != SCALER_NONE):
+		// Filters and scalers are looking at neighbouring pixels
+		bleed := display.scaler.bleed()
+		changes := unscaledDisplay.changedRegions.extend(bleed+display.filter.bleed(), bleed)
+		unscaledDisplay.changedRegions = changes
+		renderScaled(surface, unscaledDisplay, changes, scale, display.scaler, display.palette, display.filter)
+
+	case scale == 1:
+		display.render1x()
+
+	case scale == 2:
+		display.render2x()
+
+	default:
+		renderScaled(surface, unscaledDisplay, unscaledDisplay.changedRegions, scale, SCALER_NONE, display.palette, FILTER_NONE)
 	}
 	surface.surface.Unlock()
 
@@ -230,74 +264,45 @@ func (display *SDLScreen) render(screen *spectrum.DisplayData) {
 		screen.CompletionTime_orNil <- time.Now()
 	}
 
-	SDL_updateRects(surface.surface, unscaledDisplay.changedRegions, 1 /*scale*/, display.updatedRectsCh)
+	SDL_updateRects(surface.surface, unscaledDisplay.changedRegions, scale, display.updatedRectsCh)
 	unscaledDisplay.releaseMemory()
-
 }
 
-// ===========
-// SDLScreen2x
-// ===========
-
-type SDLScreen2x struct {
-	// Channel for receiving display changes
-	screenChannel chan *spectrum.DisplayData
-
-	// The whole screen, borders included.
-	// Initially nil.
-	screenSurface *SDLSurface
-
-	unscaledDisplay *UnscaledDisplay
+// The fast path of rendering an unscaled screen
+func (display *SDLScreen) render1x() {
+	unscaledDisplay := display.unscaledDisplay
 
-	updatedRectsCh chan []sdl.Rect
+	surface := display.screenSurface
+	bpp := surface.Bpp()
+	pixels := &unscaledDisplay.pixels
+	palette := display.palette
 
-	app *spectrum.Application
-}
+	for _, r := range *unscaledDisplay.changedRegions {
+		end_x := uint(r.X) + uint(r.W)
+		end_y := uint(r.Y) + uint(r.H)
 
-func NewSDLScreen2x(app *spectrum.Application) *SDLScreen2x {
-	SDL_screen := &SDLScreen2x{
-		screenChannel:   make(chan *spectrum.DisplayData),
-		screenSurface:   NewSDLSurface2x(app),
-		unscaledDisplay: newUnscaledDisplay(),
-		updatedRectsCh:  make(chan []sdl.Rect),
-		app:             app,
+		for y := uint(r.Y); y < end_y; y++ {
+			wy := spectrum.TotalScreenWidth * y
+			addr := surface.addrXY(uint(r.X), y)
+			for x := uint(r.X); x < end_x; x++ {
+				*(*uint32)(unsafe.Pointer(addr)) = palette[pixels[wy+x]]
+				addr += uintptr(bpp)
+			}
+		}
 	}
-
-	go screenRenderLoop(app.NewEventLoop(), SDL_screen.screenChannel, SDL_screen)
-
-	return SDL_screen
 }
 
-func (display *SDLScreen2x) UpdatedRectsCh() <-chan []sdl.Rect {
-	return display.updatedRectsCh
-}
-
-func (display *SDLScreen2x) GetSurface() *sdl.Surface {
-	return display.screenSurface.surface
-}
-
-// Implement DisplayReceiver
-func (display *SDLScreen2x) GetDisplayDataChannel() chan<- *spectrum.DisplayData {
-	return display.screenChannel
-}
-
-func (display *SDLScreen2x) Close() {
-	display.screenChannel <- nil
-}
-
-// Implement screen_renderer_t
-func (display *SDLScreen2x) render(screen *spectrum.DisplayData) {
+// The fast path of rendering a 2x scaled screen
+func (display *SDLScreen) render2x() {
 	unscaledDisplay := display.unscaledDisplay
-	unscaledDisplay.newFrame()
-	unscaledDisplay.render(screen)
 
 	surface := display.screenSurface
 	bpp := uintptr(surface.Bpp())
 	bpp2 := 2 * bpp
 	pitch := uintptr(surface.Pitch())
 	pixels := &unscaledDisplay.pixels
+	palette := display.palette
 
-	surface.surface.Lock()
 	for _, r := range *unscaledDisplay.changedRegions {
 		end_x := uint(r.X) + uint(r.W)
 		end_y := uint(r.Y) + uint(r.H)
@@ -307,7 +312,7 @@ func (display *SDLScreen2x) render(screen *spectrum.DisplayData) {
 			wy := spectrum.TotalScreenWidth * y
 
 			for x := uint(r.X); x < end_x; x++ {
-				color := spectrum.Palette[pixels[wy+x]]
+				color := palette[pixels[wy+x]]
 
 				// Fill a 2x2 rectangle
 				*(*uint32)(unsafe.Pointer(addr)) = color
@@ -319,14 +324,6 @@ func (display *SDLScreen2x) render(screen *spectrum.DisplayData) {
 			}
 		}
 	}
-	surface.surface.Unlock()
-
-	if screen.CompletionTime_orNil != nil {
-		screen.CompletionTime_orNil <- time.Now()
-	}
-
-	SDL_updateRects(surface.surface, unscaledDisplay.changedRegions, 2 /*scale*/, display.updatedRectsCh)
-	unscaledDisplay.releaseMemory()
 }
 
 // ==============
@@ -441,7 +438,8 @@ type UnscaledDisplay struct {
 	changedRegions *ListOfRects
 
 	// This is the border which was rendered to 'pixels'
-	border []spectrum.BorderEvent
+	border       []spectrum.BorderEvent
+	borderTiming *spectrum.Timing
 }
 
 func newUnscaledDisplay() *UnscaledDisplay {
@@ -487,11 +485,11 @@ func (disp *UnscaledDisplay) scanlineFill(minx, maxx, y int, color byte) {
 }
 
 // Render border in the interval [start,end)
-func (disp *UnscaledDisplay) renderBorderBetweenTwoEvents(start spectrum.BorderEvent, end spectrum.BorderEvent) {
+func (disp *UnscaledDisplay) renderBorderBetweenTwoEvents(start spectrum.BorderEvent, end spectrum.BorderEvent, timing *spectrum.Timing) {
 	spectrum.Assert(start.TState < end.TState)
 
-	const DISPLAY_START = spectrum.DISPLAY_START
-	const TSTATES_PER_LINE = spectrum.TSTATES_PER_LINE
+	DISPLAY_START := timing.DisplayStart
+	TSTATES_PER_LINE := timing.TStatesPerLine
 
 	if start.TState < DISPLAY_START {
 		start.TState = DISPLAY_START
@@ -540,25 +538,26 @@ func (disp *UnscaledDisplay) renderBorderBetweenTwoEvents(start spectrum.BorderE
 	}
 }
 
-func (disp *UnscaledDisplay) renderBorder(events []spectrum.BorderEvent) {
-	if !spectrum.SameBorderEvents(disp.border, events) {
+func (disp *UnscaledDisplay) renderBorder(events []spectrum.BorderEvent, timing *spectrum.Timing) {
+	if (timing != disp.borderTiming) || !spectrum.SameBorderEvents(disp.border, events) {
 		if len(events) > 0 {
 			firstEvent := &events[0]
 			spectrum.Assert(firstEvent.TState == 0)
 
 			lastEvent := &events[len(events)-1]
-			spectrum.Assert(lastEvent.TState == spectrum.TStatesPerFrame)
+			spectrum.Assert(lastEvent.TState == timing.TStatesPerFrame)
 
 			numEvents := len(events)
 
 			for i := 0; i < numEvents-1; i++ {
-				disp.renderBorderBetweenTwoEvents(events[i], events[i+1])
+				disp.renderBorderBetweenTwoEvents(events[i], events[i+1], timing)
 			}
 
 			disp.changedRegions.addBorder( /*scale*/ 1)
 		}
 
 		disp.border = events
+		disp.borderTiming = timing
 	}
 }
 
@@ -624,5 +623,5 @@ func (disp *UnscaledDisplay) render(screen *spectrum.DisplayData) {
 		}
 	}
 
-	disp.renderBorder(screen.BorderEvents)
+	disp.renderBorder(screen.BorderEvents, screen.Timing)
 }
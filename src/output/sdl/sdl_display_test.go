@@ -191,6 +191,8 @@ func BenchmarkRender(b *testing.B) {
 		screenSurface:   &SDLSurface{newSurface()},
 		unscaledDisplay: newUnscaledDisplay(),
 		updatedRectsCh:  make(chan []sdl.Rect),
+		scale:           1,
+		palette:         &spectrum.Palette,
 		app:             app,
 	}
 
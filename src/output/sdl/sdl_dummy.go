@@ -4,21 +4,28 @@ package sdl_output
 
 type InitialSettings struct {
 	scale2x            *bool
+	scale              *uint
+	scaler             *string
 	fullscreen         *bool
 	showPaintedRegions *bool
 
 	audio     *bool
 	audioFreq *uint
 	hqAudio   *bool
+
+	filter *string
+
+	mouseGrab *bool
 }
 
 func (s *InitialSettings) Terminated() bool {
 	return false
 }
 
-func (s *InitialSettings) ResizeVideo(scale2x, fullscreen bool) {
+func (s *InitialSettings) ResizeVideo(scale uint, fullscreen bool) {
 	// Overwrite the command-line settings
-	*s.scale2x = scale2x
+	*s.scale2x = false
+	*s.scale = scale
 	*s.fullscreen = fullscreen
 }
 
@@ -40,3 +47,28 @@ func (s *InitialSettings) SetAudioQuality(hqAudio bool) {
 	// Overwrite the command-line settings
 	*s.hqAudio = hqAudio
 }
+
+func (s *InitialSettings) SetFilter(name string) error {
+	if _, err := ParseFilter(name); err != nil {
+		return err
+	}
+
+	// Overwrite the command-line settings
+	*s.filter = name
+	return nil
+}
+
+func (s *InitialSettings) SetScaler(name string) error {
+	if _, err := ParseScaler(name); err != nil {
+		return err
+	}
+
+	// Overwrite the command-line settings
+	*s.scaler = name
+	return nil
+}
+
+func (s *InitialSettings) SetMouseGrab(enable bool) {
+	// Overwrite the command-line settings
+	*s.mouseGrab = enable
+}
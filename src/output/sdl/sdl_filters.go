@@ -0,0 +1,248 @@
+// +build linux freebsd
+
+package sdl_output
+
+import (
+	"errors"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"strings"
+	"unsafe"
+)
+
+// A set of post-processing filters applied when rendering the Spectrum screen.
+// Filters can be combined, for example: (FILTER_SCANLINES | FILTER_CRT).
+type Filter uint
+
+const (
+	FILTER_SCANLINES Filter = 1 << iota // Darken every other host scanline
+	FILTER_PAL                          // PAL colour bleed (horizontal blur)
+	FILTER_CRT                          // Aperture-grille mask
+
+	FILTER_NONE Filter = 0
+)
+
+var filterNames = []struct {
+	name   string
+	filter Filter
+}{
+	{"scanlines", FILTER_SCANLINES},
+	{"pal", FILTER_PAL},
+	{"crt", FILTER_CRT},
+}
+
+// Parses a filter specification such as "scanlines" or "pal+scanlines".
+// The names "none" and "" denote FILTER_NONE.
+func ParseFilter(spec string) (Filter, error) {
+	filter := FILTER_NONE
+
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if (spec == "") || (spec == "none") {
+		return filter, nil
+	}
+
+	for _, name := range strings.Split(spec, "+") {
+		name = strings.TrimSpace(name)
+
+		found := false
+		for _, f := range filterNames {
+			if f.name == name {
+				filter |= f.filter
+				found = true
+				break
+			}
+		}
+		if !found {
+			return FILTER_NONE, errors.New("unknown filter \"" + name + "\"")
+		}
+	}
+
+	return filter, nil
+}
+
+func (filter Filter) String() string {
+	if filter == FILTER_NONE {
+		return "none"
+	}
+
+	var names []string
+	for _, f := range filterNames {
+		if (filter & f.filter) != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// Returns the number of unscaled pixels by which a changed region
+// has to be extended horizontally, because of filters which are
+// mixing the colors of neighbouring pixels.
+func (filter Filter) bleed() uint {
+	if (filter & FILTER_PAL) != 0 {
+		return 1
+	}
+	return 0
+}
+
+// Multiplies the R, G and B components of 'color' by (mulR/256, mulG/256, mulB/256)
+func mulRGB(color uint32, mulR, mulG, mulB uint32) uint32 {
+	R := (((color >> 16) & 0xFF) * mulR) >> 8
+	G := (((color >> 8) & 0xFF) * mulG) >> 8
+	B := (((color >> 0) & 0xFF) * mulB) >> 8
+	return (color & 0xFF000000) | (R << 16) | (G << 8) | B
+}
+
+// Computes (a + 2*b + c) / 4, component-wise
+func blend121(a, b, c uint32) uint32 {
+	R := (((a >> 16) & 0xFF) + 2*((b>>16)&0xFF) + ((c >> 16) & 0xFF)) >> 2
+	G := (((a >> 8) & 0xFF) + 2*((b>>8)&0xFF) + ((c >> 8) & 0xFF)) >> 2
+	B := (((a >> 0) & 0xFF) + 2*((b>>0)&0xFF) + ((c >> 0) & 0xFF)) >> 2
+	return (b & 0xFF000000) | (R << 16) | (G << 8) | B
+}
+
+// Strength of the filters, in 1/256 units
+const (
+	scanlineIntensity    = 0x90 // Intensity of a darkened scanline
+	scanlineIntensity_1x = 0xC0 // Intensity of a darkened scanline, without scaling
+	crtMaskIntensity     = 0xB0 // Intensity of the color components suppressed by the CRT mask
+)
+
+// Returns a copy of 'rects' with each rectangle extended
+// by 'dx' pixels horizontally and by 'dy' pixels vertically
+func (l *ListOfRects) extend(dx, dy uint) *ListOfRects {
+	extended := newListOfRects()
+	for _, r := range *l {
+		minx := int(r.X) - int(dx)
+		miny := int(r.Y) - int(dy)
+		maxx := int(r.X) + int(r.W) + int(dx)
+		maxy := int(r.Y) + int(r.H) + int(dy)
+		if minx < 0 {
+			minx = 0
+		}
+		if miny < 0 {
+			miny = 0
+		}
+		if maxx > spectrum.TotalScreenWidth {
+			maxx = spectrum.TotalScreenWidth
+		}
+		if maxy > spectrum.TotalScreenHeight {
+			maxy = spectrum.TotalScreenHeight
+		}
+		extended.add(minx, miny, uint(maxx-minx), uint(maxy-miny))
+	}
+	return extended
+}
+
+// Provides the colors of unscaled pixels, with coordinates clamped to the screen
+type pixelSource_t struct {
+	pixels  *[spectrum.TotalScreenWidth * spectrum.TotalScreenHeight]byte
+	palette *[16]uint32
+	pal     bool
+}
+
+// Returns the color of the pixel at (x,y), without filters
+func (src *pixelSource_t) base(x, y int) uint32 {
+	const W = spectrum.TotalScreenWidth
+	const H = spectrum.TotalScreenHeight
+
+	if x < 0 {
+		x = 0
+	} else if x >= W {
+		x = W - 1
+	}
+	if y < 0 {
+		y = 0
+	} else if y >= H {
+		y = H - 1
+	}
+
+	return src.palette[src.pixels[W*y+x]]
+}
+
+// Returns the color of the pixel at (x,y), after applying the PAL filter
+func (src *pixelSource_t) color(x, y int) uint32 {
+	if src.pal {
+		return blend121(src.base(x-1, y), src.base(x, y), src.base(x+1, y))
+	}
+	return src.base(x, y)
+}
+
+// Renders the specified regions of 'disp' to 'surface' using the given palette,
+// scale, scaler and filters. The regions are in unscaled coordinates.
+//
+// This is the slow path of rendering.
+// It is used only when some filter is enabled, or when the scale has no fast path.
+func renderScaled(surface *SDLSurface, disp *UnscaledDisplay, rects *ListOfRects, scale uint, scaler Scaler, palette *[16]uint32, filter Filter) {
+	bpp := uintptr(surface.Bpp())
+
+	src := pixelSource_t{
+		pixels:  &disp.pixels,
+		palette: palette,
+		pal:     (filter & FILTER_PAL) != 0,
+	}
+
+	var n neighbourhood_t
+	var block [MAX_SCALE * MAX_SCALE]uint32
+
+	for _, r := range *rects {
+		end_x := uint(r.X) + uint(r.W)
+		end_y := uint(r.Y) + uint(r.H)
+
+		for y := uint(r.Y); y < end_y; y++ {
+			for x := uint(r.X); x < end_x; x++ {
+				if scaler == SCALER_NONE {
+					color := src.color(int(x), int(y))
+					for i := uint(0); i < scale*scale; i++ {
+						block[i] = color
+					}
+				} else {
+					for i := 0; i < 9; i++ {
+						nx := int(x) + (i % 3) - 1
+						ny := int(y) + (i / 3) - 1
+						n.base[i] = src.base(nx, ny)
+						n.color[i] = src.color(nx, ny)
+					}
+
+					switch scaler {
+					case SCALER_SCALE2X:
+						scale2x(&n, block[:])
+					case SCALER_SCALE3X:
+						scale3x(&n, block[:])
+					case SCALER_SMOOTH2X:
+						smooth2x(&n, block[:])
+					}
+				}
+
+				for sy := uint(0); sy < scale; sy++ {
+					addr := surface.addrXY(scale*x, scale*y+sy)
+					for sx := uint(0); sx < scale; sx++ {
+						c := block[sy*scale+sx]
+
+						if (filter & FILTER_SCANLINES) != 0 {
+							if scale == 1 {
+								if (y & 1) == 1 {
+									c = mulRGB(c, scanlineIntensity_1x, scanlineIntensity_1x, scanlineIntensity_1x)
+								}
+							} else if sy == scale-1 {
+								c = mulRGB(c, scanlineIntensity, scanlineIntensity, scanlineIntensity)
+							}
+						}
+
+						if (filter & FILTER_CRT) != 0 {
+							switch (scale*x + sx) % 3 {
+							case 0:
+								c = mulRGB(c, 0x100, crtMaskIntensity, crtMaskIntensity)
+							case 1:
+								c = mulRGB(c, crtMaskIntensity, 0x100, crtMaskIntensity)
+							case 2:
+								c = mulRGB(c, crtMaskIntensity, crtMaskIntensity, 0x100)
+							}
+						}
+
+						*(*uint32)(unsafe.Pointer(addr)) = c
+						addr += bpp
+					}
+				}
+			}
+		}
+	}
+}
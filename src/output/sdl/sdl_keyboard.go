@@ -0,0 +1,109 @@
+// +build linux freebsd
+
+package sdl_output
+
+import (
+	"errors"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"github.com/scottferg/Go-SDL/sdl"
+	"os/exec"
+	"unicode"
+)
+
+// Programs which can print the contents of the clipboard
+var clipboardCommands = [][]string{
+	{"xclip", "-selection", "clipboard", "-o"},
+	{"xsel", "--clipboard", "--output"},
+	{"wl-paste", "--no-newline"},
+}
+
+// Returns the key presses producing the symbol typed on the host keyboard,
+// or nil if the key should be handled by the input profiles.
+// This makes keys such as '"' or ':' produce the expected character,
+// regardless of where they are on the Spectrum keyboard.
+func hostSymbolKeys(unicodeChar uint16) []spectrum.KeyChord {
+	c := rune(unicodeChar)
+	if (c == 0) || (c == ' ') || unicode.IsLetter(c) || unicode.IsDigit(c) || unicode.IsControl(c) {
+		return nil
+	}
+	return spectrum.CharKeys(c)
+}
+
+// Handles a key event when the console is hidden.
+// 'symbols' records the symbols being typed, because SDL reports the character only in KEYDOWN events.
+func speccyKeyEvent(speccy *spectrum.Spectrum48k, e *sdl.KeyboardEvent, keyName string, symbols map[string][]spectrum.KeyChord) {
+	keyboard := speccy.Keyboard
+	shift := (e.Keysym.Mod & (sdl.KMOD_LSHIFT | sdl.KMOD_RSHIFT)) != 0
+
+	switch e.Type {
+	case sdl.KEYDOWN:
+		if speccy.Input.IsKeyBound(keyName) {
+			// A binding in an input profile overrides the translation of symbols
+			speccy.Input.HostKey(keyName, true)
+			return
+		}
+
+		if chords := hostSymbolKeys(e.Keysym.Unicode); chords != nil {
+			// The host's Shift key must not act as CAPS SHIFT while typing the symbol
+			keyboard.KeyUp(spectrum.KEY_CapsShift)
+
+			if len(chords) == 1 {
+				for i := 0; i < len(chords[0]); i++ {
+					keyboard.KeyDown(chords[0][i])
+				}
+			} else {
+				// E mode: the keys have to be pressed one after another
+				go keyboard.KeyPressChordSequence(chords...)
+			}
+			symbols[keyName] = chords
+			return
+		}
+
+		speccy.Input.HostKey(keyName, true)
+
+	case sdl.KEYUP:
+		if chords, typingSymbol := symbols[keyName]; typingSymbol {
+			delete(symbols, keyName)
+			if len(chords) == 1 {
+				for i := len(chords[0]) - 1; i >= 0; i-- {
+					keyboard.KeyUp(chords[0][i])
+				}
+			}
+			if shift {
+				keyboard.KeyDown(spectrum.KEY_CapsShift)
+			}
+			return
+		}
+
+		speccy.Input.HostKey(keyName, false)
+	}
+}
+
+// Returns the contents of the clipboard
+func readClipboard() (string, error) {
+	for _, command := range clipboardCommands {
+		path, err := exec.LookPath(command[0])
+		if err != nil {
+			continue
+		}
+		text, err := exec.Command(path, command[1:]...).Output()
+		if err != nil {
+			return "", err
+		}
+		return string(text), nil
+	}
+	return "", errors.New("cannot read the clipboard: xclip, xsel or wl-paste is required")
+}
+
+// Types the contents of the clipboard on the Spectrum keyboard
+func pasteClipboard(app *spectrum.Application, speccy *spectrum.Spectrum48k) {
+	text, err := readClipboard()
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		return
+	}
+	if app.Verbose {
+		app.PrintfMsg("pasting %d characters", len(text))
+	}
+	speccy.Keyboard.TypeText(text, true /*basic*/)
+}
@@ -0,0 +1,265 @@
+// +build linux freebsd
+
+package sdl_output
+
+import (
+	"errors"
+	"strings"
+)
+
+// The largest supported display scale
+const MAX_SCALE = 4
+
+// An edge-aware pixel-art scaler.
+// Each scaler works with a single scale factor (see 'Scaler.factor').
+type Scaler uint
+
+const (
+	SCALER_NONE     Scaler = iota // Plain pixel replication, works with any scale
+	SCALER_SCALE2X                // Scale2x (also known as AdvMAME2x)
+	SCALER_SCALE3X                // Scale3x (also known as AdvMAME3x)
+	SCALER_SMOOTH2X               // Blends the pixels along edges with their nearest neighbours
+)
+
+var scalerNames = []struct {
+	name   string
+	scaler Scaler
+}{
+	{"none", SCALER_NONE},
+	{"scale2x", SCALER_SCALE2X},
+	{"advmame2x", SCALER_SCALE2X},
+	{"scale3x", SCALER_SCALE3X},
+	{"advmame3x", SCALER_SCALE3X},
+	{"smooth2x", SCALER_SMOOTH2X},
+}
+
+// Parses the name of a scaler, such as "scale2x" or "smooth2x".
+// The empty string denotes SCALER_NONE.
+func ParseScaler(name string) (Scaler, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return SCALER_NONE, nil
+	}
+
+	for _, s := range scalerNames {
+		if s.name == name {
+			return s.scaler, nil
+		}
+	}
+
+	return SCALER_NONE, errors.New("unknown scaler \"" + name + "\"")
+}
+
+func (scaler Scaler) String() string {
+	for _, s := range scalerNames {
+		if s.scaler == scaler {
+			return s.name
+		}
+	}
+	return "unknown"
+}
+
+// Returns the scale factor produced by the scaler,
+// or 0 if the scaler works with any scale factor.
+func (scaler Scaler) factor() uint {
+	switch scaler {
+	case SCALER_SCALE2X, SCALER_SMOOTH2X:
+		return 2
+	case SCALER_SCALE3X:
+		return 3
+	}
+	return 0
+}
+
+// Returns the scaler which is actually going to be used at the specified scale.
+// A scaler which cannot produce the scale degrades to SCALER_NONE.
+func (scaler Scaler) at(scale uint) Scaler {
+	if (scaler.factor() != 0) && (scaler.factor() != scale) {
+		return SCALER_NONE
+	}
+	return scaler
+}
+
+// Returns the number of unscaled pixels by which a changed region has to be extended
+// (both horizontally and vertically), because the scaler is looking at neighbouring pixels.
+func (scaler Scaler) bleed() uint {
+	if scaler != SCALER_NONE {
+		return 1
+	}
+	return 0
+}
+
+// The 3x3 neighbourhood of an unscaled pixel:
+//
+//	A B C
+//	D E F
+//	G H I
+//
+// 'base' contains the colors used for detecting edges,
+// 'color' contains the colors used for the output.
+type neighbourhood_t struct {
+	base  [9]uint32
+	color [9]uint32
+}
+
+const (
+	_A = iota
+	_B
+	_C
+	_D
+	_E
+	_F
+	_G
+	_H
+	_I
+)
+
+// Implements the Scale2x algorithm.
+// The 2x2 output block is stored to 'out' in row-major order.
+func scale2x(n *neighbourhood_t, out []uint32) {
+	b := &n.base
+	c := &n.color
+
+	E := c[_E]
+	out[0], out[1], out[2], out[3] = E, E, E, E
+
+	if (b[_B] != b[_H]) && (b[_D] != b[_F]) {
+		if b[_D] == b[_B] {
+			out[0] = c[_D]
+		}
+		if b[_B] == b[_F] {
+			out[1] = c[_F]
+		}
+		if b[_D] == b[_H] {
+			out[2] = c[_D]
+		}
+		if b[_H] == b[_F] {
+			out[3] = c[_F]
+		}
+	}
+}
+
+// Implements the Scale3x algorithm.
+// The 3x3 output block is stored to 'out' in row-major order.
+func scale3x(n *neighbourhood_t, out []uint32) {
+	b := &n.base
+	c := &n.color
+
+	E := c[_E]
+	for i := 0; i < 9; i++ {
+		out[i] = E
+	}
+
+	if (b[_B] != b[_H]) && (b[_D] != b[_F]) {
+		e := b[_E]
+
+		if b[_D] == b[_B] {
+			out[0] = c[_D]
+		}
+		if ((b[_D] == b[_B]) && (e != b[_C])) || ((b[_B] == b[_F]) && (e != b[_A])) {
+			out[1] = c[_B]
+		}
+		if b[_B] == b[_F] {
+			out[2] = c[_F]
+		}
+		if ((b[_D] == b[_B]) && (e != b[_G])) || ((b[_D] == b[_H]) && (e != b[_A])) {
+			out[3] = c[_D]
+		}
+		if ((b[_B] == b[_F]) && (e != b[_I])) || ((b[_H] == b[_F]) && (e != b[_C])) {
+			out[5] = c[_F]
+		}
+		if b[_D] == b[_H] {
+			out[6] = c[_D]
+		}
+		if ((b[_D] == b[_H]) && (e != b[_I])) || ((b[_H] == b[_F]) && (e != b[_G])) {
+			out[7] = c[_H]
+		}
+		if b[_H] == b[_F] {
+			out[8] = c[_F]
+		}
+	}
+}
+
+// Returns true if the colors are perceptually different,
+// using the YUV thresholds of the HQnx scalers.
+func yuvDiff(c1, c2 uint32) bool {
+	if c1 == c2 {
+		return false
+	}
+
+	yuv := func(c uint32) (int, int, int) {
+		r := int((c >> 16) & 0xFF)
+		g := int((c >> 8) & 0xFF)
+		b := int((c >> 0) & 0xFF)
+		y := (r + g + b) >> 2
+		u := 128 + ((r - b) >> 2)
+		v := 128 + ((2*g - r - b) >> 3)
+		return y, u, v
+	}
+
+	abs := func(a int) int {
+		if a < 0 {
+			return -a
+		}
+		return a
+	}
+
+	y1, u1, v1 := yuv(c1)
+	y2, u2, v2 := yuv(c2)
+
+	return (abs(y1-y2) > 0x30) || (abs(u1-u2) > 0x07) || (abs(v1-v2) > 0x06)
+}
+
+// Computes (w1*c1 + w2*c2) >> shift, component-wise.
+// The sum of the weights has to be equal to (1 << shift).
+func interp2(c1, w1, c2, w2 uint32, shift uint) uint32 {
+	R := (((c1>>16)&0xFF)*w1 + ((c2>>16)&0xFF)*w2) >> shift
+	G := (((c1>>8)&0xFF)*w1 + ((c2>>8)&0xFF)*w2) >> shift
+	B := (((c1>>0)&0xFF)*w1 + ((c2>>0)&0xFF)*w2) >> shift
+	return (c1 & 0xFF000000) | (R << 16) | (G << 8) | B
+}
+
+// Computes (w1*c1 + w2*c2 + w3*c3) >> shift, component-wise.
+// The sum of the weights has to be equal to (1 << shift).
+func interp3(c1, w1, c2, w2, c3, w3 uint32, shift uint) uint32 {
+	R := (((c1>>16)&0xFF)*w1 + ((c2>>16)&0xFF)*w2 + ((c3>>16)&0xFF)*w3) >> shift
+	G := (((c1>>8)&0xFF)*w1 + ((c2>>8)&0xFF)*w2 + ((c3>>8)&0xFF)*w3) >> shift
+	B := (((c1>>0)&0xFF)*w1 + ((c2>>0)&0xFF)*w2 + ((c3>>0)&0xFF)*w3) >> shift
+	return (c1 & 0xFF000000) | (R << 16) | (G << 8) | B
+}
+
+// Computes one output pixel of Smooth2x.
+// 'e' is the center pixel, 'd' the diagonal neighbour in the direction of the output pixel,
+// 'h' and 'v' the horizontal and vertical neighbours in the direction of the output pixel.
+func smooth2x_pixel(n *neighbourhood_t, e, h, v, d int) uint32 {
+	b := &n.base
+	c := &n.color
+
+	switch {
+	case !yuvDiff(b[h], b[v]) && yuvDiff(b[e], b[h]):
+		// An edge is passing between the center and the corner:
+		// blend the center with the two neighbours on the other side of the edge
+		if !yuvDiff(b[d], b[h]) {
+			return interp3(c[e], 2, c[h], 1, c[v], 1, 2)
+		}
+		return interp3(c[e], 6, c[h], 1, c[v], 1, 3)
+
+	case yuvDiff(b[e], b[d]) && !yuvDiff(b[e], b[h]) && !yuvDiff(b[e], b[v]):
+		// Only the corner differs: move the center a quarter of the way towards it
+		return interp2(c[e], 3, c[d], 1, 2)
+	}
+
+	return c[e]
+}
+
+// Implements Smooth2x, an edge-smoothing scaler producing 2x2 pixels from each pixel.
+// It uses the color comparison of HQ2x, but not its 256-case rule table:
+// each output pixel is blended only with its three nearest neighbours
+// (the horizontal, the vertical and the diagonal neighbour on the side of the output pixel).
+// The 2x2 output block is stored to 'out' in row-major order.
+func smooth2x(n *neighbourhood_t, out []uint32) {
+	out[0] = smooth2x_pixel(n, _E, _D, _B, _A)
+	out[1] = smooth2x_pixel(n, _E, _F, _B, _C)
+	out[2] = smooth2x_pixel(n, _E, _D, _H, _G)
+	out[3] = smooth2x_pixel(n, _E, _F, _H, _I)
+}
@@ -3,7 +3,8 @@
 package sdl_output
 
 import (
-	intp "github.com/remogatto/gospeccy/src/interpreter"
+	"fmt"
+	"github.com/remogatto/gospeccy/src/interpreter"
 	"github.com/sbinet/go-eval"
 	"sync"
 )
@@ -11,11 +12,14 @@ import (
 type userInterfaceSettings_t interface {
 	Terminated() bool
 
-	ResizeVideo(scale2x, fullscreen bool)
+	ResizeVideo(scale uint, fullscreen bool)
 	ShowPaintedRegions(enable bool)
 	EnableAudio(enable bool)
 	SetAudioFreq(freq uint) // 0 means "default frequency"
 	SetAudioQuality(hqAudio bool)
+	SetScaler(name string) error
+	SetFilter(name string) error
+	SetMouseGrab(enable bool)
 }
 
 var uiSettings userInterfaceSettings_t
@@ -29,42 +33,39 @@ func setUI(ui userInterfaceSettings_t) {
 }
 
 // Signature: func scale(n uint)
-func wrapper_scale(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func wrapper_scale(intp *interpreter.Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
 	if uiSettings.Terminated() {
 		return
 	}
 	n := in[0].(eval.UintValue).Get(t)
-	switch n {
-	case 1:
+	if (n >= 1) && (n <= MAX_SCALE) {
 		mutex.Lock()
-		uiSettings.ResizeVideo(false, false)
-		mutex.Unlock()
-	case 2:
-		mutex.Lock()
-		uiSettings.ResizeVideo(true, false)
+		uiSettings.ResizeVideo(uint(n), false)
 		mutex.Unlock()
+	} else {
+		fmt.Fprintf(intp.Stdout(), "invalid scale %d (expected 1...%d)\n", n, MAX_SCALE)
 	}
 }
 
 // Signature: func fullscreen(enable bool)
-func wrapper_fullscreen(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func wrapper_fullscreen(intp *interpreter.Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
 	if uiSettings.Terminated() {
 		return
 	}
 	enable := in[0].(eval.BoolValue).Get(t)
 	if enable {
 		mutex.Lock()
-		uiSettings.ResizeVideo(true, true)
+		uiSettings.ResizeVideo(2, true)
 		mutex.Unlock()
 	} else {
 		mutex.Lock()
-		uiSettings.ResizeVideo(true, false)
+		uiSettings.ResizeVideo(2, false)
 		mutex.Unlock()
 	}
 }
 
 // Signature: func showPaint(enable bool)
-func wrapper_showPaint(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func wrapper_showPaint(intp *interpreter.Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
 	if uiSettings.Terminated() {
 		return
 	}
@@ -77,7 +78,7 @@ func wrapper_showPaint(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func audio(enable bool)
-func wrapper_audio(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func wrapper_audio(intp *interpreter.Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
 	if uiSettings.Terminated() {
 		return
 	}
@@ -90,7 +91,7 @@ func wrapper_audio(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func audioFreq(freq uint)
-func wrapper_audioFreq(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func wrapper_audioFreq(intp *interpreter.Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
 	if uiSettings.Terminated() {
 		return
 	}
@@ -103,7 +104,7 @@ func wrapper_audioFreq(t *eval.Thread, in []eval.Value, out []eval.Value) {
 }
 
 // Signature: func audioHQ(enable bool)
-func wrapper_audioHQ(t *eval.Thread, in []eval.Value, out []eval.Value) {
+func wrapper_audioHQ(intp *interpreter.Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
 	if uiSettings.Terminated() {
 		return
 	}
@@ -115,73 +116,140 @@ func wrapper_audioHQ(t *eval.Thread, in []eval.Value, out []eval.Value) {
 	mutex.Unlock()
 }
 
+// Signature: func scaler(name string)
+func wrapper_scaler(intp *interpreter.Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if uiSettings.Terminated() {
+		return
+	}
+
+	name := in[0].(eval.StringValue).Get(t)
+
+	mutex.Lock()
+	err := uiSettings.SetScaler(name)
+	mutex.Unlock()
+
+	if err != nil {
+		fmt.Fprintf(intp.Stdout(), "%s\n", err)
+	}
+}
+
+// Signature: func filter(name string)
+func wrapper_filter(intp *interpreter.Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if uiSettings.Terminated() {
+		return
+	}
+
+	name := in[0].(eval.StringValue).Get(t)
+
+	mutex.Lock()
+	err := uiSettings.SetFilter(name)
+	mutex.Unlock()
+
+	if err != nil {
+		fmt.Fprintf(intp.Stdout(), "%s\n", err)
+	}
+}
+
+// Signature: func mouseGrab(enable bool)
+func wrapper_mouseGrab(intp *interpreter.Interpreter, t *eval.Thread, in []eval.Value, out []eval.Value) {
+	if uiSettings.Terminated() {
+		return
+	}
+	enable := in[0].(eval.BoolValue).Get(t)
+	mutex.Lock()
+	uiSettings.SetMouseGrab(enable)
+	mutex.Unlock()
+}
+
+func defineFunction(f interpreter.Function) {
+	err := interpreter.DefineFunction(f)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func defineFunctions() {
 	{
-		var functionSignature func(uint)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_scale, functionSignature)
-		intp.DefineFunction(intp.Function{
+		defineFunction(interpreter.Function{
 			Name:       "scale",
-			Type:       funcType,
-			Value:      funcValue,
+			Signature:  (func(uint))(nil),
+			Value:      wrapper_scale,
 			Help_key:   "scale(n uint)",
-			Help_value: "Change the display scale (1 or 2)",
+			Help_value: "Change the display scale (1...4)",
+		})
+	}
+	{
+		defineFunction(interpreter.Function{
+			Name:       "scaler",
+			Signature:  (func(string))(nil),
+			Value:      wrapper_scaler,
+			Help_key:   "scaler(name string)",
+			Help_value: "Change the display scaler (none, scale2x, scale3x, smooth2x)",
 		})
 	}
 	{
-		var functionSignature func(bool)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_fullscreen, functionSignature)
-		intp.DefineFunction(intp.Function{
+		defineFunction(interpreter.Function{
 			Name:       "fullscreen",
-			Type:       funcType,
-			Value:      funcValue,
+			Signature:  (func(bool))(nil),
+			Value:      wrapper_fullscreen,
 			Help_key:   "fullscreen(enable bool)",
 			Help_value: "Fullscreen on/off",
 		})
 	}
 	{
-		var functionSignature func(bool)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_showPaint, functionSignature)
-		intp.DefineFunction(intp.Function{
+		defineFunction(interpreter.Function{
 			Name:       "showPaint",
-			Type:       funcType,
-			Value:      funcValue,
+			Signature:  (func(bool))(nil),
+			Value:      wrapper_showPaint,
 			Help_key:   "showPaint(enable bool)",
 			Help_value: "Show painted regions",
 		})
 	}
 	{
-		var functionSignature func(bool)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_audio, functionSignature)
-		intp.DefineFunction(intp.Function{
+		defineFunction(interpreter.Function{
 			Name:       "audio",
-			Type:       funcType,
-			Value:      funcValue,
+			Signature:  (func(bool))(nil),
+			Value:      wrapper_audio,
 			Help_key:   "audio(enable bool)",
 			Help_value: "Enable or disable audio",
 		})
 	}
 	{
-		var functionSignature func(uint)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_audioFreq, functionSignature)
-		intp.DefineFunction(intp.Function{
+		defineFunction(interpreter.Function{
 			Name:       "audioFreq",
-			Type:       funcType,
-			Value:      funcValue,
+			Signature:  (func(uint))(nil),
+			Value:      wrapper_audioFreq,
 			Help_key:   "audioFreq(freq uint)",
 			Help_value: "Set audio playback frequency (0=default frequency)",
 		})
 	}
 	{
-		var functionSignature func(bool)
-		funcType, funcValue := eval.FuncFromNativeTyped(wrapper_audioHQ, functionSignature)
-		intp.DefineFunction(intp.Function{
+		defineFunction(interpreter.Function{
 			Name:       "audioHQ",
-			Type:       funcType,
-			Value:      funcValue,
+			Signature:  (func(bool))(nil),
+			Value:      wrapper_audioHQ,
 			Help_key:   "audioHQ(enable bool)",
 			Help_value: "Enable or disable high-quality audio",
 		})
 	}
+	{
+		defineFunction(interpreter.Function{
+			Name:       "filter",
+			Signature:  (func(string))(nil),
+			Value:      wrapper_filter,
+			Help_key:   "filter(name string)",
+			Help_value: "Change the display filters (none, scanlines, pal, crt, e.g. \"pal+scanlines\")",
+		})
+	}
+	{
+		defineFunction(interpreter.Function{
+			Name:       "mouseGrab",
+			Signature:  (func(bool))(nil),
+			Value:      wrapper_mouseGrab,
+			Help_key:   "mouseGrab(enable bool)",
+			Help_value: "Grab the mouse pointer for the Kempston mouse (also toggled by F11)",
+		})
+	}
 }
 
 func init() {
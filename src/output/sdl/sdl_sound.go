@@ -466,6 +466,8 @@ func add_hq(samples []float64, x, w, h, spread, spread1 float64) {
 }
 
 func (audio *SDLAudio) render(audioData *spectrum.AudioData) {
+	tstatesPerFrame := audioData.Timing.TStatesPerFrame
+
 	var events []spectrum.BeeperEvent
 
 	if len(audioData.BeeperEvents) > 0 {
@@ -473,23 +475,23 @@ func (audio *SDLAudio) render(audioData *spectrum.AudioData) {
 		spectrum.Assert(firstEvent.TState == 0)
 
 		var lastEvent *spectrum.BeeperEvent = &audioData.BeeperEvents[len(audioData.BeeperEvents)-1]
-		spectrum.Assert(lastEvent.TState == spectrum.TStatesPerFrame)
+		spectrum.Assert(lastEvent.TState == tstatesPerFrame)
 
 		events = audioData.BeeperEvents
 	} else {
 		events = make([]spectrum.BeeperEvent, 2)
 		events[0] = spectrum.BeeperEvent{TState: 0, Level: 0}
-		events[1] = spectrum.BeeperEvent{TState: spectrum.TStatesPerFrame, Level: 0}
+		events[1] = spectrum.BeeperEvent{TState: tstatesPerFrame, Level: 0}
 	}
 
 	/*
 		// A test signal
-		const D = spectrum.TStatesPerFrame/128
-		events = make([]spectrum.BeeperEvent, spectrum.TStatesPerFrame/D+1)
+		D := tstatesPerFrame / 128
+		events = make([]spectrum.BeeperEvent, tstatesPerFrame/D+1)
 		for i:=uint(0); i<128; i++ {
 			events[i] = spectrum.BeeperEvent{TState: i*D, Level: uint8(i%2)}
 		}
-		events[len(events)-1] = spectrum.BeeperEvent{TState: spectrum.TStatesPerFrame, Level: 0}
+		events[len(events)-1] = spectrum.BeeperEvent{TState: tstatesPerFrame, Level: 0}
 	*/
 
 	numEvents := len(events)
@@ -535,7 +537,7 @@ func (audio *SDLAudio) render(audioData *spectrum.AudioData) {
 		audio.mutex.Unlock()
 	}
 
-	var k float64 = float64(numSamples) / spectrum.TStatesPerFrame
+	var k float64 = float64(numSamples) / float64(tstatesPerFrame)
 
 	{
 		for i := 0; i < len(samples); i++ {
@@ -560,6 +562,24 @@ func (audio *SDLAudio) render(audioData *spectrum.AudioData) {
 			}
 		}
 
+		// Mix in the output of the sampled sound devices
+		sampleEvents := audioData.SampleEvents
+		for i := 0; i < len(sampleEvents)-1; i++ {
+			start := sampleEvents[i]
+			end := sampleEvents[i+1]
+
+			level := float64(start.Level)
+
+			var position0 float64 = float64(start.TState) * k
+			var position1 float64 = float64(end.TState) * k
+
+			if audio.hqAudio {
+				add_hq(samples, position0+1, position1-position0, level, spread, spread1)
+			} else {
+				add_lq(samples, position0+1, position1-position0, level)
+			}
+		}
+
 		copy(overflow[:], samples[numSamples:])
 	}
 
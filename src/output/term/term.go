@@ -0,0 +1,56 @@
+// +build linux freebsd
+
+// GoSpeccy terminal interface (video output to an ANSI terminal, keyboard input from stdin)
+package term_output
+
+import (
+	"flag"
+	"github.com/remogatto/gospeccy/src/env"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"os"
+	"reflect"
+	"sync"
+)
+
+var (
+	enableTerm = flag.Bool("term", false, "Enable the terminal user interface (24-bit ANSI colors, replaces the SDL window)")
+)
+
+// Returns whether the terminal user interface has been enabled on the command-line
+func Enabled() bool {
+	return *enableTerm
+}
+
+func Main() {
+	var init_waitGroup *sync.WaitGroup
+	init_waitGroup = env.WaitName("init WaitGroup").(*sync.WaitGroup)
+	init_waitGroup.Add(1)
+
+	var app *spectrum.Application
+	app = env.Wait(reflect.TypeOf(app)).(*spectrum.Application)
+
+	var speccy *spectrum.Spectrum48k
+	speccy = env.Wait(reflect.TypeOf(speccy)).(*spectrum.Spectrum48k)
+
+	if !*enableTerm {
+		init_waitGroup.Done()
+		return
+	}
+
+	terminal, err := newTerminal(os.Stdin)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		app.RequestExit()
+		init_waitGroup.Done()
+		return
+	}
+
+	// Setup the display
+	display := NewTermDisplay(app, os.Stdout)
+	speccy.CommandChannel <- spectrum.Cmd_AddDisplay{display}
+
+	// Start the input loop
+	go inputLoop(app, speccy, terminal, os.Stdout)
+
+	init_waitGroup.Done()
+}
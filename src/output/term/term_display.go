@@ -0,0 +1,233 @@
+// +build linux freebsd
+
+package term_output
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"io"
+	"time"
+)
+
+// The size of the border drawn around the Spectrum screen.
+// The border is drawn using a single color.
+const (
+	BORDER_COLUMNS = 4
+	BORDER_ROWS    = 2 // Each row corresponds to 2 scanlines
+)
+
+// The size of the terminal area occupied by the Spectrum screen, border included
+const (
+	TERM_COLUMNS = spectrum.ScreenWidth + 2*BORDER_COLUMNS
+	TERM_ROWS    = spectrum.ScreenHeight/2 + 2*BORDER_ROWS
+)
+
+// The Unicode character "upper half block".
+// The foreground color is the color of the upper pixel,
+// the background color is the color of the lower pixel.
+const upperHalfBlock = "▀"
+
+// Renders the Spectrum screen to an ANSI terminal supporting 24-bit colors.
+// Each character cell displays two vertically adjacent pixels.
+type TermDisplay struct {
+	// Channel for receiving display changes
+	displayChannel chan *spectrum.DisplayData
+
+	out     io.Writer
+	palette *[16]uint32 // Follows 'app.Palette()'
+
+	// True after the terminal has been cleared
+	initialized bool
+
+	// The border color currently displayed by the terminal, or -1
+	borderColor int
+
+	// The colors set by the last SGR escape sequence, or -1
+	fg, bg int
+
+	app *spectrum.Application
+}
+
+func NewTermDisplay(app *spectrum.Application, out io.Writer) *TermDisplay {
+	display := newTermDisplay(app, out)
+	go display.renderLoop(app.NewEventLoop())
+	return display
+}
+
+func newTermDisplay(app *spectrum.Application, out io.Writer) *TermDisplay {
+	return &TermDisplay{
+		displayChannel: make(chan *spectrum.DisplayData),
+		out:            out,
+		palette:        app.Palette(),
+		borderColor:    -1,
+		app:            app,
+	}
+}
+
+// Implement DisplayReceiver
+func (display *TermDisplay) GetDisplayDataChannel() chan<- *spectrum.DisplayData {
+	return display.displayChannel
+}
+
+func (display *TermDisplay) Close() {
+	display.displayChannel <- nil
+}
+
+func (display *TermDisplay) renderLoop(evtLoop *spectrum.EventLoop) {
+	terminating := false
+
+	for {
+		select {
+		case <-evtLoop.Pause:
+			terminating = true
+			evtLoop.Pause <- 0
+
+		case <-evtLoop.Terminate:
+			// Terminate this Go routine
+			if evtLoop.App().Verbose {
+				evtLoop.App().PrintfMsg("terminal render loop: exit")
+			}
+			evtLoop.Terminate <- 0
+			return
+
+		case screen := <-display.displayChannel:
+			if screen != nil {
+				if !terminating {
+					display.render(screen)
+				}
+			} else {
+				done := evtLoop.Delete()
+				go func() { <-done }()
+			}
+		}
+	}
+}
+
+// Moves the cursor to the specified position (0-based)
+func moveTo(buf *bytes.Buffer, column, row int) {
+	fmt.Fprintf(buf, "\x1b[%d;%dH", row+1, column+1)
+}
+
+func (display *TermDisplay) setColors(buf *bytes.Buffer, fg, bg byte) {
+	if int(fg) != display.fg {
+		c := display.palette[fg]
+		fmt.Fprintf(buf, "\x1b[38;2;%d;%d;%dm", (c>>16)&0xFF, (c>>8)&0xFF, c&0xFF)
+		display.fg = int(fg)
+	}
+	if int(bg) != display.bg {
+		c := display.palette[bg]
+		fmt.Fprintf(buf, "\x1b[48;2;%d;%d;%dm", (c>>16)&0xFF, (c>>8)&0xFF, c&0xFF)
+		display.bg = int(bg)
+	}
+}
+
+func (display *TermDisplay) renderBorder(buf *bytes.Buffer, color byte) {
+	display.setColors(buf, color, color)
+
+	var line bytes.Buffer
+	for x := 0; x < TERM_COLUMNS; x++ {
+		line.WriteString(" ")
+	}
+	var side bytes.Buffer
+	for x := 0; x < BORDER_COLUMNS; x++ {
+		side.WriteString(" ")
+	}
+
+	for row := 0; row < BORDER_ROWS; row++ {
+		moveTo(buf, 0, row)
+		buf.Write(line.Bytes())
+		moveTo(buf, 0, TERM_ROWS-BORDER_ROWS+row)
+		buf.Write(line.Bytes())
+	}
+	for row := BORDER_ROWS; row < TERM_ROWS-BORDER_ROWS; row++ {
+		moveTo(buf, 0, row)
+		buf.Write(side.Bytes())
+		moveTo(buf, TERM_COLUMNS-BORDER_COLUMNS, row)
+		buf.Write(side.Bytes())
+	}
+}
+
+// Renders the 8x8 attribute cell at (attr_x,attr_y) as 4 rows of 8 characters
+func (display *TermDisplay) renderCell(buf *bytes.Buffer, screen *spectrum.DisplayData, attr_x, attr_y uint) {
+	for row := uint(0); row < 4; row++ {
+		moveTo(buf, int(BORDER_COLUMNS+8*attr_x), int(BORDER_ROWS+4*attr_y+row))
+
+		y := 8*attr_y + 2*row
+		ofs_upper := (y << spectrum.BytesPerLine_log2) + attr_x
+		ofs_lower := ofs_upper + spectrum.BytesPerLine
+
+		attr_upper := byte(screen.Attr[ofs_upper])
+		attr_lower := byte(screen.Attr[ofs_lower])
+		bitmap_upper := screen.Bitmap[ofs_upper]
+		bitmap_lower := screen.Bitmap[ofs_lower]
+
+		for x := uint(0); x < 8; x++ {
+			// Paper is in the lower 4 bits, ink is in the higher 4 bits
+			var upper, lower byte
+			if ((bitmap_upper >> (7 - x)) & 1) != 0 {
+				upper = attr_upper >> 4
+			} else {
+				upper = attr_upper & 0xf
+			}
+			if ((bitmap_lower >> (7 - x)) & 1) != 0 {
+				lower = attr_lower >> 4
+			} else {
+				lower = attr_lower & 0xf
+			}
+
+			display.setColors(buf, upper, lower)
+			buf.WriteString(upperHalfBlock)
+		}
+	}
+}
+
+func (display *TermDisplay) render(screen *spectrum.DisplayData) {
+	var buf bytes.Buffer
+
+	// The state of the terminal is unknown at the beginning of a frame
+	display.fg = -1
+	display.bg = -1
+
+	if !display.initialized {
+		// Clear the terminal and hide the cursor
+		buf.WriteString("\x1b[0m\x1b[2J\x1b[?25l")
+		display.initialized = true
+	}
+
+	// After a change of the palette, the whole screen has to be repainted
+	repaint := false
+	if palette := display.app.Palette(); palette != display.palette {
+		display.palette = palette
+		display.borderColor = -1
+		repaint = true
+	}
+
+	if n := len(screen.BorderEvents); n > 0 {
+		color := screen.BorderEvents[n-1].Color
+		if int(color) != display.borderColor {
+			display.renderBorder(&buf, color)
+			display.borderColor = int(color)
+		}
+	}
+
+	for attr_y := uint(0); attr_y < spectrum.ScreenHeight_Attr; attr_y++ {
+		for attr_x := uint(0); attr_x < spectrum.ScreenWidth_Attr; attr_x++ {
+			if repaint || screen.Dirty[attr_y*spectrum.ScreenWidth_Attr+attr_x] {
+				display.renderCell(&buf, screen, attr_x, attr_y)
+			}
+		}
+	}
+
+	if buf.Len() > 0 {
+		// Move the cursor below the screen, so that messages do not overwrite it
+		buf.WriteString("\x1b[0m")
+		moveTo(&buf, 0, TERM_ROWS)
+
+		display.out.Write(buf.Bytes())
+	}
+
+	if screen.CompletionTime_orNil != nil {
+		screen.CompletionTime_orNil <- time.Now()
+	}
+}
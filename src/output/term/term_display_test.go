@@ -0,0 +1,116 @@
+// +build linux freebsd
+
+package term_output
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"strings"
+	"testing"
+)
+
+func TestRenderOnlyDirtyCells(t *testing.T) {
+	var out bytes.Buffer
+	display := newTermDisplay(spectrum.NewApplication(), &out)
+
+	// First frame: the whole screen
+	screen := &spectrum.DisplayData{}
+	for i := range screen.Dirty {
+		screen.Dirty[i] = true
+	}
+	screen.BorderEvents = []spectrum.BorderEvent{{0, 7}, {spectrum.Timing48K.TStatesPerFrame, 7}}
+	screen.Timing = spectrum.Timing48K
+	display.render(screen)
+
+	numBlocks := strings.Count(out.String(), upperHalfBlock)
+	if numBlocks != spectrum.ScreenWidth*spectrum.ScreenHeight/2 {
+		t.Errorf("expected %d half-blocks, got %d", spectrum.ScreenWidth*spectrum.ScreenHeight/2, numBlocks)
+	}
+
+	// Second frame: a single 8x8 cell, the border is unchanged
+	out.Reset()
+	screen = &spectrum.DisplayData{}
+	screen.Dirty[5] = true
+	screen.BorderEvents = []spectrum.BorderEvent{{0, 7}, {spectrum.Timing48K.TStatesPerFrame, 7}}
+	screen.Timing = spectrum.Timing48K
+	display.render(screen)
+
+	numBlocks = strings.Count(out.String(), upperHalfBlock)
+	if numBlocks != 8*4 {
+		t.Errorf("expected %d half-blocks, got %d", 8*4, numBlocks)
+	}
+	if !strings.Contains(out.String(), "\x1b[3;45H") {
+		t.Errorf("the dirty cell was rendered at a wrong position")
+	}
+}
+
+func TestPaletteChange(t *testing.T) {
+	var out bytes.Buffer
+	app := spectrum.NewApplication()
+	display := newTermDisplay(app, &out)
+
+	screen := &spectrum.DisplayData{}
+	for i := range screen.Dirty {
+		screen.Dirty[i] = true
+	}
+	screen.BorderEvents = []spectrum.BorderEvent{{0, 2}, {spectrum.Timing48K.TStatesPerFrame, 2}}
+	screen.Timing = spectrum.Timing48K
+	display.render(screen)
+
+	// Nothing has changed on the screen, but the colors are different
+	app.SetPalette(&spectrum.Palette_GreenScreen)
+	out.Reset()
+	screen.Dirty = [len(screen.Dirty)]bool{}
+	display.render(screen)
+
+	numBlocks := strings.Count(out.String(), upperHalfBlock)
+	if numBlocks != spectrum.ScreenWidth*spectrum.ScreenHeight/2 {
+		t.Errorf("expected %d half-blocks, got %d", spectrum.ScreenWidth*spectrum.ScreenHeight/2, numBlocks)
+	}
+
+	c := spectrum.Palette_GreenScreen[2]
+	border := fmt.Sprintf("\x1b[48;2;%d;%d;%dm", (c>>16)&0xFF, (c>>8)&0xFF, c&0xFF)
+	if !strings.Contains(out.String(), border) {
+		t.Errorf("the border was not repainted using the new palette")
+	}
+}
+
+func TestTranslateInput(t *testing.T) {
+	sequences := translateInput([]byte("aZ\x1b[A."))
+
+	expected := [][]uint{
+		{spectrum.KEY_A},
+		{spectrum.KEY_CapsShift, spectrum.KEY_Z},
+		{spectrum.KEY_CapsShift, spectrum.KEY_7},
+		{spectrum.KEY_SymbolShift, spectrum.KEY_M},
+	}
+
+	if len(sequences) != len(expected) {
+		t.Fatalf("expected %d key sequences, got %d", len(expected), len(sequences))
+	}
+	for i := range expected {
+		if len(sequences[i]) != len(expected[i]) {
+			t.Fatalf("sequence %d: expected %v, got %v", i, expected[i], sequences[i])
+		}
+		for j := range expected[i] {
+			if sequences[i][j] != expected[i][j] {
+				t.Errorf("sequence %d: expected %v, got %v", i, expected[i], sequences[i])
+			}
+		}
+	}
+}
+
+func TestBracketedPaste(t *testing.T) {
+	var p pasteParser_t
+
+	// The paste is split between two reads
+	typed, pasted := p.parse([]byte("a" + PASTE_START + "10 PRINT"))
+	if (string(typed) != "a") || (pasted != nil) {
+		t.Fatalf("unexpected result: typed %q, pasted %q", typed, pasted)
+	}
+	typed, pasted = p.parse([]byte(" 1\r" + PASTE_END + "b"))
+	if (string(typed) != "b") || (string(pasted) != "10 PRINT 1\r") {
+		t.Errorf("unexpected result: typed %q, pasted %q", typed, pasted)
+	}
+}
@@ -0,0 +1,161 @@
+// +build linux freebsd
+
+package term_output
+
+import (
+	"bytes"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"io"
+	"os"
+	"time"
+)
+
+// Maps the final character of ANSI cursor-key escape sequences ("ESC [ x") to Spectrum keys
+var escapeKeyMap = map[byte]spectrum.KeyChord{
+	'A': spectrum.KeyChord{spectrum.KEY_CapsShift, spectrum.KEY_7}, // Up
+	'B': spectrum.KeyChord{spectrum.KEY_CapsShift, spectrum.KEY_6}, // Down
+	'C': spectrum.KeyChord{spectrum.KEY_CapsShift, spectrum.KEY_8}, // Right
+	'D': spectrum.KeyChord{spectrum.KEY_CapsShift, spectrum.KEY_5}, // Left
+}
+
+// Bracketed paste mode: the terminal surrounds pasted text with these sequences
+const (
+	PASTE_ON    = "\x1b[?2004h"
+	PASTE_OFF   = "\x1b[?2004l"
+	PASTE_START = "\x1b[200~"
+	PASTE_END   = "\x1b[201~"
+)
+
+// Translates the characters received from the terminal into key presses.
+// Unknown characters and escape sequences are ignored.
+func translateInput(input []byte) []spectrum.KeyChord {
+	var sequences []spectrum.KeyChord
+
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+
+		if c == 0x1b {
+			// Escape sequence
+			if (i+2 < len(input)) && ((input[i+1] == '[') || (input[i+1] == 'O')) {
+				if sequence, haveMapping := escapeKeyMap[input[i+2]]; haveMapping {
+					sequences = append(sequences, sequence)
+				}
+				i += 2
+			}
+			continue
+		}
+
+		sequences = append(sequences, spectrum.CharKeys(rune(c))...)
+	}
+
+	return sequences
+}
+
+// Separates pasted text from typed characters
+type pasteParser_t struct {
+	pasting bool
+	paste   bytes.Buffer
+}
+
+// Returns the typed characters, and the pasted text if a paste has just ended
+func (p *pasteParser_t) parse(input []byte) (typed []byte, pasted_orNil []byte) {
+	for len(input) > 0 {
+		if !p.pasting {
+			i := bytes.Index(input, []byte(PASTE_START))
+			if i < 0 {
+				typed = append(typed, input...)
+				break
+			}
+			typed = append(typed, input[0:i]...)
+			input = input[i+len(PASTE_START):]
+			p.pasting = true
+			p.paste.Reset()
+		} else {
+			i := bytes.Index(input, []byte(PASTE_END))
+			if i < 0 {
+				p.paste.Write(input)
+				break
+			}
+			p.paste.Write(input[0:i])
+			input = input[i+len(PASTE_END):]
+			p.pasting = false
+			pasted_orNil = append(pasted_orNil, p.paste.Bytes()...)
+		}
+	}
+	return typed, pasted_orNil
+}
+
+// Presses the keys simultaneously, holds them for a few frames, and releases them.
+//
+// A terminal does not report key releases,
+// so each received character is treated as a complete key press.
+func pressKeys(speccy *spectrum.Spectrum48k, sequence spectrum.KeyChord) {
+	keyboard := speccy.Keyboard
+	frame := 1e9 / time.Duration(speccy.GetCurrentFPS())
+
+	for i := 0; i < len(sequence); i++ {
+		keyboard.KeyDown(sequence[i])
+	}
+	time.Sleep(3 * frame)
+	for i := len(sequence) - 1; i >= 0; i-- {
+		keyboard.KeyUp(sequence[i])
+	}
+	time.Sleep(frame)
+}
+
+// Reads the input from the terminal and sends it to 'inputCh'
+func readInput(in io.Reader, inputCh chan<- []byte) {
+	for {
+		var buf [1024]byte
+		n, err := in.Read(buf[:])
+		if err != nil {
+			close(inputCh)
+			return
+		}
+		inputCh <- buf[0:n]
+	}
+}
+
+// A Go routine for processing terminal input
+func inputLoop(app *spectrum.Application, speccy *spectrum.Spectrum48k, terminal *terminal_t, out io.Writer) {
+	evtLoop := app.NewEventLoop()
+
+	inputCh := make(chan []byte)
+	go readInput(os.Stdin, inputCh)
+
+	out.Write([]byte(PASTE_ON))
+	var pasteParser pasteParser_t
+
+	for {
+		select {
+		case <-evtLoop.Pause:
+			evtLoop.Pause <- 0
+
+		case <-evtLoop.Terminate:
+			// Restore the terminal: reset colors, show the cursor
+			out.Write([]byte("\x1b[0m\x1b[?25h" + PASTE_OFF + "\n"))
+			terminal.restore()
+
+			// Terminate this Go routine
+			if app.Verbose {
+				app.PrintfMsg("terminal input loop: exit")
+			}
+			evtLoop.Terminate <- 0
+			return
+
+		case input, ok := <-inputCh:
+			if !ok {
+				inputCh = nil
+				continue
+			}
+			typed, pasted_orNil := pasteParser.parse(input)
+			for _, sequence := range translateInput(typed) {
+				pressKeys(speccy, sequence)
+			}
+			if pasted_orNil != nil {
+				// Typing a long text takes a while, keep handling the input
+				go speccy.Keyboard.TypeText(string(pasted_orNil), true /*basic*/)
+			}
+		}
+	}
+}
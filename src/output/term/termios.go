@@ -0,0 +1,51 @@
+// +build linux freebsd
+
+package term_output
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// A terminal switched to non-canonical mode without echo
+type terminal_t struct {
+	fd       uintptr
+	original syscall.Termios
+}
+
+func ioctl(fd, request uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Switches the terminal to non-canonical mode without echo,
+// so that individual keypresses can be read from it.
+// Signals (such as Ctrl+C) are still handled by the terminal.
+func newTerminal(f *os.File) (*terminal_t, error) {
+	t := &terminal_t{fd: f.Fd()}
+
+	if err := ioctl(t.fd, ioctl_GETATTR, &t.original); err != nil {
+		return nil, errors.New(f.Name() + " is not a terminal")
+	}
+
+	raw := t.original
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(t.fd, ioctl_SETATTR, &raw); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Restores the original terminal settings
+func (t *terminal_t) restore() {
+	ioctl(t.fd, ioctl_SETATTR, &t.original)
+}
@@ -0,0 +1,8 @@
+package term_output
+
+import "syscall"
+
+const (
+	ioctl_GETATTR = syscall.TCGETS
+	ioctl_SETATTR = syscall.TCSETS
+)
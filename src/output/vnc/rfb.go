@@ -0,0 +1,322 @@
+package vnc_output
+
+// The parts of the RFB protocol (RFC 6143) needed by the VNC server:
+// the handshake without authentication, pixel formats and the client messages.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const RFB_VERSION = "RFB 003.008\n"
+
+// Security types
+const (
+	SECURITY_INVALID = 0
+	SECURITY_NONE    = 1
+)
+
+// Client-to-server messages
+const (
+	MSG_SET_PIXEL_FORMAT           = 0
+	MSG_SET_ENCODINGS              = 2
+	MSG_FRAMEBUFFER_UPDATE_REQUEST = 3
+	MSG_KEY_EVENT                  = 4
+	MSG_POINTER_EVENT              = 5
+	MSG_CLIENT_CUT_TEXT            = 6
+)
+
+// Server-to-client messages
+const (
+	MSG_FRAMEBUFFER_UPDATE     = 0
+	MSG_SET_COLOUR_MAP_ENTRIES = 1
+)
+
+// Encodings
+const (
+	ENCODING_RAW     = 0
+	ENCODING_HEXTILE = 5
+	ENCODING_ZRLE    = 16
+)
+
+// The maximum length of the clipboard text accepted from a client
+const MAX_CUT_TEXT = 0x10000
+
+type pixelFormat_t struct {
+	BitsPerPixel uint8
+	Depth        uint8
+	BigEndian    uint8
+	TrueColour   uint8
+	RedMax       uint16
+	GreenMax     uint16
+	BlueMax      uint16
+	RedShift     uint8
+	GreenShift   uint8
+	BlueShift    uint8
+	Padding      [3]uint8
+}
+
+// 32-bit true-colour, used until the client asks for something else
+var defaultPixelFormat = pixelFormat_t{
+	BitsPerPixel: 32,
+	Depth:        24,
+	BigEndian:    0,
+	TrueColour:   1,
+	RedMax:       255,
+	GreenMax:     255,
+	BlueMax:      255,
+	RedShift:     16,
+	GreenShift:   8,
+	BlueShift:    0,
+}
+
+func (pf *pixelFormat_t) validate() error {
+	switch pf.BitsPerPixel {
+	case 8, 16, 32:
+	default:
+		return fmt.Errorf("unsupported pixel format: %d bits per pixel", pf.BitsPerPixel)
+	}
+	return nil
+}
+
+func (pf *pixelFormat_t) bytesPerPixel() int {
+	return int(pf.BitsPerPixel) / 8
+}
+
+// Returns the pixel value of the specified 0xRRGGBB color.
+// 'index' is used as the pixel value if the client uses a colour map.
+func (pf *pixelFormat_t) pixel(rgb uint32, index byte) uint32 {
+	if pf.TrueColour == 0 {
+		return uint32(index)
+	}
+
+	r := (rgb >> 16) & 0xff
+	g := (rgb >> 8) & 0xff
+	b := rgb & 0xff
+
+	return ((r*uint32(pf.RedMax)+127)/255)<<pf.RedShift |
+		((g*uint32(pf.GreenMax)+127)/255)<<pf.GreenShift |
+		((b*uint32(pf.BlueMax)+127)/255)<<pf.BlueShift
+}
+
+// Appends the pixel value in the client's byte order
+func (pf *pixelFormat_t) appendPixel(buf []byte, pixel uint32) []byte {
+	switch pf.BitsPerPixel {
+	case 8:
+		return append(buf, byte(pixel))
+	case 16:
+		if pf.BigEndian != 0 {
+			return append(buf, byte(pixel>>8), byte(pixel))
+		}
+		return append(buf, byte(pixel), byte(pixel>>8))
+	default:
+		if pf.BigEndian != 0 {
+			return append(buf, byte(pixel>>24), byte(pixel>>16), byte(pixel>>8), byte(pixel))
+		}
+		return append(buf, byte(pixel), byte(pixel>>8), byte(pixel>>16), byte(pixel>>24))
+	}
+}
+
+// Appends a compressed pixel (CPIXEL) as used by the ZRLE encoding.
+// A 32-bit true-colour pixel with depth <= 24 is sent in 3 bytes.
+func (pf *pixelFormat_t) appendCPixel(buf []byte, pixel uint32) []byte {
+	if (pf.TrueColour == 0) || (pf.BitsPerPixel != 32) || (pf.Depth > 24) {
+		return pf.appendPixel(buf, pixel)
+	}
+
+	mask := uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+	switch {
+	case (mask & 0xff000000) == 0:
+		// The least significant 3 bytes
+		if pf.BigEndian != 0 {
+			return append(buf, byte(pixel>>16), byte(pixel>>8), byte(pixel))
+		}
+		return append(buf, byte(pixel), byte(pixel>>8), byte(pixel>>16))
+	case (mask & 0x000000ff) == 0:
+		// The most significant 3 bytes
+		if pf.BigEndian != 0 {
+			return append(buf, byte(pixel>>24), byte(pixel>>16), byte(pixel>>8))
+		}
+		return append(buf, byte(pixel>>8), byte(pixel>>16), byte(pixel>>24))
+	}
+	return pf.appendPixel(buf, pixel)
+}
+
+// Performs the handshake up to and including the ServerInit message.
+// Authentication is not supported.
+func handshake(rw *bufio.ReadWriter, width, height uint16, name string) error {
+	if _, err := rw.WriteString(RFB_VERSION); err != nil {
+		return err
+	}
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+
+	var version [12]byte
+	if _, err := io.ReadFull(rw, version[:]); err != nil {
+		return err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(version[:]), "RFB %03d.%03d\n", &major, &minor); (err != nil) || (major != 3) {
+		return fmt.Errorf("unsupported RFB protocol version %q", version[:])
+	}
+
+	if minor < 7 {
+		// Version 3.3: the server decides the security type
+		binary.Write(rw, binary.BigEndian, uint32(SECURITY_NONE))
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+	} else {
+		rw.Write([]byte{1, SECURITY_NONE})
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		securityType, err := rw.ReadByte()
+		if err != nil {
+			return err
+		}
+		if securityType != SECURITY_NONE {
+			return fmt.Errorf("unsupported security type %d", securityType)
+		}
+
+		if minor >= 8 {
+			// SecurityResult: OK
+			binary.Write(rw, binary.BigEndian, uint32(0))
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// ClientInit: the shared-flag is ignored, the server is always shared
+	if _, err := rw.ReadByte(); err != nil {
+		return err
+	}
+
+	// ServerInit
+	binary.Write(rw, binary.BigEndian, width)
+	binary.Write(rw, binary.BigEndian, height)
+	binary.Write(rw, binary.BigEndian, &defaultPixelFormat)
+	binary.Write(rw, binary.BigEndian, uint32(len(name)))
+	rw.WriteString(name)
+
+	return rw.Flush()
+}
+
+// Client messages
+
+type setPixelFormat_t struct {
+	pixelFormat pixelFormat_t
+}
+
+type setEncodings_t struct {
+	encodings []int32
+}
+
+type framebufferUpdateRequest_t struct {
+	incremental         bool
+	x, y, width, height uint16
+}
+
+type keyEvent_t struct {
+	down bool
+	key  uint32
+}
+
+type pointerEvent_t struct {
+	buttonMask uint8
+	x, y       uint16
+}
+
+type clientCutText_t struct {
+	text string
+}
+
+// Reads the next message sent by the client
+func readClientMessage(r *bufio.Reader) (interface{}, error) {
+	messageType, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch messageType {
+	case MSG_SET_PIXEL_FORMAT:
+		var msg struct {
+			Padding     [3]uint8
+			PixelFormat pixelFormat_t
+		}
+		if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+			return nil, err
+		}
+		return setPixelFormat_t{msg.PixelFormat}, nil
+
+	case MSG_SET_ENCODINGS:
+		var msg struct {
+			Padding      uint8
+			NumEncodings uint16
+		}
+		if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+			return nil, err
+		}
+		encodings := make([]int32, msg.NumEncodings)
+		if err := binary.Read(r, binary.BigEndian, encodings); err != nil {
+			return nil, err
+		}
+		return setEncodings_t{encodings}, nil
+
+	case MSG_FRAMEBUFFER_UPDATE_REQUEST:
+		var msg struct {
+			Incremental         uint8
+			X, Y, Width, Height uint16
+		}
+		if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+			return nil, err
+		}
+		return framebufferUpdateRequest_t{msg.Incremental != 0, msg.X, msg.Y, msg.Width, msg.Height}, nil
+
+	case MSG_KEY_EVENT:
+		var msg struct {
+			Down    uint8
+			Padding [2]uint8
+			Key     uint32
+		}
+		if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+			return nil, err
+		}
+		return keyEvent_t{msg.Down != 0, msg.Key}, nil
+
+	case MSG_POINTER_EVENT:
+		var msg struct {
+			ButtonMask uint8
+			X, Y       uint16
+		}
+		if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+			return nil, err
+		}
+		return pointerEvent_t{msg.ButtonMask, msg.X, msg.Y}, nil
+
+	case MSG_CLIENT_CUT_TEXT:
+		var msg struct {
+			Padding [3]uint8
+			Length  uint32
+		}
+		if err := binary.Read(r, binary.BigEndian, &msg); err != nil {
+			return nil, err
+		}
+		if msg.Length > MAX_CUT_TEXT {
+			return nil, errors.New("clipboard text is too long")
+		}
+		text := make([]byte, msg.Length)
+		if _, err := io.ReadFull(r, text); err != nil {
+			return nil, err
+		}
+		return clientCutText_t{string(text)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown RFB message type %d", messageType)
+}
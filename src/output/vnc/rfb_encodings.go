@@ -0,0 +1,195 @@
+package vnc_output
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+)
+
+type rect_t struct {
+	x, y, width, height int
+}
+
+// Raw encoding: the pixels of the rectangle, left-to-right and top-to-bottom
+func encodeRaw(buf []byte, fb *framebuffer_t, colors *[16]uint32, pf *pixelFormat_t, r rect_t) []byte {
+	for y := r.y; y < r.y+r.height; y++ {
+		for x := r.x; x < r.x+r.width; x++ {
+			buf = pf.appendPixel(buf, colors[fb.at(x, y)])
+		}
+	}
+	return buf
+}
+
+// Hextile subencoding flags
+const (
+	HEXTILE_RAW                  = 1
+	HEXTILE_BACKGROUND_SPECIFIED = 2
+	HEXTILE_FOREGROUND_SPECIFIED = 4
+	HEXTILE_ANY_SUBRECTS         = 8
+)
+
+// Hextile encoding: the rectangle is split into 16x16 tiles.
+// Single-color tiles are sent as a background color, two-color tiles as
+// a background color plus horizontal runs of the foreground color,
+// other tiles as raw pixels.
+func encodeHextile(buf []byte, fb *framebuffer_t, colors *[16]uint32, pf *pixelFormat_t, r rect_t) []byte {
+	bpp := pf.bytesPerPixel()
+
+	for ty := r.y; ty < r.y+r.height; ty += 16 {
+		th := min(16, r.y+r.height-ty)
+		for tx := r.x; tx < r.x+r.width; tx += 16 {
+			tw := min(16, r.x+r.width-tx)
+			tile := rect_t{tx, ty, tw, th}
+
+			bg := fb.at(tx, ty)
+			fg, numColors := bg, 1
+			for y := ty; (y < ty+th) && (numColors <= 2); y++ {
+				for x := tx; x < tx+tw; x++ {
+					c := fb.at(x, y)
+					if (c != bg) && ((numColors == 1) || (c != fg)) {
+						fg = c
+						numColors++
+						if numColors > 2 {
+							break
+						}
+					}
+				}
+			}
+
+			if numColors == 1 {
+				buf = append(buf, HEXTILE_BACKGROUND_SPECIFIED)
+				buf = pf.appendPixel(buf, colors[bg])
+				continue
+			}
+
+			if numColors == 2 {
+				var subrects []byte
+				for y := 0; y < th; y++ {
+					for x := 0; x < tw; {
+						if fb.at(tx+x, ty+y) != fg {
+							x++
+							continue
+						}
+						w := 1
+						for (x+w < tw) && (fb.at(tx+x+w, ty+y) == fg) {
+							w++
+						}
+						subrects = append(subrects, byte(x<<4|y), byte((w-1)<<4))
+						x += w
+					}
+				}
+
+				numSubrects := len(subrects) / 2
+				if (numSubrects < 256) && (2*bpp+1+len(subrects) < tw*th*bpp) {
+					buf = append(buf, HEXTILE_BACKGROUND_SPECIFIED|HEXTILE_FOREGROUND_SPECIFIED|HEXTILE_ANY_SUBRECTS)
+					buf = pf.appendPixel(buf, colors[bg])
+					buf = pf.appendPixel(buf, colors[fg])
+					buf = append(buf, byte(numSubrects))
+					buf = append(buf, subrects...)
+					continue
+				}
+			}
+
+			buf = append(buf, HEXTILE_RAW)
+			buf = encodeRaw(buf, fb, colors, pf, tile)
+		}
+	}
+
+	return buf
+}
+
+// ZRLE encoding. The zlib stream persists for the whole connection.
+type zrleEncoder_t struct {
+	compressed bytes.Buffer
+	w          *zlib.Writer
+}
+
+func newZRLEEncoder() *zrleEncoder_t {
+	e := &zrleEncoder_t{}
+	e.w = zlib.NewWriter(&e.compressed)
+	return e
+}
+
+// Appends the ZRLE encoding of the rectangle.
+// The rectangle is split into 64x64 tiles. The Spectrum never has more than
+// 16 colors, so every tile is either a solid tile or a packed palette tile.
+func (e *zrleEncoder_t) encode(buf []byte, fb *framebuffer_t, colors *[16]uint32, pf *pixelFormat_t, r rect_t) []byte {
+	var tiles []byte
+
+	for ty := r.y; ty < r.y+r.height; ty += 64 {
+		th := min(64, r.y+r.height-ty)
+		for tx := r.x; tx < r.x+r.width; tx += 64 {
+			tw := min(64, r.x+r.width-tx)
+
+			// Find the colors used in the tile
+			var paletteIndex [16]int
+			for i := range paletteIndex {
+				paletteIndex[i] = -1
+			}
+			var palette []byte
+			for y := ty; y < ty+th; y++ {
+				for x := tx; x < tx+tw; x++ {
+					c := fb.at(x, y)
+					if paletteIndex[c] < 0 {
+						paletteIndex[c] = len(palette)
+						palette = append(palette, c)
+					}
+				}
+			}
+
+			tiles = append(tiles, byte(len(palette)))
+			for _, c := range palette {
+				tiles = pf.appendCPixel(tiles, colors[c])
+			}
+			if len(palette) == 1 {
+				// Solid tile
+				continue
+			}
+
+			var bits uint
+			switch {
+			case len(palette) == 2:
+				bits = 1
+			case len(palette) <= 4:
+				bits = 2
+			default:
+				bits = 4
+			}
+
+			// Packed palette indices, each row is padded to a whole number of bytes
+			for y := ty; y < ty+th; y++ {
+				var b byte
+				var n uint
+				for x := tx; x < tx+tw; x++ {
+					b = (b << bits) | byte(paletteIndex[fb.at(x, y)])
+					n += bits
+					if n == 8 {
+						tiles = append(tiles, b)
+						b, n = 0, 0
+					}
+				}
+				if n > 0 {
+					tiles = append(tiles, b<<(8-n))
+				}
+			}
+		}
+	}
+
+	e.w.Write(tiles)
+	e.w.Flush()
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(e.compressed.Len()))
+	buf = append(buf, length[:]...)
+	buf = append(buf, e.compressed.Bytes()...)
+	e.compressed.Reset()
+
+	return buf
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,56 @@
+// GoSpeccy VNC server (video output to any VNC client, keyboard input)
+//
+// The server implements the RFB protocol with the raw, hextile and ZRLE encodings.
+// No authentication is performed, so the server should usually listen on localhost only.
+package vnc_output
+
+import (
+	"flag"
+	"github.com/remogatto/gospeccy/src/env"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"net"
+	"reflect"
+	"sync"
+)
+
+var (
+	listenAddr = flag.String("vnc", "", "Run a VNC server at the specified address (ex: -vnc=localhost:5900)")
+)
+
+func Main() {
+	var init_waitGroup *sync.WaitGroup
+	init_waitGroup = env.WaitName("init WaitGroup").(*sync.WaitGroup)
+	init_waitGroup.Add(1)
+
+	var app *spectrum.Application
+	app = env.Wait(reflect.TypeOf(app)).(*spectrum.Application)
+
+	var speccy *spectrum.Spectrum48k
+	speccy = env.Wait(reflect.TypeOf(speccy)).(*spectrum.Spectrum48k)
+
+	if *listenAddr == "" {
+		init_waitGroup.Done()
+		return
+	}
+
+	listener, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		app.RequestExit()
+		init_waitGroup.Done()
+		return
+	}
+
+	server := NewVNCServer(app, speccy)
+	speccy.CommandChannel <- spectrum.Cmd_AddDisplay{server}
+
+	go server.Serve(listener)
+	go func() {
+		<-app.HasTerminated
+		listener.Close()
+	}()
+
+	app.PrintfMsg("VNC server: %s", listener.Addr())
+
+	init_waitGroup.Done()
+}
@@ -0,0 +1,49 @@
+package vnc_output
+
+// Translation of X11 keysyms, as sent by VNC clients, to the key names
+// used in 'spectrum.SDL_KeyMap'.
+
+var keysymNames = map[uint32]string{
+	0x0020: "space",
+	0x0027: "'",
+	0x002c: ",",
+	0x002d: "-",
+	0x002e: ".",
+	0x002f: "/",
+	0x003b: ";",
+	0x003d: "=",
+	0x005b: "[",
+	0x005d: "]",
+
+	0xff08: "backspace",
+	0xff0d: "return",
+	0xff8d: "return", // KP_Enter
+	0xff51: "left",
+	0xff52: "up",
+	0xff53: "right",
+	0xff54: "down",
+	0xffe1: "left shift",
+	0xffe2: "right shift",
+	0xffe3: "left ctrl",
+	0xffe4: "right ctrl",
+
+	0xffaa: "[*]",
+	0xffab: "[+]",
+	0xffad: "[-]",
+	0xffaf: "[/]",
+}
+
+// Returns the key name corresponding to the keysym, or "" if the keysym has no mapping
+func keysymName(keysym uint32) string {
+	switch {
+	case (keysym >= 'a') && (keysym <= 'z'), (keysym >= '0') && (keysym <= '9'):
+		return string(rune(keysym))
+	case (keysym >= 'A') && (keysym <= 'Z'):
+		// The Shift key is sent as a separate event
+		return string(rune(keysym - 'A' + 'a'))
+	case (keysym >= 0xffb0) && (keysym <= 0xffb9):
+		// KP_0 ... KP_9
+		return "[" + string(rune(keysym-0xffb0+'0')) + "]"
+	}
+	return keysymNames[keysym]
+}
@@ -0,0 +1,492 @@
+package vnc_output
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"net"
+	"sync"
+	"time"
+)
+
+// The framebuffer seen by VNC clients: the screen surrounded by the border
+const (
+	BORDER    = 32
+	FB_WIDTH  = BORDER + spectrum.ScreenWidth + BORDER
+	FB_HEIGHT = BORDER + spectrum.ScreenHeight + BORDER
+)
+
+const DESKTOP_NAME = "GoSpeccy"
+
+// Color indices of all pixels in the framebuffer
+type framebuffer_t [FB_WIDTH * FB_HEIGHT]byte
+
+func (fb *framebuffer_t) at(x, y int) byte {
+	return fb[y*FB_WIDTH+x]
+}
+
+// A connected VNC client
+type client_t struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	// Closed when the client disconnects
+	done chan bool
+
+	// Wakes up the writer when there might be something to send
+	wake chan bool
+
+	mutex           sync.Mutex
+	dirty           [spectrum.ScreenWidth_Attr * spectrum.ScreenHeight_Attr]bool
+	borderDirty     bool
+	updateRequested bool
+	pixelFormat     pixelFormat_t
+	encoding        int32
+	sendColourMap   bool
+
+	// Only used by the writer
+	zrle *zrleEncoder_t
+
+	// Keys currently pressed by the client, only used by the reader
+	keys map[string]bool
+}
+
+// An RFB server displaying the Spectrum's screen and forwarding keyboard events to the Spectrum
+type VNCServer struct {
+	app    *spectrum.Application
+	speccy *spectrum.Spectrum48k
+
+	displayChannel chan *spectrum.DisplayData
+
+	mutex   sync.RWMutex
+	fb      framebuffer_t
+	border  byte
+	clients map[*client_t]bool
+
+	palette *[16]uint32 // Follows 'app.Palette()'
+}
+
+func NewVNCServer(app *spectrum.Application, speccy *spectrum.Spectrum48k) *VNCServer {
+	server := &VNCServer{
+		app:            app,
+		speccy:         speccy,
+		displayChannel: make(chan *spectrum.DisplayData),
+		clients:        make(map[*client_t]bool),
+		palette:        app.Palette(),
+	}
+
+	go server.renderLoop(app.NewEventLoop())
+
+	return server
+}
+
+// Implement DisplayReceiver
+func (server *VNCServer) GetDisplayDataChannel() chan<- *spectrum.DisplayData {
+	return server.displayChannel
+}
+
+func (server *VNCServer) Close() {
+	server.displayChannel <- nil
+}
+
+func (server *VNCServer) renderLoop(evtLoop *spectrum.EventLoop) {
+	for {
+		select {
+		case <-evtLoop.Pause:
+			evtLoop.Pause <- 0
+
+		case <-evtLoop.Terminate:
+			// Terminate this Go routine
+			if evtLoop.App().Verbose {
+				evtLoop.App().PrintfMsg("VNC render loop: exit")
+			}
+			server.closeAllClients()
+			evtLoop.Terminate <- 0
+			return
+
+		case screen := <-server.displayChannel:
+			if screen != nil {
+				server.update(screen)
+
+				if screen.CompletionTime_orNil != nil {
+					screen.CompletionTime_orNil <- time.Now()
+				}
+			} else {
+				done := evtLoop.Delete()
+				go func() { <-done }()
+			}
+		}
+	}
+}
+
+// Updates the framebuffer and tells the clients which parts of it have changed
+func (server *VNCServer) update(screen *spectrum.DisplayData) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	// After a change of the palette, the clients need the whole screen
+	// and, if they are using a colour map, the new colour map
+	if palette := server.app.Palette(); palette != server.palette {
+		server.palette = palette
+		for c := range server.clients {
+			c.mutex.Lock()
+			c.markAllDirty()
+			c.sendColourMap = (c.pixelFormat.TrueColour == 0)
+			c.mutex.Unlock()
+		}
+	}
+
+	borderChanged := false
+	if n := len(screen.BorderEvents); n > 0 {
+		color := screen.BorderEvents[n-1].Color
+		if color != server.border {
+			server.border = color
+			server.fillBorder()
+			borderChanged = true
+		}
+	}
+
+	for attr_y := uint(0); attr_y < spectrum.ScreenHeight_Attr; attr_y++ {
+		for attr_x := uint(0); attr_x < spectrum.ScreenWidth_Attr; attr_x++ {
+			if screen.Dirty[attr_y*spectrum.ScreenWidth_Attr+attr_x] {
+				server.renderCell(screen, attr_x, attr_y)
+			}
+		}
+	}
+
+	for c := range server.clients {
+		c.mutex.Lock()
+		for i, dirty := range screen.Dirty {
+			if dirty {
+				c.dirty[i] = true
+			}
+		}
+		if borderChanged {
+			c.borderDirty = true
+		}
+		c.mutex.Unlock()
+
+		c.wakeUp()
+	}
+}
+
+func (server *VNCServer) fillBorder() {
+	for y := 0; y < FB_HEIGHT; y++ {
+		for x := 0; x < FB_WIDTH; x++ {
+			if (y < BORDER) || (y >= BORDER+spectrum.ScreenHeight) || (x < BORDER) || (x >= BORDER+spectrum.ScreenWidth) {
+				server.fb[y*FB_WIDTH+x] = server.border
+			}
+		}
+	}
+}
+
+func (server *VNCServer) renderCell(screen *spectrum.DisplayData, attr_x, attr_y uint) {
+	ofs := ((8 * attr_y) << spectrum.BytesPerLine_log2) + attr_x
+	fbOfs := (BORDER+8*int(attr_y))*FB_WIDTH + BORDER + 8*int(attr_x)
+	for y := 0; y < 8; y++ {
+		// Paper is in the lower 4 bits, ink is in the higher 4 bits
+		attr := byte(screen.Attr[ofs])
+		bitmap := screen.Bitmap[ofs]
+		for x := uint(0); x < 8; x++ {
+			if ((bitmap >> (7 - x)) & 1) != 0 {
+				server.fb[fbOfs+int(x)] = attr >> 4
+			} else {
+				server.fb[fbOfs+int(x)] = attr & 0xf
+			}
+		}
+		ofs += spectrum.BytesPerLine
+		fbOfs += FB_WIDTH
+	}
+}
+
+func (server *VNCServer) closeAllClients() {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	for c := range server.clients {
+		c.conn.Close()
+	}
+}
+
+// Accepts VNC clients until the listener is closed
+func (server *VNCServer) Serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.serveClient(conn)
+	}
+}
+
+func (server *VNCServer) serveClient(conn net.Conn) {
+	defer conn.Close()
+
+	c := &client_t{
+		conn:        conn,
+		rw:          bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+		done:        make(chan bool),
+		wake:        make(chan bool, 1),
+		borderDirty: true,
+		pixelFormat: defaultPixelFormat,
+		encoding:    ENCODING_RAW,
+		zrle:        newZRLEEncoder(),
+		keys:        make(map[string]bool),
+	}
+	for i := range c.dirty {
+		c.dirty[i] = true
+	}
+
+	if err := handshake(c.rw, FB_WIDTH, FB_HEIGHT, DESKTOP_NAME); err != nil {
+		if server.app.Verbose {
+			server.app.PrintfMsg("VNC: %s: %s", conn.RemoteAddr(), err)
+		}
+		return
+	}
+
+	server.mutex.Lock()
+	server.clients[c] = true
+	server.mutex.Unlock()
+
+	if server.app.Verbose {
+		server.app.PrintfMsg("VNC: %s connected", conn.RemoteAddr())
+	}
+
+	go server.writeLoop(c)
+
+	err := server.readLoop(c)
+
+	server.mutex.Lock()
+	delete(server.clients, c)
+	server.mutex.Unlock()
+
+	close(c.done)
+	server.releaseAll(c)
+
+	if server.app.Verbose {
+		server.app.PrintfMsg("VNC: %s disconnected (%s)", conn.RemoteAddr(), err)
+	}
+}
+
+func (c *client_t) wakeUp() {
+	select {
+	case c.wake <- true:
+	default:
+	}
+}
+
+// Handles the messages sent by the client until the connection is closed
+func (server *VNCServer) readLoop(c *client_t) error {
+	for {
+		msg, err := readClientMessage(c.rw.Reader)
+		if err != nil {
+			return err
+		}
+
+		switch msg := msg.(type) {
+		case setPixelFormat_t:
+			pf := msg.pixelFormat
+			if err := pf.validate(); err != nil {
+				return err
+			}
+			c.mutex.Lock()
+			c.pixelFormat = pf
+			c.sendColourMap = (pf.TrueColour == 0)
+			c.markAllDirty()
+			c.mutex.Unlock()
+
+		case setEncodings_t:
+			encoding := int32(ENCODING_RAW)
+		search:
+			for _, e := range msg.encodings {
+				switch e {
+				case ENCODING_RAW, ENCODING_HEXTILE, ENCODING_ZRLE:
+					encoding = e
+					break search
+				}
+			}
+			c.mutex.Lock()
+			c.encoding = encoding
+			c.mutex.Unlock()
+
+		case framebufferUpdateRequest_t:
+			c.mutex.Lock()
+			if !msg.incremental {
+				c.markAllDirty()
+			}
+			c.updateRequested = true
+			c.mutex.Unlock()
+			c.wakeUp()
+
+		case keyEvent_t:
+			server.keyEvent(c, msg)
+
+		case pointerEvent_t, clientCutText_t:
+			// Ignored
+		}
+	}
+}
+
+// The caller has to hold the client's mutex
+func (c *client_t) markAllDirty() {
+	for i := range c.dirty {
+		c.dirty[i] = true
+	}
+	c.borderDirty = true
+}
+
+// Sends framebuffer updates whenever the client has requested one and something has changed
+func (server *VNCServer) writeLoop(c *client_t) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.wake:
+		}
+
+		c.mutex.Lock()
+		if !c.updateRequested {
+			c.mutex.Unlock()
+			continue
+		}
+		rects := c.dirtyRects()
+		if len(rects) == 0 {
+			c.mutex.Unlock()
+			continue
+		}
+		c.updateRequested = false
+		pf := c.pixelFormat
+		encoding := c.encoding
+		sendColourMap := c.sendColourMap
+		c.sendColourMap = false
+		c.mutex.Unlock()
+
+		var msg []byte
+		if sendColourMap {
+			msg = server.colourMapEntries()
+		}
+		msg = append(msg, server.framebufferUpdate(c, &pf, encoding, rects)...)
+
+		if _, err := c.conn.Write(msg); err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// Returns the dirty parts of the framebuffer and clears the dirty flags.
+// Consecutive dirty cells in a row of cells are merged into a single rectangle.
+// The caller has to hold the client's mutex.
+func (c *client_t) dirtyRects() []rect_t {
+	var rects []rect_t
+
+	if c.borderDirty {
+		rects = append(rects,
+			rect_t{0, 0, FB_WIDTH, BORDER},
+			rect_t{0, BORDER + spectrum.ScreenHeight, FB_WIDTH, BORDER},
+			rect_t{0, BORDER, BORDER, spectrum.ScreenHeight},
+			rect_t{BORDER + spectrum.ScreenWidth, BORDER, BORDER, spectrum.ScreenHeight})
+		c.borderDirty = false
+	}
+
+	for attr_y := 0; attr_y < spectrum.ScreenHeight_Attr; attr_y++ {
+		row := c.dirty[attr_y*spectrum.ScreenWidth_Attr : (attr_y+1)*spectrum.ScreenWidth_Attr]
+		for attr_x := 0; attr_x < spectrum.ScreenWidth_Attr; {
+			if !row[attr_x] {
+				attr_x++
+				continue
+			}
+			n := 0
+			for (attr_x+n < spectrum.ScreenWidth_Attr) && row[attr_x+n] {
+				row[attr_x+n] = false
+				n++
+			}
+			rects = append(rects, rect_t{BORDER + 8*attr_x, BORDER + 8*attr_y, 8 * n, 8})
+			attr_x += n
+		}
+	}
+
+	return rects
+}
+
+// Returns a SetColourMapEntries message with the Spectrum's palette
+func (server *VNCServer) colourMapEntries() []byte {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	msg := []byte{MSG_SET_COLOUR_MAP_ENTRIES, 0, 0, 0, 0, 16}
+	for _, rgb := range server.palette {
+		r, g, b := byte(rgb>>16), byte(rgb>>8), byte(rgb)
+		msg = append(msg, r, r, g, g, b, b)
+	}
+	return msg
+}
+
+// Returns a FramebufferUpdate message with the specified rectangles
+func (server *VNCServer) framebufferUpdate(c *client_t, pf *pixelFormat_t, encoding int32, rects []rect_t) []byte {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	var colors [16]uint32
+	for i, rgb := range server.palette {
+		colors[i] = pf.pixel(rgb, byte(i))
+	}
+
+	msg := []byte{MSG_FRAMEBUFFER_UPDATE, 0, 0, 0}
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(rects)))
+
+	for _, r := range rects {
+		var header [12]byte
+		binary.BigEndian.PutUint16(header[0:2], uint16(r.x))
+		binary.BigEndian.PutUint16(header[2:4], uint16(r.y))
+		binary.BigEndian.PutUint16(header[4:6], uint16(r.width))
+		binary.BigEndian.PutUint16(header[6:8], uint16(r.height))
+		binary.BigEndian.PutUint32(header[8:12], uint32(encoding))
+		msg = append(msg, header[:]...)
+
+		switch encoding {
+		case ENCODING_HEXTILE:
+			msg = encodeHextile(msg, &server.fb, &colors, pf, r)
+		case ENCODING_ZRLE:
+			msg = c.zrle.encode(msg, &server.fb, &colors, pf, r)
+		default:
+			msg = encodeRaw(msg, &server.fb, &colors, pf, r)
+		}
+	}
+
+	return msg
+}
+
+func (server *VNCServer) keyEvent(c *client_t, event keyEvent_t) {
+	name := keysymName(event.key)
+	sequence, haveMapping := spectrum.SDL_KeyMap[name]
+	if !haveMapping {
+		return
+	}
+
+	keyboard := server.speccy.Keyboard
+	if event.down && !c.keys[name] {
+		// Normal order
+		for i := 0; i < len(sequence); i++ {
+			keyboard.KeyDown(sequence[i])
+		}
+		c.keys[name] = true
+	} else if !event.down && c.keys[name] {
+		// Reverse order
+		for i := len(sequence) - 1; i >= 0; i-- {
+			keyboard.KeyUp(sequence[i])
+		}
+		delete(c.keys, name)
+	}
+}
+
+// Releases all keys held by a disconnected client
+func (server *VNCServer) releaseAll(c *client_t) {
+	for name := range c.keys {
+		sequence := spectrum.SDL_KeyMap[name]
+		for i := len(sequence) - 1; i >= 0; i-- {
+			server.speccy.Keyboard.KeyUp(sequence[i])
+		}
+	}
+	c.keys = make(map[string]bool)
+}
@@ -0,0 +1,170 @@
+package vnc_output
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"io"
+	"net"
+	"testing"
+)
+
+func newTestServer() *VNCServer {
+	app := spectrum.NewApplication()
+	return &VNCServer{
+		app:     app,
+		clients: make(map[*client_t]bool),
+		palette: app.Palette(),
+	}
+}
+
+func TestHandshakeAndRawUpdate(t *testing.T) {
+	server := newTestServer()
+	server.border = 2
+	server.fillBorder()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go server.serveClient(serverConn)
+
+	r := bufio.NewReader(clientConn)
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+
+	if version := string(read(12)); version != RFB_VERSION {
+		t.Fatalf("unexpected protocol version %q", version)
+	}
+	clientConn.Write([]byte(RFB_VERSION))
+
+	if securityTypes := read(2); !bytes.Equal(securityTypes, []byte{1, SECURITY_NONE}) {
+		t.Fatalf("unexpected security types %v", securityTypes)
+	}
+	clientConn.Write([]byte{SECURITY_NONE})
+	if result := binary.BigEndian.Uint32(read(4)); result != 0 {
+		t.Fatalf("security handshake failed")
+	}
+
+	// ClientInit
+	clientConn.Write([]byte{1})
+
+	serverInit := read(24)
+	width, height := binary.BigEndian.Uint16(serverInit[0:2]), binary.BigEndian.Uint16(serverInit[2:4])
+	if (width != FB_WIDTH) || (height != FB_HEIGHT) {
+		t.Fatalf("unexpected framebuffer size %dx%d", width, height)
+	}
+	if name := string(read(int(binary.BigEndian.Uint32(serverInit[20:24])))); name != DESKTOP_NAME {
+		t.Errorf("unexpected desktop name %q", name)
+	}
+
+	// Request the top border only, the server is allowed to send the whole framebuffer
+	clientConn.Write([]byte{MSG_FRAMEBUFFER_UPDATE_REQUEST, 0, 0, 0, 0, 0, 1, 64, 0, 32})
+
+	header := read(4)
+	if header[0] != MSG_FRAMEBUFFER_UPDATE {
+		t.Fatalf("expected a framebuffer update, got message type %d", header[0])
+	}
+	numRects := int(binary.BigEndian.Uint16(header[2:4]))
+
+	// 4 border rectangles, plus a rectangle for each row of cells
+	if numRects != 4+spectrum.ScreenHeight_Attr {
+		t.Fatalf("expected %d rectangles, got %d", 4+spectrum.ScreenHeight_Attr, numRects)
+	}
+
+	borderPixel := defaultPixelFormat.pixel(spectrum.Palette[2], 2)
+	for i := 0; i < numRects; i++ {
+		rect := read(12)
+		w, h := int(binary.BigEndian.Uint16(rect[4:6])), int(binary.BigEndian.Uint16(rect[6:8]))
+		if encoding := binary.BigEndian.Uint32(rect[8:12]); encoding != ENCODING_RAW {
+			t.Fatalf("expected the raw encoding, got %d", encoding)
+		}
+		pixels := read(4 * w * h)
+		if (i == 0) && (binary.LittleEndian.Uint32(pixels) != borderPixel) {
+			t.Errorf("wrong border color %x", binary.LittleEndian.Uint32(pixels))
+		}
+	}
+}
+
+func TestZRLESolidAndPaletteTiles(t *testing.T) {
+	var fb framebuffer_t
+	for x := 0; x < 8; x++ {
+		fb[x] = 1
+	}
+
+	var colors [16]uint32
+	for i, rgb := range spectrum.Palette {
+		colors[i] = defaultPixelFormat.pixel(rgb, byte(i))
+	}
+
+	e := newZRLEEncoder()
+	pf := defaultPixelFormat
+
+	// A solid tile followed by a two-color tile
+	msg := e.encode(nil, &fb, &colors, &pf, rect_t{0, 8, 8, 8})
+	msg = e.encode(msg, &fb, &colors, &pf, rect_t{0, 0, 16, 2})
+
+	z := bytes.NewReader(msg)
+	var length uint32
+	binary.Read(z, binary.BigEndian, &length)
+	first := make([]byte, length)
+	z.Read(first)
+	binary.Read(z, binary.BigEndian, &length)
+	second := make([]byte, length)
+	z.Read(second)
+
+	zr, err := zlib.NewReader(io.MultiReader(bytes.NewReader(first), bytes.NewReader(second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Solid tile: palette size 1, the color in 3 bytes
+	solid := make([]byte, 4)
+	io.ReadFull(zr, solid)
+	if !bytes.Equal(solid, []byte{1, 0, 0, 0}) {
+		t.Errorf("unexpected solid tile %v", solid)
+	}
+
+	// Packed palette tile: 2 colors, 1 bit per pixel, 2 bytes per row
+	tile := make([]byte, 1+2*3+2*2)
+	io.ReadFull(zr, tile)
+	if (tile[0] != 2) || !bytes.Equal(tile[7:], []byte{0x00, 0xff, 0xff, 0xff}) {
+		t.Errorf("unexpected palette tile %v", tile)
+	}
+}
+
+func TestPaletteChange(t *testing.T) {
+	server := newTestServer()
+
+	trueColour := &client_t{pixelFormat: defaultPixelFormat}
+	colourMap := &client_t{pixelFormat: defaultPixelFormat}
+	colourMap.pixelFormat.TrueColour = 0
+	server.clients[trueColour] = true
+	server.clients[colourMap] = true
+
+	// Nothing has changed on the screen, but the colors are different
+	server.app.SetPalette(&spectrum.Palette_GreenScreen)
+	server.update(&spectrum.DisplayData{})
+
+	for c := range server.clients {
+		if !c.borderDirty || !c.dirty[0] || !c.dirty[len(c.dirty)-1] {
+			t.Errorf("the whole screen was not marked as changed")
+		}
+	}
+	if trueColour.sendColourMap || !colourMap.sendColourMap {
+		t.Errorf("the colour map has to be sent only to the colour map client")
+	}
+
+	msg := server.colourMapEntries()
+	for i, rgb := range spectrum.Palette_GreenScreen {
+		r, g, b := byte(rgb>>16), byte(rgb>>8), byte(rgb)
+		if !bytes.Equal(msg[6+6*i:12+6*i], []byte{r, r, g, g, b, b}) {
+			t.Errorf("color %d: expected %06x, got %v", i, rgb&0xffffff, msg[6+6*i:12+6*i])
+		}
+	}
+}
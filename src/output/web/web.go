@@ -0,0 +1,61 @@
+// GoSpeccy web interface (audio&video output to a web browser, keyboard/joystick input)
+//
+// The HTML page is served from the executable, so no Internet connection is required.
+// The screen and the sound are streamed to the browser over a WebSocket.
+package web_output
+
+import (
+	"flag"
+	"github.com/remogatto/gospeccy/src/env"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+var (
+	listenAddr = flag.String("web", "", "Serve the web frontend at the specified address (ex: -web=localhost:8080)")
+)
+
+// Sample rate of the audio streamed to the browser
+const AUDIO_FREQUENCY = 22050
+
+func Main() {
+	var init_waitGroup *sync.WaitGroup
+	init_waitGroup = env.WaitName("init WaitGroup").(*sync.WaitGroup)
+	init_waitGroup.Add(1)
+
+	var app *spectrum.Application
+	app = env.Wait(reflect.TypeOf(app)).(*spectrum.Application)
+
+	var speccy *spectrum.Spectrum48k
+	speccy = env.Wait(reflect.TypeOf(speccy)).(*spectrum.Spectrum48k)
+
+	if *listenAddr == "" {
+		init_waitGroup.Done()
+		return
+	}
+
+	listener, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+		app.RequestExit()
+		init_waitGroup.Done()
+		return
+	}
+
+	server := NewWebServer(app, speccy)
+	speccy.CommandChannel <- spectrum.Cmd_AddDisplay{server.Display()}
+	speccy.CommandChannel <- spectrum.Cmd_AddAudioReceiver{server.Audio()}
+
+	go http.Serve(listener, server)
+	go func() {
+		<-app.HasTerminated
+		listener.Close()
+	}()
+
+	app.PrintfMsg("web frontend: http://%s/", listener.Addr())
+
+	init_waitGroup.Done()
+}
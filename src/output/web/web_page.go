@@ -0,0 +1,203 @@
+package web_output
+
+// The HTML page of the web frontend.
+// It is self-contained, so the frontend works without an Internet connection.
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>GoSpeccy</title>
+<style>
+  body { background: #222; color: #ccc; font-family: sans-serif; text-align: center; }
+  canvas { width: 640px; height: 512px; image-rendering: pixelated; image-rendering: crisp-edges; margin-top: 1em; }
+  #status { margin: 0.5em; }
+</style>
+</head>
+<body>
+<canvas id="screen" width="320" height="256"></canvas>
+<div>
+  <button id="sound">Enable sound</button>
+  <label><input type="checkbox" id="joystick"> Arrow keys and Space control the joystick</label>
+</div>
+<div id="status">Connecting...</div>
+<script>
+(function() {
+  var MSG_HELLO = 0, MSG_SCREEN = 1, MSG_AUDIO = 2;
+  var W = 256, H = 192, BX = 32, BY = 32;
+
+  var canvas = document.getElementById("screen");
+  var ctx = canvas.getContext("2d");
+  var image = ctx.createImageData(W, H);
+  var statusLine = document.getElementById("status");
+  var joystick = document.getElementById("joystick");
+
+  var palette = [], rate = 22050;
+  var audioCtx = null, audioTime = 0;
+
+  var ws = new WebSocket((location.protocol == "https:" ? "wss://" : "ws://") + location.host + "/ws");
+  ws.binaryType = "arraybuffer";
+  ws.onopen = function() { statusLine.textContent = "Connected"; };
+  ws.onclose = function() { statusLine.textContent = "Disconnected"; };
+
+  ws.onmessage = function(e) {
+    var data = new Uint8Array(e.data);
+    switch (data[0]) {
+    case MSG_HELLO: hello(data); break;
+    case MSG_SCREEN: drawScreen(data); break;
+    case MSG_AUDIO: playAudio(data); break;
+    }
+  };
+
+  function hello(data) {
+    rate = data[1] | (data[2] << 8) | (data[3] << 16) | (data[4] << 24);
+    palette = [];
+    for (var i = 0; i < 16; i++) {
+      palette.push([data[5+3*i], data[6+3*i], data[7+3*i]]);
+    }
+  }
+
+  function drawScreen(data) {
+    var border = palette[data[1]];
+    ctx.fillStyle = "rgb(" + border.join(",") + ")";
+    ctx.fillRect(0, 0, canvas.width, BY);
+    ctx.fillRect(0, BY + H, canvas.width, canvas.height - BY - H);
+    ctx.fillRect(0, BY, BX, H);
+    ctx.fillRect(BX + W, BY, canvas.width - BX - W, H);
+
+    var n = data[2] | (data[3] << 8);
+    if (n == 0) {
+      ctx.putImageData(image, BX, BY);
+      return;
+    }
+
+    var pixels = image.data, p = 4;
+    var minX = 32, minY = 24, maxX = 0, maxY = 0;
+    for (var c = 0; c < n; c++) {
+      var cx = data[p], cy = data[p+1];
+      p += 2;
+      minX = Math.min(minX, cx); maxX = Math.max(maxX, cx);
+      minY = Math.min(minY, cy); maxY = Math.max(maxY, cy);
+      for (var y = 0; y < 8; y++) {
+        var o = ((8*cy + y)*W + 8*cx) * 4;
+        for (var x = 0; x < 8; x++) {
+          var color = palette[data[p++]];
+          pixels[o] = color[0]; pixels[o+1] = color[1]; pixels[o+2] = color[2]; pixels[o+3] = 255;
+          o += 4;
+        }
+      }
+    }
+    ctx.putImageData(image, BX, BY, 8*minX, 8*minY, 8*(maxX-minX+1), 8*(maxY-minY+1));
+  }
+
+  function playAudio(data) {
+    if (audioCtx == null) {
+      return;
+    }
+    var n = (data.length - 1) >> 1;
+    if (n == 0) {
+      return;
+    }
+    var buffer = audioCtx.createBuffer(1, n, rate);
+    var samples = buffer.getChannelData(0);
+    for (var i = 0; i < n; i++) {
+      var v = data[1+2*i] | (data[2+2*i] << 8);
+      if (v >= 0x8000) {
+        v -= 0x10000;
+      }
+      samples[i] = v / 32768;
+    }
+    var source = audioCtx.createBufferSource();
+    source.buffer = buffer;
+    source.connect(audioCtx.destination);
+
+    // Keep a small amount of audio queued, resynchronize if the queue is empty or too long
+    var now = audioCtx.currentTime;
+    if ((audioTime < now) || (audioTime > now + 0.5)) {
+      audioTime = now + 0.05;
+    }
+    source.start(audioTime);
+    audioTime += buffer.duration;
+  }
+
+  // Browsers allow audio to start only after a user action
+  function enableSound() {
+    if (audioCtx == null) {
+      var AudioContext = window.AudioContext || window.webkitAudioContext;
+      if (AudioContext) {
+        audioCtx = new AudioContext();
+        document.getElementById("sound").disabled = true;
+      }
+    }
+  }
+  document.getElementById("sound").onclick = enableSound;
+
+  // KeyboardEvent.code -> key names used by GoSpeccy (see spectrum.SDL_KeyMap)
+  var keyNames = {
+    "Enter": "return", "NumpadEnter": "return", "Space": "space", "Backspace": "backspace",
+    "ShiftLeft": "left shift", "ShiftRight": "right shift",
+    "ControlLeft": "left ctrl", "ControlRight": "right ctrl",
+    "ArrowLeft": "left", "ArrowRight": "right", "ArrowUp": "up", "ArrowDown": "down",
+    "Minus": "-", "Equal": "=", "BracketLeft": "[", "BracketRight": "]",
+    "Semicolon": ";", "Quote": "'", "Comma": ",", "Period": ".", "Slash": "/"
+  };
+  var joystickNames = { "ArrowLeft": "left", "ArrowRight": "right", "ArrowUp": "up", "ArrowDown": "down", "Space": "fire" };
+
+  function keyName(code) {
+    if (code in keyNames) return keyNames[code];
+    if (/^Key[A-Z]$/.test(code)) return code.substring(3).toLowerCase();
+    if (/^Digit[0-9]$/.test(code)) return code.substring(5);
+    if (/^Numpad[0-9]$/.test(code)) return "[" + code.substring(6) + "]";
+    return null;
+  }
+
+  var pressed = {};
+
+  function send(msg) {
+    if (ws.readyState == WebSocket.OPEN) {
+      ws.send(msg);
+    }
+  }
+
+  function onKey(e, down) {
+    var msg;
+    if (joystick.checked && (e.code in joystickNames)) {
+      msg = "j" + (down ? "d " : "u ") + joystickNames[e.code];
+    } else {
+      var name = keyName(e.code);
+      if (name == null) {
+        return;
+      }
+      msg = "k" + (down ? "d " : "u ") + name;
+    }
+    e.preventDefault();
+    if (down) {
+      if (pressed[e.code]) {
+        return;
+      }
+      pressed[e.code] = msg.replace(/^(.)d/, "$1u");
+    } else {
+      if (!pressed[e.code]) {
+        return;
+      }
+      msg = pressed[e.code];
+      delete pressed[e.code];
+    }
+    send(msg);
+  }
+
+  function releaseAll() {
+    for (var code in pressed) {
+      send(pressed[code]);
+    }
+    pressed = {};
+  }
+
+  document.addEventListener("keydown", function(e) { enableSound(); onKey(e, true); });
+  document.addEventListener("keyup", function(e) { onKey(e, false); });
+  window.addEventListener("blur", releaseAll);
+  joystick.addEventListener("change", releaseAll);
+})();
+</script>
+</body>
+</html>
+`
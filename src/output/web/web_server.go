@@ -0,0 +1,542 @@
+package web_output
+
+import (
+	"encoding/binary"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of messages sent to the browser.
+//
+// MSG_HELLO:  [MSG_HELLO] [audio frequency: uint32] [palette: 16 x RGB]
+// MSG_SCREEN: [MSG_SCREEN] [border color] [number of cells: uint16] [cells],
+// where each cell is [x] [y] [8x8 color indices]
+// MSG_AUDIO:  [MSG_AUDIO] [samples: int16]
+//
+// Multi-byte values are little-endian.
+const (
+	MSG_HELLO  = 0
+	MSG_SCREEN = 1
+	MSG_AUDIO  = 2
+)
+
+// The number of messages which can be queued for a single browser.
+// If the browser is too slow, screen updates are dropped and the whole
+// screen is sent again when the browser catches up.
+const CLIENT_QUEUE_LENGTH = 32
+
+// The key names sent by the browser are the same as those in 'spectrum.SDL_KeyMap'
+var joystickNames = map[string]uint{
+	"fire":  spectrum.KEMPSTON_FIRE,
+	"up":    spectrum.KEMPSTON_UP,
+	"down":  spectrum.KEMPSTON_DOWN,
+	"left":  spectrum.KEMPSTON_LEFT,
+	"right": spectrum.KEMPSTON_RIGHT,
+}
+
+// A connected browser
+type client_t struct {
+	conn *wsConn
+	out  chan []byte
+
+	// True if a screen update has been dropped
+	resync bool
+
+	// True if the browser has to receive a MSG_HELLO with the new palette
+	newPalette bool
+
+	// Keys and joystick directions currently pressed by the browser
+	keys      map[string]bool
+	joystick  map[uint]uint // Direction -> joystick interface
+	keysMutex sync.Mutex
+}
+
+// Serves the web frontend and streams the Spectrum's screen and sound to all connected browsers
+type WebServer struct {
+	app     *spectrum.Application
+	speccy  *spectrum.Spectrum48k
+	display *webDisplay
+	audio   *webAudio
+
+	mutex   sync.Mutex
+	clients map[*client_t]bool
+
+	// The current contents of the screen, as 8x8 cells of color indices
+	cells  [spectrum.ScreenWidth_Attr * spectrum.ScreenHeight_Attr][64]byte
+	border byte
+
+	palette *[16]uint32 // Follows 'app.Palette()'
+
+	numSamples_cummulativeFraction float32
+}
+
+type webDisplay struct {
+	server *WebServer
+	ch     chan *spectrum.DisplayData
+}
+
+// Implement DisplayReceiver
+func (d *webDisplay) GetDisplayDataChannel() chan<- *spectrum.DisplayData {
+	return d.ch
+}
+
+func (d *webDisplay) Close() {
+	d.ch <- nil
+}
+
+type webAudio struct {
+	server *WebServer
+	ch     chan *spectrum.AudioData
+}
+
+// Implement AudioReceiver
+func (a *webAudio) GetAudioDataChannel() chan<- *spectrum.AudioData {
+	return a.ch
+}
+
+func (a *webAudio) Close() {
+	a.ch <- nil
+}
+
+func NewWebServer(app *spectrum.Application, speccy *spectrum.Spectrum48k) *WebServer {
+	server := &WebServer{
+		app:     app,
+		speccy:  speccy,
+		clients: make(map[*client_t]bool),
+		palette: app.Palette(),
+	}
+	server.display = &webDisplay{server, make(chan *spectrum.DisplayData)}
+	server.audio = &webAudio{server, make(chan *spectrum.AudioData)}
+
+	go server.displayLoop(app.NewEventLoop())
+	go server.audioLoop(app.NewEventLoop())
+
+	return server
+}
+
+func (server *WebServer) Display() spectrum.DisplayReceiver {
+	return server.display
+}
+
+func (server *WebServer) Audio() spectrum.AudioReceiver {
+	return server.audio
+}
+
+func (server *WebServer) displayLoop(evtLoop *spectrum.EventLoop) {
+	for {
+		select {
+		case <-evtLoop.Pause:
+			evtLoop.Pause <- 0
+
+		case <-evtLoop.Terminate:
+			// Terminate this Go routine
+			if evtLoop.App().Verbose {
+				evtLoop.App().PrintfMsg("web display loop: exit")
+			}
+			server.closeAllClients()
+			evtLoop.Terminate <- 0
+			return
+
+		case screen := <-server.display.ch:
+			if screen != nil {
+				server.updatePalette()
+				server.broadcast(server.updateScreen(screen), true)
+
+				if screen.CompletionTime_orNil != nil {
+					screen.CompletionTime_orNil <- time.Now()
+				}
+			} else {
+				done := evtLoop.Delete()
+				go func() { <-done }()
+			}
+		}
+	}
+}
+
+func (server *WebServer) audioLoop(evtLoop *spectrum.EventLoop) {
+	for {
+		select {
+		case <-evtLoop.Pause:
+			evtLoop.Pause <- 0
+
+		case <-evtLoop.Terminate:
+			// Terminate this Go routine
+			if evtLoop.App().Verbose {
+				evtLoop.App().PrintfMsg("web audio loop: exit")
+			}
+			evtLoop.Terminate <- 0
+			return
+
+		case audioData := <-server.audio.ch:
+			if audioData != nil {
+				server.broadcast(server.encodeAudio(audioData), false)
+			} else {
+				done := evtLoop.Delete()
+				go func() { <-done }()
+			}
+		}
+	}
+}
+
+// Returns the 8x8 color indices of the specified cell
+func cellPixels(screen *spectrum.DisplayData, attr_x, attr_y uint, cell *[64]byte) {
+	ofs := ((8 * attr_y) << spectrum.BytesPerLine_log2) + attr_x
+	for y := uint(0); y < 8; y++ {
+		// Paper is in the lower 4 bits, ink is in the higher 4 bits
+		attr := byte(screen.Attr[ofs])
+		bitmap := screen.Bitmap[ofs]
+		for x := uint(0); x < 8; x++ {
+			if ((bitmap >> (7 - x)) & 1) != 0 {
+				cell[8*y+x] = attr >> 4
+			} else {
+				cell[8*y+x] = attr & 0xf
+			}
+		}
+		ofs += spectrum.BytesPerLine
+	}
+}
+
+func appendCell(msg []byte, x, y uint, cell *[64]byte) []byte {
+	msg = append(msg, byte(x), byte(y))
+	return append(msg, cell[:]...)
+}
+
+// Updates the screen state, and returns a MSG_SCREEN message with the changes
+func (server *WebServer) updateScreen(screen *spectrum.DisplayData) []byte {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if n := len(screen.BorderEvents); n > 0 {
+		server.border = screen.BorderEvents[n-1].Color
+	}
+
+	msg := []byte{MSG_SCREEN, server.border, 0, 0}
+	numCells := 0
+	for attr_y := uint(0); attr_y < spectrum.ScreenHeight_Attr; attr_y++ {
+		for attr_x := uint(0); attr_x < spectrum.ScreenWidth_Attr; attr_x++ {
+			i := attr_y*spectrum.ScreenWidth_Attr + attr_x
+			if screen.Dirty[i] {
+				cellPixels(screen, attr_x, attr_y, &server.cells[i])
+				msg = appendCell(msg, attr_x, attr_y, &server.cells[i])
+				numCells++
+			}
+		}
+	}
+	binary.LittleEndian.PutUint16(msg[2:4], uint16(numCells))
+
+	return msg
+}
+
+// Checks whether the palette has changed. If it has, all browsers are
+// going to receive the new palette followed by the whole screen.
+func (server *WebServer) updatePalette() {
+	palette := server.app.Palette()
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if palette == server.palette {
+		return
+	}
+
+	server.palette = palette
+	for c := range server.clients {
+		c.newPalette = true
+		c.resync = true
+	}
+}
+
+// Returns a MSG_SCREEN message with the whole screen.
+// The caller has to hold the mutex.
+func (server *WebServer) wholeScreen() []byte {
+	msg := []byte{MSG_SCREEN, server.border, 0, 0}
+	for attr_y := uint(0); attr_y < spectrum.ScreenHeight_Attr; attr_y++ {
+		for attr_x := uint(0); attr_x < spectrum.ScreenWidth_Attr; attr_x++ {
+			msg = appendCell(msg, attr_x, attr_y, &server.cells[attr_y*spectrum.ScreenWidth_Attr+attr_x])
+		}
+	}
+	binary.LittleEndian.PutUint16(msg[2:4], uint16(len(server.cells)))
+	return msg
+}
+
+func (server *WebServer) hello() []byte {
+	msg := []byte{MSG_HELLO, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(msg[1:5], AUDIO_FREQUENCY)
+	for _, c := range server.palette {
+		msg = append(msg, byte(c>>16), byte(c>>8), byte(c))
+	}
+	return msg
+}
+
+// Converts the beeper events to a MSG_AUDIO message
+func (server *WebServer) encodeAudio(audioData *spectrum.AudioData) []byte {
+	events := audioData.BeeperEvents
+	if len(events) == 0 {
+		events = []spectrum.BeeperEvent{{TState: 0, Level: 0}, {TState: audioData.Timing.TStatesPerFrame, Level: 0}}
+	}
+
+	numSamples_float := AUDIO_FREQUENCY / audioData.FPS
+	numSamples := int(numSamples_float)
+	server.numSamples_cummulativeFraction += numSamples_float - float32(numSamples)
+	if server.numSamples_cummulativeFraction >= 1.0 {
+		numSamples += 1
+		server.numSamples_cummulativeFraction -= 1.0
+	}
+
+	// Each sample is the average level of the beeper and of the sampled sound devices
+	// during the sample's period
+	samples := make([]float64, numSamples)
+	{
+		tstates := make([]int, len(events))
+		levels := make([]float64, len(events))
+		for i, e := range events {
+			tstates[i] = e.TState
+			levels[i] = float64(spectrum.Audio16_Table[e.Level])
+		}
+		addAverageLevels(samples, tstates, levels)
+	}
+	if len(audioData.SampleEvents) > 0 {
+		tstates := make([]int, len(audioData.SampleEvents))
+		levels := make([]float64, len(audioData.SampleEvents))
+		for i, e := range audioData.SampleEvents {
+			tstates[i] = e.TState
+			levels[i] = float64(e.Level)
+		}
+		addAverageLevels(samples, tstates, levels)
+	}
+
+	msg := make([]byte, 1+2*numSamples)
+	msg[0] = MSG_AUDIO
+
+	for i, sum := range samples {
+		const VOLUME_ADJUSTMENT = 0.5
+		sample := int16(VOLUME_ADJUSTMENT * sum)
+		binary.LittleEndian.PutUint16(msg[1+2*i:], uint16(sample))
+	}
+
+	return msg
+}
+
+// Adds the average level of a signal during each sample's period to the samples.
+// The signal is described by the T-states and the levels of its changes,
+// from T-state 0 to the end of the frame (the last T-state).
+func addAverageLevels(samples []float64, tstates []int, levels []float64) {
+	k := float64(tstates[len(tstates)-1]) / float64(len(samples))
+	e := 0
+	for i := range samples {
+		t0 := float64(i) * k
+		t1 := t0 + k
+
+		var sum float64
+		for t := t0; t < t1; {
+			for (e+1 < len(tstates)-1) && (float64(tstates[e+1]) <= t) {
+				e++
+			}
+			end := t1
+			if (e+1 < len(tstates)) && (float64(tstates[e+1]) < end) {
+				end = float64(tstates[e+1])
+			}
+			sum += (end - t) * levels[e]
+			t = end
+		}
+
+		samples[i] += sum / k
+	}
+}
+
+// Sends the message to all browsers, without blocking
+func (server *WebServer) broadcast(msg []byte, isScreenUpdate bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for c := range server.clients {
+		if c.newPalette {
+			select {
+			case c.out <- server.hello():
+				c.newPalette = false
+			default:
+				continue
+			}
+		}
+
+		if c.resync {
+			// The whole screen includes the changes in 'msg'
+			select {
+			case c.out <- server.wholeScreen():
+				c.resync = false
+			default:
+			}
+			continue
+		}
+
+		select {
+		case c.out <- msg:
+		default:
+			if isScreenUpdate {
+				c.resync = true
+			}
+		}
+	}
+}
+
+func (server *WebServer) closeAllClients() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for c := range server.clients {
+		c.conn.Close()
+	}
+}
+
+// Implement http.Handler
+func (server *WebServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+
+	case "/ws":
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			if server.app.Verbose {
+				server.app.PrintfMsg("web: %s", err)
+			}
+			return
+		}
+		server.serveClient(conn)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (server *WebServer) serveClient(conn *wsConn) {
+	c := &client_t{
+		conn:     conn,
+		out:      make(chan []byte, CLIENT_QUEUE_LENGTH),
+		keys:     make(map[string]bool),
+		joystick: make(map[uint]uint),
+	}
+
+	server.mutex.Lock()
+	c.out <- server.hello()
+	c.out <- server.wholeScreen()
+	server.clients[c] = true
+	server.mutex.Unlock()
+
+	if server.app.Verbose {
+		server.app.PrintfMsg("web: %s connected", conn.conn.RemoteAddr())
+	}
+
+	// Writer
+	go func() {
+		for msg := range c.out {
+			if err := conn.WriteMessage(WS_BINARY, msg); err != nil {
+				conn.Close()
+				break
+			}
+		}
+	}()
+
+	// Reader
+	for {
+		opcode, msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if opcode == WS_TEXT {
+			server.handleInput(c, string(msg))
+		}
+	}
+
+	server.mutex.Lock()
+	delete(server.clients, c)
+	close(c.out)
+	server.mutex.Unlock()
+
+	conn.Close()
+	server.releaseAll(c)
+
+	if server.app.Verbose {
+		server.app.PrintfMsg("web: %s disconnected", conn.conn.RemoteAddr())
+	}
+}
+
+// Handles an input event sent by the browser:
+//
+//	"kd <key>", "ku <key>"  Key down/up. The key names are those of 'spectrum.SDL_KeyMap'.
+//	"jd <dir>", "ju <dir>"  Joystick down/up: up, down, left, right, fire.
+//	                        The joystick is the interface selected for gamepad 0.
+func (server *WebServer) handleInput(c *client_t, input string) {
+	fields := strings.SplitN(input, " ", 2)
+	if len(fields) != 2 {
+		return
+	}
+	name := fields[1]
+
+	keyboard := server.speccy.Keyboard
+	joystick := server.speccy.Joystick
+
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+
+	switch fields[0] {
+	case "kd":
+		if sequence, haveMapping := spectrum.SDL_KeyMap[name]; haveMapping && !c.keys[name] {
+			// Normal order
+			for i := 0; i < len(sequence); i++ {
+				keyboard.KeyDown(sequence[i])
+			}
+			c.keys[name] = true
+		}
+
+	case "ku":
+		if sequence, haveMapping := spectrum.SDL_KeyMap[name]; haveMapping && c.keys[name] {
+			// Reverse order
+			for i := len(sequence) - 1; i >= 0; i-- {
+				keyboard.KeyUp(sequence[i])
+			}
+			delete(c.keys, name)
+		}
+
+	case "jd":
+		if dir, ok := joystickNames[name]; ok {
+			if _, pressed := c.joystick[dir]; !pressed {
+				iface := server.speccy.Input.JoystickInterface(0)
+				joystick.Down(iface, dir)
+				c.joystick[dir] = iface
+			}
+		}
+
+	case "ju":
+		if dir, ok := joystickNames[name]; ok {
+			if iface, pressed := c.joystick[dir]; pressed {
+				joystick.Up(iface, dir)
+				delete(c.joystick, dir)
+			}
+		}
+	}
+}
+
+// Releases all keys held by a disconnected browser
+func (server *WebServer) releaseAll(c *client_t) {
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+
+	for name := range c.keys {
+		sequence := spectrum.SDL_KeyMap[name]
+		for i := len(sequence) - 1; i >= 0; i-- {
+			server.speccy.Keyboard.KeyUp(sequence[i])
+		}
+	}
+	for dir, iface := range c.joystick {
+		server.speccy.Joystick.Up(iface, dir)
+	}
+
+	c.keys = make(map[string]bool)
+	c.joystick = make(map[uint]uint)
+}
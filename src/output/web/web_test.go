@@ -0,0 +1,198 @@
+package web_output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/remogatto/gospeccy/src/spectrum"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebSocketAccept(t *testing.T) {
+	// The example from RFC 6455
+	accept := websocketAccept("dGhlIHNhbXBsZSBub25jZQ==")
+	if accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wrong accept key: %s", accept)
+	}
+}
+
+func newHandshakeRequest(host, origin string) *http.Request {
+	r := httptest.NewRequest("GET", "http://"+host+"/ws", nil)
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	return r
+}
+
+func TestCrossOriginHandshake(t *testing.T) {
+	w := httptest.NewRecorder()
+	conn, err := upgradeWebSocket(w, newHandshakeRequest("localhost:8080", "http://evil.example.com"))
+	if (conn != nil) || (err == nil) {
+		t.Fatal("a cross-origin handshake was accepted")
+	}
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestSameOriginHandshake(t *testing.T) {
+	accepted := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r)
+		if err == nil {
+			conn.conn.Close()
+		}
+		accepted <- err
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	for _, origin := range []string{"", server.URL} {
+		client, err := net.Dial("tcp", host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = newHandshakeRequest(host, origin).Write(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status, err := bufio.NewReader(client).ReadString('\n')
+		client.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(status, "101") {
+			t.Errorf("origin %q: expected 101 Switching Protocols, got %q", origin, status)
+		}
+		if err := <-accepted; err != nil {
+			t.Errorf("origin %q: %s", origin, err)
+		}
+	}
+}
+
+func TestReadMaskedMessage(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+
+	go func() {
+		// A masked text frame "Hello", from RFC 6455
+		client.Write([]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58})
+	}()
+
+	opcode, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (opcode != WS_TEXT) || !bytes.Equal(msg, []byte("Hello")) {
+		t.Errorf("expected a text message \"Hello\", got opcode %d %q", opcode, msg)
+	}
+}
+
+func TestEncodeScreenAndAudio(t *testing.T) {
+	server := &WebServer{palette: &spectrum.Palette, clients: make(map[*client_t]bool)}
+
+	screen := &spectrum.DisplayData{}
+	screen.Bitmap[0] = 0xf0
+	screen.Attr[0] = 0x27 // Ink 2, paper 7
+	screen.Dirty[0] = true
+	screen.BorderEvents = []spectrum.BorderEvent{{0, 1}, {spectrum.Timing48K.TStatesPerFrame, 3}}
+	screen.Timing = spectrum.Timing48K
+
+	msg := server.updateScreen(screen)
+	if (msg[0] != MSG_SCREEN) || (msg[1] != 3) || (binary.LittleEndian.Uint16(msg[2:4]) != 1) {
+		t.Fatalf("wrong screen message header: %v", msg[0:4])
+	}
+	if len(msg) != 4+2+64 {
+		t.Fatalf("expected a single cell, got %d bytes", len(msg))
+	}
+	if (msg[6] != 2) || (msg[9] != 2) || (msg[10] != 7) || (msg[13] != 7) {
+		t.Errorf("wrong cell colors: %v", msg[6:14])
+	}
+
+	audio := server.encodeAudio(&spectrum.AudioData{
+		FPS:    50,
+		Timing: spectrum.Timing48K,
+		BeeperEvents: []spectrum.BeeperEvent{
+			{TState: 0, Level: 3},
+			{TState: spectrum.Timing48K.TStatesPerFrame, Level: 3},
+		},
+	})
+	numSamples := (len(audio) - 1) / 2
+	if (audio[0] != MSG_AUDIO) || (numSamples != AUDIO_FREQUENCY/50) {
+		t.Fatalf("expected %d samples, got %d", AUDIO_FREQUENCY/50, numSamples)
+	}
+	expected := int16(0.5 * float64(spectrum.Audio16_Table[3]))
+	for i := 0; i < numSamples; i++ {
+		sample := int16(binary.LittleEndian.Uint16(audio[1+2*i:]))
+		if (sample < expected-1) || (sample > expected+1) {
+			t.Fatalf("sample %d: expected %d, got %d", i, expected, sample)
+		}
+	}
+}
+
+func TestPaletteChange(t *testing.T) {
+	app := spectrum.NewApplication()
+	server := &WebServer{app: app, palette: app.Palette(), clients: make(map[*client_t]bool)}
+
+	c := &client_t{out: make(chan []byte, CLIENT_QUEUE_LENGTH)}
+	server.clients[c] = true
+
+	// The browser receives the new palette, followed by the whole screen
+	app.SetPalette(&spectrum.Palette_GreenScreen)
+	server.updatePalette()
+	server.broadcast(server.updateScreen(&spectrum.DisplayData{}), true)
+
+	hello := <-c.out
+	if hello[0] != MSG_HELLO {
+		t.Fatalf("expected MSG_HELLO, got %d", hello[0])
+	}
+	for i, rgb := range spectrum.Palette_GreenScreen {
+		if (hello[5+3*i] != byte(rgb>>16)) || (hello[6+3*i] != byte(rgb>>8)) || (hello[7+3*i] != byte(rgb)) {
+			t.Errorf("color %d: expected %06x, got %v", i, rgb&0xffffff, hello[5+3*i:8+3*i])
+		}
+	}
+
+	screen := <-c.out
+	numCells := int(binary.LittleEndian.Uint16(screen[2:4]))
+	if (screen[0] != MSG_SCREEN) || (numCells != spectrum.ScreenWidth_Attr*spectrum.ScreenHeight_Attr) {
+		t.Errorf("expected the whole screen, got %d cells", numCells)
+	}
+
+	if len(c.out) != 0 {
+		t.Errorf("unexpected messages after the whole screen")
+	}
+}
+
+func TestEncodeSampleEvents(t *testing.T) {
+	server := &WebServer{palette: &spectrum.Palette, clients: make(map[*client_t]bool)}
+
+	// Silent beeper, a DAC at half the full level during the second half of the frame
+	audio := server.encodeAudio(&spectrum.AudioData{
+		FPS:    50,
+		Timing: spectrum.Timing48K,
+		SampleEvents: []spectrum.SampleEvent{
+			{TState: 0, Level: 0},
+			{TState: spectrum.Timing48K.TStatesPerFrame / 2, Level: 0x4000},
+			{TState: spectrum.Timing48K.TStatesPerFrame, Level: 0x4000},
+		},
+	})
+	numSamples := (len(audio) - 1) / 2
+
+	first := int16(binary.LittleEndian.Uint16(audio[1:]))
+	last := int16(binary.LittleEndian.Uint16(audio[1+2*(numSamples-1):]))
+	if (first != 0) || (last < 0x2000-1) || (last > 0x2000) {
+		t.Errorf("expected the samples 0 ... 0x2000, got %d ... %d", first, last)
+	}
+}
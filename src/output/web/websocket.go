@@ -0,0 +1,252 @@
+package web_output
+
+// A minimal server-side implementation of the WebSocket protocol (RFC 6455).
+// It supports everything needed by the web frontend: unfragmented and fragmented
+// text/binary messages, ping/pong and the closing handshake.
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The maximum size of a message received from a client
+const MAX_MESSAGE_SIZE = 0x10000
+
+// WebSocket opcodes
+const (
+	WS_CONTINUATION = 0x0
+	WS_TEXT         = 0x1
+	WS_BINARY       = 0x2
+	WS_CLOSE        = 0x8
+	WS_PING         = 0x9
+	WS_PONG         = 0xA
+)
+
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	// Serializes writes from multiple goroutines
+	writeMutex sync.Mutex
+}
+
+// Returns true if the comma-separated list of tokens contains 'token'
+func headerContains(header, token string) bool {
+	for _, s := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(s), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+websocketGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Returns true if the request has no Origin header, or if the origin is the server itself.
+// Browsers send the Origin header with every WebSocket handshake, so this prevents
+// other web pages from connecting to the emulator (cross-site WebSocket hijacking).
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Performs the opening handshake, taking over the HTTP connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !headerContains(r.Header.Get("Connection"), "upgrade") || (key == "") {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("invalid WebSocket handshake")
+	}
+
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket connections are not allowed", http.StatusForbidden)
+		return nil, errors.New("WebSocket handshake from a foreign origin: " + r.Header.Get("Origin"))
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot hijack the connection", http.StatusInternalServerError)
+		return nil, errors.New("cannot hijack the HTTP connection")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n\r\n"
+
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// Reads a single frame
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.rw, header[:]); err != nil {
+		return
+	}
+
+	fin = (header[0] & 0x80) != 0
+	opcode = header[0] & 0x0F
+	masked := (header[1] & 0x80) != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if !masked {
+		err = errors.New("unmasked WebSocket frame from client")
+		return
+	}
+	if length > MAX_MESSAGE_SIZE {
+		err = errors.New("WebSocket frame is too large")
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.rw, mask[:]); err != nil {
+		return
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.rw, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return
+}
+
+// Reads the next text or binary message.
+// Control frames are handled internally.
+// Returns io.EOF after the client has closed the connection.
+func (c *wsConn) ReadMessage() (opcode byte, message []byte, err error) {
+	for {
+		fin, frameOpcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frameOpcode {
+		case WS_PING:
+			if err := c.WriteMessage(WS_PONG, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+
+		case WS_PONG:
+			continue
+
+		case WS_CLOSE:
+			c.WriteMessage(WS_CLOSE, nil)
+			return 0, nil, io.EOF
+
+		case WS_TEXT, WS_BINARY:
+			opcode = frameOpcode
+			message = payload
+
+		case WS_CONTINUATION:
+			if opcode == 0 {
+				return 0, nil, errors.New("unexpected WebSocket continuation frame")
+			}
+			if len(message)+len(payload) > MAX_MESSAGE_SIZE {
+				return 0, nil, errors.New("WebSocket message is too large")
+			}
+			message = append(message, payload...)
+
+		default:
+			return 0, nil, errors.New("unknown WebSocket opcode")
+		}
+
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// Sends an unfragmented message
+func (c *wsConn) WriteMessage(opcode byte, message []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	var header [10]byte
+	header[0] = 0x80 | opcode
+
+	var n int
+	length := len(message)
+	switch {
+	case length < 126:
+		header[1] = byte(length)
+		n = 2
+	case length <= 0xFFFF:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:4], uint16(length))
+		n = 4
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:10], uint64(length))
+		n = 10
+	}
+
+	if _, err := c.rw.Write(header[0:n]); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(message); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,15 @@
+// +build linux freebsd
+
+package pull_modules
+
+import (
+	"github.com/remogatto/gospeccy/src/output/sdl"
+	"github.com/remogatto/gospeccy/src/output/term"
+)
+
+func init() {
+	// The terminal user interface and the SDL window are mutually exclusive
+	sdl_output.WindowReplaced = term_output.Enabled
+
+	go term_output.Main()
+}
@@ -0,0 +1,9 @@
+package pull_modules
+
+import (
+	"github.com/remogatto/gospeccy/src/output/vnc"
+)
+
+func init() {
+	go vnc_output.Main()
+}
@@ -0,0 +1,9 @@
+package pull_modules
+
+import (
+	"github.com/remogatto/gospeccy/src/output/web"
+)
+
+func init() {
+	go web_output.Main()
+}
@@ -0,0 +1,169 @@
+package spectrum
+
+import (
+	"math"
+)
+
+// The 64 allophones of the SP0256-AL2 speech synthesiser used by the Currah µSpeech.
+//
+// The sounds are not a reproduction of the chip's output. They are synthesised from the table
+// below: voiced sounds are a train of glottal pulses exciting two formants, the unvoiced sounds
+// are noise, and the stops are a closure followed by a burst.
+const (
+	allophone_sampleRate = 10000 // Hz, the output rate of the SP0256
+	allophone_sampleTime = 350   // T-states per sample
+	allophone_rampTime   = 50    // Samples of fade-in and fade-out
+
+	allophone_lowPitch  = 100 // Hz
+	allophone_highPitch = 125 // Hz
+)
+
+type allophoneKind int
+
+const (
+	allophone_pause allophoneKind = iota
+	allophone_vowel
+	allophone_nasal
+	allophone_fricative
+	allophone_voicedFricative
+	allophone_stop
+	allophone_voicedStop
+)
+
+type allophone struct {
+	name     string
+	duration int // Milliseconds
+	kind     allophoneKind
+	f1, f2   int // The formant frequencies (Hz) of the voiced sounds
+}
+
+var allophones = [64]allophone{
+	{"PA1", 10, allophone_pause, 0, 0},
+	{"PA2", 30, allophone_pause, 0, 0},
+	{"PA3", 50, allophone_pause, 0, 0},
+	{"PA4", 100, allophone_pause, 0, 0},
+	{"PA5", 200, allophone_pause, 0, 0},
+	{"OY", 290, allophone_vowel, 550, 960},
+	{"AY", 170, allophone_vowel, 710, 1100},
+	{"EH", 50, allophone_vowel, 530, 1840},
+	{"KK3", 80, allophone_stop, 0, 0},
+	{"PP", 150, allophone_stop, 0, 0},
+	{"JH", 100, allophone_voicedStop, 250, 1800},
+	{"NN1", 170, allophone_nasal, 250, 1500},
+	{"IH", 50, allophone_vowel, 390, 1990},
+	{"TT2", 100, allophone_stop, 0, 0},
+	{"RR1", 130, allophone_vowel, 420, 1300},
+	{"AX", 50, allophone_vowel, 500, 1500},
+	{"MM", 180, allophone_nasal, 250, 1000},
+	{"TT1", 80, allophone_stop, 0, 0},
+	{"DH1", 140, allophone_voicedFricative, 300, 1600},
+	{"IY", 170, allophone_vowel, 270, 2290},
+	{"EY", 200, allophone_vowel, 450, 2000},
+	{"DD1", 50, allophone_voicedStop, 250, 1700},
+	{"UW1", 60, allophone_vowel, 300, 870},
+	{"AO", 70, allophone_vowel, 570, 840},
+	{"AA", 60, allophone_vowel, 730, 1090},
+	{"YY2", 130, allophone_vowel, 280, 2200},
+	{"AE", 80, allophone_vowel, 660, 1720},
+	{"HH1", 90, allophone_fricative, 0, 0},
+	{"BB1", 40, allophone_voicedStop, 250, 800},
+	{"TH", 130, allophone_fricative, 0, 0},
+	{"UH", 70, allophone_vowel, 440, 1020},
+	{"UW2", 170, allophone_vowel, 300, 870},
+	{"AW", 250, allophone_vowel, 700, 1000},
+	{"DD2", 80, allophone_voicedStop, 250, 1700},
+	{"GG3", 120, allophone_voicedStop, 250, 2000},
+	{"VV", 130, allophone_voicedFricative, 300, 1100},
+	{"GG1", 80, allophone_voicedStop, 250, 2000},
+	{"SH", 120, allophone_fricative, 0, 0},
+	{"ZH", 130, allophone_voicedFricative, 300, 1800},
+	{"RR2", 80, allophone_vowel, 420, 1300},
+	{"FF", 110, allophone_fricative, 0, 0},
+	{"KK2", 140, allophone_stop, 0, 0},
+	{"KK1", 120, allophone_stop, 0, 0},
+	{"ZZ", 150, allophone_voicedFricative, 300, 1600},
+	{"NG", 200, allophone_nasal, 250, 2000},
+	{"LL", 80, allophone_vowel, 360, 1300},
+	{"WW", 140, allophone_vowel, 300, 700},
+	{"XR", 250, allophone_vowel, 500, 1400},
+	{"WH", 150, allophone_fricative, 0, 0},
+	{"YY1", 90, allophone_vowel, 280, 2200},
+	{"CH", 150, allophone_stop, 0, 0},
+	{"ER1", 110, allophone_vowel, 490, 1350},
+	{"ER2", 210, allophone_vowel, 490, 1350},
+	{"OW", 170, allophone_vowel, 500, 900},
+	{"DH2", 180, allophone_voicedFricative, 300, 1600},
+	{"SS", 60, allophone_fricative, 0, 0},
+	{"NN2", 140, allophone_nasal, 250, 1500},
+	{"HH2", 130, allophone_fricative, 0, 0},
+	{"OR", 240, allophone_vowel, 550, 850},
+	{"AR", 200, allophone_vowel, 700, 1200},
+	{"YR", 250, allophone_vowel, 400, 1900},
+	{"GG2", 80, allophone_voicedStop, 250, 2000},
+	{"EL", 140, allophone_vowel, 400, 1000},
+	{"BB2", 60, allophone_voicedStop, 250, 800},
+}
+
+// Synthesises the samples of the allophones, spoken at the specified pitch (Hz)
+func synthesiseAllophones(pitch int) [][]int16 {
+	samples := make([][]int16, len(allophones))
+	for i, a := range allophones {
+		samples[i] = a.synthesise(pitch)
+	}
+	return samples
+}
+
+func (a *allophone) synthesise(pitch int) []int16 {
+	n := a.duration * allophone_sampleRate / 1000
+	samples := make([]int16, n)
+	if a.kind == allophone_pause {
+		return samples
+	}
+
+	period := allophone_sampleRate / pitch
+	var noise uint32 = 1
+
+	for i := range samples {
+		// The response of the formants to the last glottal pulse
+		t := float64(i%period) / allophone_sampleRate
+		voice := math.Exp(-t*300) * (0.6*math.Sin(2*math.Pi*float64(a.f1)*t) + 0.4*math.Sin(2*math.Pi*float64(a.f2)*t))
+
+		noise = noise*1103515245 + 12345
+		hiss := float64(int32(noise)>>16) / 0x8000
+
+		var x float64
+		switch a.kind {
+		case allophone_vowel:
+			x = 0.8 * voice
+		case allophone_nasal:
+			x = 0.5 * voice
+		case allophone_fricative:
+			x = 0.3 * hiss
+		case allophone_voicedFricative:
+			x = 0.4*voice + 0.2*hiss
+		case allophone_stop:
+			// The closure, then the burst
+			if i >= n*2/3 {
+				x = 0.5 * hiss * float64(n-i) / float64(n/3)
+			}
+		case allophone_voicedStop:
+			if i < n/2 {
+				x = 0.3 * voice
+			} else {
+				x = 0.3 * hiss * float64(n-i) / float64(n-n/2)
+			}
+		}
+
+		// Fade in and out, to avoid clicks between the allophones
+		if i < allophone_rampTime {
+			x *= float64(i) / allophone_rampTime
+		}
+		if n-i < allophone_rampTime {
+			x *= float64(n-i) / allophone_rampTime
+		}
+
+		samples[i] = int16(x * 0x7fff)
+	}
+
+	return samples
+}
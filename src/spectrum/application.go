@@ -27,6 +27,9 @@ type Application struct {
 
 	messageOutput MessageOutput
 
+	// The color palette used by all display backends
+	palette *[16]uint32
+
 	Verbose         bool
 	VerboseShutdown bool
 
@@ -40,6 +43,7 @@ func NewApplication() *Application {
 		eventLoops:    make([]*EventLoop, 0, 8),
 		CreationTime:  time.Now(),
 		messageOutput: &stdoutMessageOutput{},
+		palette:       &Palette,
 	}
 
 	go appGoroutine(app)
@@ -191,6 +195,22 @@ func (app *Application) SetMessageOutput(out MessageOutput) MessageOutput {
 	return prev
 }
 
+// Returns the color palette used by the display backends
+func (app *Application) Palette() *[16]uint32 {
+	app.mutex.Lock()
+	palette := app.palette
+	app.mutex.Unlock()
+	return palette
+}
+
+// Changes the color palette used by the display backends.
+// The backends are checking the palette before rendering a frame.
+func (app *Application) SetPalette(palette *[16]uint32) {
+	app.mutex.Lock()
+	app.palette = palette
+	app.mutex.Unlock()
+}
+
 func (app *Application) PrintfMsg(format string, a ...interface{}) {
 	app.mutex.Lock()
 	out := app.messageOutput
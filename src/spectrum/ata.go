@@ -0,0 +1,311 @@
+package spectrum
+
+// An ATA hard disk in PIO mode, as connected to the DivIDE.
+//
+// Only the master device is present. The disk supports CHS and LBA addressing,
+// and the commands IDENTIFY DEVICE, READ SECTORS, WRITE SECTORS,
+// INITIALIZE DEVICE PARAMETERS and SET FEATURES. The 16-bit data register
+// is accessed one byte at a time, low byte first.
+
+// Registers
+const (
+	ATA_DATA = iota
+	ATA_ERROR_FEATURE
+	ATA_SECTOR_COUNT
+	ATA_SECTOR
+	ATA_CYLINDER_LOW
+	ATA_CYLINDER_HIGH
+	ATA_DRIVE_HEAD
+	ATA_STATUS_COMMAND
+)
+
+// Status bits
+const (
+	ATA_STATUS_ERR  = 0x01
+	ATA_STATUS_DRQ  = 0x08
+	ATA_STATUS_DSC  = 0x10
+	ATA_STATUS_DRDY = 0x40
+	ATA_STATUS_BSY  = 0x80
+)
+
+// Error bits
+const (
+	ATA_ERROR_ABRT = 0x04
+	ATA_ERROR_IDNF = 0x10
+	ATA_ERROR_UNC  = 0x40
+)
+
+const (
+	ATA_CMD_READ_SECTORS     = 0x20
+	ATA_CMD_READ_SECTORS_NR  = 0x21
+	ATA_CMD_WRITE_SECTORS    = 0x30
+	ATA_CMD_WRITE_SECTORS_NR = 0x31
+	ATA_CMD_INIT_PARAMETERS  = 0x91
+	ATA_CMD_IDENTIFY_DEVICE  = 0xec
+	ATA_CMD_SET_FEATURES     = 0xef
+)
+
+const (
+	ata_transferNone  = iota
+	ata_transferRead  // Device to host
+	ata_transferWrite // Host to device
+)
+
+type ataDrive struct {
+	disk *diskImage
+
+	// Geometry
+	cylinders, heads, sectorsPerTrack uint32
+
+	// Task file
+	regs   [8]byte
+	status byte
+	error  byte
+
+	buf       [DISK_SECTOR_SIZE]byte
+	bufPos    int
+	transfer  int
+	remaining int
+	lba       uint32
+}
+
+func newATADrive(disk *diskImage) *ataDrive {
+	drive := &ataDrive{disk: disk, heads: 16, sectorsPerTrack: 63}
+	drive.cylinders = disk.sectors / (drive.heads * drive.sectorsPerTrack)
+	if drive.cylinders > 16383 {
+		drive.cylinders = 16383
+	}
+	drive.reset()
+	return drive
+}
+
+func (drive *ataDrive) reset() {
+	drive.regs = [8]byte{}
+	drive.regs[ATA_SECTOR_COUNT] = 1
+	drive.regs[ATA_SECTOR] = 1
+	drive.status = ATA_STATUS_DRDY | ATA_STATUS_DSC
+	drive.error = 0x01 // Diagnostic passed
+	drive.transfer = ata_transferNone
+}
+
+// Returns true if the slave device is selected
+func (drive *ataDrive) slave() bool {
+	return (drive.regs[ATA_DRIVE_HEAD] & 0x10) != 0
+}
+
+func (drive *ataDrive) read(reg int) byte {
+	if drive.slave() && (reg != ATA_DRIVE_HEAD) {
+		// No device
+		return 0x00
+	}
+
+	switch reg {
+	case ATA_DATA:
+		if drive.transfer != ata_transferRead {
+			return 0xff
+		}
+		b := drive.buf[drive.bufPos]
+		drive.bufPos++
+		if drive.bufPos == DISK_SECTOR_SIZE {
+			drive.remaining--
+			if drive.remaining > 0 {
+				drive.lba++
+				drive.readSector()
+			} else {
+				drive.done()
+			}
+		}
+		return b
+
+	case ATA_ERROR_FEATURE:
+		return drive.error
+
+	case ATA_STATUS_COMMAND:
+		return drive.status
+	}
+
+	return drive.regs[reg]
+}
+
+func (drive *ataDrive) write(reg int, b byte) {
+	switch reg {
+	case ATA_DATA:
+		if drive.slave() || (drive.transfer != ata_transferWrite) {
+			return
+		}
+		drive.buf[drive.bufPos] = b
+		drive.bufPos++
+		if drive.bufPos == DISK_SECTOR_SIZE {
+			if err := drive.disk.writeSector(drive.lba, drive.buf[:]); err != nil {
+				drive.fail(ATA_ERROR_UNC)
+				return
+			}
+			drive.remaining--
+			if drive.remaining > 0 {
+				drive.lba++
+				drive.setAddress(drive.lba)
+				drive.bufPos = 0
+			} else {
+				drive.setAddress(drive.lba + 1)
+				drive.done()
+			}
+		}
+
+	case ATA_STATUS_COMMAND:
+		if !drive.slave() {
+			drive.execute(b)
+		}
+
+	default:
+		drive.regs[reg] = b
+	}
+}
+
+// Returns the sector addressed by the task file
+func (drive *ataDrive) address() uint32 {
+	if (drive.regs[ATA_DRIVE_HEAD] & 0x40) != 0 {
+		return uint32(drive.regs[ATA_DRIVE_HEAD]&0x0f)<<24 |
+			uint32(drive.regs[ATA_CYLINDER_HIGH])<<16 |
+			uint32(drive.regs[ATA_CYLINDER_LOW])<<8 |
+			uint32(drive.regs[ATA_SECTOR])
+	}
+
+	cylinder := uint32(drive.regs[ATA_CYLINDER_HIGH])<<8 | uint32(drive.regs[ATA_CYLINDER_LOW])
+	head := uint32(drive.regs[ATA_DRIVE_HEAD] & 0x0f)
+	sector := uint32(drive.regs[ATA_SECTOR])
+	if sector == 0 {
+		return 0xffffffff
+	}
+	return (cylinder*drive.heads+head)*drive.sectorsPerTrack + sector - 1
+}
+
+// Stores the sector address in the task file
+func (drive *ataDrive) setAddress(lba uint32) {
+	if (drive.regs[ATA_DRIVE_HEAD] & 0x40) != 0 {
+		drive.regs[ATA_SECTOR] = byte(lba)
+		drive.regs[ATA_CYLINDER_LOW] = byte(lba >> 8)
+		drive.regs[ATA_CYLINDER_HIGH] = byte(lba >> 16)
+		drive.regs[ATA_DRIVE_HEAD] = (drive.regs[ATA_DRIVE_HEAD] & 0xf0) | byte(lba>>24)&0x0f
+		return
+	}
+
+	sector := lba%drive.sectorsPerTrack + 1
+	head := (lba / drive.sectorsPerTrack) % drive.heads
+	cylinder := lba / (drive.sectorsPerTrack * drive.heads)
+	drive.regs[ATA_SECTOR] = byte(sector)
+	drive.regs[ATA_CYLINDER_LOW] = byte(cylinder)
+	drive.regs[ATA_CYLINDER_HIGH] = byte(cylinder >> 8)
+	drive.regs[ATA_DRIVE_HEAD] = (drive.regs[ATA_DRIVE_HEAD] & 0xf0) | byte(head)
+}
+
+func (drive *ataDrive) execute(cmd byte) {
+	drive.error = 0
+
+	count := int(drive.regs[ATA_SECTOR_COUNT])
+	if count == 0 {
+		count = 256
+	}
+
+	switch cmd {
+	case ATA_CMD_READ_SECTORS, ATA_CMD_READ_SECTORS_NR:
+		drive.lba = drive.address()
+		drive.remaining = count
+		drive.readSector()
+
+	case ATA_CMD_WRITE_SECTORS, ATA_CMD_WRITE_SECTORS_NR:
+		drive.lba = drive.address()
+		if drive.lba >= drive.disk.sectors {
+			drive.fail(ATA_ERROR_IDNF)
+			return
+		}
+		drive.remaining = count
+		drive.bufPos = 0
+		drive.transfer = ata_transferWrite
+		drive.status = ATA_STATUS_DRDY | ATA_STATUS_DSC | ATA_STATUS_DRQ
+
+	case ATA_CMD_IDENTIFY_DEVICE:
+		drive.identify()
+		drive.bufPos = 0
+		drive.remaining = 1
+		drive.transfer = ata_transferRead
+		drive.status = ATA_STATUS_DRDY | ATA_STATUS_DSC | ATA_STATUS_DRQ
+
+	case ATA_CMD_INIT_PARAMETERS:
+		// The geometry is fixed, accept only the native one
+		if (uint32(drive.regs[ATA_DRIVE_HEAD]&0x0f)+1 != drive.heads) ||
+			(uint32(drive.regs[ATA_SECTOR_COUNT]) != drive.sectorsPerTrack) {
+			drive.fail(ATA_ERROR_ABRT)
+			return
+		}
+		drive.done()
+
+	case ATA_CMD_SET_FEATURES:
+		drive.done()
+
+	default:
+		drive.fail(ATA_ERROR_ABRT)
+	}
+}
+
+// Reads the sector at 'lba' into the buffer, and requests the transfer to the host
+func (drive *ataDrive) readSector() {
+	if err := drive.disk.readSector(drive.lba, drive.buf[:]); err != nil {
+		drive.fail(ATA_ERROR_IDNF)
+		return
+	}
+	drive.setAddress(drive.lba)
+	drive.bufPos = 0
+	drive.transfer = ata_transferRead
+	drive.status = ATA_STATUS_DRDY | ATA_STATUS_DSC | ATA_STATUS_DRQ
+}
+
+func (drive *ataDrive) done() {
+	drive.transfer = ata_transferNone
+	drive.status = ATA_STATUS_DRDY | ATA_STATUS_DSC
+}
+
+func (drive *ataDrive) fail(err byte) {
+	drive.transfer = ata_transferNone
+	drive.error = err
+	drive.status = ATA_STATUS_DRDY | ATA_STATUS_DSC | ATA_STATUS_ERR
+}
+
+// Fills the buffer with the IDENTIFY DEVICE data
+func (drive *ataDrive) identify() {
+	drive.buf = [DISK_SECTOR_SIZE]byte{}
+
+	word := func(index int, value uint32) {
+		drive.buf[2*index] = byte(value)
+		drive.buf[2*index+1] = byte(value >> 8)
+	}
+	str := func(index int, length int, s string) {
+		// Two characters per word, the first one in the high byte
+		for i := 0; i < 2*length; i++ {
+			var c byte = ' '
+			if i < len(s) {
+				c = s[i]
+			}
+			drive.buf[2*index+(i^1)] = c
+		}
+	}
+
+	chs := drive.cylinders * drive.heads * drive.sectorsPerTrack
+
+	word(0, 0x0040) // Fixed disk
+	word(1, drive.cylinders)
+	word(3, drive.heads)
+	word(6, drive.sectorsPerTrack)
+	str(10, 10, "GOSPECCY0001")
+	str(23, 4, "1.0")
+	str(27, 20, "GOSPECCY DISK IMAGE")
+	word(47, 1)      // Sectors per READ/WRITE MULTIPLE
+	word(49, 0x0200) // LBA supported
+	word(53, 0x0001) // Words 54-58 are valid
+	word(54, drive.cylinders)
+	word(55, drive.heads)
+	word(56, drive.sectorsPerTrack)
+	word(57, chs&0xffff)
+	word(58, chs>>16)
+	word(60, drive.disk.sectors&0xffff)
+	word(61, drive.disk.sectors>>16)
+}
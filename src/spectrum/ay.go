@@ -0,0 +1,311 @@
+package spectrum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// The AY-3-8912 sound chip of the 128K models.
+//
+// The Z80 selects a register by writing its number to port 0xFFFD, then writes the register
+// through port 0xBFFD, or reads it through port 0xFFFD. The chip runs at half the clock of the Z80.
+// It has three square-wave tone generators (channels A, B and C), a noise generator, and an envelope
+// generator which can replace the fixed volume of any channel. The registers are:
+//
+//   - 0-5: the tone periods of the channels (12 bits, fine and coarse)
+//   - 6: the noise period (5 bits)
+//   - 7: the mixer (bits 0-2 disable the tones, bits 3-5 disable the noise)
+//   - 8-10: the volumes of the channels (bits 0-3), bit 4 selects the envelope
+//   - 11-12: the envelope period (16 bits)
+//   - 13: the envelope shape (writing restarts the envelope)
+//   - 14-15: the I/O ports
+type AY struct {
+	speccy *Spectrum48k
+
+	registers [16]byte
+	selected  byte
+
+	// The T-state (since the beginning of the frame) of the next tick of the counters
+	tstate int
+
+	toneCounters [3]int
+	toneOutputs  [3]bool
+
+	noiseCounter int
+	noiseShift   uint32 // A 17-bit linear feedback shift register
+
+	envelopeCounter int
+	envelopeStep    byte // 0 ... 15
+	envelopeAttack  bool // Whether the envelope is rising
+	envelopeHolding bool
+
+	output dacOutput
+}
+
+// The tone counters advance every 8 cycles of the AY clock
+const ay_tickTime = 16 // T-states
+
+// The bits of the registers which are implemented
+var ay_registerMasks = [16]byte{0xff, 0x0f, 0xff, 0x0f, 0xff, 0x0f, 0x1f, 0xff, 0x1f, 0x1f, 0x1f, 0xff, 0xff, 0x0f, 0xff, 0xff}
+
+// The output level of a channel at each volume. The volumes are logarithmic,
+// and the sum of the three channels at the full volume is the maximum 16-bit level.
+var ay_levels = [16]int16{0, 150, 224, 318, 462, 675, 925, 1495, 1847, 2891, 3852, 4914, 6230, 7507, 9264, 10922}
+
+func newAY(speccy *Spectrum48k) *AY {
+	ay := &AY{speccy: speccy}
+	ay.reset()
+	return ay
+}
+
+func (ay *AY) reset() {
+	ay.update(ay.speccy.Cpu.Tstates)
+
+	ay.registers = [16]byte{}
+	ay.selected = 0
+	ay.toneCounters = [3]int{}
+	ay.toneOutputs = [3]bool{}
+	ay.noiseCounter = 0
+	ay.noiseShift = 1
+	ay.restartEnvelope()
+
+	ay.output.set(ay.speccy.Cpu.Tstates, 0)
+}
+
+// Handles a write to port 0xFFFD
+func (ay *AY) selectRegister(b byte) {
+	ay.selected = b
+}
+
+// Handles a read from port 0xFFFD
+func (ay *AY) readRegister() byte {
+	if ay.selected >= 16 {
+		return 0xff
+	}
+	return ay.registers[ay.selected]
+}
+
+// Handles a write to port 0xBFFD
+func (ay *AY) writeRegister(b byte) {
+	if ay.selected >= 16 {
+		return
+	}
+
+	now := ay.speccy.Cpu.Tstates
+	ay.update(now)
+
+	ay.registers[ay.selected] = b & ay_registerMasks[ay.selected]
+	if ay.selected == 13 {
+		ay.restartEnvelope()
+	}
+
+	ay.output.set(now, ay.level())
+}
+
+func (ay *AY) restartEnvelope() {
+	ay.envelopeCounter = 0
+	ay.envelopeStep = 0
+	ay.envelopeAttack = ((ay.registers[13] & 0x04) != 0)
+	ay.envelopeHolding = false
+}
+
+func (ay *AY) tonePeriod(channel int) int {
+	period := int(ay.registers[2*channel]) | (int(ay.registers[2*channel+1]) << 8)
+	if period == 0 {
+		period = 1
+	}
+	return period
+}
+
+// Advances the counters up to the specified T-state, and records the changes of the output
+func (ay *AY) update(tstate int) {
+	for ay.tstate <= tstate {
+		for channel := 0; channel < 3; channel++ {
+			ay.toneCounters[channel]++
+			if ay.toneCounters[channel] >= ay.tonePeriod(channel) {
+				ay.toneCounters[channel] = 0
+				ay.toneOutputs[channel] = !ay.toneOutputs[channel]
+			}
+		}
+
+		// The noise and the envelope advance at half the rate of the tones
+		noisePeriod := int(ay.registers[6])
+		if noisePeriod == 0 {
+			noisePeriod = 1
+		}
+		ay.noiseCounter++
+		if ay.noiseCounter >= 2*noisePeriod {
+			ay.noiseCounter = 0
+			bit := (ay.noiseShift ^ (ay.noiseShift >> 3)) & 1
+			ay.noiseShift = (ay.noiseShift >> 1) | (bit << 16)
+		}
+
+		envelopePeriod := int(ay.registers[11]) | (int(ay.registers[12]) << 8)
+		if envelopePeriod == 0 {
+			envelopePeriod = 1
+		}
+		ay.envelopeCounter++
+		if ay.envelopeCounter >= 2*envelopePeriod {
+			ay.envelopeCounter = 0
+			ay.stepEnvelope()
+		}
+
+		ay.output.set(ay.tstate, ay.level())
+		ay.tstate += ay_tickTime
+	}
+}
+
+func (ay *AY) stepEnvelope() {
+	if ay.envelopeHolding {
+		return
+	}
+
+	ay.envelopeStep++
+	if ay.envelopeStep <= 15 {
+		return
+	}
+
+	shape := ay.registers[13]
+	switch {
+	case (shape & 0x08) == 0:
+		// Not continuing: silence
+		ay.envelopeStep = 15
+		ay.envelopeAttack = false
+		ay.envelopeHolding = true
+
+	case (shape & 0x01) != 0:
+		// Hold the last level, or the opposite level if alternating
+		ay.envelopeStep = 15
+		if (shape & 0x02) != 0 {
+			ay.envelopeAttack = !ay.envelopeAttack
+		}
+		ay.envelopeHolding = true
+
+	default:
+		ay.envelopeStep = 0
+		if (shape & 0x02) != 0 {
+			ay.envelopeAttack = !ay.envelopeAttack
+		}
+	}
+}
+
+func (ay *AY) envelopeVolume() byte {
+	if ay.envelopeAttack {
+		return ay.envelopeStep
+	}
+	return 15 - ay.envelopeStep
+}
+
+// Returns the current output level of the three channels together
+func (ay *AY) level() int16 {
+	mixer := ay.registers[7]
+	noise := ((ay.noiseShift & 1) != 0)
+
+	var level int16
+	for channel := uint(0); channel < 3; channel++ {
+		toneOn := ay.toneOutputs[channel] || ((mixer & (0x01 << channel)) != 0)
+		noiseOn := noise || ((mixer & (0x08 << channel)) != 0)
+		if !toneOn || !noiseOn {
+			continue
+		}
+
+		volume := ay.registers[8+channel]
+		if (volume & 0x10) != 0 {
+			level += ay_levels[ay.envelopeVolume()]
+		} else {
+			level += ay_levels[volume&0x0f]
+		}
+	}
+	return level
+}
+
+func (ay *AY) saveState() interface{} {
+	state := *ay
+	state.output = dacOutput{}
+	return &state
+}
+
+func (ay *AY) restoreState(state interface{}) {
+	level, tstate := ay.output.level, ay.tstate
+	*ay = *state.(*AY)
+
+	// The state is restored between two frames
+	ay.tstate = tstate
+	ay.output = dacOutput{level: level}
+	ay.output.set(0, ay.level())
+}
+
+func (ay *AY) rewindRAM() [][]byte {
+	return nil
+}
+
+// The registers and the counters of the AY, as stored by 'encodeState'
+type ay_encodedState struct {
+	Registers [16]byte
+	Selected  byte
+
+	ToneCounters [3]int32
+	ToneOutputs  [3]bool
+
+	NoiseCounter int32
+	NoiseShift   uint32
+
+	EnvelopeCounter int32
+	EnvelopeStep    byte
+	EnvelopeAttack  bool
+	EnvelopeHolding bool
+}
+
+func (ay *AY) encodeState(state interface{}) []byte {
+	s := state.(*AY)
+	e := ay_encodedState{
+		Registers:       s.registers,
+		Selected:        s.selected,
+		ToneOutputs:     s.toneOutputs,
+		NoiseCounter:    int32(s.noiseCounter),
+		NoiseShift:      s.noiseShift,
+		EnvelopeCounter: int32(s.envelopeCounter),
+		EnvelopeStep:    s.envelopeStep,
+		EnvelopeAttack:  s.envelopeAttack,
+		EnvelopeHolding: s.envelopeHolding,
+	}
+	for i, counter := range s.toneCounters {
+		e.ToneCounters[i] = int32(counter)
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &e)
+	return buf.Bytes()
+}
+
+func (ay *AY) decodeState(data []byte) (interface{}, error) {
+	var e ay_encodedState
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &e); err != nil {
+		return nil, errors.New("invalid AY state")
+	}
+
+	s := &AY{
+		speccy:          ay.speccy,
+		registers:       e.Registers,
+		selected:        e.Selected,
+		toneOutputs:     e.ToneOutputs,
+		noiseCounter:    int(e.NoiseCounter),
+		noiseShift:      e.NoiseShift,
+		envelopeCounter: int(e.EnvelopeCounter),
+		envelopeStep:    e.EnvelopeStep & 0x0f,
+		envelopeAttack:  e.EnvelopeAttack,
+		envelopeHolding: e.EnvelopeHolding,
+	}
+	for i, counter := range e.ToneCounters {
+		s.toneCounters[i] = int(counter)
+	}
+	return s, nil
+}
+
+func (ay *AY) frameSound() []SampleEvent {
+	tstatesPerFrame := ay.speccy.timing.TStatesPerFrame
+	ay.update(tstatesPerFrame - 1)
+	ay.tstate -= tstatesPerFrame
+	return ay.output.endFrame(tstatesPerFrame)
+}
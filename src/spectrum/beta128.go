@@ -0,0 +1,181 @@
+package spectrum
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// The Beta 128 disk interface.
+//
+// The interface has a 16K ROM (TR-DOS) and a WD1793 floppy disk controller with four drives.
+// The TR-DOS ROM is paged over the system ROM when the Z80 fetches an opcode from 0x3D00-0x3DFF
+// while the 48 BASIC ROM is selected, and it is paged out as soon as the Z80 fetches an opcode
+// from RAM. The ports of the controller (0x1F, 0x3F, 0x5F, 0x7F and 0xFF) are decoded only while
+// the TR-DOS ROM is paged in, so they do not conflict with the Kempston joystick.
+//
+// Disks are TRD images, or SCL archives converted to TRD images when they are inserted.
+// The changes made to a disk converted from an SCL archive are not saved.
+const BETA_ROM_SIZE = 0x4000
+
+type Beta128 struct {
+	rom    []byte
+	speccy *Spectrum48k
+
+	// Whether the TR-DOS ROM is paged in
+	active bool
+
+	fdc wd1793
+}
+
+// Reads the TR-DOS ROM from the specified file
+func ReadBetaROM(path string) ([]byte, error) {
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rom) != BETA_ROM_SIZE {
+		return nil, errors.New(path + ": invalid TR-DOS ROM file")
+	}
+	return rom, nil
+}
+
+// Creates a Beta 128 interface with the specified TR-DOS ROM
+func NewBeta128(rom []byte) (*Beta128, error) {
+	if len(rom) != BETA_ROM_SIZE {
+		return nil, errors.New("invalid TR-DOS ROM size")
+	}
+	beta := &Beta128{rom: rom}
+	beta.fdc.resetController()
+	return beta, nil
+}
+
+func (beta *Beta128) Name() string {
+	return "beta128"
+}
+
+func (beta *Beta128) attach(speccy *Spectrum48k) error {
+	for _, name := range []string{"divmmc", "divide", "multiface", "if1"} {
+		if speccy.findPeripheral(name) != nil {
+			return fmt.Errorf("the Beta 128 cannot be connected while the %s is connected", name)
+		}
+	}
+
+	beta.speccy = speccy
+	beta.fdc.speccy = speccy
+
+	for address := uint16(0x3d00); address <= 0x3dff; address++ {
+		speccy.addFetchTrap(beta, address, beta.pageIn, nil)
+	}
+	speccy.addRAMFetchTrap(beta, beta.pageOut)
+
+	return nil
+}
+
+func (beta *Beta128) detach() {
+	if beta.active {
+		beta.speccy.Memory.unpageROM()
+		beta.active = false
+	}
+}
+
+func (beta *Beta128) reset() {
+	if beta.active {
+		beta.speccy.Memory.unpageROM()
+		beta.active = false
+	}
+	beta.fdc.reset()
+}
+
+func (beta *Beta128) pageIn(pc uint16) {
+	memory := beta.speccy.Memory
+	if !beta.active && memory.basicROMPaged() {
+		memory.pageROM(beta.rom[0:0x2000], beta.rom[0x2000:0x4000], false, false)
+		beta.active = true
+	}
+}
+
+func (beta *Beta128) pageOut(pc uint16) {
+	if beta.active {
+		beta.speccy.Memory.unpageROM()
+		beta.active = false
+	}
+}
+
+func (beta *Beta128) saveState() interface{} {
+	return beta.active
+}
+
+func (beta *Beta128) restoreState(state interface{}) {
+	beta.active = state.(bool)
+	if beta.active {
+		beta.speccy.Memory.pageROM(beta.rom[0:0x2000], beta.rom[0x2000:0x4000], false, false)
+	}
+}
+
+func (beta *Beta128) rewindRAM() [][]byte {
+	return nil
+}
+
+func (beta *Beta128) encodeState(state interface{}) []byte {
+	return encodeFlags(state.(bool))
+}
+
+func (beta *Beta128) decodeState(data []byte) (interface{}, error) {
+	flags, err := decodeFlags(data, 1)
+	if err != nil {
+		return nil, err
+	}
+	return flags[0], nil
+}
+
+func (beta *Beta128) readPort(address uint16) (byte, bool) {
+	if !beta.active {
+		return 0, false
+	}
+
+	switch address & 0x00ff {
+	case 0x1f:
+		return beta.fdc.readStatus(), true
+	case 0x3f:
+		return beta.fdc.readTrackReg(), true
+	case 0x5f:
+		return beta.fdc.readSectorReg(), true
+	case 0x7f:
+		return beta.fdc.readData(), true
+	case 0xff:
+		return beta.fdc.readSystem(), true
+	}
+	return 0, false
+}
+
+func (beta *Beta128) writePort(address uint16, b byte) {
+	if !beta.active {
+		return
+	}
+
+	switch address & 0x00ff {
+	case 0x1f:
+		beta.fdc.writeCommand(b)
+	case 0x3f:
+		beta.fdc.writeTrackReg(b)
+	case 0x5f:
+		beta.fdc.writeSectorReg(b)
+	case 0x7f:
+		beta.fdc.writeData(b)
+	case 0xff:
+		beta.fdc.writeSystem(b)
+	}
+}
+
+// Inserts the TRD image or the SCL archive stored in the specified file into the drive (0 = A:, ..., 3 = D:).
+// If a TRD file does not exist, an unformatted disk is inserted and it will be created
+// when the disk is written. A file that cannot be written is inserted as a write-protected disk.
+func (beta *Beta128) InsertDisk(drive uint, path string) error {
+	return beta.fdc.insertDisk(drive, path)
+}
+
+// Ejects the disk from the drive (0 = A:, ..., 3 = D:)
+func (beta *Beta128) EjectDisk(drive uint) error {
+	return beta.fdc.ejectDisk(drive)
+}
@@ -0,0 +1,73 @@
+package spectrum
+
+import (
+	"errors"
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// The Interface 2 ROM cartridge slot.
+//
+// An inserted cartridge replaces the system ROM until it is ejected, and the machine is reset
+// when the cartridge is inserted or ejected. The Interface 2 also has two joystick sockets,
+// which are connected to the keyboard (see JOYSTICK_SINCLAIR1 and JOYSTICK_SINCLAIR2):
+// while a cartridge is inserted, the first two gamepads emulate them.
+
+type Cmd_EjectCartridge struct {
+	ErrChan chan<- error
+}
+
+// Called in the emulation goroutine
+func (speccy *Spectrum48k) insertCartridge(cartridge *formats.Cartridge) error {
+	if speccy.model.banked() {
+		return errors.New("ROM cartridges can only be used with the 48k")
+	}
+
+	if speccy.systemROM_orNil == nil {
+		systemROM := speccy.rom
+		speccy.systemROM_orNil = &systemROM
+
+		for i := range speccy.cartridgeJoysticks {
+			speccy.cartridgeJoysticks[i] = speccy.Input.JoystickInterface(i)
+		}
+		speccy.Input.SetJoystickInterface(0, JOYSTICK_SINCLAIR1)
+		speccy.Input.SetJoystickInterface(1, JOYSTICK_SINCLAIR2)
+	}
+
+	speccy.rom = cartridge.ROM
+	speccy.clearRewindBuffer()
+	speccy.reset(nil)
+	return nil
+}
+
+// Called in the emulation goroutine
+func (speccy *Spectrum48k) ejectCartridge() error {
+	if speccy.systemROM_orNil == nil {
+		return errors.New("no ROM cartridge is inserted")
+	}
+
+	speccy.removeCartridge()
+	speccy.clearRewindBuffer()
+	speccy.reset(nil)
+	return nil
+}
+
+// Restores the system ROM and the joystick interfaces, without resetting the machine
+func (speccy *Spectrum48k) removeCartridge() {
+	if speccy.systemROM_orNil == nil {
+		return
+	}
+
+	speccy.rom = *speccy.systemROM_orNil
+	speccy.systemROM_orNil = nil
+
+	for i, iface := range speccy.cartridgeJoysticks {
+		speccy.Input.SetJoystickInterface(i, iface)
+	}
+}
+
+// Ejects the ROM cartridge, restoring the system ROM, and resets the machine
+func (speccy *Spectrum48k) EjectCartridge() error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_EjectCartridge{errChan}
+	return <-errChan
+}
@@ -0,0 +1,70 @@
+package spectrum
+
+import (
+	"fmt"
+)
+
+// The SpecDrum and the Covox.
+//
+// Both devices are an 8-bit DAC driven by writing the samples to a port.
+// The SpecDrum uses port 0xDF, the Covox uses port 0xFB (Pentagon) or 0xDD (Scorpion).
+// Only the low byte of the port address is decoded, and the devices cannot be read.
+// The value 0x80 is silence.
+type DAC struct {
+	name   string
+	port   byte
+	speccy *Spectrum48k
+
+	output dacOutput
+}
+
+// The ports of the Covox, as used on the command-line
+var CovoxPortNames = map[string]byte{
+	"fb": 0xfb,
+	"dd": 0xdd,
+}
+
+// Creates a SpecDrum
+func NewSpecDrum() *DAC {
+	return &DAC{name: "specdrum", port: 0xdf}
+}
+
+// Creates a Covox connected to the specified port
+func NewCovox(port byte) *DAC {
+	return &DAC{name: "covox", port: port}
+}
+
+func (dac *DAC) Name() string {
+	return dac.name
+}
+
+func (dac *DAC) attach(speccy *Spectrum48k) error {
+	if (dac.port == 0xfb) && (speccy.findPeripheral("printer") != nil) {
+		return fmt.Errorf("the %s cannot use port 0xFB while the printer is connected", dac.name)
+	}
+
+	dac.speccy = speccy
+	dac.output = dacOutput{}
+	return nil
+}
+
+func (dac *DAC) detach() {
+}
+
+func (dac *DAC) reset() {
+	dac.output.set(dac.speccy.Cpu.Tstates, 0)
+}
+
+func (dac *DAC) readPort(address uint16) (byte, bool) {
+	return 0, false
+}
+
+func (dac *DAC) writePort(address uint16, b byte) {
+	if byte(address&0x00ff) == dac.port {
+		dac.output.set(dac.speccy.Cpu.Tstates, int16(int(b)-0x80)<<8)
+	}
+}
+
+func (dac *DAC) frameSound() []SampleEvent {
+	return dac.output.endFrame(dac.speccy.timing.TStatesPerFrame)
+}
@@ -0,0 +1,68 @@
+package spectrum
+
+import (
+	"errors"
+	"os"
+)
+
+// A raw disk image (an SD card or a hard disk), accessed in 512-byte sectors
+const DISK_SECTOR_SIZE = 512
+
+type diskImage struct {
+	file     *os.File
+	sectors  uint32
+	readOnly bool
+}
+
+// Opens the image for reading and writing, or read-only if it cannot be written
+func openDiskImage(path string) (*diskImage, error) {
+	readOnly := false
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsPermission(err) {
+		readOnly = true
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() < DISK_SECTOR_SIZE {
+		file.Close()
+		return nil, errors.New(path + ": the disk image is too small")
+	}
+
+	sectors := info.Size() / DISK_SECTOR_SIZE
+	if sectors > 0xffffffff {
+		sectors = 0xffffffff
+	}
+
+	return &diskImage{file: file, sectors: uint32(sectors), readOnly: readOnly}, nil
+}
+
+func (disk *diskImage) readSector(sector uint32, buf []byte) error {
+	if sector >= disk.sectors {
+		return errors.New("sector out of range")
+	}
+	_, err := disk.file.ReadAt(buf[0:DISK_SECTOR_SIZE], int64(sector)*DISK_SECTOR_SIZE)
+	return err
+}
+
+func (disk *diskImage) writeSector(sector uint32, buf []byte) error {
+	if sector >= disk.sectors {
+		return errors.New("sector out of range")
+	}
+	if disk.readOnly {
+		return errors.New("the disk image is read-only")
+	}
+	_, err := disk.file.WriteAt(buf[0:DISK_SECTOR_SIZE], int64(sector)*DISK_SECTOR_SIZE)
+	return err
+}
+
+func (disk *diskImage) close() error {
+	return disk.file.Close()
+}
@@ -51,7 +51,7 @@ const (
 	ATTR_BASE_ADDR   = 0x5800
 )
 
-// Spectrum 48k video timings
+// Video timings common to all models. The others are described by 'Timing'.
 const (
 	PIXELS_PER_TSTATE      = 2 // The number of screen pixels painted per T-state
 	PIXELS_PER_TSTATE_LOG2 = 1 // = Log2(PIXELS_PER_TSTATE)
@@ -59,23 +59,13 @@ const (
 	// Horizontal
 	LINE_SCREEN       = ScreenWidth / PIXELS_PER_TSTATE // 128 T states of screen
 	LINE_RIGHT_BORDER = 24                              // 24 T states of right border
-	LINE_RETRACE      = 48                              // 48 T states of horizontal retrace
 	LINE_LEFT_BORDER  = 24                              // 24 T states of left border
 
-	TSTATES_PER_LINE = (LINE_RIGHT_BORDER + LINE_SCREEN + LINE_LEFT_BORDER + LINE_RETRACE) // 224 T states
-
-	FIRST_SCREEN_BYTE = 14336 // T-state when the first byte of the screen (16384) is displayed
-
 	// Vertical
-	LINES_TOP     = 64
-	LINES_SCREEN  = ScreenHeight
-	LINES_BOTTOM  = 56
 	BORDER_TOP    = ScreenBorderY
 	BORDER_BOTTOM = ScreenBorderY
 
-	// The T-state which corresponds to pixel (0,0) on the host-machine display.
-	// That pixel belongs to the border.
-	DISPLAY_START            = (FIRST_SCREEN_BYTE - TSTATES_PER_LINE*BORDER_TOP - ScreenBorderX/PIXELS_PER_TSTATE + BORDER_TSTATE_ADJUSTMENT)
+	// The adjustment of 'Timing.DisplayStart'
 	BORDER_TSTATE_ADJUSTMENT = 2
 )
 
@@ -143,6 +133,9 @@ type DisplayData struct {
 
 	BorderEvents []BorderEvent
 
+	// The timing of the emulated model. The last border event is at 'Timing.TStatesPerFrame'.
+	Timing *Timing
+
 	// From structure Cmd_RenderFrame
 	CompletionTime_orNil chan<- time.Time
 }
@@ -155,22 +148,3 @@ type DisplayReceiver interface {
 	Close()
 }
 
-// Let 'addr' be in range 0x4000 ... 0x5800-1.
-// Then 'screenline_start_tstates[(addr-0x4000)/BytesPerLine]' is the T-state when the Spectrum
-// starts painting the screenline containing 'addr'.
-var screenline_start_tstates [ScreenHeight]int
-
-func init() {
-	for y := uint8(0); y < ScreenHeight; y++ {
-		addr := xy_to_screenAddr(0, y)
-		screenline_start_tstates[(addr-SCREEN_BASE_ADDR)/BytesPerLine] = FIRST_SCREEN_BYTE + int(y)*TSTATES_PER_LINE
-	}
-}
-
-func init() {
-	// Some sanity checks
-	Assert(ScreenBorderX <= LINE_RIGHT_BORDER*PIXELS_PER_TSTATE)
-	Assert(ScreenBorderY <= LINE_RIGHT_BORDER*PIXELS_PER_TSTATE)
-	Assert(ScreenBorderY <= LINES_TOP)
-	Assert(ScreenBorderY <= LINES_BOTTOM)
-}
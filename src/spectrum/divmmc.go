@@ -0,0 +1,288 @@
+package spectrum
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// The DivMMC and DivIDE interfaces.
+//
+// Both interfaces have an 8K EEPROM (usually holding esxDOS) and 8K RAM banks,
+// which are paged over the system ROM either explicitly (CONMEM bit of port 0xE3)
+// or automatically when the Z80 fetches an opcode from one of the entry points
+// of the system ROM ("automapping"):
+//
+//   - The opcode fetched from 0x0000, 0x0008, 0x0038, 0x0066, 0x04C6 or 0x0562 comes from
+//     the system ROM, the memory is paged in after the fetch.
+//   - The opcode fetched from 0x3D00-0x3DFF already comes from the EEPROM.
+//   - The memory is paged out after fetching an opcode from 0x1FF8-0x1FFF.
+//
+// Port 0xE3 (write-only): bit 7 = CONMEM, bit 6 = MAPRAM, bits 0-5 = RAM bank at 0x2000-0x3FFF.
+// When MAPRAM is set, RAM bank 3 replaces the EEPROM (read-only), and MAPRAM can be reset only
+// by turning off the machine.
+//
+// The DivMMC accesses an SD card through SPI: port 0xE7 is the card select (active low),
+// port 0xEB is the SPI data. The DivIDE accesses a hard disk through the ATA registers
+// at ports 0xA3, 0xA7, ..., 0xBF.
+const (
+	DIV_ROM_SIZE  = 0x2000
+	DIV_BANK_SIZE = 0x2000
+
+	DIVMMC_NUM_BANKS = 16 // 128K
+	DIVIDE_NUM_BANKS = 4  // 32K
+
+	DIV_CONMEM = 0x80
+	DIV_MAPRAM = 0x40
+)
+
+var divAutomapAddresses = []uint16{0x0000, 0x0008, 0x0038, 0x0066, 0x04c6, 0x0562}
+
+type DivMMC struct {
+	name string
+	rom  []byte
+	ram  [][]byte
+
+	speccy *Spectrum48k
+
+	control byte
+	automap bool
+
+	// DivMMC
+	card_orNil *sdCard
+	spiData    byte
+	cardSelect bool
+
+	// DivIDE
+	drive_orNil *ataDrive
+
+	disk *diskImage
+}
+
+// Reads the 8K EEPROM contents (such as esxDOS) from the specified file
+func ReadDivROM(path string) ([]byte, error) {
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rom) != DIV_ROM_SIZE {
+		return nil, errors.New(path + ": invalid DivMMC/DivIDE ROM file")
+	}
+	return rom, nil
+}
+
+func newDiv(name string, rom []byte, numBanks int, imagePath string) (*DivMMC, error) {
+	if len(rom) != DIV_ROM_SIZE {
+		return nil, errors.New("invalid DivMMC/DivIDE ROM size")
+	}
+
+	div := &DivMMC{name: name, rom: rom}
+	div.ram = make([][]byte, numBanks)
+	for i := range div.ram {
+		div.ram[i] = make([]byte, DIV_BANK_SIZE)
+	}
+
+	if imagePath != "" {
+		disk, err := openDiskImage(imagePath)
+		if err != nil {
+			return nil, err
+		}
+		div.disk = disk
+	}
+
+	return div, nil
+}
+
+// Creates a DivMMC with the specified EEPROM contents,
+// and an SD card backed by the raw disk image (if the path is not empty)
+func NewDivMMC(rom []byte, imagePath string) (*DivMMC, error) {
+	div, err := newDiv("divmmc", rom, DIVMMC_NUM_BANKS, imagePath)
+	if err != nil {
+		return nil, err
+	}
+	if div.disk != nil {
+		div.card_orNil = newSDCard(div.disk)
+	}
+	return div, nil
+}
+
+// Creates a DivIDE with the specified EEPROM contents,
+// and a hard disk backed by the raw disk image (if the path is not empty)
+func NewDivIDE(rom []byte, imagePath string) (*DivMMC, error) {
+	div, err := newDiv("divide", rom, DIVIDE_NUM_BANKS, imagePath)
+	if err != nil {
+		return nil, err
+	}
+	if div.disk != nil {
+		div.drive_orNil = newATADrive(div.disk)
+	}
+	return div, nil
+}
+
+func (div *DivMMC) Name() string {
+	return div.name
+}
+
+func (div *DivMMC) attach(speccy *Spectrum48k) error {
+	for _, name := range []string{"divmmc", "divide", "esxdos", "beta128", "multiface", "if1"} {
+		if speccy.findPeripheral(name) != nil {
+			return fmt.Errorf("%s is already connected", name)
+		}
+	}
+
+	div.speccy = speccy
+
+	for _, address := range divAutomapAddresses {
+		speccy.addFetchTrap(div, address, nil, div.mapIn)
+	}
+	for address := uint16(0x3d00); address <= 0x3dff; address++ {
+		speccy.addFetchTrap(div, address, div.mapIn, nil)
+	}
+	for address := uint16(0x1ff8); address <= 0x1fff; address++ {
+		speccy.addFetchTrap(div, address, nil, div.mapOut)
+	}
+
+	return nil
+}
+
+func (div *DivMMC) detach() {
+	div.speccy.Memory.unpageROM()
+
+	if div.disk != nil {
+		div.disk.close()
+	}
+}
+
+func (div *DivMMC) reset() {
+	// MAPRAM survives a reset
+	div.control &= DIV_MAPRAM
+	div.automap = false
+	div.updatePaging()
+
+	if div.card_orNil != nil {
+		div.card_orNil.reset()
+	}
+	if div.drive_orNil != nil {
+		div.drive_orNil.reset()
+	}
+	div.cardSelect = false
+	div.spiData = 0xff
+}
+
+func (div *DivMMC) mapIn(pc uint16) {
+	if !div.automap {
+		div.automap = true
+		div.updatePaging()
+	}
+}
+
+func (div *DivMMC) mapOut(pc uint16) {
+	if div.automap {
+		div.automap = false
+		div.updatePaging()
+	}
+}
+
+func (div *DivMMC) updatePaging() {
+	memory := div.speccy.Memory
+
+	bank := int(div.control&0x3f) % len(div.ram)
+	ram := div.ram[bank]
+
+	switch {
+	case (div.control & DIV_CONMEM) != 0:
+		memory.pageROM(div.rom, ram, false, true)
+
+	case div.automap && ((div.control & DIV_MAPRAM) != 0):
+		memory.pageROM(div.ram[3], ram, false, (bank != 3))
+
+	case div.automap:
+		memory.pageROM(div.rom, ram, false, true)
+
+	default:
+		memory.unpageROM()
+	}
+}
+
+type divMMC_state struct {
+	control byte
+	automap bool
+}
+
+func (div *DivMMC) saveState() interface{} {
+	return divMMC_state{div.control, div.automap}
+}
+
+func (div *DivMMC) restoreState(state interface{}) {
+	s := state.(divMMC_state)
+	div.control = s.control
+	div.automap = s.automap
+	div.updatePaging()
+}
+
+func (div *DivMMC) rewindRAM() [][]byte {
+	return div.ram
+}
+
+func (div *DivMMC) encodeState(state interface{}) []byte {
+	s := state.(divMMC_state)
+	return append([]byte{s.control}, encodeFlags(s.automap)...)
+}
+
+func (div *DivMMC) decodeState(data []byte) (interface{}, error) {
+	if len(data) < 1 {
+		return nil, errors.New("invalid DivMMC state")
+	}
+	flags, err := decodeFlags(data[1:], 1)
+	if err != nil {
+		return nil, err
+	}
+	return divMMC_state{data[0], flags[0]}, nil
+}
+
+func (div *DivMMC) readPort(address uint16) (byte, bool) {
+	port := address & 0x00ff
+
+	if div.drive_orNil != nil {
+		if (port & 0xe3) == 0xa3 {
+			return div.drive_orNil.read(int(port>>2) & 0x07), true
+		}
+	}
+
+	if (div.card_orNil != nil) && (port == 0xeb) {
+		// Reading the port also clocks out 0xFF
+		b := div.spiData
+		div.spiData = div.spi(0xff)
+		return b, true
+	}
+
+	return 0, false
+}
+
+func (div *DivMMC) writePort(address uint16, b byte) {
+	port := address & 0x00ff
+
+	switch {
+	case port == 0xe3:
+		// MAPRAM cannot be reset
+		div.control = b | (div.control & DIV_MAPRAM)
+		div.updatePaging()
+
+	case (div.drive_orNil != nil) && ((port & 0xe3) == 0xa3):
+		div.drive_orNil.write(int(port>>2)&0x07, b)
+
+	case (div.card_orNil != nil) && (port == 0xe7):
+		div.cardSelect = ((b & 0x01) == 0)
+
+	case (div.card_orNil != nil) && (port == 0xeb):
+		div.spiData = div.spi(b)
+	}
+}
+
+// Exchanges a byte with the selected SD card
+func (div *DivMMC) spi(b byte) byte {
+	if !div.cardSelect {
+		return 0xff
+	}
+	return div.card_orNil.exchange(b)
+}
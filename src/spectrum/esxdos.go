@@ -0,0 +1,673 @@
+package spectrum
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	pathutil "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Serves the esxDOS API from a directory of the host.
+//
+// Programs call esxDOS with "RST 8" followed by a byte identifying the function.
+// When the Z80 is about to execute the restart with a function code in the esxDOS range,
+// the call is handled here and the Z80 continues after the function code, as it would
+// after returning from esxDOS. Other restarts (such as the error restart of the system ROM)
+// run normally.
+//
+// The registers follow the esxDOS conventions: IX points to the file name or buffer,
+// A holds the file handle, BC the length, and the carry flag is set on error
+// with the error code in A.
+const (
+	ESXDOS_MAX_HANDLES = 16
+
+	// Functions
+	ESXDOS_M_DOSVERSION = 0x88
+	ESXDOS_M_GETSETDRV  = 0x89
+	ESXDOS_M_GETDATE    = 0x8e
+	ESXDOS_F_OPEN       = 0x9a
+	ESXDOS_F_CLOSE      = 0x9b
+	ESXDOS_F_SYNC       = 0x9c
+	ESXDOS_F_READ       = 0x9d
+	ESXDOS_F_WRITE      = 0x9e
+	ESXDOS_F_SEEK       = 0x9f
+	ESXDOS_F_FGETPOS    = 0xa0
+	ESXDOS_F_FSTAT      = 0xa1
+	ESXDOS_F_FTRUNCATE  = 0xa2
+	ESXDOS_F_OPENDIR    = 0xa3
+	ESXDOS_F_READDIR    = 0xa4
+	ESXDOS_F_TELLDIR    = 0xa5
+	ESXDOS_F_SEEKDIR    = 0xa6
+	ESXDOS_F_REWINDDIR  = 0xa7
+	ESXDOS_F_GETCWD     = 0xa8
+	ESXDOS_F_CHDIR      = 0xa9
+	ESXDOS_F_MKDIR      = 0xaa
+	ESXDOS_F_RMDIR      = 0xab
+	ESXDOS_F_STAT       = 0xac
+	ESXDOS_F_UNLINK     = 0xad
+	ESXDOS_F_TRUNCATE   = 0xae
+	ESXDOS_F_RENAME     = 0xb0
+
+	// Error codes
+	ESXDOS_ENONSENSE    = 2
+	ESXDOS_ENOENT       = 5
+	ESXDOS_EIO          = 6
+	ESXDOS_EINVAL       = 7
+	ESXDOS_EACCES       = 8
+	ESXDOS_ENOSPC       = 9
+	ESXDOS_ENFILE       = 12
+	ESXDOS_EBADF        = 13
+	ESXDOS_EISDIR       = 16
+	ESXDOS_ENOTDIR      = 17
+	ESXDOS_EEXIST       = 18
+	ESXDOS_ENAMETOOLONG = 21
+	ESXDOS_ENOCMD       = 22
+
+	// F_OPEN modes
+	ESXDOS_FA_READ       = 0x01
+	ESXDOS_FA_WRITE      = 0x02
+	ESXDOS_FA_OPEN_EX    = 0x00
+	ESXDOS_FA_CREATE_NEW = 0x04
+	ESXDOS_FA_OPEN_AL    = 0x08
+	ESXDOS_FA_CREATE_AL  = 0x0c
+
+	// Attributes
+	ESXDOS_ATTR_READONLY = 0x01
+	ESXDOS_ATTR_DIR      = 0x10
+	ESXDOS_ATTR_ARCHIVE  = 0x20
+
+	esxdos_drive = 'C'
+)
+
+// An open file or directory
+type esxdosHandle struct {
+	file_orNil *os.File
+
+	// Directory
+	entries []os.FileInfo
+	pos     int
+}
+
+type EsxDOS struct {
+	root string
+
+	// The current directory, relative to the root, using '/' as the separator
+	cwd string
+
+	speccy  *Spectrum48k
+	handles [ESXDOS_MAX_HANDLES]*esxdosHandle
+}
+
+// Returns an esxDOS whose root directory is the specified directory of the host
+func NewEsxDOS(root string) (*EsxDOS, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(root + " is not a directory")
+	}
+
+	return &EsxDOS{root: root, cwd: "/"}, nil
+}
+
+// Returns the host directory serving as the root directory
+func (esx *EsxDOS) Root() string {
+	return esx.root
+}
+
+func (esx *EsxDOS) Name() string {
+	return "esxdos"
+}
+
+func (esx *EsxDOS) attach(speccy *Spectrum48k) error {
+	for _, name := range []string{"divmmc", "divide", "if1"} {
+		if speccy.findPeripheral(name) != nil {
+			return errors.New("esxDOS cannot be served from a host directory while the " + name + " is connected")
+		}
+	}
+
+	esx.speccy = speccy
+	speccy.addFetchTrap(esx, 0x0008, esx.rst8, nil)
+	return nil
+}
+
+func (esx *EsxDOS) detach() {
+	esx.closeAll()
+}
+
+func (esx *EsxDOS) reset() {
+	esx.closeAll()
+	esx.cwd = "/"
+}
+
+func (esx *EsxDOS) readPort(address uint16) (byte, bool) {
+	return 0, false
+}
+
+func (esx *EsxDOS) writePort(address uint16, b byte) {
+}
+
+func (esx *EsxDOS) closeAll() {
+	for i, h := range esx.handles {
+		if (h != nil) && (h.file_orNil != nil) {
+			h.file_orNil.Close()
+		}
+		esx.handles[i] = nil
+	}
+}
+
+// Called when the Z80 is about to fetch the opcode at 0x0008
+func (esx *EsxDOS) rst8(pc uint16) {
+	if esx.speccy.Memory.romPaged || !esx.speccy.Memory.basicROMPaged() {
+		// The restart belongs to a paged ROM, or to a ROM of the 128K models other than 48 BASIC
+		return
+	}
+
+	cpu := esx.speccy.Cpu
+	memory := esx.speccy.Memory
+
+	sp := cpu.SP()
+	ret := uint16(memory.Read(sp)) | uint16(memory.Read(sp+1))<<8
+	function := memory.Read(ret)
+
+	if function < 0x80 {
+		// A ROM error
+		return
+	}
+
+	err := esx.call(function)
+	if err != 0 {
+		cpu.A = err
+		cpu.F |= 0x01
+	} else {
+		cpu.F &^= 0x01
+	}
+
+	// Return to the instruction following the function code
+	cpu.SetSP(sp + 2)
+	cpu.SetPC(ret + 1)
+}
+
+// Performs the function. Returns 0 on success, or the esxDOS error code.
+func (esx *EsxDOS) call(function byte) byte {
+	cpu := esx.speccy.Cpu
+
+	ix := uint16(cpu.IXH)<<8 | uint16(cpu.IXL)
+	bc := uint16(cpu.B)<<8 | uint16(cpu.C)
+	de := uint16(cpu.D)<<8 | uint16(cpu.E)
+
+	switch function {
+	case ESXDOS_M_DOSVERSION:
+		cpu.B, cpu.C = 0x00, 0x85
+		return 0
+
+	case ESXDOS_M_GETSETDRV:
+		cpu.A = esxdos_drive
+		return 0
+
+	case ESXDOS_M_GETDATE:
+		date, tm := dosTime(time.Now())
+		cpu.B, cpu.C = byte(date>>8), byte(date)
+		cpu.D, cpu.E = byte(tm>>8), byte(tm)
+		return 0
+
+	case ESXDOS_F_OPEN:
+		return esx.open(esx.readString(ix), cpu.B)
+
+	case ESXDOS_F_CLOSE:
+		h, err := esx.handle(cpu.A)
+		if err != 0 {
+			return err
+		}
+		if h.file_orNil != nil {
+			h.file_orNil.Close()
+		}
+		esx.handles[cpu.A] = nil
+		return 0
+
+	case ESXDOS_F_SYNC:
+		f, err := esx.file(cpu.A)
+		if err != 0 {
+			return err
+		}
+		return esxdosError(f.Sync())
+
+	case ESXDOS_F_READ:
+		f, err := esx.file(cpu.A)
+		if err != 0 {
+			return err
+		}
+		buf := make([]byte, bc)
+		n, e := io.ReadFull(f, buf)
+		if (e != nil) && (e != io.EOF) && (e != io.ErrUnexpectedEOF) {
+			return esxdosError(e)
+		}
+		esx.writeMemory(ix, buf[0:n])
+		cpu.B, cpu.C = byte(n>>8), byte(n)
+		end := ix + uint16(n)
+		cpu.H, cpu.L = byte(end>>8), byte(end)
+		return 0
+
+	case ESXDOS_F_WRITE:
+		f, err := esx.file(cpu.A)
+		if err != 0 {
+			return err
+		}
+		n, e := f.Write(esx.readMemory(ix, int(bc)))
+		cpu.B, cpu.C = byte(n>>8), byte(n)
+		return esxdosError(e)
+
+	case ESXDOS_F_SEEK:
+		f, err := esx.file(cpu.A)
+		if err != 0 {
+			return err
+		}
+		offset := int64(bc)<<16 | int64(de)
+		var pos int64
+		var e error
+		switch cpu.L {
+		case 0:
+			pos, e = f.Seek(offset, 0)
+		case 1:
+			pos, e = f.Seek(offset, 1)
+		case 2:
+			pos, e = f.Seek(-offset, 1)
+		default:
+			return ESXDOS_EINVAL
+		}
+		if e != nil {
+			return esxdosError(e)
+		}
+		esx.setBCDE(uint32(pos))
+		return 0
+
+	case ESXDOS_F_FGETPOS:
+		f, err := esx.file(cpu.A)
+		if err != 0 {
+			return err
+		}
+		pos, e := f.Seek(0, 1)
+		if e != nil {
+			return esxdosError(e)
+		}
+		esx.setBCDE(uint32(pos))
+		return 0
+
+	case ESXDOS_F_FSTAT:
+		f, err := esx.file(cpu.A)
+		if err != 0 {
+			return err
+		}
+		info, e := f.Stat()
+		if e != nil {
+			return esxdosError(e)
+		}
+		esx.writeStat(ix, info)
+		return 0
+
+	case ESXDOS_F_FTRUNCATE:
+		f, err := esx.file(cpu.A)
+		if err != 0 {
+			return err
+		}
+		return esxdosError(f.Truncate(int64(bc)<<16 | int64(de)))
+
+	case ESXDOS_F_OPENDIR:
+		return esx.openDir(esx.readString(ix))
+
+	case ESXDOS_F_READDIR:
+		h, err := esx.dir(cpu.A)
+		if err != 0 {
+			return err
+		}
+		if h.pos >= len(h.entries) {
+			cpu.A = 0
+			return 0
+		}
+		esx.writeDirEntry(ix, h.entries[h.pos])
+		h.pos++
+		cpu.A = 1
+		return 0
+
+	case ESXDOS_F_TELLDIR:
+		h, err := esx.dir(cpu.A)
+		if err != 0 {
+			return err
+		}
+		esx.setBCDE(uint32(h.pos))
+		return 0
+
+	case ESXDOS_F_SEEKDIR:
+		h, err := esx.dir(cpu.A)
+		if err != 0 {
+			return err
+		}
+		pos := int(bc)<<16 | int(de)
+		if pos > len(h.entries) {
+			return ESXDOS_EINVAL
+		}
+		h.pos = pos
+		return 0
+
+	case ESXDOS_F_REWINDDIR:
+		h, err := esx.dir(cpu.A)
+		if err != 0 {
+			return err
+		}
+		h.pos = 0
+		return 0
+
+	case ESXDOS_F_GETCWD:
+		esx.writeMemory(ix, append([]byte(esx.cwd), 0))
+		return 0
+
+	case ESXDOS_F_CHDIR:
+		dir := esx.resolve(esx.readString(ix))
+		info, e := os.Stat(esx.hostPath(dir))
+		if e != nil {
+			return esxdosError(e)
+		}
+		if !info.IsDir() {
+			return ESXDOS_ENOTDIR
+		}
+		esx.cwd = dir
+		return 0
+
+	case ESXDOS_F_MKDIR:
+		return esxdosError(os.Mkdir(esx.hostPath(esx.resolve(esx.readString(ix))), 0755))
+
+	case ESXDOS_F_RMDIR:
+		path := esx.hostPath(esx.resolve(esx.readString(ix)))
+		info, e := os.Stat(path)
+		if e != nil {
+			return esxdosError(e)
+		}
+		if !info.IsDir() {
+			return ESXDOS_ENOTDIR
+		}
+		return esxdosError(os.Remove(path))
+
+	case ESXDOS_F_STAT:
+		info, e := os.Stat(esx.hostPath(esx.resolve(esx.readString(ix))))
+		if e != nil {
+			return esxdosError(e)
+		}
+		esx.writeStat(de, info)
+		return 0
+
+	case ESXDOS_F_UNLINK:
+		path := esx.hostPath(esx.resolve(esx.readString(ix)))
+		info, e := os.Stat(path)
+		if e != nil {
+			return esxdosError(e)
+		}
+		if info.IsDir() {
+			return ESXDOS_EISDIR
+		}
+		return esxdosError(os.Remove(path))
+
+	case ESXDOS_F_TRUNCATE:
+		return esxdosError(os.Truncate(esx.hostPath(esx.resolve(esx.readString(ix))), int64(bc)<<16|int64(de)))
+
+	case ESXDOS_F_RENAME:
+		oldPath := esx.hostPath(esx.resolve(esx.readString(ix)))
+		newPath := esx.hostPath(esx.resolve(esx.readString(de)))
+		return esxdosError(os.Rename(oldPath, newPath))
+	}
+
+	return ESXDOS_ENOCMD
+}
+
+func (esx *EsxDOS) open(name string, mode byte) byte {
+	var flags int
+	switch mode & (ESXDOS_FA_READ | ESXDOS_FA_WRITE) {
+	case ESXDOS_FA_READ:
+		flags = os.O_RDONLY
+	case ESXDOS_FA_WRITE:
+		flags = os.O_WRONLY
+	case ESXDOS_FA_READ | ESXDOS_FA_WRITE:
+		flags = os.O_RDWR
+	default:
+		return ESXDOS_EINVAL
+	}
+
+	switch mode & ESXDOS_FA_CREATE_AL {
+	case ESXDOS_FA_CREATE_NEW:
+		flags |= os.O_CREATE | os.O_EXCL
+	case ESXDOS_FA_OPEN_AL:
+		flags |= os.O_CREATE
+	case ESXDOS_FA_CREATE_AL:
+		flags |= os.O_CREATE | os.O_TRUNC
+	}
+
+	handle, err := esx.freeHandle()
+	if err != 0 {
+		return err
+	}
+
+	path := esx.hostPath(esx.resolve(name))
+	if info, e := os.Stat(path); (e == nil) && info.IsDir() {
+		return ESXDOS_EISDIR
+	}
+
+	f, e := os.OpenFile(path, flags, 0644)
+	if e != nil {
+		return esxdosError(e)
+	}
+
+	esx.handles[handle] = &esxdosHandle{file_orNil: f}
+	esx.speccy.Cpu.A = handle
+	return 0
+}
+
+func (esx *EsxDOS) openDir(name string) byte {
+	handle, err := esx.freeHandle()
+	if err != 0 {
+		return err
+	}
+
+	entries, e := ioutil.ReadDir(esx.hostPath(esx.resolve(name)))
+	if e != nil {
+		return esxdosError(e)
+	}
+	sort.Sort(esxdosEntries(entries))
+
+	esx.handles[handle] = &esxdosHandle{entries: entries}
+	esx.speccy.Cpu.A = handle
+	return 0
+}
+
+// Returns an unused handle. Handle 0 is never used, some programs treat it as "no file".
+func (esx *EsxDOS) freeHandle() (byte, byte) {
+	for i := 1; i < len(esx.handles); i++ {
+		if esx.handles[i] == nil {
+			return byte(i), 0
+		}
+	}
+	return 0, ESXDOS_ENFILE
+}
+
+func (esx *EsxDOS) handle(handle byte) (*esxdosHandle, byte) {
+	if (int(handle) >= len(esx.handles)) || (esx.handles[handle] == nil) {
+		return nil, ESXDOS_EBADF
+	}
+	return esx.handles[handle], 0
+}
+
+func (esx *EsxDOS) file(handle byte) (*os.File, byte) {
+	h, err := esx.handle(handle)
+	if err != 0 {
+		return nil, err
+	}
+	if h.file_orNil == nil {
+		return nil, ESXDOS_EISDIR
+	}
+	return h.file_orNil, 0
+}
+
+func (esx *EsxDOS) dir(handle byte) (*esxdosHandle, byte) {
+	h, err := esx.handle(handle)
+	if err != 0 {
+		return nil, err
+	}
+	if h.file_orNil != nil {
+		return nil, ESXDOS_ENOTDIR
+	}
+	return h, 0
+}
+
+// Returns the absolute path (relative to the root) of the esxDOS path
+func (esx *EsxDOS) resolve(name string) string {
+	name = strings.Replace(name, "\\", "/", -1)
+
+	// Drive specifiers ("C:", "$:", "*:") are ignored, there is only one drive
+	if (len(name) >= 2) && (name[1] == ':') {
+		name = name[2:]
+	}
+
+	if !strings.HasPrefix(name, "/") {
+		name = pathutil.Join(esx.cwd, name)
+	}
+	return pathutil.Clean("/" + name)
+}
+
+// Returns the path on the host. Each component is matched case-insensitively
+// if the exact name does not exist, as the names on a FAT filesystem.
+func (esx *EsxDOS) hostPath(path string) string {
+	hostPath := esx.root
+	for _, component := range strings.Split(path, "/") {
+		if component == "" {
+			continue
+		}
+
+		next := filepath.Join(hostPath, component)
+		if _, err := os.Lstat(next); os.IsNotExist(err) {
+			if entries, err := ioutil.ReadDir(hostPath); err == nil {
+				for _, entry := range entries {
+					if strings.EqualFold(entry.Name(), component) {
+						next = filepath.Join(hostPath, entry.Name())
+						break
+					}
+				}
+			}
+		}
+		hostPath = next
+	}
+	return hostPath
+}
+
+func (esx *EsxDOS) setBCDE(value uint32) {
+	cpu := esx.speccy.Cpu
+	cpu.B, cpu.C = byte(value>>24), byte(value>>16)
+	cpu.D, cpu.E = byte(value>>8), byte(value)
+}
+
+// Reads a zero-terminated string
+func (esx *EsxDOS) readString(address uint16) string {
+	var s []byte
+	for i := 0; i < 256; i++ {
+		b := esx.speccy.Memory.Read(address + uint16(i))
+		if b == 0 {
+			break
+		}
+		s = append(s, b)
+	}
+	return string(s)
+}
+
+func (esx *EsxDOS) readMemory(address uint16, length int) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = esx.speccy.Memory.Read(address + uint16(i))
+	}
+	return data
+}
+
+func (esx *EsxDOS) writeMemory(address uint16, data []byte) {
+	for i, b := range data {
+		esx.speccy.Memory.WriteByteInternal(address+uint16(i), b)
+	}
+}
+
+func esxdosAttributes(info os.FileInfo) byte {
+	var attr byte = ESXDOS_ATTR_ARCHIVE
+	if info.IsDir() {
+		attr = ESXDOS_ATTR_DIR
+	}
+	if (info.Mode() & 0200) == 0 {
+		attr |= ESXDOS_ATTR_READONLY
+	}
+	return attr
+}
+
+func esxdosDateSize(info os.FileInfo) []byte {
+	date, tm := dosTime(info.ModTime())
+	size := uint32(info.Size())
+	return []byte{
+		byte(tm), byte(tm >> 8), byte(date), byte(date >> 8),
+		byte(size), byte(size >> 8), byte(size >> 16), byte(size >> 24),
+	}
+}
+
+// Writes the 11-byte status: drive, device, attributes, date and size
+func (esx *EsxDOS) writeStat(address uint16, info os.FileInfo) {
+	data := []byte{esxdos_drive, 0, esxdosAttributes(info)}
+	esx.writeMemory(address, append(data, esxdosDateSize(info)...))
+}
+
+// Writes the directory entry: attributes, zero-terminated name, date and size
+func (esx *EsxDOS) writeDirEntry(address uint16, info os.FileInfo) {
+	data := []byte{esxdosAttributes(info)}
+	data = append(data, info.Name()...)
+	data = append(data, 0)
+	esx.writeMemory(address, append(data, esxdosDateSize(info)...))
+}
+
+// Returns the date and time in the MS-DOS format
+func dosTime(t time.Time) (date, tm uint16) {
+	year := t.Year() - 1980
+	if year < 0 {
+		year = 0
+	}
+	date = uint16(year<<9 | int(t.Month())<<5 | t.Day())
+	tm = uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()/2)
+	return
+}
+
+// Converts the host error to an esxDOS error code
+func esxdosError(err error) byte {
+	switch {
+	case err == nil:
+		return 0
+	case os.IsNotExist(err):
+		return ESXDOS_ENOENT
+	case os.IsExist(err):
+		return ESXDOS_EEXIST
+	case os.IsPermission(err):
+		return ESXDOS_EACCES
+	}
+	return ESXDOS_EIO
+}
+
+// Directory entries sorted by name, directories first
+type esxdosEntries []os.FileInfo
+
+func (e esxdosEntries) Len() int      { return len(e) }
+func (e esxdosEntries) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e esxdosEntries) Less(i, j int) bool {
+	if e[i].IsDir() != e[j].IsDir() {
+		return e[i].IsDir()
+	}
+	return strings.ToLower(e[i].Name()) < strings.ToLower(e[j].Name())
+}
@@ -0,0 +1,53 @@
+package spectrum
+
+import (
+	"testing"
+)
+
+func TestEsxDOSTrapRequiresBasicROM(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	speccy := NewSpectrum48k(app, *rom)
+	roms := make([][]byte, MODEL_PLUS3.NumROMs())
+	for i := range roms {
+		roms[i] = make([]byte, 0x4000)
+	}
+	speccy.Memory.setModel(MODEL_PLUS3, roms)
+
+	esx, err := NewEsxDOS("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := esx.attach(speccy); err != nil {
+		t.Fatal(err)
+	}
+
+	// RST 8 followed by M_DOSVERSION
+	cpu := speccy.Cpu
+	speccy.Memory.Write(0x8000, 0x00, false)
+	speccy.Memory.Write(0x8001, 0x90, false)
+	speccy.Memory.Write(0x9000, ESXDOS_M_DOSVERSION, false)
+
+	for rom := 0; rom < 4; rom++ {
+		speccy.Memory.write7ffd(byte(rom&1) << 4)
+		speccy.Memory.write1ffd(byte(rom&2) << 1)
+
+		cpu.SetSP(0x8000)
+		cpu.SetPC(0x0008)
+		esx.rst8(0x0008)
+
+		trapped := (cpu.PC() == 0x9001)
+		if trapped != (rom == 3) {
+			t.Errorf("ROM %d: trapped=%v, the call has to be served only in 48 BASIC", rom, trapped)
+		}
+	}
+}
@@ -55,6 +55,8 @@ func init() {
 
 var customSearchPaths []string
 var downloadPath string
+var quickSavePath string
+var autosavePath string
 var mutex sync.RWMutex
 
 func AddCustomSearchPath(path string) {
@@ -80,6 +82,42 @@ func SetDownloadPath(path string) {
 	mutex.Unlock()
 }
 
+// The directory of the quick-save slots
+func QuickSavePath() string {
+	mutex.RLock()
+	p := quickSavePath
+	mutex.RUnlock()
+
+	if p == "" {
+		p = path.Join(DefaultUserDir, "quicksave")
+	}
+	return p
+}
+
+func SetQuickSavePath(path string) {
+	mutex.Lock()
+	quickSavePath = path
+	mutex.Unlock()
+}
+
+// The directory of the session saved when the emulator exits
+func AutosavePath() string {
+	mutex.RLock()
+	p := autosavePath
+	mutex.RUnlock()
+
+	if p == "" {
+		p = path.Join(DefaultUserDir, "autosave")
+	}
+	return p
+}
+
+func SetAutosavePath(path string) {
+	mutex.Lock()
+	autosavePath = path
+	mutex.Unlock()
+}
+
 func searchForValidPath(paths []string, fileName string) (string, error) {
 	for _, dir := range paths {
 		if _, err := os.Lstat(dir); err == nil {
@@ -201,6 +239,30 @@ func FontPath(fileName string) (string, error) {
 	return searchForValidPath(paths, fileName)
 }
 
+// Return a valid path for the specified palette file,
+// or the original filename if the search did not find anything.
+//
+// An error is returned if the search could not proceed.
+//
+// The search is performed in this order:
+// 1. ./palettes/
+// 2. $HOME/.config/gospeccy/palettes/
+// 3. $GOPATH/src/github.com/remogatto/gospeccy/palettes/
+// 4. Custom search paths
+func PalettePath(fileName string) (string, error) {
+	var (
+		currDir = "palettes"
+		userDir = path.Join(DefaultUserDir, "palettes")
+		srcDir  = path.Join(srcDir, "palettes")
+	)
+
+	var paths []string
+	paths = append(paths, currDir, userDir, srcDir)
+	appendCustomSearchPaths(&paths)
+
+	return searchForValidPath(paths, fileName)
+}
+
 // Reads the 16KB ROM from the specified file
 func ReadROM(path string) (*[0x4000]byte, error) {
 	fileData, err := ioutil.ReadFile(path)
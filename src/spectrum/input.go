@@ -0,0 +1,688 @@
+package spectrum
+
+// Mapping of host input devices to the Spectrum's keyboard and joysticks.
+//
+// A profile binds host inputs to actions. Host inputs are written as:
+//
+//   key:<name>              A host key, named as in 'SDL_KeyMap' (key:a, key:left shift, key:return)
+//   joy<N>:button<B>        Button B of gamepad N (joy0:button1)
+//   joy<N>:axis<A>-         Axis A of gamepad N, negative direction (joy0:axis0- is usually left)
+//   joy<N>:axis<A>+         Axis A of gamepad N, positive direction
+//   joy<N>:hat<H>:<dir>     Hat H of gamepad N, dir is up, down, left or right
+//
+// The gamepad number can be omitted ("joy:button0") to bind all gamepads.
+//
+// Actions are Spectrum keys, or joystick directions:
+//
+//   q, 5, enter, space, caps, symbol   A Spectrum key
+//   caps+5                             Keys pressed simultaneously
+//   kempston:fire, kempston:up, ...    A joystick direction (see 'JoystickInterfaceNames')
+//   joystick:fire, joystick:up, ...    A direction of the interface selected for the gamepad
+//                                      (see 'SetJoystickInterface'), gamepad 0 for host keys
+//
+// Profiles are stored in a text file:
+//
+//   # Comment
+//   [manic]
+//   games = manic*.tap, manicminer.z80
+//   key:q = o
+//   joy0:button1 = space
+//
+// Each binding lists one or more actions separated by spaces.
+// Inputs not bound by the active profile use the "default" profile,
+// and host keys not bound by any profile use 'SDL_KeyMap'.
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_INPUT_PROFILE = "default"
+
+// Axis values within this distance from the center are ignored
+const JOYSTICK_AXIS_DEADZONE = 8000
+
+// Hat values, as reported by SDL
+const (
+	HAT_UP    = 0x01
+	HAT_RIGHT = 0x02
+	HAT_DOWN  = 0x04
+	HAT_LEFT  = 0x08
+)
+
+var hatDirections = []struct {
+	mask byte
+	name string
+}{
+	{HAT_UP, "up"},
+	{HAT_RIGHT, "right"},
+	{HAT_DOWN, "down"},
+	{HAT_LEFT, "left"},
+}
+
+// The names of the Spectrum keys, in the order of the logical key codes
+var spectrumKeyNames = []string{
+	"1", "2", "3", "4", "5", "6", "7", "8", "9", "0",
+	"q", "w", "e", "r", "t", "y", "u", "i", "o", "p",
+	"a", "s", "d", "f", "g", "h", "j", "k", "l", "enter",
+	"caps", "z", "x", "c", "v", "b", "n", "m", "symbol", "space",
+}
+
+// An action performed when a host input is pressed, and undone when it is released
+type InputAction struct {
+	// Spectrum keys pressed simultaneously, or nil
+	keys KeyChord
+
+	joystick  uint
+	direction uint
+}
+
+func ParseInputAction(s string) (InputAction, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if i := strings.Index(s, ":"); i >= 0 {
+		iface, haveIface := JoystickInterfaceNames[s[0:i]]
+		if s[0:i] == "joystick" {
+			iface, haveIface = JOYSTICK_SELECTED, true
+		}
+		direction, haveDirection := JoystickDirectionNames[s[i+1:]]
+		if !haveIface || !haveDirection {
+			return InputAction{}, fmt.Errorf("invalid joystick action \"%s\"", s)
+		}
+		return InputAction{joystick: iface, direction: direction}, nil
+	}
+
+	var keys KeyChord
+	for _, name := range strings.Split(s, "+") {
+		found := false
+		for code, keyName := range spectrumKeyNames {
+			if keyName == name {
+				keys = append(keys, uint(code))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return InputAction{}, fmt.Errorf("unknown Spectrum key \"%s\"", name)
+		}
+	}
+	return InputAction{keys: keys}, nil
+}
+
+func (a InputAction) String() string {
+	if a.keys == nil {
+		iface := JoystickInterfaceName(a.joystick)
+		if a.joystick == JOYSTICK_SELECTED {
+			iface = "joystick"
+		}
+		var direction string
+		for name, d := range JoystickDirectionNames {
+			if d == a.direction {
+				direction = name
+			}
+		}
+		return iface + ":" + direction
+	}
+
+	var names []string
+	for _, key := range a.keys {
+		names = append(names, spectrumKeyNames[key])
+	}
+	return strings.Join(names, "+")
+}
+
+func (a InputAction) press(speccy *Spectrum48k) {
+	if a.keys == nil {
+		speccy.Joystick.Down(a.joystick, a.direction)
+		return
+	}
+	// Normal order
+	for i := 0; i < len(a.keys); i++ {
+		speccy.Keyboard.KeyDown(a.keys[i])
+	}
+}
+
+func (a InputAction) release(speccy *Spectrum48k) {
+	if a.keys == nil {
+		speccy.Joystick.Up(a.joystick, a.direction)
+		return
+	}
+	// Reverse order
+	for i := len(a.keys) - 1; i >= 0; i-- {
+		speccy.Keyboard.KeyUp(a.keys[i])
+	}
+}
+
+// A named set of bindings
+type InputProfile struct {
+	Name string
+
+	// Patterns matching the names of programs which use this profile (see 'path.Match')
+	Games []string
+
+	bindings map[string][]InputAction
+}
+
+func NewInputProfile(name string) *InputProfile {
+	return &InputProfile{Name: name, bindings: make(map[string][]InputAction)}
+}
+
+// Returns the profile with the built-in gamepad bindings:
+// the first axes, the first hat and the first button control the joystick selected for the gamepad.
+func defaultInputProfile() *InputProfile {
+	p := NewInputProfile(DEFAULT_INPUT_PROFILE)
+	p.bindings["joy:axis0-"] = []InputAction{{joystick: JOYSTICK_SELECTED, direction: KEMPSTON_LEFT}}
+	p.bindings["joy:axis0+"] = []InputAction{{joystick: JOYSTICK_SELECTED, direction: KEMPSTON_RIGHT}}
+	p.bindings["joy:axis1-"] = []InputAction{{joystick: JOYSTICK_SELECTED, direction: KEMPSTON_UP}}
+	p.bindings["joy:axis1+"] = []InputAction{{joystick: JOYSTICK_SELECTED, direction: KEMPSTON_DOWN}}
+	p.bindings["joy:hat0:up"] = []InputAction{{joystick: JOYSTICK_SELECTED, direction: KEMPSTON_UP}}
+	p.bindings["joy:hat0:down"] = []InputAction{{joystick: JOYSTICK_SELECTED, direction: KEMPSTON_DOWN}}
+	p.bindings["joy:hat0:left"] = []InputAction{{joystick: JOYSTICK_SELECTED, direction: KEMPSTON_LEFT}}
+	p.bindings["joy:hat0:right"] = []InputAction{{joystick: JOYSTICK_SELECTED, direction: KEMPSTON_RIGHT}}
+	p.bindings["joy:button0"] = []InputAction{{joystick: JOYSTICK_SELECTED, direction: KEMPSTON_FIRE}}
+	return p
+}
+
+// Checks the syntax of a host input, and returns it in the canonical form
+func parseInputSource(source string) (string, error) {
+	source = strings.ToLower(strings.TrimSpace(source))
+
+	if strings.HasPrefix(source, "key:") {
+		if len(source) == len("key:") {
+			return "", errors.New("missing key name")
+		}
+		return source, nil
+	}
+
+	if !strings.HasPrefix(source, "joy") {
+		return "", fmt.Errorf("invalid input \"%s\"", source)
+	}
+
+	fields := strings.Split(source, ":")
+	if (len(fields) < 2) || (len(fields) > 3) {
+		return "", fmt.Errorf("invalid gamepad input \"%s\"", source)
+	}
+	if n := fields[0][len("joy"):]; n != "" {
+		if _, err := strconv.ParseUint(n, 10, 8); err != nil {
+			return "", fmt.Errorf("invalid gamepad number in \"%s\"", source)
+		}
+	}
+
+	isNumber := func(s string) bool {
+		_, err := strconv.ParseUint(s, 10, 8)
+		return err == nil
+	}
+
+	control := fields[1]
+	switch {
+	case strings.HasPrefix(control, "button") && (len(fields) == 2):
+		if isNumber(control[len("button"):]) {
+			return source, nil
+		}
+
+	case strings.HasPrefix(control, "axis") && (len(fields) == 2):
+		n := len(control)
+		if (n > len("axis")+1) && ((control[n-1] == '-') || (control[n-1] == '+')) && isNumber(control[len("axis"):n-1]) {
+			return source, nil
+		}
+
+	case strings.HasPrefix(control, "hat") && (len(fields) == 3):
+		if isNumber(control[len("hat"):]) {
+			for _, d := range hatDirections {
+				if fields[2] == d.name {
+					return source, nil
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("invalid gamepad input \"%s\"", source)
+}
+
+// Binds the host input to the actions (separated by spaces)
+func (p *InputProfile) Bind(source string, actions string) error {
+	source, err := parseInputSource(source)
+	if err != nil {
+		return err
+	}
+
+	var list []InputAction
+	for _, s := range strings.Fields(actions) {
+		action, err := ParseInputAction(s)
+		if err != nil {
+			return err
+		}
+		list = append(list, action)
+	}
+	if len(list) == 0 {
+		return fmt.Errorf("no action specified for \"%s\"", source)
+	}
+
+	p.bindings[source] = list
+	return nil
+}
+
+func (p *InputProfile) Unbind(source string) error {
+	source, err := parseInputSource(source)
+	if err != nil {
+		return err
+	}
+	delete(p.bindings, source)
+	return nil
+}
+
+// Returns the bindings in the syntax used by 'DecodeInputProfiles'
+func (p *InputProfile) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[%s]\n", p.Name)
+	if len(p.Games) > 0 {
+		fmt.Fprintf(&buf, "games = %s\n", strings.Join(p.Games, ", "))
+	}
+
+	var sources []string
+	for source := range p.bindings {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		var actions []string
+		for _, a := range p.bindings[source] {
+			actions = append(actions, a.String())
+		}
+		fmt.Fprintf(&buf, "%s = %s\n", source, strings.Join(actions, " "))
+	}
+
+	return buf.String()
+}
+
+// Parses profiles in the format described at the top of this file
+func DecodeInputProfiles(data []byte) ([]*InputProfile, error) {
+	var profiles []*InputProfile
+	var current *InputProfile
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[0:i])
+		}
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, fmt.Errorf("line %d: missing profile name", lineNumber)
+			}
+			current = NewInputProfile(name)
+			profiles = append(profiles, current)
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected \"input = action\"", lineNumber)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: a profile has to start with [name]", lineNumber)
+		}
+
+		key := strings.TrimSpace(line[0:i])
+		value := strings.TrimSpace(line[i+1:])
+
+		if strings.ToLower(key) == "games" {
+			for _, game := range strings.Split(value, ",") {
+				if game = strings.ToLower(strings.TrimSpace(game)); game != "" {
+					current.Games = append(current.Games, game)
+				}
+			}
+			continue
+		}
+
+		if err := current.Bind(key, value); err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+	}
+
+	return profiles, scanner.Err()
+}
+
+// Translates host input events into actions, according to the active profile
+type InputMapper struct {
+	speccy *Spectrum48k
+
+	mutex    sync.Mutex
+	profiles map[string]*InputProfile
+
+	// The profile selected by the user, used for programs without their own profile
+	base   *InputProfile
+	active *InputProfile
+
+	// The actions performed by the host inputs which are currently pressed.
+	// Releasing an input undoes the same actions, even if the profile has changed meanwhile.
+	pressed map[string][]InputAction
+
+	// The current direction (-1, 0, +1) of gamepad axes, and the current value of hats
+	axes map[string]int
+	hats map[string]byte
+
+	// The joystick interface emulated by each gamepad.
+	// Gamepads not present in the map are Kempston joysticks.
+	interfaces map[int]uint
+}
+
+func NewInputMapper() *InputMapper {
+	m := &InputMapper{
+		profiles: make(map[string]*InputProfile),
+		pressed:  make(map[string][]InputAction),
+		axes:     make(map[string]int),
+		hats:     make(map[string]byte),
+
+		interfaces: make(map[int]uint),
+	}
+
+	def := defaultInputProfile()
+	m.profiles[def.Name] = def
+	m.base = def
+	m.active = def
+
+	return m
+}
+
+func (m *InputMapper) init(speccy *Spectrum48k) {
+	m.speccy = speccy
+}
+
+// Adds the profiles, replacing existing profiles with the same name
+func (m *InputMapper) AddProfiles(profiles []*InputProfile) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, p := range profiles {
+		if old, exists := m.profiles[p.Name]; exists {
+			if m.base == old {
+				m.base = p
+			}
+			if m.active == old {
+				m.active = p
+			}
+		}
+		m.profiles[p.Name] = p
+	}
+}
+
+// Reads profiles from the file
+func (m *InputMapper) ReadProfiles(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	profiles, err := DecodeInputProfiles(data)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	m.AddProfiles(profiles)
+	return nil
+}
+
+// Activates the profile. The profile is also used for programs without their own profile.
+func (m *InputMapper) SetProfile(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	p, exists := m.profiles[name]
+	if !exists {
+		return fmt.Errorf("no such input profile: \"%s\"", name)
+	}
+	m.base = p
+	m.active = p
+	return nil
+}
+
+// Returns the name of the active profile
+func (m *InputMapper) ActiveProfile() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.active.Name
+}
+
+func (m *InputMapper) ProfileNames() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var names []string
+	for name := range m.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Selects the joystick interface (JOYSTICK_KEMPSTON, ...) emulated by the gamepad.
+// Bindings to "joystick:..." actions use this interface.
+func (m *InputMapper) SetJoystickInterface(which int, iface uint) error {
+	if iface >= NUM_JOYSTICK_INTERFACES {
+		return fmt.Errorf("invalid joystick interface %d", iface)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.interfaces[which] = iface
+	return nil
+}
+
+// Returns the joystick interface emulated by the gamepad
+func (m *InputMapper) JoystickInterface(which int) uint {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.interfaces[which]
+}
+
+// Binds the host input in the active profile
+func (m *InputMapper) Bind(source string, actions string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.active.Bind(source, actions)
+}
+
+// Removes the binding from the active profile
+func (m *InputMapper) Unbind(source string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.active.Unbind(source)
+}
+
+// Selects the profile of the program, or the profile selected by the user
+func (m *InputMapper) programLoaded(fileName string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name := strings.ToLower(path.Base(fileName))
+
+	var names []string
+	for n := range m.profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	m.active = m.base
+	for _, n := range names {
+		for _, pattern := range m.profiles[n].Games {
+			if matched, _ := path.Match(pattern, name); matched {
+				m.active = m.profiles[n]
+			}
+		}
+	}
+
+	if (m.speccy != nil) && m.speccy.app.Verbose {
+		m.speccy.app.PrintfMsg("input profile: %s", m.active.Name)
+	}
+}
+
+// Returns the actions bound to the first of the host inputs found in the active profile,
+// or in the default profile. The caller has to hold the mutex.
+func (m *InputMapper) lookup(sources ...string) ([]InputAction, bool) {
+	for _, p := range []*InputProfile{m.active, m.profiles[DEFAULT_INPUT_PROFILE]} {
+		if p == nil {
+			continue
+		}
+		for _, source := range sources {
+			if actions, bound := p.bindings[source]; bound {
+				return actions, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Returns true if a profile binds the host key
+func (m *InputMapper) IsKeyBound(keyName string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, bound := m.lookup("key:" + keyName)
+	return bound
+}
+
+// Performs the actions of the host input pressed on the gamepad (0 for host keys).
+// The caller has to hold the mutex.
+func (m *InputMapper) press(source string, which int, actions []InputAction) {
+	if _, alreadyPressed := m.pressed[source]; alreadyPressed {
+		return
+	}
+
+	// Resolve the joystick selected for the gamepad now,
+	// so that the release affects the same interface
+	resolved := make([]InputAction, len(actions))
+	for i, a := range actions {
+		if (a.keys == nil) && (a.joystick == JOYSTICK_SELECTED) {
+			a.joystick = m.interfaces[which]
+		}
+		resolved[i] = a
+	}
+
+	m.pressed[source] = resolved
+	for _, a := range resolved {
+		a.press(m.speccy)
+	}
+}
+
+// The caller has to hold the mutex
+func (m *InputMapper) release(source string) {
+	actions, pressed := m.pressed[source]
+	if !pressed {
+		return
+	}
+	delete(m.pressed, source)
+	for i := len(actions) - 1; i >= 0; i-- {
+		actions[i].release(m.speccy)
+	}
+}
+
+// Handles a host key event. The key is named as in 'SDL_KeyMap'.
+// Returns false if the key has no mapping.
+func (m *InputMapper) HostKey(keyName string, down bool) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	source := "key:" + keyName
+	if !down {
+		_, pressed := m.pressed[source]
+		m.release(source)
+		return pressed
+	}
+
+	actions, bound := m.lookup(source)
+	if !bound {
+		sequence, haveMapping := SDL_KeyMap[keyName]
+		if !haveMapping {
+			return false
+		}
+		actions = []InputAction{{keys: sequence}}
+	}
+	m.press(source, 0, actions)
+	return true
+}
+
+func (m *InputMapper) gamepadInput(down bool, which int, control string) {
+	specific := fmt.Sprintf("joy%d:%s", which, control)
+	if !down {
+		m.release(specific)
+		return
+	}
+	if actions, bound := m.lookup(specific, "joy:"+control); bound {
+		m.press(specific, which, actions)
+	}
+}
+
+// Handles a gamepad button event
+func (m *InputMapper) JoystickButton(which int, button int, down bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.gamepadInput(down, which, fmt.Sprintf("button%d", button))
+}
+
+// Handles a gamepad axis event
+func (m *InputMapper) JoystickAxis(which int, axis int, value int16) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var direction int
+	switch {
+	case value < -JOYSTICK_AXIS_DEADZONE:
+		direction = -1
+	case value > JOYSTICK_AXIS_DEADZONE:
+		direction = +1
+	}
+
+	key := fmt.Sprintf("joy%d:axis%d", which, axis)
+	old := m.axes[key]
+	if direction == old {
+		return
+	}
+	m.axes[key] = direction
+
+	switch old {
+	case -1:
+		m.gamepadInput(false, which, fmt.Sprintf("axis%d-", axis))
+	case +1:
+		m.gamepadInput(false, which, fmt.Sprintf("axis%d+", axis))
+	}
+	switch direction {
+	case -1:
+		m.gamepadInput(true, which, fmt.Sprintf("axis%d-", axis))
+	case +1:
+		m.gamepadInput(true, which, fmt.Sprintf("axis%d+", axis))
+	}
+}
+
+// Handles a gamepad hat event. The value is a combination of HAT_UP, HAT_DOWN, ...
+func (m *InputMapper) JoystickHat(which int, hat int, value byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := fmt.Sprintf("joy%d:hat%d", which, hat)
+	old := m.hats[key]
+	m.hats[key] = value
+
+	for _, d := range hatDirections {
+		control := fmt.Sprintf("hat%d:%s", hat, d.name)
+		wasDown, isDown := (old&d.mask) != 0, (value&d.mask) != 0
+		if wasDown && !isDown {
+			m.gamepadInput(false, which, control)
+		} else if !wasDown && isDown {
+			m.gamepadInput(true, which, control)
+		}
+	}
+}
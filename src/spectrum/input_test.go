@@ -0,0 +1,240 @@
+package spectrum
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseInputSource(t *testing.T) {
+	tests := []struct {
+		source    string
+		canonical string // Empty if the source is invalid
+	}{
+		{"key:a", "key:a"},
+		{" Key:Left Shift ", "key:left shift"},
+		{"joy0:button1", "joy0:button1"},
+		{"joy:button0", "joy:button0"},
+		{"joy1:axis0-", "joy1:axis0-"},
+		{"joy1:axis12+", "joy1:axis12+"},
+		{"joy0:hat0:up", "joy0:hat0:up"},
+		{"JOY0:HAT1:Left", "joy0:hat1:left"},
+
+		{"key:", ""},
+		{"a", ""},
+		{"mouse:button0", ""},
+		{"joyx:button0", ""},
+		{"joy0", ""},
+		{"joy0:button", ""},
+		{"joy0:buttonx", ""},
+		{"joy0:button0:up", ""},
+		{"joy0:axis0", ""},
+		{"joy0:axis-", ""},
+		{"joy0:axisx+", ""},
+		{"joy0:hat0", ""},
+		{"joy0:hat0:middle", ""},
+		{"joy0:trigger0", ""},
+	}
+
+	for _, test := range tests {
+		canonical, err := parseInputSource(test.source)
+		if test.canonical == "" {
+			if err == nil {
+				t.Errorf("\"%s\": expected an error, got \"%s\"", test.source, canonical)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("\"%s\": %s", test.source, err)
+		} else if canonical != test.canonical {
+			t.Errorf("\"%s\": expected \"%s\", got \"%s\"", test.source, test.canonical, canonical)
+		}
+	}
+}
+
+func TestDecodeInputProfiles(t *testing.T) {
+	data := `
+# Comment
+[manic]
+games = Manic*.tap, manicminer.z80
+key:q = o       # Left
+KEY:W = p
+joy0:button1 = space caps+5
+joy:hat0:up = kempston:up
+
+[empty]
+`
+	profiles, err := DecodeInputProfiles([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("expected 2 profiles, got %d", len(profiles))
+	}
+
+	expected := "[manic]\n" +
+		"games = manic*.tap, manicminer.z80\n" +
+		"joy0:button1 = space caps+5\n" +
+		"joy:hat0:up = kempston:up\n" +
+		"key:q = o\n" +
+		"key:w = p\n"
+	if s := profiles[0].String(); s != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+	if s := profiles[1].String(); s != "[empty]\n" {
+		t.Errorf("expected an empty profile, got:\n%s", s)
+	}
+
+	// The errors report the line
+	errors := []struct {
+		data string
+		err  string
+	}{
+		{"key:q = o", "line 1: a profile has to start with [name]"},
+		{"[]", "line 1: missing profile name"},
+		{"[p]\nkey:q", "line 2: expected \"input = action\""},
+		{"[p]\n\nkey:q =", "line 3: no action specified for \"key:q\""},
+		{"[p]\nkey:q = qq", "line 2: unknown Spectrum key \"qq\""},
+		{"[p]\nkey:q = caps+x+", "line 2: unknown Spectrum key \"\""},
+		{"[p]\nkey:q = kempston:sideways", "line 2: invalid joystick action \"kempston:sideways\""},
+		{"[p]\nkey:q = atari:fire", "line 2: invalid joystick action \"atari:fire\""},
+		{"[p]\njoy0:axis0 = o", "line 2: invalid gamepad input \"joy0:axis0\""},
+	}
+	for _, test := range errors {
+		_, err := DecodeInputProfiles([]byte(test.data))
+		if (err == nil) || (err.Error() != test.err) {
+			t.Errorf("%q: expected error %q, got %v", test.data, test.err, err)
+		}
+	}
+}
+
+func TestInputProfileOfProgram(t *testing.T) {
+	profiles, err := DecodeInputProfiles([]byte(`
+[manic]
+games = manic*.tap, manicminer.z80
+key:q = o
+
+[jetpac]
+games = jetpac.*
+key:q = p
+
+[user]
+key:q = a
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewInputMapper()
+	m.AddProfiles(profiles)
+
+	tests := []struct {
+		fileName string
+		profile  string
+	}{
+		{"manic.tap", "manic"},
+		{"/home/user/games/ManicMiner2.TAP", "manic"},
+		{"manicminer.z80", "manic"},
+		{"manicminer.sna", DEFAULT_INPUT_PROFILE},
+		{"jetpac.z80", "jetpac"},
+		{"jetpac", DEFAULT_INPUT_PROFILE},
+		{"", DEFAULT_INPUT_PROFILE},
+	}
+	for _, test := range tests {
+		m.programLoaded(test.fileName)
+		if p := m.ActiveProfile(); p != test.profile {
+			t.Errorf("\"%s\": expected profile \"%s\", got \"%s\"", test.fileName, test.profile, p)
+		}
+	}
+
+	// A program without its own profile uses the profile selected by the user
+	if err := m.SetProfile("user"); err != nil {
+		t.Fatal(err)
+	}
+	m.programLoaded("jetpac.tap")
+	if p := m.ActiveProfile(); p != "jetpac" {
+		t.Errorf("expected profile \"jetpac\", got \"%s\"", p)
+	}
+	m.programLoaded("other.tap")
+	if p := m.ActiveProfile(); p != "user" {
+		t.Errorf("expected profile \"user\", got \"%s\"", p)
+	}
+
+	if err := m.SetProfile("unknown"); err == nil {
+		t.Error("expected an error when selecting an unknown profile")
+	}
+}
+
+func keyPressed(keyboard *Keyboard, key uint) bool {
+	cell := keyCodes[key]
+	return (keyboard.GetKeyState(uint(cell.row)) & cell.mask) == 0
+}
+
+func TestInputReleaseAfterProfileChange(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	speccy := NewSpectrum48k(app, *rom)
+	m := speccy.Input
+
+	profiles, err := DecodeInputProfiles([]byte(strings.Join([]string{
+		"[first]", "key:x = o",
+		"[second]", "key:x = p",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddProfiles(profiles)
+
+	steps := []struct {
+		action string
+		o, p   bool // Whether the Spectrum keys O and P are pressed after the action
+	}{
+		{"profile first", false, false},
+		{"press", true, false},
+		{"profile second", true, false},
+		{"release", false, false},
+		{"press", false, true},
+		{"profile first", false, true},
+		{"release", false, false},
+	}
+	for i, step := range steps {
+		switch {
+		case strings.HasPrefix(step.action, "profile "):
+			if err := m.SetProfile(strings.TrimPrefix(step.action, "profile ")); err != nil {
+				t.Fatal(err)
+			}
+		case step.action == "press":
+			m.HostKey("x", true)
+		case step.action == "release":
+			m.HostKey("x", false)
+		}
+
+		if o, p := keyPressed(speccy.Keyboard, KEY_O), keyPressed(speccy.Keyboard, KEY_P); (o != step.o) || (p != step.p) {
+			t.Errorf("step %d (%s): expected O=%v P=%v, got O=%v P=%v", i, step.action, step.o, step.p, o, p)
+		}
+	}
+
+	// The joystick selected for the gamepad is resolved when the button is pressed
+	m.JoystickButton(0, 0, true)
+	if speccy.Joystick.GetState() != kempstonMask[KEMPSTON_FIRE] {
+		t.Fatal("the Kempston fire button is not pressed")
+	}
+	if err := m.SetJoystickInterface(0, JOYSTICK_SINCLAIR1); err != nil {
+		t.Fatal(err)
+	}
+	m.JoystickButton(0, 0, false)
+	if speccy.Joystick.GetState() != 0 {
+		t.Error("the Kempston fire button is still pressed")
+	}
+	if keyPressed(speccy.Keyboard, KEY_0) {
+		t.Error("the fire button of the Sinclair joystick has been pressed")
+	}
+}
@@ -0,0 +1,317 @@
+package spectrum
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// The ZX Interface 1.
+//
+// The Interface 1 pages its 8K shadow ROM over the system ROM when the Z80 fetches an opcode
+// from 0x0008 (error restart) or 0x1708 (CLOSE# of an unknown stream), and pages it out
+// after fetching the opcode at 0x0700.
+//
+// The ports are 0xE7 (Microdrive data), 0xEF (status and control) and 0xF7 (RS-232 and network).
+// Only A0, A3 and A4 are decoded.
+const (
+	IF1_ROM_SIZE       = 0x2000
+	IF1_NUM_MICRODRIVE = 8
+)
+
+type Interface1 struct {
+	rom []byte
+
+	speccy *Spectrum48k
+
+	// Whether the shadow ROM is paged in
+	active bool
+
+	// Protects the microdrives and the RS-232 connection,
+	// which can be accessed from any goroutine by the exported methods
+	mutex sync.Mutex
+
+	drives [IF1_NUM_MICRODRIVE]Microdrive
+
+	// The previous state of the COMMS CLK line, used to detect the falling edge
+	commsClk bool
+
+	// The R/W line (true = write)
+	writing bool
+
+	rs232 rs232
+}
+
+// Reads the Interface 1 ROM from the specified file
+func ReadInterface1ROM(path string) ([]byte, error) {
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rom) != IF1_ROM_SIZE {
+		return nil, errors.New(path + ": invalid Interface 1 ROM file")
+	}
+	return rom, nil
+}
+
+func NewInterface1(rom []byte) *Interface1 {
+	if len(rom) != IF1_ROM_SIZE {
+		panic("invalid Interface 1 ROM size")
+	}
+	return &Interface1{rom: rom}
+}
+
+func (if1 *Interface1) Name() string {
+	return "if1"
+}
+
+func (if1 *Interface1) attach(speccy *Spectrum48k) error {
+	for _, name := range []string{"divmmc", "divide", "esxdos", "beta128", "multiface"} {
+		if speccy.findPeripheral(name) != nil {
+			return fmt.Errorf("the Interface 1 cannot be connected while the %s is connected", name)
+		}
+	}
+
+	if1.speccy = speccy
+	if1.rs232.speccy = speccy
+	if1.rs232.lastTstates = speccy.Cpu.Tstates
+
+	speccy.addFetchTrap(if1, 0x0008, if1.pageIn, nil)
+	speccy.addFetchTrap(if1, 0x1708, if1.pageIn, nil)
+	speccy.addFetchTrap(if1, 0x0700, nil, if1.pageOut)
+
+	return nil
+}
+
+func (if1 *Interface1) detach() {
+	if1.pageOut(0)
+
+	if1.mutex.Lock()
+	defer if1.mutex.Unlock()
+
+	for i := range if1.drives {
+		if err := if1.drives[i].eject(); err != nil {
+			if1.speccy.app.PrintfMsg("%s", err)
+		}
+	}
+	if1.rs232.disconnect()
+}
+
+func (if1 *Interface1) reset() {
+	if1.pageOut(0)
+
+	if1.mutex.Lock()
+	defer if1.mutex.Unlock()
+
+	for i := range if1.drives {
+		if1.drives[i].motorOn = false
+	}
+	if1.commsClk = false
+	if1.writing = false
+	if1.rs232.reset()
+}
+
+func (if1 *Interface1) pageIn(pc uint16) {
+	if !if1.active {
+		if1.speccy.Memory.pageROM(if1.rom, if1.rom, false, false)
+		if1.active = true
+	}
+}
+
+func (if1 *Interface1) pageOut(pc uint16) {
+	if if1.active {
+		if1.speccy.Memory.unpageROM()
+		if1.active = false
+	}
+}
+
+func (if1 *Interface1) saveState() interface{} {
+	return if1.active
+}
+
+func (if1 *Interface1) restoreState(state interface{}) {
+	if1.active = false
+	if state.(bool) {
+		if1.pageIn(0)
+	}
+}
+
+func (if1 *Interface1) rewindRAM() [][]byte {
+	return nil
+}
+
+func (if1 *Interface1) encodeState(state interface{}) []byte {
+	return encodeFlags(state.(bool))
+}
+
+func (if1 *Interface1) decodeState(data []byte) (interface{}, error) {
+	flags, err := decodeFlags(data, 1)
+	if err != nil {
+		return nil, err
+	}
+	return flags[0], nil
+}
+
+// Returns the drive whose motor is running, or nil
+func (if1 *Interface1) runningDrive() *Microdrive {
+	for i := range if1.drives {
+		if if1.drives[i].running() {
+			return &if1.drives[i]
+		}
+	}
+	return nil
+}
+
+func (if1 *Interface1) readPort(address uint16) (byte, bool) {
+	if (address & 0x0001) == 0 {
+		return 0, false
+	}
+
+	switch address & 0x0018 {
+	case 0x0000: // 0xE7
+		if1.mutex.Lock()
+		defer if1.mutex.Unlock()
+
+		if drive := if1.runningDrive(); drive != nil {
+			return drive.readData(), true
+		}
+		return 0xff, true
+
+	case 0x0008: // 0xEF
+		if1.mutex.Lock()
+		defer if1.mutex.Unlock()
+
+		var status byte = 0xff
+		if drive := if1.runningDrive(); drive != nil {
+			status &= drive.status()
+		}
+		if !if1.rs232.connected() {
+			// DTR
+			status &= 0xf7
+		}
+		return status, true
+
+	case 0x0010: // 0xF7
+		if1.mutex.Lock()
+		defer if1.mutex.Unlock()
+
+		return if1.rs232.read(), true
+	}
+
+	return 0, false
+}
+
+func (if1 *Interface1) writePort(address uint16, b byte) {
+	if (address & 0x0001) == 0 {
+		return
+	}
+
+	switch address & 0x0018 {
+	case 0x0000: // 0xE7
+		if1.mutex.Lock()
+		if drive := if1.runningDrive(); (drive != nil) && if1.writing {
+			drive.writeData(b)
+		}
+		if1.mutex.Unlock()
+
+	case 0x0008: // 0xEF
+		if1.mutex.Lock()
+
+		// The drive motors are selected by shifting the COMMS DATA bit
+		// on the falling edge of COMMS CLK
+		commsClk := ((b & 0x02) != 0)
+		if if1.commsClk && !commsClk {
+			for i := IF1_NUM_MICRODRIVE - 1; i > 0; i-- {
+				if1.drives[i].motorOn = if1.drives[i-1].motorOn
+			}
+			if1.drives[0].motorOn = ((b & 0x01) == 0)
+		}
+		if1.commsClk = commsClk
+
+		writing := ((b & 0x04) == 0)
+		if writing && !if1.writing {
+			if drive := if1.runningDrive(); drive != nil {
+				drive.startWriting()
+			}
+		}
+		if1.writing = writing
+
+		if1.mutex.Unlock()
+
+	case 0x0010: // 0xF7
+		if1.mutex.Lock()
+		if1.rs232.write(b)
+		if1.mutex.Unlock()
+	}
+}
+
+func checkDrive(drive uint) error {
+	if (drive < 1) || (drive > IF1_NUM_MICRODRIVE) {
+		return fmt.Errorf("invalid microdrive number %d", drive)
+	}
+	return nil
+}
+
+// Inserts the cartridge stored in the specified file into the microdrive (1..8).
+// If the file does not exist, a blank cartridge is inserted,
+// and it will be written to the file when it is ejected.
+func (if1 *Interface1) InsertCartridge(drive uint, path string) error {
+	if err := checkDrive(drive); err != nil {
+		return err
+	}
+
+	if1.mutex.Lock()
+	defer if1.mutex.Unlock()
+
+	d := &if1.drives[drive-1]
+	if err := d.eject(); err != nil {
+		return err
+	}
+	return d.insert(path)
+}
+
+// Ejects the cartridge from the microdrive (1..8), saving it if it has been modified
+func (if1 *Interface1) EjectCartridge(drive uint) error {
+	if err := checkDrive(drive); err != nil {
+		return err
+	}
+
+	if1.mutex.Lock()
+	defer if1.mutex.Unlock()
+
+	d := &if1.drives[drive-1]
+	if d.cartridge_orNil == nil {
+		return fmt.Errorf("microdrive %d is empty", drive)
+	}
+	return d.eject()
+}
+
+// Sets the write-protect tab of the cartridge in the microdrive (1..8)
+func (if1 *Interface1) SetWriteProtect(drive uint, protect bool) error {
+	if err := checkDrive(drive); err != nil {
+		return err
+	}
+
+	if1.mutex.Lock()
+	defer if1.mutex.Unlock()
+
+	d := &if1.drives[drive-1]
+	if d.cartridge_orNil == nil {
+		return fmt.Errorf("microdrive %d is empty", drive)
+	}
+	if d.cartridge_orNil.WriteProtected != protect {
+		d.cartridge_orNil.WriteProtected = protect
+		d.modified = true
+	}
+	return nil
+}
+
+// Connects the RS-232 port to a host pty ("pty") or to a TCP socket on localhost ("tcp:PORT").
+// Returns a message describing where the port can be reached.
+func (if1 *Interface1) ConnectRS232(spec string) (string, error) {
+	if1.mutex.Lock()
+	defer if1.mutex.Unlock()
+
+	return if1.rs232.connect(spec)
+}
@@ -43,10 +43,72 @@ var kempstonMask = map[uint]byte{
 	KEMPSTON_RIGHT: 0x0001,
 }
 
+// Masks of the bits in the Fuller joystick port (0x7F), the bits are active low
+var fullerMask = map[uint]byte{
+	KEMPSTON_FIRE:  0x80,
+	KEMPSTON_UP:    0x01,
+	KEMPSTON_DOWN:  0x02,
+	KEMPSTON_LEFT:  0x04,
+	KEMPSTON_RIGHT: 0x08,
+}
+
+// Joystick interfaces
+const (
+	JOYSTICK_KEMPSTON  = iota // Port 0x1F
+	JOYSTICK_SINCLAIR1        // Interface 2 right socket (player 1): keys 6, 7, 8, 9, 0
+	JOYSTICK_SINCLAIR2        // Interface 2 left socket (player 2): keys 1, 2, 3, 4, 5
+	JOYSTICK_CURSOR           // Cursor, Protek, AGF: keys 5, 6, 7, 8, 0
+	JOYSTICK_FULLER           // Port 0x7F
+
+	NUM_JOYSTICK_INTERFACES
+
+	// Not an interface: the interface selected for the gamepad (see 'InputMapper.SetJoystickInterface')
+	JOYSTICK_SELECTED
+)
+
+var JoystickInterfaceNames = map[string]uint{
+	"kempston":  JOYSTICK_KEMPSTON,
+	"sinclair1": JOYSTICK_SINCLAIR1,
+	"sinclair2": JOYSTICK_SINCLAIR2,
+	"cursor":    JOYSTICK_CURSOR,
+	"fuller":    JOYSTICK_FULLER,
+}
+
+// Returns the name of the joystick interface (JOYSTICK_KEMPSTON, ...)
+func JoystickInterfaceName(iface uint) string {
+	for name, i := range JoystickInterfaceNames {
+		if i == iface {
+			return name
+		}
+	}
+	return ""
+}
+
+// Names of the joystick directions (KEMPSTON_UP, ...)
+var JoystickDirectionNames = map[string]uint{
+	"fire":  KEMPSTON_FIRE,
+	"up":    KEMPSTON_UP,
+	"down":  KEMPSTON_DOWN,
+	"left":  KEMPSTON_LEFT,
+	"right": KEMPSTON_RIGHT,
+}
+
+// The keys pressed by the joysticks connected to the keyboard, indexed by direction
+var joystickKeys = map[uint][5]uint{
+	//                   FIRE,  UP,    DOWN,  LEFT,  RIGHT
+	JOYSTICK_SINCLAIR1: {KEY_0, KEY_9, KEY_8, KEY_6, KEY_7},
+	JOYSTICK_SINCLAIR2: {KEY_5, KEY_4, KEY_3, KEY_1, KEY_2},
+	JOYSTICK_CURSOR:    {KEY_0, KEY_7, KEY_6, KEY_5, KEY_8},
+}
+
 type Joystick struct {
 	speccy *Spectrum48k
 	state  byte
 	mutex  sync.RWMutex
+
+	// The directions held down on the other interfaces, using the same bits as the Kempston port.
+	// The index is JOYSTICK_SINCLAIR1, JOYSTICK_SINCLAIR2, ... (the Kempston state is in 'state').
+	states [NUM_JOYSTICK_INTERFACES]byte
 }
 
 func NewJoystick() *Joystick {
@@ -87,3 +149,72 @@ func (joystick *Joystick) KempstonUp(logicalCode uint) {
 	joystick.state &= ^kempstonMask[logicalCode]
 	joystick.mutex.Unlock()
 }
+
+// Presses the joystick direction (KEMPSTON_UP, ...) on the specified interface
+func (joystick *Joystick) Down(iface uint, direction uint) {
+	if iface == JOYSTICK_KEMPSTON {
+		joystick.KempstonDown(direction)
+		return
+	}
+	if iface >= NUM_JOYSTICK_INTERFACES {
+		return
+	}
+
+	joystick.mutex.Lock()
+	joystick.states[iface] |= kempstonMask[direction]
+	joystick.mutex.Unlock()
+
+	if _, keyboardJoystick := joystickKeys[iface]; keyboardJoystick {
+		joystick.updateKeyboard()
+	}
+}
+
+// Releases the joystick direction (KEMPSTON_UP, ...) on the specified interface
+func (joystick *Joystick) Up(iface uint, direction uint) {
+	if iface == JOYSTICK_KEMPSTON {
+		joystick.KempstonUp(direction)
+		return
+	}
+	if iface >= NUM_JOYSTICK_INTERFACES {
+		return
+	}
+
+	joystick.mutex.Lock()
+	joystick.states[iface] &= ^kempstonMask[direction]
+	joystick.mutex.Unlock()
+
+	if _, keyboardJoystick := joystickKeys[iface]; keyboardJoystick {
+		joystick.updateKeyboard()
+	}
+}
+
+// Returns the value read from the Fuller joystick port
+func (joystick *Joystick) GetFullerState() byte {
+	joystick.mutex.RLock()
+	state := joystick.states[JOYSTICK_FULLER]
+	joystick.mutex.RUnlock()
+
+	var result byte = 0xff
+	for direction, mask := range fullerMask {
+		if (state & kempstonMask[direction]) != 0 {
+			result &= ^mask
+		}
+	}
+	return result
+}
+
+// Presses the keys corresponding to the directions held on the Sinclair and Cursor joysticks
+func (joystick *Joystick) updateKeyboard() {
+	var keys []uint
+
+	joystick.mutex.Lock()
+	for iface, ifaceKeys := range joystickKeys {
+		for direction, key := range ifaceKeys {
+			if (joystick.states[iface] & kempstonMask[uint(direction)]) != 0 {
+				keys = append(keys, key)
+			}
+		}
+	}
+	joystick.speccy.Keyboard.setJoystickKeys(keys)
+	joystick.mutex.Unlock()
+}
@@ -35,12 +35,13 @@ type rowState struct {
 }
 
 type Cmd_KeyPress struct {
-	logicalKeyCode uint
-	done           chan bool
+	logicalKeyCodes KeyChord // Pressed simultaneously
+	done            chan bool
 }
 
 type Cmd_SendLoad struct {
 	romType RomType
+	model   Model
 }
 
 type Keyboard struct {
@@ -48,12 +49,20 @@ type Keyboard struct {
 	keyStates [8]byte
 	mutex     sync.RWMutex
 
+	// The keys held down by joysticks which are connected to the keyboard
+	// (Sinclair, Cursor). They are kept separately from 'keyStates',
+	// so that releasing a joystick does not release a key held on the keyboard.
+	joystickKeyStates [8]byte
+
 	CommandChannel chan interface{}
 }
 
 func NewKeyboard() *Keyboard {
 	keyboard := &Keyboard{}
 	keyboard.reset()
+	for row := uint(0); row < 8; row++ {
+		keyboard.joystickKeyStates[row] = 0xff
+	}
 
 	keyboard.CommandChannel = make(chan interface{})
 
@@ -94,14 +103,25 @@ func (keyboard *Keyboard) commandLoop() {
 		case untyped_cmd := <-keyboard.CommandChannel:
 			switch cmd := untyped_cmd.(type) {
 			case Cmd_KeyPress:
-				keyboard.KeyDown(cmd.logicalKeyCode)
+				// Normal order
+				for i := 0; i < len(cmd.logicalKeyCodes); i++ {
+					keyboard.KeyDown(cmd.logicalKeyCodes[i])
+				}
 				keyboard.delayAfterKeyDown()
-				keyboard.KeyUp(cmd.logicalKeyCode)
+				// Reverse order
+				for i := len(cmd.logicalKeyCodes) - 1; i >= 0; i-- {
+					keyboard.KeyUp(cmd.logicalKeyCodes[i])
+				}
 				keyboard.delayAfterKeyUp()
 				cmd.done <- true
 
 			case Cmd_SendLoad:
-				if cmd.romType == ROM_OPENSE {
+				if cmd.model.banked() {
+					// The "Tape Loader" option is selected in the menu of the 128K models
+					keyboard.KeyDown(KEY_Enter)
+					keyboard.delayAfterKeyDown()
+					keyboard.KeyUp(KEY_Enter)
+				} else if cmd.romType == ROM_OPENSE {
 					// Sleep for 30 frames
 					time.Sleep(30e9 / time.Duration(keyboard.speccy.GetCurrentFPS()))
 
@@ -172,7 +192,7 @@ func (k *Keyboard) reset() {
 
 func (keyboard *Keyboard) GetKeyState(row uint) byte {
 	keyboard.mutex.RLock()
-	keyState := keyboard.keyStates[row]
+	keyState := keyboard.keyStates[row] & keyboard.joystickKeyStates[row]
 	keyboard.mutex.RUnlock()
 	return keyState
 }
@@ -203,20 +223,54 @@ func (keyboard *Keyboard) KeyUp(logicalKeyCode uint) {
 	}
 }
 
+// Replaces the keys held down by joysticks
+func (keyboard *Keyboard) setJoystickKeys(logicalKeyCodes []uint) {
+	var states [8]byte
+	for row := range states {
+		states[row] = 0xff
+	}
+	for _, logicalKeyCode := range logicalKeyCodes {
+		if keyCode, ok := keyCodes[logicalKeyCode]; ok {
+			states[keyCode.row] &= ^(keyCode.mask)
+		}
+	}
+
+	keyboard.mutex.Lock()
+	keyboard.joystickKeyStates = states
+	keyboard.mutex.Unlock()
+}
+
 func (keyboard *Keyboard) KeyPress(logicalKeyCode uint) chan bool {
 	done := make(chan bool)
-	keyboard.CommandChannel <- Cmd_KeyPress{logicalKeyCode, done}
+	keyboard.CommandChannel <- Cmd_KeyPress{KeyChord{logicalKeyCode}, done}
 	return done
 }
 
 func (keyboard *Keyboard) KeyPressSequence(logicalKeyCodes ...uint) chan bool {
-	done := make(chan bool, len(logicalKeyCodes))
-	for _, keyCode := range logicalKeyCodes {
-		keyboard.CommandChannel <- Cmd_KeyPress{keyCode, done}
+	chords := make([]KeyChord, len(logicalKeyCodes))
+	for i, keyCode := range logicalKeyCodes {
+		chords[i] = KeyChord{keyCode}
+	}
+	return keyboard.KeyPressChordSequence(chords...)
+}
+
+// Presses the chords one after another.
+// The returned channel receives a value after each chord has been released.
+func (keyboard *Keyboard) KeyPressChordSequence(chords ...KeyChord) chan bool {
+	done := make(chan bool, len(chords))
+	for _, chord := range chords {
+		keyboard.CommandChannel <- Cmd_KeyPress{chord, done}
 	}
 	return done
 }
 
+// Types the text, see 'TranslateText' for details.
+// Returns the number of key presses, and a channel which receives a value after each of them.
+func (keyboard *Keyboard) TypeText(text string, basic bool) (int, chan bool) {
+	chords := TranslateText(text, basic)
+	return len(chords), keyboard.KeyPressChordSequence(chords...)
+}
+
 // Logical key codes
 const (
 	KEY_1 = iota
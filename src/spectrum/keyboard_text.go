@@ -0,0 +1,399 @@
+package spectrum
+
+// Translation of text into Spectrum key presses.
+//
+// The translation follows the cursor modes of the 48K ROM editor:
+//
+//   K mode  At the start of a BASIC statement: letters produce keywords (P -> PRINT)
+//   L mode  Elsewhere: letters produce letters, CAPS SHIFT produces capital letters
+//   E mode  Entered by pressing CAPS SHIFT and SYMBOL SHIFT together; lasts for one key.
+//           Used for functions (CODE, VAL), some statements (READ, BEEP) and a few symbols ([, ~, ©).
+//   G mode  Toggled by CAPS SHIFT + 9; digits produce block graphics
+//
+// Symbols such as '"', ':' and '=' are typed with SYMBOL SHIFT in both K and L mode.
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A set of keys pressed simultaneously
+type KeyChord []uint
+
+var (
+	chord_Enter    = KeyChord{KEY_Enter}
+	chord_Space    = KeyChord{KEY_Space}
+	chord_Delete   = KeyChord{KEY_CapsShift, KEY_0}
+	chord_EMode    = KeyChord{KEY_CapsShift, KEY_SymbolShift}
+	chord_Graphics = KeyChord{KEY_CapsShift, KEY_9}
+
+	letterKeys [26]uint
+	digitKeys  [10]uint
+)
+
+// The block graphics characters 0x81 ... 0x8F
+var blockGraphics = []rune("▝▘▀▗▐▚▜▖▞▌▛▄▟▙█")
+
+// Characters typed with SYMBOL SHIFT, in K and L mode
+var symbolShiftChars = map[rune]uint{
+	'!': KEY_1, '@': KEY_2, '#': KEY_3, '$': KEY_4, '%': KEY_5,
+	'&': KEY_6, '\'': KEY_7, '(': KEY_8, ')': KEY_9, '_': KEY_0,
+	'<': KEY_R, '>': KEY_T, ';': KEY_O, '"': KEY_P, '^': KEY_H,
+	'-': KEY_J, '+': KEY_K, '=': KEY_L, ':': KEY_Z, '?': KEY_C,
+	'/': KEY_V, '*': KEY_B, ',': KEY_N, '.': KEY_M, '£': KEY_X,
+}
+
+// Characters typed with SYMBOL SHIFT in E mode
+var eModeChars = map[rune]uint{
+	'~': KEY_A, '|': KEY_S, '\\': KEY_D, '{': KEY_F, '}': KEY_G,
+	'[': KEY_Y, ']': KEY_U, '©': KEY_P,
+}
+
+// A BASIC keyword and the keys producing it
+type keyword_t struct {
+	text    string
+	strokes []KeyChord
+
+	// True if the keyword can only be typed in K mode
+	kModeOnly bool
+}
+
+// All keywords of the 48K Spectrum, the longest first
+var keywords []keyword_t
+
+func addKeyword(text string, kModeOnly bool, strokes ...KeyChord) {
+	keywords = append(keywords, keyword_t{text, strokes, kModeOnly})
+}
+
+// Sorts the keywords by length, the longest first.
+// Keywords of the same length are sorted alphabetically.
+type keywordsByLength []keyword_t
+
+func (k keywordsByLength) Len() int      { return len(k) }
+func (k keywordsByLength) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k keywordsByLength) Less(i, j int) bool {
+	if len(k[i].text) != len(k[j].text) {
+		return len(k[i].text) > len(k[j].text)
+	}
+	return k[i].text < k[j].text
+}
+
+func init() {
+	letters := []uint{
+		KEY_A, KEY_B, KEY_C, KEY_D, KEY_E, KEY_F, KEY_G, KEY_H, KEY_I, KEY_J, KEY_K, KEY_L, KEY_M,
+		KEY_N, KEY_O, KEY_P, KEY_Q, KEY_R, KEY_S, KEY_T, KEY_U, KEY_V, KEY_W, KEY_X, KEY_Y, KEY_Z,
+	}
+	copy(letterKeys[:], letters)
+	copy(digitKeys[:], []uint{KEY_0, KEY_1, KEY_2, KEY_3, KEY_4, KEY_5, KEY_6, KEY_7, KEY_8, KEY_9})
+
+	key := func(c byte) uint {
+		if (c >= '0') && (c <= '9') {
+			return digitKeys[c-'0']
+		}
+		return letterKeys[c-'A']
+	}
+
+	// K mode: the keyword printed on the key
+	kMode := map[string]byte{
+		"NEW": 'A', "BORDER": 'B', "CONTINUE": 'C', "DIM": 'D', "REM": 'E', "FOR": 'F',
+		"GO TO": 'G', "GO SUB": 'H', "INPUT": 'I', "LOAD": 'J', "LIST": 'K', "LET": 'L',
+		"PAUSE": 'M', "NEXT": 'N', "POKE": 'O', "PRINT": 'P', "PLOT": 'Q', "RUN": 'R',
+		"SAVE": 'S', "RANDOMIZE": 'T', "IF": 'U', "CLS": 'V', "DRAW": 'W', "CLEAR": 'X',
+		"RETURN": 'Y', "COPY": 'Z',
+	}
+	for text, c := range kMode {
+		addKeyword(text, true, KeyChord{key(c)})
+	}
+
+	// K or L mode: SYMBOL SHIFT
+	symbolShift := map[string]byte{
+		"STOP": 'A', "STEP": 'D', ">=": 'E', "TO": 'F', "THEN": 'G', "AT": 'I',
+		"<=": 'Q', "NOT": 'S', "OR": 'U', "<>": 'W', "AND": 'Y',
+	}
+	for text, c := range symbolShift {
+		addKeyword(text, false, KeyChord{KEY_SymbolShift, key(c)})
+	}
+
+	// E mode
+	eMode := map[string]byte{
+		"READ": 'A', "BIN": 'B', "LPRINT": 'C', "DATA": 'D', "TAN": 'E', "SGN": 'F', "ABS": 'G',
+		"SQR": 'H', "CODE": 'I', "VAL": 'J', "LEN": 'K', "USR": 'L', "PI": 'M', "INKEY$": 'N',
+		"PEEK": 'O', "TAB": 'P', "SIN": 'Q', "INT": 'R', "RESTORE": 'S', "RND": 'T', "CHR$": 'U',
+		"LLIST": 'V', "COS": 'W', "EXP": 'X', "STR$": 'Y', "LN": 'Z',
+	}
+	for text, c := range eMode {
+		addKeyword(text, false, chord_EMode, KeyChord{key(c)})
+	}
+
+	// E mode: SYMBOL SHIFT
+	eModeSymbolShift := map[string]byte{
+		"BRIGHT": 'B', "PAPER": 'C', "ATN": 'E', "CIRCLE": 'H', "IN": 'I', "VAL$": 'J',
+		"SCREEN$": 'K', "ATTR": 'L', "INVERSE": 'M', "OVER": 'N', "OUT": 'O', "ASN": 'Q',
+		"VERIFY": 'R', "MERGE": 'T', "FLASH": 'V', "ACS": 'W', "INK": 'X', "BEEP": 'Z',
+		"DEF FN": '1', "FN": '2', "LINE": '3', "OPEN #": '4', "CLOSE #": '5',
+		"MOVE": '6', "ERASE": '7', "POINT": '8', "CAT": '9', "FORMAT": '0',
+	}
+	for text, c := range eModeSymbolShift {
+		addKeyword(text, false, chord_EMode, KeyChord{KEY_SymbolShift, key(c)})
+	}
+
+	sort.Sort(keywordsByLength(keywords))
+}
+
+// Returns the key presses producing the character in L mode.
+// Returns nil if the character cannot be typed.
+func CharKeys(c rune) []KeyChord {
+	switch {
+	case (c >= 'a') && (c <= 'z'):
+		return []KeyChord{{letterKeys[c-'a']}}
+	case (c >= 'A') && (c <= 'Z'):
+		return []KeyChord{{KEY_CapsShift, letterKeys[c-'A']}}
+	case (c >= '0') && (c <= '9'):
+		return []KeyChord{{digitKeys[c-'0']}}
+	case c == ' ':
+		return []KeyChord{chord_Space}
+	case (c == '\n') || (c == '\r'):
+		return []KeyChord{chord_Enter}
+	case (c == 0x08) || (c == 0x7f):
+		// Backspace
+		return []KeyChord{chord_Delete}
+	}
+
+	if key, ok := symbolShiftChars[c]; ok {
+		return []KeyChord{{KEY_SymbolShift, key}}
+	}
+	if key, ok := eModeChars[c]; ok {
+		return []KeyChord{chord_EMode, {KEY_SymbolShift, key}}
+	}
+
+	return nil
+}
+
+// Returns the G mode key presses producing the block graphics character, or nil
+func graphicsKeys(c rune) []KeyChord {
+	for i, g := range blockGraphics {
+		// Spectrum character codes 0x81 ... 0x8F
+		code := i + 1
+		switch {
+		case g != c:
+			continue
+		case code <= 7:
+			return []KeyChord{{digitKeys[code]}}
+		case code == 0xF:
+			return []KeyChord{{KEY_CapsShift, KEY_8}}
+		default:
+			// Inverted graphics
+			return []KeyChord{{KEY_CapsShift, digitKeys[0xF-code]}}
+		}
+	}
+	return nil
+}
+
+func isAlnum(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// Returns the length of the keyword matched at the start of 'text', or 0.
+// Spaces within the keyword ("GO TO", "DEF FN") are optional.
+func matchKeyword(text []rune, kw string, ignoreCase bool) int {
+	n := 0
+	for _, k := range kw {
+		if k == ' ' {
+			for (n < len(text)) && (text[n] == ' ') {
+				n++
+			}
+			continue
+		}
+		if n >= len(text) {
+			return 0
+		}
+		c := text[n]
+		if ignoreCase {
+			c = unicode.ToUpper(c)
+		}
+		if c != k {
+			return 0
+		}
+		n++
+	}
+
+	// A keyword ending with a letter cannot be followed by a letter: "INTEREST" is not "INT"
+	last := rune(kw[len(kw)-1])
+	if unicode.IsLetter(last) && (n < len(text)) && unicode.IsLetter(text[n]) {
+		return 0
+	}
+
+	return n
+}
+
+// Translates a line of BASIC, starting in K mode
+type lineTranslator_t struct {
+	line   []rune
+	pos    int
+	kMode  bool
+	gMode  bool
+	quoted bool
+	rem    bool
+
+	// Spaces are not typed before keywords, the ROM inserts them automatically
+	pendingSpaces int
+
+	strokes []KeyChord
+}
+
+func (t *lineTranslator_t) emit(strokes ...KeyChord) {
+	for ; t.pendingSpaces > 0; t.pendingSpaces-- {
+		t.strokes = append(t.strokes, chord_Space)
+	}
+	t.strokes = append(t.strokes, strokes...)
+}
+
+func (t *lineTranslator_t) setGraphicsMode(enable bool) {
+	if t.gMode != enable {
+		t.emit(chord_Graphics)
+		t.gMode = enable
+	}
+}
+
+// Tries to type a keyword at the current position
+func (t *lineTranslator_t) keyword() bool {
+	// A keyword starting with a letter cannot follow a letter or a digit: "a1TO" is not "a1 TO"
+	afterAlnum := !t.kMode && (t.pos > 0) && isAlnum(t.line[t.pos-1])
+
+	rest := t.line[t.pos:]
+	for _, kw := range keywords {
+		if kw.kModeOnly && !t.kMode {
+			continue
+		}
+		if afterAlnum && unicode.IsLetter(rune(kw.text[0])) {
+			continue
+		}
+
+		// In K mode anything is a keyword, elsewhere only capitals are recognized
+		n := matchKeyword(rest, kw.text, t.kMode)
+		if n == 0 {
+			continue
+		}
+
+		t.pendingSpaces = 0
+		t.setGraphicsMode(false)
+		t.emit(kw.strokes...)
+		t.pos += n
+
+		// The ROM prints a space after the keyword
+		if (t.pos < len(t.line)) && (t.line[t.pos] == ' ') {
+			t.pos++
+		}
+
+		t.kMode = (kw.text == "THEN")
+		t.rem = (kw.text == "REM")
+		return true
+	}
+
+	return false
+}
+
+func (t *lineTranslator_t) translate() []KeyChord {
+	// Leading spaces and the line number
+	for (t.pos < len(t.line)) && (t.line[t.pos] == ' ') {
+		t.pos++
+	}
+	for (t.pos < len(t.line)) && unicode.IsDigit(t.line[t.pos]) {
+		t.emit(CharKeys(t.line[t.pos])...)
+		t.pos++
+	}
+	for (t.pos < len(t.line)) && (t.line[t.pos] == ' ') {
+		t.pos++
+	}
+
+	for t.pos < len(t.line) {
+		c := t.line[t.pos]
+
+		if !t.quoted && !t.rem {
+			if c == ' ' {
+				t.pendingSpaces++
+				t.pos++
+				continue
+			}
+			if t.keyword() {
+				continue
+			}
+		}
+
+		if g := graphicsKeys(c); g != nil {
+			t.setGraphicsMode(true)
+			t.emit(g...)
+			t.pos++
+			continue
+		}
+		t.setGraphicsMode(false)
+
+		if keys := CharKeys(c); keys != nil {
+			t.emit(keys...)
+		}
+		t.pos++
+
+		switch {
+		case t.rem:
+		case c == '"':
+			t.quoted = !t.quoted
+			t.kMode = false
+		case (c == ':') && !t.quoted:
+			t.kMode = true
+		default:
+			t.kMode = false
+		}
+	}
+
+	t.setGraphicsMode(false)
+	return t.strokes
+}
+
+// Translates the text into key presses.
+//
+// If 'basic' is true, each line is assumed to start in K mode and BASIC keywords
+// are typed as keywords. Outside K mode keywords are recognized only if they are
+// written in capitals, like in a program listing.
+// If 'basic' is false, the text is typed character by character in L mode.
+//
+// Each line is terminated by ENTER.
+// Characters which cannot be typed on the Spectrum are ignored.
+func TranslateText(text string, basic bool) []KeyChord {
+	var strokes []KeyChord
+
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	lines := strings.Split(text, "\n")
+
+	for i, line := range lines {
+		if basic {
+			t := &lineTranslator_t{line: []rune(line), kMode: true}
+			strokes = append(strokes, t.translate()...)
+		} else {
+			gMode := false
+			for _, c := range line {
+				g := graphicsKeys(c)
+				if (g != nil) != gMode {
+					strokes = append(strokes, chord_Graphics)
+					gMode = !gMode
+				}
+				if g != nil {
+					strokes = append(strokes, g...)
+				} else {
+					strokes = append(strokes, CharKeys(c)...)
+				}
+			}
+			if gMode {
+				strokes = append(strokes, chord_Graphics)
+			}
+		}
+
+		// No ENTER after the last line unless the text ends with a newline
+		if i < len(lines)-1 {
+			strokes = append(strokes, chord_Enter)
+		}
+	}
+
+	return strokes
+}
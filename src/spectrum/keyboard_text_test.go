@@ -0,0 +1,72 @@
+package spectrum
+
+import (
+	"strings"
+	"testing"
+)
+
+// Describes the key presses, for example "caps+a b"
+func chordsString(strokes []KeyChord) string {
+	var chords []string
+	for _, chord := range strokes {
+		var names []string
+		for _, key := range chord {
+			names = append(names, spectrumKeyNames[key])
+		}
+		chords = append(chords, strings.Join(names, "+"))
+	}
+	return strings.Join(chords, " ")
+}
+
+func TestTranslateBasic(t *testing.T) {
+	tests := []struct {
+		text    string
+		strokes string
+	}{
+		// K mode keywords, symbols typed with SYMBOL SHIFT
+		{`10 PRINT "hi"`, `1 0 p symbol+p h i symbol+p`},
+		{`goto 10`, `g 1 0`},
+		{`PRINT AT 1,2;"x"`, `p symbol+i 1 symbol+n 2 symbol+o symbol+p x symbol+p`},
+		{`IF a<=1 THEN CLS : STOP`, `u a symbol+q 1 symbol+g v symbol+z symbol+a`},
+
+		// E mode keywords and symbols
+		{`LET a=CODE "x"`, `l a symbol+l caps+symbol i symbol+p x symbol+p`},
+		{`BEEP 1,2`, `caps+symbol symbol+z 1 symbol+n 2`},
+		{`PRINT "[~]"`, `p symbol+p caps+symbol symbol+y caps+symbol symbol+a caps+symbol symbol+u symbol+p`},
+
+		// Keywords are not recognized within words, in strings and after REM
+		{`PRINT INTO`, `p caps+i caps+n caps+t caps+o`},
+		{`PRINT "TO"`, `p symbol+p caps+t caps+o symbol+p`},
+		{`REM PRINT x`, `e caps+p caps+r caps+i caps+n caps+t space x`},
+
+		// Block graphics in G mode
+		{`PRINT "▘█a"`, `p symbol+p caps+9 2 caps+8 caps+9 a symbol+p`},
+
+		// Lines are separated by ENTER, characters which cannot be typed are ignored
+		{"10 CLS\n20 GO TO 10\n", `1 0 v enter 2 0 g 1 0 enter`},
+		{"PRINT \"é\"", `p symbol+p symbol+p`},
+	}
+
+	for _, test := range tests {
+		if s := chordsString(TranslateText(test.text, true)); s != test.strokes {
+			t.Errorf("%q: expected %q, got %q", test.text, test.strokes, s)
+		}
+	}
+}
+
+func TestTranslatePlainText(t *testing.T) {
+	tests := []struct {
+		text    string
+		strokes string
+	}{
+		{"PRINT x", `caps+p caps+r caps+i caps+n caps+t space x`},
+		{"Ab1 ▗\n", `caps+a b 1 space caps+9 4 caps+9 enter`},
+		{"a:b=\"~\"", `a symbol+z b symbol+l symbol+p caps+symbol symbol+a symbol+p`},
+	}
+
+	for _, test := range tests {
+		if s := chordsString(TranslateText(test.text, false)); s != test.strokes {
+			t.Errorf("%q: expected %q, got %q", test.text, test.strokes, s)
+		}
+	}
+}
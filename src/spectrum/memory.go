@@ -1,14 +1,55 @@
 package spectrum
 
-import "github.com/remogatto/z80"
-
 type Memory struct {
-	data [0x10000]byte
+	data   [0x10000]byte
 	speccy *Spectrum48k
+
+	// The 16K pages visible at 0x0000, 0x4000, 0x8000 and 0xC000.
+	// On the 48K, the pages are slices of 'data'. A nil page is unattached memory (16K model).
+	pages          [4][]byte
+	pagesWritable  [4]bool
+	pagesContended [4]bool
+	pagesScreen    [4]bool // Whether the page holds the displayed screen
+
+	// The displayed screen (bitmap and attributes)
+	screen []byte
+
+	model Model
+
+	// The RAM banks and the ROMs of the 128K models (nil on the 48K)
+	banks [][]byte
+	roms  [][]byte
+
+	// The values written to the paging ports of the 128K models
+	port7ffd byte
+	port1ffd byte
+
+	// Memory paged over the system ROM by a peripheral, in two 8K pages
+	// (0x0000-0x1FFF and 0x2000-0x3FFF). A nil page means the system ROM.
+	romPaged         bool
+	romPages         [2][]byte
+	romPagesWritable [2]bool
+
+	// A device with registers mapped into the first 16K of memory
+	mappedDevice_orNil memoryMappedDevice
+
+	// The contention delays of the model ('Timing.delayTable')
+	delayTable []byte
+}
+
+// The banks paged in when bit 0 of port 0x1FFD is set (all-RAM configurations),
+// selected by bits 1-2 of the port
+var plus3_specialPaging = [4][4]int{
+	{0, 1, 2, 3},
+	{4, 5, 6, 7},
+	{4, 5, 6, 3},
+	{4, 7, 6, 3},
 }
 
 func NewMemory() *Memory {
-	return &Memory{}
+	memory := &Memory{model: MODEL_48K}
+	memory.updatePaging()
+	return memory
 }
 
 func (memory *Memory) init(speccy *Spectrum48k) {
@@ -19,40 +60,237 @@ func (memory *Memory) reset() {
 	for i := 0; i < 0x10000; i++ {
 		memory.data[i] = 0
 	}
+	for _, bank := range memory.banks {
+		for i := range bank {
+			bank[i] = 0
+		}
+	}
+	memory.port7ffd = 0
+	memory.port1ffd = 0
+	memory.updatePaging()
+	memory.unpageROM()
+}
+
+// Changes the memory layout to the one of the model.
+// The ROMs are used only by the 128K models.
+func (memory *Memory) setModel(model Model, roms [][]byte) {
+	memory.model = model
+	if model.banked() {
+		memory.banks = make([][]byte, 8)
+		for i := range memory.banks {
+			memory.banks[i] = make([]byte, 0x4000)
+		}
+		memory.roms = roms
+	} else {
+		memory.banks = nil
+		memory.roms = nil
+	}
+	memory.port7ffd = 0
+	memory.port1ffd = 0
+	memory.updatePaging()
+}
+
+// Determines the visible pages from the model and the paging ports
+func (memory *Memory) updatePaging() {
+	oldScreen := memory.screen
+
+	if !memory.model.banked() {
+		for i := range memory.pages {
+			memory.pages[i] = memory.data[i*0x4000 : (i+1)*0x4000]
+		}
+		memory.pagesWritable = [4]bool{false, true, true, true}
+		memory.pagesContended = [4]bool{false, true, false, false}
+		memory.pagesScreen = [4]bool{false, true, false, false}
+		memory.screen = memory.data[0x4000:0x5b00]
+
+		if memory.model == MODEL_16K {
+			memory.pages[2] = nil
+			memory.pages[3] = nil
+			memory.pagesWritable[2] = false
+			memory.pagesWritable[3] = false
+		}
+		return
+	}
+
+	var banks [4]int // -1 means ROM
+	if (memory.port1ffd & 0x01) != 0 {
+		banks = plus3_specialPaging[(memory.port1ffd>>1)&0x03]
+	} else {
+		banks = [4]int{-1, 5, 2, int(memory.port7ffd & 0x07)}
+	}
+
+	screenBank := 5
+	if (memory.port7ffd & 0x08) != 0 {
+		screenBank = 7
+	}
+
+	for i, bank := range banks {
+		if bank < 0 {
+			rom := int((memory.port7ffd>>4)&0x01) | int((memory.port1ffd>>1)&0x02)
+			memory.pages[i] = memory.roms[rom]
+			memory.pagesWritable[i] = false
+			memory.pagesContended[i] = false
+			memory.pagesScreen[i] = false
+		} else {
+			memory.pages[i] = memory.banks[bank]
+			memory.pagesWritable[i] = true
+			memory.pagesContended[i] = (bank >= 4)
+			memory.pagesScreen[i] = (bank == screenBank)
+		}
+	}
+
+	memory.screen = memory.banks[screenBank][0:0x1b00]
+	if (memory.speccy != nil) && (len(oldScreen) > 0) && (&oldScreen[0] != &memory.screen[0]) {
+		memory.speccy.ula.screenSwitched()
+	}
+}
+
+// Handles a write to port 0x7FFD.
+// Bits 0-2 select the bank at 0xC000, bit 3 the screen, bit 4 the low bit of the ROM,
+// bit 5 locks the paging until the next reset (except on the Pentagon).
+func (memory *Memory) write7ffd(b byte) {
+	if ((memory.port7ffd & 0x20) != 0) && (memory.model != MODEL_PENTAGON) {
+		return
+	}
+	memory.port7ffd = b
+	memory.updatePaging()
 }
 
+// Handles a write to port 0x1FFD.
+// Bit 0 selects the all-RAM configurations (bits 1-2), otherwise bit 2 is the high bit of the ROM.
+func (memory *Memory) write1ffd(b byte) {
+	if (memory.port7ffd & 0x20) != 0 {
+		return
+	}
+	memory.port1ffd = b
+	memory.updatePaging()
+}
+
+// Prepares the memory for running a 48K program, and fills the RAM (0x4000-0xFFFF).
+// The 128K models are switched to the 48 BASIC ROM, with the paging locked.
+func (memory *Memory) load48k(ram []byte) {
+	if memory.model.banked() {
+		if memory.model.hasPort1ffd() {
+			memory.port1ffd = 0x04
+		}
+		memory.port7ffd = 0x30
+		memory.updatePaging()
+	}
+	for i, b := range ram {
+		address := 0x4000 + i
+		if page := memory.pages[address>>14]; page != nil {
+			page[address&0x3fff] = b
+		}
+	}
+}
+
+// Copies the visible RAM (0x4000-0xFFFF) to 'ram'
+func (memory *Memory) dump48k(ram []byte) {
+	for i := range ram {
+		address := 0x4000 + i
+		if page := memory.pages[address>>14]; page != nil {
+			ram[i] = page[address&0x3fff]
+		} else {
+			ram[i] = 0xff
+		}
+	}
+}
+
+// Returns true if the 48 BASIC ROM (the last ROM of a banked model) is visible at 0x0000-0x3FFF
+func (memory *Memory) basicROMPaged() bool {
+	if !memory.model.banked() {
+		return true
+	}
+	basic := memory.roms[len(memory.roms)-1]
+	return (memory.port1ffd&0x01) == 0 && (&memory.pages[0][0] == &basic[0])
+}
+
+// Pages the 8K pages over the system ROM. A page can be nil (the system ROM remains visible).
+// A writable page can be modified by the Z80 (it is RAM).
+func (memory *Memory) pageROM(low, high []byte, lowWritable, highWritable bool) {
+	memory.romPages = [2][]byte{low, high}
+	memory.romPagesWritable = [2]bool{lowWritable && (low != nil), highWritable && (high != nil)}
+	memory.romPaged = (low != nil) || (high != nil)
+}
+
+// Makes the system ROM visible again
+func (memory *Memory) unpageROM() {
+	memory.pageROM(nil, nil, false, false)
+}
+
+// Reads a byte on behalf of the CPU. A device mapped into the ROM area sees the access.
 func (memory *Memory) ReadByteInternal(address uint16) byte {
-	return memory.data[address]
+	if (memory.mappedDevice_orNil != nil) && (address < 0x4000) {
+		return memory.mappedDevice_orNil.readMemory(address, memory.readByte(address))
+	}
+	return memory.readByte(address)
+}
+
+func (memory *Memory) readByte(address uint16) byte {
+	if memory.romPaged && (address < 0x4000) {
+		if page := memory.romPages[address>>13]; page != nil {
+			return page[address&0x1fff]
+		}
+	}
+	page := memory.pages[address>>14]
+	if page == nil {
+		return memory.speccy.ula.floatingBus()
+	}
+	return page[address&0x3fff]
 }
 
 func (memory *Memory) WriteByteInternal(address uint16, b byte) {
-	if (address >= SCREEN_BASE_ADDR) && (address < ATTR_BASE_ADDR) {
-		memory.speccy.ula.screenBitmapWrite(address, memory.data[address], b)
-	} else if (address >= ATTR_BASE_ADDR) && (address < 0x5b00) {
-		memory.speccy.ula.screenAttrWrite(address, memory.data[address], b)
+	if (memory.mappedDevice_orNil != nil) && (address < 0x4000) {
+		memory.mappedDevice_orNil.writeMemory(address, b)
+	}
+
+	if memory.romPaged && (address < 0x4000) {
+		if page := memory.romPages[address>>13]; page != nil {
+			if memory.romPagesWritable[address>>13] {
+				page[address&0x1fff] = b
+			}
+			return
+		}
 	}
 
-	if address >= 0x4000 {
-		memory.data[address] = b
+	i := address >> 14
+	if !memory.pagesWritable[i] {
+		return
 	}
+
+	page := memory.pages[i]
+	ofs := address & 0x3fff
+	if memory.pagesScreen[i] {
+		if ofs < ATTR_BASE_ADDR-SCREEN_BASE_ADDR {
+			memory.speccy.ula.screenBitmapWrite(SCREEN_BASE_ADDR+ofs, page[ofs], b)
+		} else if ofs < 0x1b00 {
+			memory.speccy.ula.screenAttrWrite(SCREEN_BASE_ADDR+ofs, page[ofs], b)
+		}
+	}
+	page[ofs] = b
 }
 
 func (memory *Memory) ReadByte(address uint16) byte {
-	contendMemory(memory.speccy.Cpu, address, 3)
+	memory.contend(address, 3)
 	return memory.ReadByteInternal(address)
 }
 
 func (memory *Memory) WriteByte(address uint16, b byte) {
-	contendMemory(memory.speccy.Cpu, address, 3)
+	memory.contend(address, 3)
 	memory.WriteByteInternal(address, b)
 }
 
-func contendMemory(z80 *z80.Z80, address uint16, time int) {
-	tstates_p := &z80.Tstates
+// Returns true if the Z80 is delayed when accessing the address
+func (memory *Memory) isContended(address uint16) bool {
+	return memory.pagesContended[address>>14]
+}
+
+func (memory *Memory) contend(address uint16, time int) {
+	tstates_p := &memory.speccy.Cpu.Tstates
 	tstates := *tstates_p
 
-	if (address & 0xc000) == 0x4000 {
-		tstates += int(delay_table[tstates])
+	if memory.pagesContended[address>>14] {
+		tstates += int(memory.delayTable[tstates])
 	}
 
 	tstates += time
@@ -60,14 +298,14 @@ func contendMemory(z80 *z80.Z80, address uint16, time int) {
 	*tstates_p = tstates
 }
 
-// Equivalent to executing "contendMemory(z80, address, time)" count times
-func contendMemory_loop(z80 *z80.Z80, address uint16, time int, count uint) {
-	tstates_p := &z80.Tstates
+// Equivalent to executing "memory.contend(address, time)" count times
+func (memory *Memory) contend_loop(address uint16, time int, count uint) {
+	tstates_p := &memory.speccy.Cpu.Tstates
 	tstates := *tstates_p
 
-	if (address & 0xc000) == 0x4000 {
+	if memory.pagesContended[address>>14] {
 		for i := uint(0); i < count; i++ {
-			tstates += int(delay_table[tstates])
+			tstates += int(memory.delayTable[tstates])
 			tstates += time
 		}
 	} else {
@@ -78,62 +316,47 @@ func contendMemory_loop(z80 *z80.Z80, address uint16, time int, count uint) {
 }
 
 func (memory *Memory) ContendRead(address uint16, time int) {
-	contendMemory(memory.speccy.Cpu, address, time)
+	memory.contend(address, time)
 }
 
 func (memory *Memory) ContendReadNoMreq(address uint16, time int) {
-	contendMemory(memory.speccy.Cpu, address, time)
+	memory.contend(address, time)
 }
 
 func (memory *Memory) ContendReadNoMreq_loop(address uint16, time int, count uint) {
-	contendMemory_loop(memory.speccy.Cpu, address, time, count)
+	memory.contend_loop(address, time, count)
 }
 
 func (memory *Memory) ContendWriteNoMreq(address uint16, time int) {
-	contendMemory(memory.speccy.Cpu, address, time)
+	memory.contend(address, time)
 }
 
 func (memory *Memory) ContendWriteNoMreq_loop(address uint16, time int, count uint) {
-	contendMemory_loop(memory.speccy.Cpu, address, time, count)
+	memory.contend_loop(address, time, count)
 }
 
+// Reads a byte without side effects, for the debugger and the interpreter
 func (memory *Memory) Read(address uint16) byte {
-	return memory.data[address]
+	return memory.readByte(address)
 }
 
 func (memory *Memory) Write(address uint16, value byte, protectROM bool) {
-	if (address >= 0x4000) || !protectROM {
-		memory.data[address] = value
+	i := address >> 14
+	if (memory.pagesWritable[i] || !protectROM) && (memory.pages[i] != nil) {
+		memory.pages[i][address&0x3fff] = value
 	}
 }
 
+// Returns the 64K of memory. On the 128K models, the returned slice
+// is a copy of the visible pages.
 func (memory *Memory) Data() []byte {
-	return memory.data[:]
-}
-
-// Number of T-states to delay, for each possible T-state within a frame.
-// The array is extended at the end - this covers the case when the emulator
-// begins to execute an instruction at Tstate=(TStatesPerFrame-1). Such an
-// instruction will finish at (TStatesPerFrame-1+4) or later.
-var delay_table [TStatesPerFrame + 100]byte
-
-// Initialize 'delay_table' at program startup
-func init() {
-	// Note: The language automatically initialized all values
-	//       of the 'delay_table' array to zeroes. So, we only
-	//       have to modify the non-zero elements.
-
-	tstate := FIRST_SCREEN_BYTE - 1
-	for y := 0; y < ScreenHeight; y++ {
-		for x := 0; x < ScreenWidth; x += 16 {
-			tstate_x := x / PIXELS_PER_TSTATE
-			delay_table[tstate+tstate_x+0] = 6
-			delay_table[tstate+tstate_x+1] = 5
-			delay_table[tstate+tstate_x+2] = 4
-			delay_table[tstate+tstate_x+3] = 3
-			delay_table[tstate+tstate_x+4] = 2
-			delay_table[tstate+tstate_x+5] = 1
-		}
-		tstate += TSTATES_PER_LINE
+	if !memory.model.banked() {
+		return memory.data[:]
+	}
+
+	data := make([]byte, 0x10000)
+	for i, page := range memory.pages {
+		copy(data[i*0x4000:], page)
 	}
+	return data
 }
@@ -0,0 +1,100 @@
+package spectrum
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Returns a description of the page: "rom<N>", "bank<N>", or "?"
+func pageName(memory *Memory, page []byte) string {
+	for i, rom := range memory.roms {
+		if &page[0] == &rom[0] {
+			return fmt.Sprintf("rom%d", i)
+		}
+	}
+	for i, bank := range memory.banks {
+		if &page[0] == &bank[0] {
+			return fmt.Sprintf("bank%d", i)
+		}
+	}
+	return "?"
+}
+
+func TestPlus3Paging(t *testing.T) {
+	roms := make([][]byte, MODEL_PLUS3.NumROMs())
+	for i := range roms {
+		roms[i] = make([]byte, 0x4000)
+	}
+
+	memory := NewMemory()
+	memory.setModel(MODEL_PLUS3, roms)
+
+	tests := []struct {
+		port7ffd, port1ffd byte
+		pages              [4]string
+		contended          [4]bool
+		screenBank         int
+	}{
+		// The normal configurations: ROM (selected by bit 4 of 0x7FFD and bit 2 of 0x1FFD), 5, 2, bank at 0xC000
+		{0x00, 0x00, [4]string{"rom0", "bank5", "bank2", "bank0"}, [4]bool{false, true, false, false}, 5},
+		{0x10, 0x00, [4]string{"rom1", "bank5", "bank2", "bank0"}, [4]bool{false, true, false, false}, 5},
+		{0x00, 0x04, [4]string{"rom2", "bank5", "bank2", "bank0"}, [4]bool{false, true, false, false}, 5},
+		{0x17, 0x04, [4]string{"rom3", "bank5", "bank2", "bank7"}, [4]bool{false, true, false, true}, 5},
+		{0x0c, 0x00, [4]string{"rom0", "bank5", "bank2", "bank4"}, [4]bool{false, true, false, true}, 7},
+
+		// The all-RAM configurations, selected by bits 1-2 of 0x1FFD
+		{0x00, 0x01, [4]string{"bank0", "bank1", "bank2", "bank3"}, [4]bool{false, false, false, false}, 5},
+		{0x00, 0x03, [4]string{"bank4", "bank5", "bank6", "bank7"}, [4]bool{true, true, true, true}, 5},
+		{0x00, 0x05, [4]string{"bank4", "bank5", "bank6", "bank3"}, [4]bool{true, true, true, false}, 5},
+		{0x08, 0x07, [4]string{"bank4", "bank7", "bank6", "bank3"}, [4]bool{true, true, true, false}, 7},
+	}
+
+	for _, test := range tests {
+		memory.write1ffd(test.port1ffd)
+		memory.write7ffd(test.port7ffd)
+
+		for i, expected := range test.pages {
+			if name := pageName(memory, memory.pages[i]); name != expected {
+				t.Errorf("0x7FFD=0x%02x 0x1FFD=0x%02x: expected %s at 0x%04x, got %s",
+					test.port7ffd, test.port1ffd, expected, i*0x4000, name)
+			}
+			if memory.pagesWritable[i] != (expected[0:4] == "bank") {
+				t.Errorf("0x7FFD=0x%02x 0x1FFD=0x%02x: 0x%04x has the wrong write protection",
+					test.port7ffd, test.port1ffd, i*0x4000)
+			}
+			if memory.pagesContended[i] != test.contended[i] {
+				t.Errorf("0x7FFD=0x%02x 0x1FFD=0x%02x: 0x%04x has the wrong contention",
+					test.port7ffd, test.port1ffd, i*0x4000)
+			}
+		}
+
+		screen := memory.banks[test.screenBank]
+		if &memory.screen[0] != &screen[0] {
+			t.Errorf("0x7FFD=0x%02x 0x1FFD=0x%02x: expected the screen in bank %d",
+				test.port7ffd, test.port1ffd, test.screenBank)
+		}
+	}
+
+	// Bit 5 of 0x7FFD locks both ports
+	memory.write1ffd(0x00)
+	memory.write7ffd(0x21)
+	memory.write7ffd(0x03)
+	memory.write1ffd(0x01)
+	if name := pageName(memory, memory.pages[3]); name != "bank1" {
+		t.Errorf("the paging is not locked, expected bank1 at 0xC000, got %s", name)
+	}
+	if name := pageName(memory, memory.pages[0]); name != "rom0" {
+		t.Errorf("the paging is not locked, expected rom0 at 0x0000, got %s", name)
+	}
+
+	// The Pentagon ignores the lock
+	memory.setModel(MODEL_PENTAGON, roms[0:2])
+	memory.write7ffd(0x21)
+	memory.write7ffd(0x13)
+	if name := pageName(memory, memory.pages[3]); name != "bank3" {
+		t.Errorf("expected bank3 at 0xC000 on the Pentagon, got %s", name)
+	}
+	if name := pageName(memory, memory.pages[0]); name != "rom1" {
+		t.Errorf("expected rom1 at 0x0000 on the Pentagon, got %s", name)
+	}
+}
@@ -0,0 +1,198 @@
+package spectrum
+
+import (
+	"github.com/remogatto/gospeccy/src/formats"
+	"io/ioutil"
+	"os"
+)
+
+// A Microdrive connected to the Interface 1.
+//
+// The tape of a cartridge is a loop of blocks. The even blocks are sector headers (15 bytes),
+// the odd blocks are the sector data (528 bytes). Each block is preceded by a gap and a sync pattern,
+// which the Interface 1 reports through the status port.
+const (
+	MDR_GAP_LEN      = 15 // Number of status reads reporting the gap
+	MDR_SYNC_LEN     = 15 // Number of status reads reporting the sync pattern
+	MDR_PREAMBLE_LEN = 12 // Number of bytes written before a block (10 zeroes and 2 0xff)
+)
+
+type Microdrive struct {
+	cartridge_orNil *formats.MDR
+	path            string
+	modified        bool
+
+	// Whether each block has been formatted (written at least once)
+	formatted []bool
+
+	motorOn bool
+
+	// Position of the head
+	block  int
+	offset int
+
+	gap  int
+	sync int
+
+	// The number of bytes written since the Interface 1 started writing
+	written int
+}
+
+// Inserts the cartridge stored in the specified file.
+// If the file does not exist, a blank cartridge is inserted.
+func (drive *Microdrive) insert(path string) error {
+	var mdr *formats.MDR
+	formatted := true
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		mdr = formats.NewBlankMDR()
+		formatted = false
+	} else {
+		mdr, err = formats.NewMDR(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	drive.cartridge_orNil = mdr
+	drive.path = path
+	drive.modified = false
+	drive.formatted = make([]bool, 2*len(mdr.Sectors))
+	for i := range drive.formatted {
+		drive.formatted[i] = formatted
+	}
+	drive.block = 0
+	drive.offset = 0
+	drive.gap = MDR_GAP_LEN
+	drive.sync = MDR_SYNC_LEN
+
+	return nil
+}
+
+// Ejects the cartridge, saving it if it has been modified
+func (drive *Microdrive) eject() error {
+	err := drive.save()
+	drive.cartridge_orNil = nil
+	drive.formatted = nil
+	return err
+}
+
+// Writes the cartridge to its file if it has been modified
+func (drive *Microdrive) save() error {
+	if (drive.cartridge_orNil == nil) || !drive.modified {
+		return nil
+	}
+
+	err := ioutil.WriteFile(drive.path, drive.cartridge_orNil.Encode(), 0644)
+	if err != nil {
+		return err
+	}
+
+	drive.modified = false
+	return nil
+}
+
+func (drive *Microdrive) running() bool {
+	return drive.motorOn && (drive.cartridge_orNil != nil)
+}
+
+// Returns the bytes of the block under the head
+func (drive *Microdrive) blockData() []byte {
+	sector := &drive.cartridge_orNil.Sectors[drive.block/2]
+	if (drive.block % 2) == 0 {
+		return sector[0:formats.MDR_HEADER_LEN]
+	}
+	return sector[formats.MDR_HEADER_LEN:]
+}
+
+// Moves the head to the start of the next block
+func (drive *Microdrive) nextBlock() {
+	drive.block = (drive.block + 1) % len(drive.formatted)
+	drive.offset = 0
+	drive.gap = MDR_GAP_LEN
+	drive.sync = MDR_SYNC_LEN
+}
+
+// Returns the value of the status port bits (0 = write protect, 1 = sync, 2 = gap)
+// and moves the tape
+func (drive *Microdrive) status() byte {
+	var status byte = 0xff
+
+	if (drive.offset != 0) || !drive.formatted[drive.block] {
+		drive.nextBlock()
+	}
+
+	if drive.formatted[drive.block] {
+		if drive.gap > 0 {
+			drive.gap--
+		} else {
+			status &= 0xf9
+			if drive.sync > 0 {
+				drive.sync--
+			} else {
+				drive.nextBlock()
+			}
+		}
+	}
+
+	if drive.cartridge_orNil.WriteProtected {
+		status &= 0xfe
+	}
+
+	return status
+}
+
+// Reads the next byte of the block under the head
+func (drive *Microdrive) readData() byte {
+	data := drive.blockData()
+	if drive.offset >= len(data) {
+		return 0xff
+	}
+
+	b := data[drive.offset]
+	drive.offset++
+	return b
+}
+
+// Called when the Interface 1 switches the drive into write mode
+func (drive *Microdrive) startWriting() {
+	even := ((drive.block % 2) == 0)
+
+	switch {
+	case even && (drive.offset == formats.MDR_HEADER_LEN):
+		// The header has just been read, the data block follows
+		drive.nextBlock()
+	case drive.offset == 0:
+		// At the start of a block
+	default:
+		drive.nextBlock()
+		if (drive.block % 2) != 0 {
+			drive.nextBlock()
+		}
+	}
+
+	drive.written = 0
+}
+
+func (drive *Microdrive) writeData(b byte) {
+	if drive.cartridge_orNil.WriteProtected {
+		return
+	}
+
+	drive.written++
+	if drive.written <= MDR_PREAMBLE_LEN {
+		return
+	}
+
+	data := drive.blockData()
+	if drive.offset < len(data) {
+		data[drive.offset] = b
+		drive.offset++
+		drive.formatted[drive.block] = true
+		drive.modified = true
+	}
+}
@@ -0,0 +1,213 @@
+package spectrum
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// The emulated Spectrum models.
+//
+// The 16K has the ROM of the 48K, but only 16K of RAM: nothing is attached to 0x8000-0xFFFF,
+// and reading from there returns the value floating on the data bus.
+// The NTSC 48K differs from the 48K only in its timing (60 Hz frames).
+//
+// The +2A and the +3 have eight 16K RAM banks and four 16K ROMs
+// (0 = editor, 1 = syntax checker, 2 = +3DOS, 3 = 48 BASIC), paged by the ports 0x7FFD and 0x1FFD.
+// The +3 additionally has a uPD765A floppy disk controller with two drives.
+//
+// The Pentagon 128, a Russian clone, has eight 16K RAM banks and two 16K ROMs (0 = 128 BASIC,
+// 1 = 48 BASIC), paged by the port 0x7FFD. It ignores the bit locking the paging,
+// and its memory is not contended.
+//
+// All the 128K models have an AY-3-8912 sound chip.
+type Model int
+
+const (
+	MODEL_48K Model = iota
+	MODEL_PLUS2A
+	MODEL_PLUS3
+	MODEL_16K
+	MODEL_NTSC48K
+	MODEL_PENTAGON
+)
+
+// The names of the models, as used on the command-line and in the interpreter
+var ModelNames = map[string]Model{
+	"16k":      MODEL_16K,
+	"48k":      MODEL_48K,
+	"48k-ntsc": MODEL_NTSC48K,
+	"pentagon": MODEL_PENTAGON,
+	"plus2a":   MODEL_PLUS2A,
+	"plus3":    MODEL_PLUS3,
+}
+
+// Number of frames after a reset when the ROM of the +2A/+3 is assumed
+// to have finished initializing (the menu is shown)
+const plus3_bootFrames = 100
+
+func (model Model) String() string {
+	for name, m := range ModelNames {
+		if m == model {
+			return name
+		}
+	}
+	return fmt.Sprintf("model %d", int(model))
+}
+
+// The name of the file holding the ROMs of the model (all ROMs concatenated)
+func (model Model) ROMFile() string {
+	switch model {
+	case MODEL_PLUS2A:
+		return "plus2a.rom"
+	case MODEL_PLUS3:
+		return "plus3.rom"
+	case MODEL_PENTAGON:
+		return "pentagon.rom"
+	}
+	return "48.rom"
+}
+
+// Returns the number of 16K ROMs of the model
+func (model Model) NumROMs() int {
+	switch {
+	case model == MODEL_PENTAGON:
+		return 2
+	case model.banked():
+		return 4
+	}
+	return 1
+}
+
+// Returns true if the model has 128K of paged memory
+func (model Model) banked() bool {
+	return (model == MODEL_PLUS2A) || (model == MODEL_PLUS3) || (model == MODEL_PENTAGON)
+}
+
+// Returns true if the model has the port 0x1FFD
+func (model Model) hasPort1ffd() bool {
+	return (model == MODEL_PLUS2A) || (model == MODEL_PLUS3)
+}
+
+// Returns the timing of the model
+func (model Model) Timing() *Timing {
+	switch model {
+	case MODEL_NTSC48K:
+		return TimingNTSC48K
+	case MODEL_PLUS2A, MODEL_PLUS3:
+		return TimingPlus3
+	case MODEL_PENTAGON:
+		return TimingPentagon
+	}
+	return Timing48K
+}
+
+// Reads the ROMs of the model from the specified file, and splits them into 16K ROMs
+func ReadModelROMs(model Model, path string) ([][]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != model.NumROMs()*0x4000 {
+		return nil, fmt.Errorf("%s: invalid ROM file for the %s", path, model)
+	}
+
+	roms := make([][]byte, model.NumROMs())
+	for i := range roms {
+		roms[i] = data[i*0x4000 : (i+1)*0x4000]
+	}
+	return roms, nil
+}
+
+type Cmd_SetModel struct {
+	Model   Model
+	ROMs    [][]byte
+	ErrChan chan<- error
+}
+
+// Called in the emulation goroutine
+func (speccy *Spectrum48k) setModel(model Model, roms [][]byte) error {
+	if len(roms) != model.NumROMs() {
+		return fmt.Errorf("the %s needs %d ROMs", model, model.NumROMs())
+	}
+	for _, rom := range roms {
+		if len(rom) != 0x4000 {
+			return errors.New("invalid ROM size")
+		}
+	}
+
+	speccy.removeCartridge()
+	if !model.banked() {
+		copy(speccy.rom[:], roms[0])
+	}
+	speccy.Memory.setModel(model, roms)
+	speccy.setTiming(model.Timing())
+
+	speccy.model_mutex.Lock()
+	oldFDC := speccy.fdc_orNil
+	speccy.model = model
+	speccy.fdc_orNil = nil
+	if model == MODEL_PLUS3 {
+		speccy.fdc_orNil = newFDC(speccy.app)
+	}
+	speccy.model_mutex.Unlock()
+
+	if oldFDC != nil {
+		oldFDC.ejectAll()
+	}
+
+	speccy.ay_orNil = nil
+	if model.banked() {
+		speccy.ay_orNil = newAY(speccy)
+	}
+	speccy.clearRewindBuffer()
+
+	speccy.reset(nil)
+
+	if speccy.app.Verbose {
+		speccy.app.PrintfMsg("model: %s", model)
+	}
+	return nil
+}
+
+// Switches the emulated machine to the specified model, and resets it.
+// The ROMs are the 16K ROMs returned by ReadModelROMs.
+func (speccy *Spectrum48k) SetModel(model Model, roms [][]byte) error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_SetModel{model, roms, errChan}
+	return <-errChan
+}
+
+// Returns the emulated model
+func (speccy *Spectrum48k) Model() Model {
+	speccy.model_mutex.Lock()
+	defer speccy.model_mutex.Unlock()
+	return speccy.model
+}
+
+// Inserts the disk image stored in the specified file into the drive (0 = A:, 1 = B:) of the +3.
+// If the file does not exist, an unformatted disk is inserted and it will be created
+// when the disk is written.
+func (speccy *Spectrum48k) InsertDisk(drive uint, path string) error {
+	fdc := speccy.fdc()
+	if fdc == nil {
+		return fmt.Errorf("the %s has no disk drives", speccy.Model())
+	}
+	return fdc.InsertDisk(drive, path)
+}
+
+// Ejects the disk from the drive (0 = A:, 1 = B:) of the +3
+func (speccy *Spectrum48k) EjectDisk(drive uint) error {
+	fdc := speccy.fdc()
+	if fdc == nil {
+		return fmt.Errorf("the %s has no disk drives", speccy.Model())
+	}
+	return fdc.EjectDisk(drive)
+}
+
+// Returns the floppy disk controller, or nil
+func (speccy *Spectrum48k) fdc() *FDC {
+	speccy.model_mutex.Lock()
+	defer speccy.model_mutex.Unlock()
+	return speccy.fdc_orNil
+}
@@ -0,0 +1,128 @@
+package spectrum
+
+import (
+	"errors"
+	"sync"
+)
+
+// Kempston mouse buttons
+const (
+	MOUSE_LEFT = iota
+	MOUSE_RIGHT
+	MOUSE_MIDDLE
+)
+
+// Masks of the bits in the button port (0xFADF), the bits are active low
+var mouseButtonMask = map[uint]byte{
+	MOUSE_RIGHT:  0x01,
+	MOUSE_LEFT:   0x02,
+	MOUSE_MIDDLE: 0x04,
+}
+
+const DEFAULT_MOUSE_SENSITIVITY = 1.0
+
+// Kempston mouse.
+// The position is a pair of 8-bit counters which wrap around.
+// The Y counter increases when the mouse moves up.
+type Mouse struct {
+	speccy *Spectrum48k
+	mutex  sync.RWMutex
+
+	x, y    byte
+	buttons byte // The pressed buttons (a combination of 'mouseButtonMask' values)
+
+	// Mouse units per host unit
+	sensitivity float32
+
+	// The movement not yet applied to the counters
+	remainderX, remainderY float32
+}
+
+func NewMouse() *Mouse {
+	mouse := &Mouse{sensitivity: DEFAULT_MOUSE_SENSITIVITY}
+	mouse.reset()
+	return mouse
+}
+
+func (mouse *Mouse) init(speccy *Spectrum48k) {
+	mouse.speccy = speccy
+}
+
+func (mouse *Mouse) reset() {
+	mouse.mutex.Lock()
+	mouse.x, mouse.y = 0, 0
+	mouse.buttons = 0
+	mouse.remainderX, mouse.remainderY = 0, 0
+	mouse.mutex.Unlock()
+}
+
+// Moves the mouse. The values are in host units, positive 'dy' means down.
+func (mouse *Mouse) Move(dx, dy float32) {
+	mouse.mutex.Lock()
+	defer mouse.mutex.Unlock()
+
+	mouse.remainderX += dx * mouse.sensitivity
+	mouse.remainderY -= dy * mouse.sensitivity
+
+	// Truncate towards zero, keep the fractional part for the next move
+	ix, iy := int(mouse.remainderX), int(mouse.remainderY)
+	mouse.remainderX -= float32(ix)
+	mouse.remainderY -= float32(iy)
+
+	mouse.x += byte(ix)
+	mouse.y += byte(iy)
+}
+
+func (mouse *Mouse) ButtonDown(button uint) {
+	mouse.mutex.Lock()
+	mouse.buttons |= mouseButtonMask[button]
+	mouse.mutex.Unlock()
+}
+
+func (mouse *Mouse) ButtonUp(button uint) {
+	mouse.mutex.Lock()
+	mouse.buttons &= ^mouseButtonMask[button]
+	mouse.mutex.Unlock()
+}
+
+func (mouse *Mouse) SetSensitivity(sensitivity float32) error {
+	if sensitivity <= 0 {
+		return errors.New("mouse sensitivity must be greater than 0")
+	}
+
+	mouse.mutex.Lock()
+	mouse.sensitivity = sensitivity
+	mouse.mutex.Unlock()
+	return nil
+}
+
+func (mouse *Mouse) Sensitivity() float32 {
+	mouse.mutex.RLock()
+	sensitivity := mouse.sensitivity
+	mouse.mutex.RUnlock()
+	return sensitivity
+}
+
+// Returns the value read from port 0xFBDF
+func (mouse *Mouse) GetX() byte {
+	mouse.mutex.RLock()
+	x := mouse.x
+	mouse.mutex.RUnlock()
+	return x
+}
+
+// Returns the value read from port 0xFFDF
+func (mouse *Mouse) GetY() byte {
+	mouse.mutex.RLock()
+	y := mouse.y
+	mouse.mutex.RUnlock()
+	return y
+}
+
+// Returns the value read from port 0xFADF
+func (mouse *Mouse) GetButtons() byte {
+	mouse.mutex.RLock()
+	buttons := mouse.buttons
+	mouse.mutex.RUnlock()
+	return ^buttons
+}
@@ -0,0 +1,173 @@
+package spectrum
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// The Multiface One, Multiface 128 and Multiface 3.
+//
+// The Multiface has an 8K ROM and 8K RAM, paged over the system ROM (ROM at 0x0000-0x1FFF,
+// RAM at 0x2000-0x3FFF) when its red button generates an NMI. The Multiface software
+// pages the memory in and out by reading from the ports:
+//
+//	Multiface One: IN 0x9F pages in, IN 0x1F pages out (the Kempston joystick still responds)
+//	Multiface 128: IN 0xBF pages in, IN 0x3F pages out
+//	Multiface 3:   IN 0x3F pages in, IN 0xBF pages out
+//
+// The ROM image is not included with GoSpeccy.
+const MULTIFACE_MEMORY_SIZE = 0x2000
+
+type MultifaceModel int
+
+const (
+	MULTIFACE_ONE MultifaceModel = iota
+	MULTIFACE_128
+	MULTIFACE_3
+)
+
+// The names of the Multiface models, as used on the command-line
+var MultifaceModelNames = map[string]MultifaceModel{
+	"one": MULTIFACE_ONE,
+	"128": MULTIFACE_128,
+	"3":   MULTIFACE_3,
+}
+
+// The name of the file holding the ROM of the model
+func (model MultifaceModel) ROMFile() string {
+	switch model {
+	case MULTIFACE_128:
+		return "mf128.rom"
+	case MULTIFACE_3:
+		return "mf3.rom"
+	}
+	return "mf1.rom"
+}
+
+// The ports paging the memory in and out
+func (model MultifaceModel) ports() (pageIn, pageOut uint16) {
+	switch model {
+	case MULTIFACE_128:
+		return 0xbf, 0x3f
+	case MULTIFACE_3:
+		return 0x3f, 0xbf
+	}
+	return 0x9f, 0x1f
+}
+
+type Multiface struct {
+	model MultifaceModel
+	rom   []byte
+	ram   []byte
+
+	speccy *Spectrum48k
+
+	// Whether the memory is paged in
+	active bool
+}
+
+// Reads the 8K Multiface ROM from the specified file
+func ReadMultifaceROM(path string) ([]byte, error) {
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rom) != MULTIFACE_MEMORY_SIZE {
+		return nil, errors.New(path + ": invalid Multiface ROM file")
+	}
+	return rom, nil
+}
+
+// Creates a Multiface with the specified ROM
+func NewMultiface(model MultifaceModel, rom []byte) (*Multiface, error) {
+	if len(rom) != MULTIFACE_MEMORY_SIZE {
+		return nil, errors.New("invalid Multiface ROM size")
+	}
+	return &Multiface{model: model, rom: rom, ram: make([]byte, MULTIFACE_MEMORY_SIZE)}, nil
+}
+
+func (mf *Multiface) Name() string {
+	return "multiface"
+}
+
+func (mf *Multiface) attach(speccy *Spectrum48k) error {
+	for _, name := range []string{"divmmc", "divide", "beta128", "if1"} {
+		if speccy.findPeripheral(name) != nil {
+			return fmt.Errorf("the Multiface cannot be connected while the %s is connected", name)
+		}
+	}
+	mf.speccy = speccy
+	return nil
+}
+
+func (mf *Multiface) detach() {
+	mf.pageOut()
+}
+
+func (mf *Multiface) reset() {
+	mf.pageOut()
+}
+
+// Called when the NMI is accepted (the red button has been pressed)
+func (mf *Multiface) nmi() {
+	mf.pageIn()
+}
+
+func (mf *Multiface) pageIn() {
+	if !mf.active {
+		mf.speccy.Memory.pageROM(mf.rom, mf.ram, false, true)
+		mf.active = true
+	}
+}
+
+func (mf *Multiface) pageOut() {
+	if mf.active {
+		mf.speccy.Memory.unpageROM()
+		mf.active = false
+	}
+}
+
+func (mf *Multiface) saveState() interface{} {
+	return mf.active
+}
+
+func (mf *Multiface) restoreState(state interface{}) {
+	mf.active = false
+	if state.(bool) {
+		mf.pageIn()
+	}
+}
+
+func (mf *Multiface) rewindRAM() [][]byte {
+	return [][]byte{mf.ram}
+}
+
+func (mf *Multiface) encodeState(state interface{}) []byte {
+	return encodeFlags(state.(bool))
+}
+
+func (mf *Multiface) decodeState(data []byte) (interface{}, error) {
+	flags, err := decodeFlags(data, 1)
+	if err != nil {
+		return nil, err
+	}
+	return flags[0], nil
+}
+
+func (mf *Multiface) readPort(address uint16) (byte, bool) {
+	pageIn, pageOut := mf.model.ports()
+
+	switch address & 0x00ff {
+	case pageIn:
+		mf.pageIn()
+	case pageOut:
+		mf.pageOut()
+	}
+
+	// The Multiface does not drive the data bus
+	return 0, false
+}
+
+func (mf *Multiface) writePort(address uint16, b byte) {
+}
@@ -0,0 +1,502 @@
+package spectrum
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// Lockstep netplay between two emulators connected over TCP.
+//
+// The host waits for the peer to connect, and sends it a snapshot of the machine (SNA)
+// and the input delay. Both emulators load the snapshot, then exchange the input of each frame:
+// the state of the keyboard rows and of the Kempston and Fuller joysticks (the Sinclair and Cursor
+// joysticks are part of the keyboard rows). The input sampled before frame N is applied to frame
+// N+delay, so that the peer has time to receive it. A frame is emulated only when the inputs of both
+// players are available, and the machines read the combined input of both players.
+//
+// Every 'netplay_checksumInterval' frames, both emulators send a checksum of the memory and the
+// registers. Different checksums mean that the machines have diverged (for example, because a different
+// ROM or peripheral is connected), and netplay stops. It also stops when the connection is closed.
+// Both emulators then continue on their own.
+//
+// The messages are: the type (1 byte), the frame (4 bytes), the length of the data (4 bytes), the data.
+const NETPLAY_DEFAULT_DELAY = 2 // Frames
+
+const (
+	netplay_msgSnapshot = 'S' // Data: the input delay (1 byte), the length of the model name (1 byte), the model name, the SNA snapshot
+	netplay_msgInput    = 'I' // Data: the keyboard rows (8 bytes), Kempston, Fuller
+	netplay_msgChecksum = 'C' // Data: the CRC-32 (4 bytes)
+
+	netplay_checksumInterval = 50 // Frames
+	netplay_maxMessageLength = 0x10000
+)
+
+type netplayInput struct {
+	keys     [8]byte // Active low
+	kempston byte    // Active high
+	fuller   byte    // Active low
+}
+
+// The input when nothing is pressed
+var netplay_idleInput = netplayInput{
+	keys:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	kempston: 0x00,
+	fuller:   0xff,
+}
+
+func (in netplayInput) encode() []byte {
+	return append(in.keys[:], in.kempston, in.fuller)
+}
+
+func decodeNetplayInput(data []byte) (netplayInput, error) {
+	var in netplayInput
+	if len(data) != 10 {
+		return in, errors.New("invalid netplay input")
+	}
+	copy(in.keys[:], data[0:8])
+	in.kempston = data[8]
+	in.fuller = data[9]
+	return in, nil
+}
+
+// Returns the input of both players pressed together
+func (in netplayInput) merge(other netplayInput) netplayInput {
+	for row := range in.keys {
+		in.keys[row] &= other.keys[row]
+	}
+	in.kempston |= other.kempston
+	in.fuller &= other.fuller
+	return in
+}
+
+type netplay struct {
+	conn  net.Conn
+	delay int
+
+	// The next frame to emulate, and whether the input sampled before the frame has been sent
+	frame int
+	sent  bool
+
+	// The local inputs of the frames which have not been emulated yet, indexed by the frame
+	local map[int]netplayInput
+
+	// The combined input of the frame being emulated
+	input netplayInput
+
+	// The local checksums waiting for the checksums of the peer, indexed by the frame
+	checksums map[int]uint32
+
+	// Written by the goroutine receiving the messages of the peer
+	mutex           sync.Mutex
+	remote          map[int]netplayInput
+	remoteChecksums map[int]uint32
+	err_orNil       error
+}
+
+type Cmd_StartNetplay struct {
+	netplay  *netplay
+	snapshot formats.Snapshot
+	errChan  chan<- error
+}
+
+type Cmd_StopNetplay struct{}
+
+func newNetplay(conn net.Conn, delay int) *netplay {
+	np := &netplay{
+		conn:            conn,
+		delay:           delay,
+		local:           make(map[int]netplayInput),
+		input:           netplay_idleInput,
+		checksums:       make(map[int]uint32),
+		remote:          make(map[int]netplayInput),
+		remoteChecksums: make(map[int]uint32),
+	}
+
+	// Nothing is pressed during the first frames
+	for frame := 0; frame < delay; frame++ {
+		np.local[frame] = netplay_idleInput
+		np.remote[frame] = netplay_idleInput
+	}
+
+	return np
+}
+
+func writeNetplayMessage(w io.Writer, kind byte, frame int, data []byte) error {
+	var header [9]byte
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:5], uint32(frame))
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(data)))
+
+	_, err := w.Write(append(header[:], data...))
+	return err
+}
+
+func readNetplayMessage(r io.Reader) (kind byte, frame int, data []byte, err error) {
+	var header [9]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+
+	kind = header[0]
+	frame = int(binary.BigEndian.Uint32(header[1:5]))
+	length := binary.BigEndian.Uint32(header[5:9])
+	if length > netplay_maxMessageLength {
+		err = errors.New("invalid netplay message")
+		return
+	}
+
+	data = make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	return
+}
+
+// Receives the messages of the peer until the connection is closed
+func (np *netplay) receive() {
+	for {
+		kind, frame, data, err := readNetplayMessage(np.conn)
+		if err == io.EOF {
+			err = errors.New("the peer has disconnected")
+		}
+
+		var input netplayInput
+		if (err == nil) && (kind == netplay_msgInput) {
+			input, err = decodeNetplayInput(data)
+		}
+		if (err == nil) && (kind == netplay_msgChecksum) && (len(data) != 4) {
+			err = errors.New("invalid netplay checksum")
+		}
+
+		np.mutex.Lock()
+		switch {
+		case err != nil:
+			if np.err_orNil == nil {
+				np.err_orNil = err
+			}
+		case kind == netplay_msgInput:
+			np.remote[frame] = input
+		case kind == netplay_msgChecksum:
+			np.remoteChecksums[frame] = binary.BigEndian.Uint32(data)
+		}
+		np.mutex.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (np *netplay) send(kind byte, frame int, data []byte) {
+	err := writeNetplayMessage(np.conn, kind, frame, data)
+	if err != nil {
+		np.mutex.Lock()
+		if np.err_orNil == nil {
+			np.err_orNil = err
+		}
+		np.mutex.Unlock()
+	}
+}
+
+// Compares the checksums received from the peer with the local checksums
+func (np *netplay) checkSync() error {
+	np.mutex.Lock()
+	defer np.mutex.Unlock()
+
+	for frame, sum := range np.checksums {
+		remoteSum, ok := np.remoteChecksums[frame]
+		if !ok {
+			continue
+		}
+		if sum != remoteSum {
+			return fmt.Errorf("desync detected at frame %d", frame)
+		}
+		delete(np.checksums, frame)
+		delete(np.remoteChecksums, frame)
+	}
+	return nil
+}
+
+// Returns the input of the local player
+func (speccy *Spectrum48k) localInput() netplayInput {
+	var in netplayInput
+	for row := range in.keys {
+		in.keys[row] = speccy.Keyboard.GetKeyState(uint(row))
+	}
+	in.kempston = speccy.Joystick.GetState()
+	in.fuller = speccy.Joystick.GetFullerState()
+	return in
+}
+
+// The state of a keyboard row, as read by the Z80
+func (speccy *Spectrum48k) keyState(row uint) byte {
+	if speccy.netplay_orNil != nil {
+		return speccy.netplay_orNil.input.keys[row]
+	}
+	return speccy.Keyboard.GetKeyState(row)
+}
+
+// The state of the Kempston joystick, as read by the Z80
+func (speccy *Spectrum48k) kempstonState() byte {
+	if speccy.netplay_orNil != nil {
+		return speccy.netplay_orNil.input.kempston
+	}
+	return speccy.Joystick.GetState()
+}
+
+// The state of the Fuller joystick, as read by the Z80
+func (speccy *Spectrum48k) fullerState() byte {
+	if speccy.netplay_orNil != nil {
+		return speccy.netplay_orNil.input.fuller
+	}
+	return speccy.Joystick.GetFullerState()
+}
+
+// The Kempston mouse buttons, as read by the Z80.
+// The mouse is not part of the netplay input, so it is idle during netplay.
+func (speccy *Spectrum48k) mouseButtons() byte {
+	if speccy.netplay_orNil != nil {
+		return 0xff
+	}
+	return speccy.Mouse.GetButtons()
+}
+
+// The X position of the Kempston mouse, as read by the Z80
+func (speccy *Spectrum48k) mouseX() byte {
+	if speccy.netplay_orNil != nil {
+		return 0
+	}
+	return speccy.Mouse.GetX()
+}
+
+// The Y position of the Kempston mouse, as read by the Z80
+func (speccy *Spectrum48k) mouseY() byte {
+	if speccy.netplay_orNil != nil {
+		return 0
+	}
+	return speccy.Mouse.GetY()
+}
+
+// A checksum of the memory and the main registers
+func (speccy *Spectrum48k) netplayChecksum() uint32 {
+	s := speccy.MakeSnapshot()
+	sum := crc32.ChecksumIEEE(s.Mem[:])
+
+	cpu := s.Cpu
+	registers := []byte{
+		cpu.A, cpu.F, cpu.B, cpu.C, cpu.D, cpu.E, cpu.H, cpu.L,
+		byte(cpu.PC), byte(cpu.PC >> 8), byte(cpu.SP), byte(cpu.SP >> 8),
+	}
+	return crc32.Update(sum, crc32.IEEETable, registers)
+}
+
+// Called in the emulation goroutine before each frame.
+// Returns false if the frame has to wait for the input of the peer.
+func (speccy *Spectrum48k) netplayFrame() bool {
+	np := speccy.netplay_orNil
+	if np == nil {
+		return true
+	}
+
+	if !np.sent {
+		if (np.frame > 0) && (np.frame%netplay_checksumInterval == 0) {
+			sum := speccy.netplayChecksum()
+			np.checksums[np.frame] = sum
+
+			var data [4]byte
+			binary.BigEndian.PutUint32(data[:], sum)
+			np.send(netplay_msgChecksum, np.frame, data[:])
+		}
+
+		input := speccy.localInput()
+		np.local[np.frame+np.delay] = input
+		np.send(netplay_msgInput, np.frame+np.delay, input.encode())
+		np.sent = true
+	}
+
+	np.mutex.Lock()
+	err := np.err_orNil
+	remote, ok := np.remote[np.frame]
+	delete(np.remote, np.frame)
+	np.mutex.Unlock()
+
+	if err == nil {
+		err = np.checkSync()
+	}
+	if err != nil {
+		speccy.stopNetplay(err)
+		return true
+	}
+	if !ok {
+		return false
+	}
+
+	np.input = np.local[np.frame].merge(remote)
+	delete(np.local, np.frame)
+	np.frame++
+	np.sent = false
+	return true
+}
+
+// Starts netplay from the specified snapshot. Called in the emulation goroutine.
+func (speccy *Spectrum48k) startNetplay(np *netplay, snapshot formats.Snapshot) error {
+	if speccy.netplay_orNil != nil {
+		return errors.New("netplay is already running")
+	}
+
+	err := speccy.loadSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+
+	// The tape would not be played in sync
+	speccy.tapeDrive.Stop()
+
+	speccy.netplay_orNil = np
+	return nil
+}
+
+// Stops netplay, the reason is printed if it is not nil. Called in the emulation goroutine.
+func (speccy *Spectrum48k) stopNetplay(reason_orNil error) {
+	np := speccy.netplay_orNil
+	if np == nil {
+		return
+	}
+
+	np.conn.Close()
+	speccy.netplay_orNil = nil
+
+	if reason_orNil != nil {
+		speccy.app.PrintfMsg("netplay: %s", reason_orNil)
+	} else if speccy.app.Verbose {
+		speccy.app.PrintfMsg("netplay: stopped")
+	}
+}
+
+// Stops netplay before a command which changes only the local machine,
+// and would make the machines diverge. Called in the emulation goroutine.
+func (speccy *Spectrum48k) leaveNetplay(command string) {
+	if speccy.netplay_orNil != nil {
+		speccy.stopNetplay(errors.New("stopped by " + command))
+	}
+}
+
+func encodeNetplaySnapshot(delay uint, model Model, sna []byte) []byte {
+	name := model.String()
+	data := append([]byte{byte(delay), byte(len(name))}, name...)
+	return append(data, sna...)
+}
+
+func decodeNetplaySnapshot(data []byte) (delay int, model Model, sna []byte, err error) {
+	if (len(data) < 2) || (len(data) < 2+int(data[1])) {
+		return 0, 0, nil, errors.New("invalid netplay message")
+	}
+	name := string(data[2 : 2+int(data[1])])
+	model, ok := ModelNames[name]
+	if !ok {
+		return 0, 0, nil, fmt.Errorf("the host emulates an unknown model \"%s\"", name)
+	}
+	return int(data[0]), model, data[2+int(data[1]):], nil
+}
+
+// Starts netplay from a snapshot made by the host on the specified model
+func (speccy *Spectrum48k) beginNetplay(conn net.Conn, delay int, model Model, sna []byte) error {
+	if model != speccy.Model() {
+		conn.Close()
+		return fmt.Errorf("the host emulates the %s, not the %s", model, speccy.Model())
+	}
+
+	snapshot, err := formats.SnapshotData(sna).DecodeSNA()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	np := newNetplay(conn, delay)
+
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_StartNetplay{np, snapshot, errChan}
+	err = <-errChan
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	go np.receive()
+	return nil
+}
+
+// Waits for a peer to connect to the specified TCP address (such as ":7000"),
+// sends it a snapshot of the machine and starts netplay.
+// The input of the players is applied 'delay' frames after it is sampled.
+func (speccy *Spectrum48k) NetplayHost(address string, delay uint) error {
+	if speccy.Model().banked() {
+		return fmt.Errorf("netplay is not available on the %s", speccy.Model())
+	}
+	if delay > 0xff {
+		return errors.New("invalid netplay input delay")
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	conn, err := listener.Accept()
+	listener.Close()
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *formats.FullSnapshot)
+	speccy.CommandChannel <- Cmd_MakeSnapshot{ch}
+	sna, err := (<-ch).EncodeSNA()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	model := speccy.Model()
+	err = writeNetplayMessage(conn, netplay_msgSnapshot, 0, encodeNetplaySnapshot(delay, model, sna))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	return speccy.beginNetplay(conn, int(delay), model, sna)
+}
+
+// Connects to the host at the specified TCP address (such as "localhost:7000")
+// and starts netplay from the snapshot sent by the host
+func (speccy *Spectrum48k) NetplayJoin(address string) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	kind, _, data, err := readNetplayMessage(conn)
+	if (err == nil) && (kind != netplay_msgSnapshot) {
+		err = errors.New("invalid netplay message")
+	}
+	var delay int
+	var model Model
+	var sna []byte
+	if err == nil {
+		delay, model, sna, err = decodeNetplaySnapshot(data)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	return speccy.beginNetplay(conn, delay, model, sna)
+}
+
+// Stops netplay and closes the connection. The peer continues on its own.
+func (speccy *Spectrum48k) NetplayStop() {
+	speccy.CommandChannel <- Cmd_StopNetplay{}
+}
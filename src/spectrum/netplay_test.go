@@ -0,0 +1,289 @@
+package spectrum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNetplayInputEncoding(t *testing.T) {
+	in := netplay_idleInput
+	in.keys[1] = 0xfe
+	in.kempston = 0x10
+	in.fuller = 0x7f
+
+	out, err := decodeNetplayInput(in.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Errorf("expected %v, got %v", in, out)
+	}
+
+	if _, err := decodeNetplayInput(in.encode()[:9]); err == nil {
+		t.Error("a truncated input was accepted")
+	}
+}
+
+func TestNetplayInputMerge(t *testing.T) {
+	a := netplay_idleInput
+	a.keys[1] = 0xfe  // A
+	a.kempston = 0x10 // Fire
+
+	b := netplay_idleInput
+	b.keys[1] = 0xfd  // S
+	b.kempston = 0x01 // Right
+	b.fuller = 0x7f   // Fire
+
+	expected := netplay_idleInput
+	expected.keys[1] = 0xfc
+	expected.kempston = 0x11
+	expected.fuller = 0x7f
+
+	if merged := a.merge(b); merged != expected {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+	if merged := b.merge(a); merged != expected {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+	if merged := a.merge(netplay_idleInput); merged != a {
+		t.Errorf("expected %v, got %v", a, merged)
+	}
+}
+
+func TestReadNetplayMessage(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeNetplayMessage(&buf, netplay_msgInput, 1234, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kind, frame, data, err := readNetplayMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (kind != netplay_msgInput) || (frame != 1234) || !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Errorf("unexpected message %c %d %v", kind, frame, data)
+	}
+}
+
+func TestReadOversizeNetplayMessage(t *testing.T) {
+	var header [9]byte
+	header[0] = netplay_msgSnapshot
+	binary.BigEndian.PutUint32(header[5:9], netplay_maxMessageLength+1)
+
+	_, _, _, err := readNetplayMessage(bytes.NewReader(header[:]))
+	if err == nil {
+		t.Error("an oversize message was accepted")
+	}
+}
+
+func TestReadTruncatedNetplayMessage(t *testing.T) {
+	var buf bytes.Buffer
+	writeNetplayMessage(&buf, netplay_msgChecksum, 50, []byte{1, 2, 3, 4})
+	message := buf.Bytes()
+
+	// Truncated data
+	_, _, _, err := readNetplayMessage(bytes.NewReader(message[:len(message)-1]))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+
+	// Truncated header
+	_, _, _, err = readNetplayMessage(bytes.NewReader(message[:5]))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestNetplayCheckSync(t *testing.T) {
+	np := newNetplay(nil, NETPLAY_DEFAULT_DELAY)
+
+	// The checksum of the peer has not arrived yet
+	np.checksums[50] = 0x1234
+	if err := np.checkSync(); err != nil {
+		t.Fatal(err)
+	}
+
+	np.remoteChecksums[50] = 0x1234
+	if err := np.checkSync(); err != nil {
+		t.Fatal(err)
+	}
+	if (len(np.checksums) != 0) || (len(np.remoteChecksums) != 0) {
+		t.Error("the compared checksums were not removed")
+	}
+
+	np.checksums[100] = 0x1234
+	np.remoteChecksums[100] = 0x4321
+	if err := np.checkSync(); err == nil {
+		t.Error("the desync was not detected")
+	}
+}
+
+// Emulates the next frame, waiting for the input of the peer
+func netplayStep(t *testing.T, speccy *Spectrum48k) {
+	for i := 0; i < 1000; i++ {
+		if speccy.netplayFrame() {
+			speccy.renderFrame(nil)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("timeout while waiting for the input of the peer")
+}
+
+func TestNetplayLoopback(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	host := NewSpectrum48k(app, *rom)
+	peer := NewSpectrum48k(app, *rom)
+
+	hostConn, peerConn := net.Pipe()
+	snapshot := host.MakeSnapshot()
+	for _, p := range []struct {
+		speccy *Spectrum48k
+		conn   net.Conn
+	}{{host, hostConn}, {peer, peerConn}} {
+		np := newNetplay(p.conn, NETPLAY_DEFAULT_DELAY)
+
+		errChan := make(chan error)
+		p.speccy.CommandChannel <- Cmd_StartNetplay{np, snapshot, errChan}
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+		go np.receive()
+	}
+
+	// Each player presses something, both machines see the combined input
+	host.Keyboard.KeyDown(KEY_A)
+	peer.Joystick.KempstonDown(KEMPSTON_FIRE)
+	for frame := 0; frame < 2*netplay_checksumInterval+1; frame++ {
+		netplayStep(t, host)
+		netplayStep(t, peer)
+
+		if (host.netplay_orNil == nil) || (peer.netplay_orNil == nil) {
+			t.Fatalf("netplay stopped at frame %d", frame)
+		}
+		if host.netplay_orNil.input != peer.netplay_orNil.input {
+			t.Fatalf("the inputs differ at frame %d", frame)
+		}
+
+		input := host.netplay_orNil.input
+		pressed := (input.keys[1] == 0xfe) && (input.kempston == kempstonMask[KEMPSTON_FIRE])
+		if (frame >= NETPLAY_DEFAULT_DELAY) != pressed {
+			t.Fatalf("unexpected input %v at frame %d", input, frame)
+		}
+	}
+	if *host.MakeSnapshot() != *peer.MakeSnapshot() {
+		t.Fatal("the machines have diverged")
+	}
+
+	// A divergence is detected at the next checksum
+	host.Memory.Write(0x8000, ^host.Memory.Read(0x8000), true)
+	for frame := 0; (frame < 2*netplay_checksumInterval) && (host.netplay_orNil != nil); frame++ {
+		netplayStep(t, host)
+		netplayStep(t, peer)
+	}
+	if host.netplay_orNil != nil {
+		t.Error("the desync was not detected")
+	}
+}
+
+func TestLocalCommandsStopNetplay(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	speccy := NewSpectrum48k(app, *rom)
+	conn, _ := net.Pipe()
+	speccy.netplay_orNil = newNetplay(conn, NETPLAY_DEFAULT_DELAY)
+
+	// The mouse is not shared
+	speccy.Mouse.ButtonDown(MOUSE_LEFT)
+	speccy.Mouse.Move(10, 10)
+	if (speccy.mouseButtons() != 0xff) || (speccy.mouseX() != 0) || (speccy.mouseY() != 0) {
+		t.Error("the local mouse is visible during netplay")
+	}
+
+	// Loading a snapshot on one machine only stops netplay
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_LoadSnapshot{"", speccy.MakeSnapshot(), errChan}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if speccy.netplay_orNil != nil {
+		t.Error("netplay is still running")
+	}
+	if speccy.mouseButtons() == 0xff {
+		t.Error("the local mouse is not visible after netplay")
+	}
+}
+
+func TestNetplayJoinRejectsOtherModel(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	peer := NewSpectrum48k(app, *rom)
+	if err := peer.SetModel(MODEL_NTSC48K, [][]byte{rom[:]}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A host emulating the 48K, whose snapshot differs from the memory of the peer
+	snapshot := peer.MakeSnapshot()
+	snapshot.Mem[0x8000-0x4000] = ^peer.Memory.Read(0x8000)
+	sna, err := snapshot.EncodeSNA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		writeNetplayMessage(conn, netplay_msgSnapshot, 0, encodeNetplaySnapshot(NETPLAY_DEFAULT_DELAY, MODEL_48K, sna))
+	}()
+
+	if err := peer.NetplayJoin(listener.Addr().String()); err == nil {
+		t.Fatal("joined a host emulating another model")
+	}
+	if peer.netplay_orNil != nil {
+		t.Error("netplay is running")
+	}
+	if peer.Memory.Read(0x8000) == snapshot.Mem[0x8000-0x4000] {
+		t.Error("the snapshot of the host has been loaded")
+	}
+}
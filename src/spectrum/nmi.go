@@ -0,0 +1,56 @@
+package spectrum
+
+// The non-maskable interrupt (NMI).
+//
+// The Z80 emulation provides only maskable interrupts, so the NMI is accepted here:
+// the PC is pushed on the stack, IFF1 is reset (IFF2 keeps its state) and the Z80
+// continues at 0x0066. A requested NMI is accepted at the beginning of the next frame.
+//
+// Devices implementing 'nmiListener' are notified when the NMI is accepted,
+// before the Z80 fetches the opcode at 0x0066.
+
+type nmiListener interface {
+	nmi()
+}
+
+type Cmd_NMI struct{}
+
+// Called in the emulation goroutine
+func (speccy *Spectrum48k) acceptNMI() {
+	cpu := speccy.Cpu
+	memory := speccy.Memory
+
+	if cpu.Halted {
+		// Continue after the HALT instruction
+		cpu.IncPC(1)
+		cpu.Halted = false
+	}
+
+	cpu.IFF1 = 0
+	cpu.R = (cpu.R + 1) & 0x7f
+
+	pc := cpu.PC()
+	sp := cpu.SP() - 2
+	memory.contend(uint16(cpu.I)<<8|(cpu.R&0x7f), 5)
+	memory.contend(sp+1, 3)
+	memory.WriteByteInternal(sp+1, byte(pc>>8))
+	memory.contend(sp, 3)
+	memory.WriteByteInternal(sp, byte(pc))
+	cpu.SetSP(sp)
+	cpu.SetPC(0x0066)
+
+	speccy.peripherals_mutex.RLock()
+	peripherals := speccy.peripherals
+	speccy.peripherals_mutex.RUnlock()
+
+	for _, p := range peripherals {
+		if listener, ok := p.(nmiListener); ok {
+			listener.nmi()
+		}
+	}
+}
+
+// Requests a non-maskable interrupt, like the NMI button of the Multiface
+func (speccy *Spectrum48k) NMI() {
+	speccy.CommandChannel <- Cmd_NMI{}
+}
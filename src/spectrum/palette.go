@@ -0,0 +1,144 @@
+package spectrum
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Like 'Palette', but the non-bright colors use 215 instead of 192.
+// This makes the difference between normal and bright colors smaller.
+var Palette_Bright [16]uint32 = [16]uint32{
+	RGBA{000, 000, 000, 255}.value32(),
+	RGBA{000, 000, 215, 255}.value32(),
+	RGBA{215, 000, 000, 255}.value32(),
+	RGBA{215, 000, 215, 255}.value32(),
+	RGBA{000, 215, 000, 255}.value32(),
+	RGBA{000, 215, 215, 255}.value32(),
+	RGBA{215, 215, 000, 255}.value32(),
+	RGBA{215, 215, 215, 255}.value32(),
+	RGBA{000, 000, 000, 255}.value32(),
+	RGBA{000, 000, 255, 255}.value32(),
+	RGBA{255, 000, 000, 255}.value32(),
+	RGBA{255, 000, 255, 255}.value32(),
+	RGBA{000, 255, 000, 255}.value32(),
+	RGBA{000, 255, 255, 255}.value32(),
+	RGBA{255, 255, 000, 255}.value32(),
+	RGBA{255, 255, 255, 255}.value32(),
+}
+
+// Derived from 'Palette' at program startup
+var Palette_Greyscale [16]uint32
+
+// Derived from 'Palette' at program startup
+var Palette_GreenScreen [16]uint32
+
+// The built-in palettes, indexed by name
+var builtinPalettes = map[string]*[16]uint32{
+	"default":   &Palette,
+	"bright":    &Palette_Bright,
+	"greyscale": &Palette_Greyscale,
+	"grayscale": &Palette_Greyscale,
+	"green":     &Palette_GreenScreen,
+}
+
+func (color RGBA) luminance() byte {
+	return byte((299*uint(color.R) + 587*uint(color.G) + 114*uint(color.B)) / 1000)
+}
+
+func rgba(value32 uint32) RGBA {
+	return RGBA{byte(value32 >> 16), byte(value32 >> 8), byte(value32), byte(value32 >> 24)}
+}
+
+func init() {
+	for i, value32 := range Palette {
+		l := rgba(value32).luminance()
+		Palette_Greyscale[i] = RGBA{l, l, l, 255}.value32()
+		Palette_GreenScreen[i] = RGBA{l / 8, l, l / 4, 255}.value32()
+	}
+}
+
+// Returns the names of the built-in palettes
+func PaletteNames() []string {
+	return []string{"default", "bright", "greyscale", "green"}
+}
+
+// Parses a palette file.
+//
+// The file contains 16 colors in the order used by the ULA
+// (black, blue, red, magenta, green, cyan, yellow, white, followed by
+// their bright variants). Each color is written on a separate line
+// as a hexadecimal RRGGBB value, optionally prefixed by '#'.
+// Empty lines and lines starting with "//" are ignored.
+func DecodePalette(data []byte) (*[16]uint32, error) {
+	var palette [16]uint32
+
+	n := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if (line == "") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		if n == len(palette) {
+			return nil, errors.New("invalid palette: too many colors")
+		}
+
+		hex := strings.TrimPrefix(line, "#")
+		if len(hex) != 6 {
+			return nil, fmt.Errorf("invalid palette color \"%s\"", line)
+		}
+		value, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid palette color \"%s\"", line)
+		}
+
+		palette[n] = 0xff000000 | uint32(value)
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if n != len(palette) {
+		return nil, fmt.Errorf("invalid palette: expected %d colors, got %d", len(palette), n)
+	}
+
+	return &palette, nil
+}
+
+// Reads a palette from the specified file
+func ReadPalette(path string) (*[16]uint32, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	palette, err := DecodePalette(data)
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+
+	return palette, nil
+}
+
+// Returns the built-in palette with the specified name.
+// If there is no such built-in palette, the name is treated as
+// the name of a palette file and the file is searched for via 'PalettePath'.
+func GetPalette(name string) (*[16]uint32, error) {
+	if palette, isBuiltin := builtinPalettes[strings.ToLower(name)]; isBuiltin {
+		return palette, nil
+	}
+
+	path, err := PalettePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadPalette(path)
+}
@@ -0,0 +1,195 @@
+package spectrum
+
+// Devices connected to the expansion bus (Interface 1, DivMMC, ...).
+//
+// A device can respond to I/O ports, and it can react to the Z80 fetching
+// an opcode from particular addresses in the first 16K of memory, or from RAM
+// while its memory is paged in. Such fetch traps are how the real hardware pages its own ROM in and out.
+
+import (
+	"fmt"
+)
+
+type Peripheral interface {
+	// A short name identifying the device, such as "if1"
+	Name() string
+
+	// Called in the emulation goroutine when the device is connected to the machine
+	attach(speccy *Spectrum48k) error
+
+	// Called in the emulation goroutine when the device is disconnected,
+	// or when the machine is turned off
+	detach()
+
+	// Called when the machine is reset
+	reset()
+
+	// Returns the value read from the port, and false if the device does not respond to the port
+	readPort(address uint16) (byte, bool)
+
+	// Called for every port write
+	writePort(address uint16, b byte)
+}
+
+// Implemented by a device with registers mapped into the first 16K of memory.
+// At most one such device can be connected.
+type memoryMappedDevice interface {
+	// Called for every read from 0x0000-0x3FFF. 'b' is the value read from memory,
+	// the returned value is the value seen by the Z80.
+	readMemory(address uint16, b byte) byte
+
+	// Called for every write to 0x0000-0x3FFF
+	writeMemory(address uint16, b byte)
+}
+
+// A function called when the Z80 is about to fetch an opcode from the trapped address ('before'),
+// or right after the opcode has been fetched ('after'). Either function can be nil.
+//
+// If 'before' changes the PC, the opcode is not fetched.
+type fetchTrap struct {
+	owner  Peripheral
+	before func(pc uint16)
+	after  func(pc uint16)
+}
+
+type Cmd_AttachPeripheral struct {
+	Peripheral Peripheral
+	ErrChan    chan<- error
+}
+
+type Cmd_DetachPeripheral struct {
+	Name    string
+	ErrChan chan<- error
+}
+
+// Adds a fetch trap. The address must be in the first 16K of memory.
+func (speccy *Spectrum48k) addFetchTrap(owner Peripheral, address uint16, before, after func(pc uint16)) {
+	if address >= 0x4000 {
+		panic(fmt.Sprintf("invalid fetch trap address 0x%04x", address))
+	}
+	if speccy.fetchTraps == nil {
+		speccy.fetchTraps = make(map[uint16][]fetchTrap)
+	}
+	speccy.fetchTraps[address] = append(speccy.fetchTraps[address], fetchTrap{owner, before, after})
+	speccy.isFetchTrap[address] = true
+}
+
+// Adds a function called when the Z80 is about to fetch an opcode from RAM (0x4000-0xFFFF)
+// while memory is paged over the system ROM. This is how a device pages out its ROM
+// as soon as the program leaves it.
+func (speccy *Spectrum48k) addRAMFetchTrap(owner Peripheral, before func(pc uint16)) {
+	speccy.ramFetchTraps = append(speccy.ramFetchTraps, fetchTrap{owner, before, nil})
+}
+
+// Removes all fetch traps added by the device
+func (speccy *Spectrum48k) removeFetchTraps(owner Peripheral) {
+	var remaining []fetchTrap
+	for _, trap := range speccy.ramFetchTraps {
+		if trap.owner != owner {
+			remaining = append(remaining, trap)
+		}
+	}
+	speccy.ramFetchTraps = remaining
+
+	for address, traps := range speccy.fetchTraps {
+		var remaining []fetchTrap
+		for _, trap := range traps {
+			if trap.owner != owner {
+				remaining = append(remaining, trap)
+			}
+		}
+		if len(remaining) > 0 {
+			speccy.fetchTraps[address] = remaining
+		} else {
+			delete(speccy.fetchTraps, address)
+			speccy.isFetchTrap[address] = false
+		}
+	}
+}
+
+// Connects the device to the machine.
+// At most one device with a particular name can be connected.
+func (speccy *Spectrum48k) attachPeripheral(p Peripheral) error {
+	if speccy.findPeripheral(p.Name()) != nil {
+		return fmt.Errorf("%s is already connected", p.Name())
+	}
+
+	err := p.attach(speccy)
+	if err != nil {
+		return err
+	}
+
+	speccy.peripherals_mutex.Lock()
+	speccy.peripherals = append(speccy.peripherals, p)
+	speccy.peripherals_mutex.Unlock()
+	speccy.clearRewindBuffer()
+
+	if speccy.app.Verbose {
+		speccy.app.PrintfMsg("connected %s", p.Name())
+	}
+	return nil
+}
+
+// Disconnects the device with the specified name
+func (speccy *Spectrum48k) detachPeripheral(name string) error {
+	p := speccy.findPeripheral(name)
+	if p == nil {
+		return fmt.Errorf("%s is not connected", name)
+	}
+
+	speccy.removeFetchTraps(p)
+	p.detach()
+
+	speccy.peripherals_mutex.Lock()
+	for i, p2 := range speccy.peripherals {
+		if p2 == p {
+			speccy.peripherals = append(speccy.peripherals[0:i], speccy.peripherals[i+1:]...)
+			break
+		}
+	}
+	speccy.peripherals_mutex.Unlock()
+	speccy.clearRewindBuffer()
+
+	if speccy.app.Verbose {
+		speccy.app.PrintfMsg("disconnected %s", name)
+	}
+	return nil
+}
+
+func (speccy *Spectrum48k) detachAllPeripherals() {
+	for len(speccy.peripherals) > 0 {
+		speccy.detachPeripheral(speccy.peripherals[0].Name())
+	}
+}
+
+func (speccy *Spectrum48k) findPeripheral(name string) Peripheral {
+	speccy.peripherals_mutex.RLock()
+	defer speccy.peripherals_mutex.RUnlock()
+
+	for _, p := range speccy.peripherals {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Returns the connected device with the specified name, or nil.
+// The device's exported methods can be called from any goroutine.
+func (speccy *Spectrum48k) Peripheral(name string) Peripheral {
+	return speccy.findPeripheral(name)
+}
+
+// Connects the device to the machine, and waits until it is connected
+func (speccy *Spectrum48k) AttachPeripheral(p Peripheral) error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_AttachPeripheral{p, errChan}
+	return <-errChan
+}
+
+// Disconnects the device, and waits until it is disconnected
+func (speccy *Spectrum48k) DetachPeripheral(name string) error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_DetachPeripheral{name, errChan}
+	return <-errChan
+}
@@ -25,8 +25,6 @@ WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 
 package spectrum
 
-import "github.com/remogatto/z80"
-
 type FrameStatusOfPorts struct {
 	shouldPlayTheTape bool
 }
@@ -68,12 +66,21 @@ type Ports struct {
 	// Number of supposed reads from tapedrive port.
 	// This counter is reset to 0 at the beginning of each frame.
 	tapeReadCount uint
+
+	// Bits 3 (MIC) and 4 (EAR) of the last value written to port 0xFE, shifted to bits 0-1
+	earMicOut byte
+
+	// Whether the emulated machine is an Issue 2 Spectrum (see 'earInput')
+	issue2 bool
 }
 
 // If 'tapeReadCount' is equal to or above this threshold,
 // the program running within the emulated machine probably wants to read data from the tape
 const tapeReadCount_tapeAccessThreshold = 400
 
+// The voltage on the EAR/MIC pin of the ULA above which bit 6 of port 0xFE reads as 1
+const earInput_thresholdVoltage = 0.7
+
 func NewPorts() *Ports {
 	p := &Ports{}
 	p.borderEvents = []BorderEvent{}
@@ -94,6 +101,22 @@ func (p *Ports) reset() {
 	p.beeperLevel = 0
 	p.beeperEvents = p.beeperEvents[0:0]
 	p.beeperEvents = append(p.beeperEvents, BeeperEvent{TState: 0, Level: p.beeperLevel})
+
+	p.earMicOut = 0
+}
+
+// Returns bit 6 of port 0xFE when there is no signal from the tape.
+// The bit depends on the voltage set by the last write to the port: on an Issue 2 Spectrum
+// writing either MIC or EAR sets the bit, on an Issue 3 Spectrum only writing EAR sets it.
+func (p *Ports) earInput() byte {
+	voltage := Voltage_Issue3[p.earMicOut]
+	if p.issue2 {
+		voltage = Voltage_Issue2[p.earMicOut]
+	}
+	if voltage > earInput_thresholdVoltage {
+		return 0xff
+	}
+	return 0xbf
 }
 
 func SameBorderEvents(l1, l2 []BorderEvent) bool {
@@ -118,13 +141,15 @@ func (p *Ports) frame_begin() {
 }
 
 func (p *Ports) frame_end() FrameStatusOfPorts {
+	tstatesPerFrame := p.speccy.timing.TStatesPerFrame
+
 	// Border events
 	{
 		// Determine the number of events overflowing the frame
 		var numOverflow int
 		{
 			i := len(p.borderEvents)
-			for (i > 0) && (p.borderEvents[i-1].TState >= TStatesPerFrame) {
+			for (i > 0) && (p.borderEvents[i-1].TState >= tstatesPerFrame) {
 				i--
 			}
 			numOverflow = len(p.borderEvents) - i
@@ -138,7 +163,7 @@ func (p *Ports) frame_end() FrameStatusOfPorts {
 		var colorAtTState0 byte
 		if numOverflow == 0 {
 			colorAtTState0 = p.speccy.ula.getBorderColor()
-		} else if overflow[0].TState == TStatesPerFrame {
+		} else if overflow[0].TState == tstatesPerFrame {
 			colorAtTState0 = overflow[0].Color
 		} else {
 			// Use the Color of the last event that did NOT overflow.
@@ -148,7 +173,7 @@ func (p *Ports) frame_end() FrameStatusOfPorts {
 			colorAtTState0 = p.borderEvents[numEvents-1].Color
 		}
 
-		if (numOverflow > 0) && (overflow[0].TState == TStatesPerFrame) {
+		if (numOverflow > 0) && (overflow[0].TState == tstatesPerFrame) {
 			p.borderEvents = p.borderEvents[0:0]
 		} else {
 			p.borderEvents = p.borderEvents[0:0]
@@ -157,7 +182,7 @@ func (p *Ports) frame_end() FrameStatusOfPorts {
 
 		// Replay the overflowing events
 		for i := 0; i < numOverflow; i++ {
-			p.borderEvents = append(p.borderEvents, BorderEvent{(overflow[i].TState - TStatesPerFrame), overflow[i].Color})
+			p.borderEvents = append(p.borderEvents, BorderEvent{(overflow[i].TState - tstatesPerFrame), overflow[i].Color})
 		}
 	}
 
@@ -167,7 +192,7 @@ func (p *Ports) frame_end() FrameStatusOfPorts {
 		var numOverflow int
 		{
 			i := len(p.beeperEvents)
-			for (i > 0) && (p.beeperEvents[i-1].TState >= TStatesPerFrame) {
+			for (i > 0) && (p.beeperEvents[i-1].TState >= tstatesPerFrame) {
 				i--
 			}
 			numOverflow = len(p.beeperEvents) - i
@@ -181,7 +206,7 @@ func (p *Ports) frame_end() FrameStatusOfPorts {
 		var levelAtTState0 byte
 		if numOverflow == 0 {
 			levelAtTState0 = p.beeperLevel
-		} else if overflow[0].TState == TStatesPerFrame {
+		} else if overflow[0].TState == tstatesPerFrame {
 			levelAtTState0 = overflow[0].Level
 		} else {
 			// Use the Level of the last event that did NOT overflow.
@@ -191,7 +216,7 @@ func (p *Ports) frame_end() FrameStatusOfPorts {
 			levelAtTState0 = p.beeperEvents[numEvents-1].Level
 		}
 
-		if (numOverflow > 0) && (overflow[0].TState == TStatesPerFrame) {
+		if (numOverflow > 0) && (overflow[0].TState == tstatesPerFrame) {
 			p.beeperEvents = p.beeperEvents[0:0]
 		} else {
 			p.beeperEvents = p.beeperEvents[0:0]
@@ -200,7 +225,7 @@ func (p *Ports) frame_end() FrameStatusOfPorts {
 
 		// Replay the overflowing events
 		for i := 0; i < numOverflow; i++ {
-			p.beeperEvents = append(p.beeperEvents, BeeperEvent{(overflow[i].TState - TStatesPerFrame), overflow[i].Level})
+			p.beeperEvents = append(p.beeperEvents, BeeperEvent{(overflow[i].TState - tstatesPerFrame), overflow[i].Level})
 		}
 	}
 
@@ -216,16 +241,18 @@ func (p *Ports) frame_end() FrameStatusOfPorts {
 // 
 // If the returned list is non-empty, its length is at least 2.
 func (p *Ports) getBorderEvents() []BorderEvent {
+	tstatesPerFrame := p.speccy.timing.TStatesPerFrame
+
 	n := len(p.borderEvents)
-	for (n > 0) && (p.borderEvents[n-1].TState > TStatesPerFrame) {
+	for (n > 0) && (p.borderEvents[n-1].TState > tstatesPerFrame) {
 		n--
 	}
 
 	ret := make([]BorderEvent, n, n+1)
 	copy(ret[0:n], p.borderEvents[0:n])
 
-	if (n > 0) && (ret[n-1].TState < TStatesPerFrame) {
-		ret = append(ret, BorderEvent{TStatesPerFrame, ret[n-1].Color})
+	if (n > 0) && (ret[n-1].TState < tstatesPerFrame) {
+		ret = append(ret, BorderEvent{tstatesPerFrame, ret[n-1].Color})
 	}
 
 	return ret
@@ -238,16 +265,18 @@ func (p *Ports) getBorderEvents() []BorderEvent {
 //
 // If the returned list is non-empty, its length is at least 2.
 func (p *Ports) getBeeperEvents() []BeeperEvent {
+	tstatesPerFrame := p.speccy.timing.TStatesPerFrame
+
 	n := len(p.beeperEvents)
-	for (n > 0) && (p.beeperEvents[n-1].TState > TStatesPerFrame) {
+	for (n > 0) && (p.beeperEvents[n-1].TState > tstatesPerFrame) {
 		n--
 	}
 
 	ret := make([]BeeperEvent, n, n+1)
 	copy(ret[0:n], p.beeperEvents[0:n])
 
-	if (n > 0) && (ret[n-1].TState < TStatesPerFrame) {
-		ret = append(ret, BeeperEvent{TStatesPerFrame, ret[n-1].Level})
+	if (n > 0) && (ret[n-1].TState < tstatesPerFrame) {
+		ret = append(ret, BeeperEvent{tstatesPerFrame, ret[n-1].Level})
 	}
 
 	return ret
@@ -265,12 +294,26 @@ func (p *Ports) ReadPortInternal(address uint16, contend bool) byte {
 
 	var result byte = 0xff
 
+	// Devices connected to the expansion bus
+	if len(p.speccy.peripherals) > 0 {
+		responded := false
+		for _, device := range p.speccy.peripherals {
+			if b, ok := device.readPort(address); ok {
+				result &= b
+				responded = true
+			}
+		}
+		if responded {
+			return result
+		}
+	}
+
 	if (address & 0x0001) == 0x0000 {
 		// Read keyboard
 		var row uint
 		for row = 0; row < 8; row++ {
 			if (address & (1 << (uint16(row) + 8))) == 0 { // bit held low, so scan this row
-				result &= p.speccy.Keyboard.GetKeyState(row)
+				result &= p.speccy.keyState(row)
 			}
 		}
 
@@ -279,9 +322,31 @@ func (p *Ports) ReadPortInternal(address uint16, contend bool) byte {
 			p.tapeReadCount++
 			earBit := p.speccy.tapeDrive.getEarBit()
 			result &= earBit
+		} else {
+			result &= p.earInput()
 		}
+	} else if (p.speccy.fdc_orNil != nil) && ((address & 0xf002) == 0x2000) {
+		// +3 floppy disk controller: 0x2FFD
+		result &= p.speccy.fdc_orNil.readStatus()
+	} else if (p.speccy.fdc_orNil != nil) && ((address & 0xf002) == 0x3000) {
+		// +3 floppy disk controller: 0x3FFD
+		result &= p.speccy.fdc_orNil.readData()
+	} else if (p.speccy.ay_orNil != nil) && ((address & 0xc002) == 0xc000) {
+		// AY sound chip: 0xFFFD
+		result &= p.speccy.ay_orNil.readRegister()
+	} else if (address & 0x01a1) == 0x0081 {
+		// Kempston mouse: 0xFADF
+		result &= p.speccy.mouseButtons()
+	} else if (address & 0x05a1) == 0x0181 {
+		// Kempston mouse: 0xFBDF
+		result &= p.speccy.mouseX()
+	} else if (address & 0x05a1) == 0x0581 {
+		// Kempston mouse: 0xFFDF
+		result &= p.speccy.mouseY()
 	} else if (address & 0x00e0) == 0x0000 {
-		result &= p.speccy.Joystick.GetState()
+		result &= p.speccy.kempstonState()
+	} else if (address & 0x00ff) == 0x007f {
+		result &= p.speccy.fullerState()
 	} else {
 		// Unassigned port
 		result = 0xff
@@ -315,7 +380,8 @@ func (p *Ports) WritePortInternal(address uint16, b byte, contend bool) {
 		}
 
 		// EAR(bit 4) and MIC(bit 3) output
-		newBeeperLevel := (b & 0x18) >> 3
+		p.earMicOut = (b & 0x18) >> 3
+		newBeeperLevel := p.earMicOut
 		if p.speccy.readFromTape && !p.speccy.tapeDrive.AcceleratedLoad {
 			if p.speccy.tapeDrive.earBit == 0xff {
 				newBeeperLevel |= 2
@@ -335,20 +401,55 @@ func (p *Ports) WritePortInternal(address uint16, b byte, contend bool) {
 		}
 	}
 
+	if model := p.speccy.Memory.model; model.banked() {
+		switch {
+		case (address & 0xc002) == 0xc000:
+			// 0xFFFD
+			p.speccy.ay_orNil.selectRegister(b)
+
+		case (address & 0xc002) == 0x8000:
+			// 0xBFFD
+			p.speccy.ay_orNil.writeRegister(b)
+
+		case (model == MODEL_PENTAGON) && ((address & 0x8002) == 0x0000):
+			// 0x7FFD, the Pentagon decodes only A15 and A1
+			p.speccy.Memory.write7ffd(b)
+
+		case (address & 0xc002) == 0x4000:
+			// 0x7FFD
+			p.speccy.Memory.write7ffd(b)
+
+		case model.hasPort1ffd() && ((address & 0xf002) == 0x1000):
+			// 0x1FFD, bit 3 is the disk motor
+			p.speccy.Memory.write1ffd(b)
+			if p.speccy.fdc_orNil != nil {
+				p.speccy.fdc_orNil.setMotor((b & 0x08) != 0)
+			}
+
+		case (p.speccy.fdc_orNil != nil) && ((address & 0xf002) == 0x3000):
+			// 0x3FFD
+			p.speccy.fdc_orNil.writeData(b)
+		}
+	}
+
+	for _, device := range p.speccy.peripherals {
+		device.writePort(address, b)
+	}
+
 	if contend {
 		p.ContendPortPostio(address)
 	}
 }
 
-func contendPort(z80 *z80.Z80, time int) {
-	tstates_p := &z80.Tstates
-	*tstates_p += int(delay_table[*tstates_p])
+func (p *Ports) contendPort(time int) {
+	tstates_p := &p.speccy.Cpu.Tstates
+	*tstates_p += int(p.speccy.Memory.delayTable[*tstates_p])
 	*tstates_p += time
 }
 
 func (p *Ports) ContendPortPreio(address uint16) {
-	if (address & 0xc000) == 0x4000 {
-		contendPort(p.speccy.Cpu, 1)
+	if p.speccy.Memory.isContended(address) {
+		p.contendPort(1)
 	} else {
 		p.speccy.Cpu.Tstates += 1
 	}
@@ -356,15 +457,15 @@ func (p *Ports) ContendPortPreio(address uint16) {
 
 func (p *Ports) ContendPortPostio(address uint16) {
 	if (address & 0x0001) == 1 {
-		if (address & 0xc000) == 0x4000 {
-			contendPort(p.speccy.Cpu, 1)
-			contendPort(p.speccy.Cpu, 1)
-			contendPort(p.speccy.Cpu, 1)
+		if p.speccy.Memory.isContended(address) {
+			p.contendPort(1)
+			p.contendPort(1)
+			p.contendPort(1)
 		} else {
 			p.speccy.Cpu.Tstates += 3
 		}
 
 	} else {
-		contendPort(p.speccy.Cpu, 3)
+		p.contendPort(3)
 	}
 }
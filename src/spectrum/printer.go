@@ -0,0 +1,292 @@
+package spectrum
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The ZX Printer and the Alphacom 32.
+//
+// Both printers are driven through port 0xFB. Write: bit 7 = 1 burns the dot under the stylus,
+// bit 2 = 1 stops the motor, bit 1 = 1 slows the motor down (the ROM slows down the ZX Printer
+// for the last two lines). Read: bit 0 = 1 when the stylus has moved to the next dot since the last
+// write (encoder pulse), bit 6 = 0 (the printer is connected), bit 7 = 1 while the stylus is at
+// the start of a line.
+//
+// The stylus moves across the paper at a fixed speed. A line has 256 dots on the paper followed
+// by the return of the stylus, and the paper advances by one row of dots per line.
+// Only the low byte of the port address is decoded, so the printer does not conflict with the DivMMC.
+//
+// The printed rows are kept in memory. They can be saved as a PNG image,
+// or converted to text by matching each 8x8 cell with the character set of the 48 BASIC ROM.
+const PRINTER_WIDTH = 256
+
+type PrinterModel int
+
+const (
+	PRINTER_ZX PrinterModel = iota
+	PRINTER_ALPHACOM
+)
+
+// The names of the printers, as used on the command-line
+var PrinterModelNames = map[string]PrinterModel{
+	"zx":       PRINTER_ZX,
+	"alphacom": PRINTER_ALPHACOM,
+}
+
+const (
+	printer_lineLength = 320 // Dot positions per line, including the return of the stylus
+	printer_dotTime    = 875 // T-states per dot position (12.5 lines per second)
+	printer_slowFactor = 2   // The slow motor speed of the ZX Printer
+	printer_fontOffset = 0x3d00
+)
+
+type printerRow [PRINTER_WIDTH / 8]byte
+
+type Printer struct {
+	model  PrinterModel
+	speccy *Spectrum48k
+
+	// The character set of the 48 BASIC ROM (0x20-0x7F), used when converting the output to text
+	font []byte
+
+	motorOn bool
+	slow    bool
+
+	// The position of the stylus, in dots since the motor was started
+	position  int64
+	lastWrite int64
+	lineUsed  bool
+	lastTime  int64
+	remainder int64
+
+	// Protects the printed rows, which can be accessed from any goroutine by the exported methods
+	mutex sync.Mutex
+	line  printerRow
+	rows  []printerRow
+}
+
+func NewPrinter(model PrinterModel) *Printer {
+	return &Printer{model: model}
+}
+
+func (p *Printer) Name() string {
+	return "printer"
+}
+
+func (p *Printer) attach(speccy *Spectrum48k) error {
+	if covox, ok := speccy.findPeripheral("covox").(*DAC); ok && (covox.port == 0xfb) {
+		return errors.New("the printer cannot be connected while the Covox uses port 0xFB")
+	}
+
+	p.speccy = speccy
+
+	rom := speccy.rom[:]
+	if speccy.model.banked() {
+		rom = speccy.Memory.roms[len(speccy.Memory.roms)-1]
+	}
+	p.font = make([]byte, 0x4000-printer_fontOffset)
+	copy(p.font, rom[printer_fontOffset:])
+
+	p.lastTime = speccy.time()
+	return nil
+}
+
+func (p *Printer) detach() {
+}
+
+func (p *Printer) reset() {
+	p.stopMotor()
+	p.lastTime = 0
+}
+
+// Moves the stylus to its current position, feeding the paper at the end of each line
+func (p *Printer) advance() {
+	now := p.speccy.time()
+	if (now < p.lastTime) || !p.motorOn {
+		p.lastTime = now
+		return
+	}
+
+	period := int64(printer_dotTime)
+	if p.slow && (p.model == PRINTER_ZX) {
+		period *= printer_slowFactor
+	}
+
+	elapsed := now - p.lastTime + p.remainder
+	position := p.position + elapsed/period
+	p.remainder = elapsed % period
+	p.lastTime = now
+
+	for line := p.position / printer_lineLength; line < position/printer_lineLength; line++ {
+		p.feed()
+	}
+	p.position = position
+}
+
+// Adds the current line to the printed rows
+func (p *Printer) feed() {
+	p.mutex.Lock()
+	p.rows = append(p.rows, p.line)
+	p.line = printerRow{}
+	p.mutex.Unlock()
+
+	p.lineUsed = false
+}
+
+func (p *Printer) stopMotor() {
+	if p.motorOn && p.lineUsed {
+		p.feed()
+	}
+	p.motorOn = false
+}
+
+func (p *Printer) readPort(address uint16) (byte, bool) {
+	if (address & 0x00ff) != 0xfb {
+		return 0, false
+	}
+
+	p.advance()
+
+	var b byte = 0x3e
+	if p.motorOn {
+		if p.position > p.lastWrite {
+			b |= 0x01
+		}
+		if (p.position % printer_lineLength) == 0 {
+			b |= 0x80
+		}
+	}
+	return b, true
+}
+
+func (p *Printer) writePort(address uint16, b byte) {
+	if (address & 0x00ff) != 0xfb {
+		return
+	}
+
+	p.advance()
+
+	motorOn := ((b & 0x04) == 0)
+	switch {
+	case p.motorOn && motorOn:
+		column := p.position % printer_lineLength
+		if ((b & 0x80) != 0) && (column < PRINTER_WIDTH) {
+			p.mutex.Lock()
+			p.line[column/8] |= 0x80 >> uint(column%8)
+			p.mutex.Unlock()
+		}
+		p.lastWrite = p.position
+		p.lineUsed = true
+
+	case !p.motorOn && motorOn:
+		p.motorOn = true
+		p.position = 0
+		p.lastWrite = -1
+		p.remainder = 0
+
+	case p.motorOn && !motorOn:
+		p.stopMotor()
+	}
+
+	p.slow = ((b & 0x02) != 0)
+}
+
+// Saves the printed output as a PNG image
+func (p *Printer) SavePNG(path string) error {
+	p.mutex.Lock()
+	rows := p.rows
+	p.mutex.Unlock()
+
+	if len(rows) == 0 {
+		return errors.New("nothing has been printed")
+	}
+
+	// The ZX Printer uses aluminium coated paper, the Alphacom 32 uses thermal paper
+	paper := color.RGBA{0xc8, 0xc8, 0xc8, 0xff}
+	ink := color.RGBA{0x30, 0x30, 0x30, 0xff}
+	if p.model == PRINTER_ALPHACOM {
+		paper = color.RGBA{0xff, 0xff, 0xff, 0xff}
+		ink = color.RGBA{0x20, 0x20, 0x60, 0xff}
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, PRINTER_WIDTH, len(rows)), color.Palette{paper, ink})
+	for y, row := range rows {
+		for x := 0; x < PRINTER_WIDTH; x++ {
+			if (row[x/8] & (0x80 >> uint(x%8))) != 0 {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(file, img)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Converts the printed output to text. Each 8 rows form a line of 32 characters.
+// The cells not matching any character of the 48 BASIC ROM are converted to '?'.
+func (p *Printer) Text() string {
+	p.mutex.Lock()
+	rows := p.rows
+	p.mutex.Unlock()
+
+	var lines []string
+	for y := 0; y < len(rows); y += 8 {
+		var line []rune
+		for column := 0; column < PRINTER_WIDTH/8; column++ {
+			var cell [8]byte
+			for i := range cell {
+				if y+i < len(rows) {
+					cell[i] = rows[y+i][column]
+				}
+			}
+			line = append(line, p.match(cell))
+		}
+		lines = append(lines, strings.TrimRight(string(line), " "))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Returns the character printed in the 8x8 cell
+func (p *Printer) match(cell [8]byte) rune {
+	if cell == [8]byte{} {
+		return ' '
+	}
+
+	for i := 1; i < len(p.font)/8; i++ {
+		if string(p.font[i*8:i*8+8]) == string(cell[:]) {
+			switch c := 0x20 + i; c {
+			case 0x5e:
+				return '↑'
+			case 0x60:
+				return '£'
+			case 0x7f:
+				return '©'
+			default:
+				return rune(c)
+			}
+		}
+	}
+	return '?'
+}
+
+// Discards the printed output
+func (p *Printer) Clear() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rows = nil
+}
@@ -0,0 +1,78 @@
+// +build linux
+
+package spectrum
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// A pseudo-terminal. The slave side is kept open,
+// so that the pty survives the clients opening and closing it.
+type pty struct {
+	*os.File
+	slave *os.File
+}
+
+func (p *pty) Close() error {
+	p.slave.Close()
+	return p.File.Close()
+}
+
+func ioctl(fd uintptr, request uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Creates a pseudo-terminal in raw mode. Returns the master side and the path of the slave device.
+func openPty() (*pty, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var unlock int32 = 0
+	err = ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+
+	var n uint32
+	err = ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	path := "/dev/pts/" + strconv.Itoa(int(n))
+
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+
+	// Raw mode: no echo, no line editing, no character translation
+	var termios syscall.Termios
+	err = ioctl(slave.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	if err == nil {
+		termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+		termios.Oflag &^= syscall.OPOST
+		termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+		termios.Cflag |= syscall.CS8
+		err = ioctl(slave.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+	}
+	if err != nil {
+		slave.Close()
+		master.Close()
+		return nil, "", err
+	}
+
+	return &pty{master, slave}, path, nil
+}
@@ -0,0 +1,12 @@
+// +build !linux
+
+package spectrum
+
+import (
+	"errors"
+	"io"
+)
+
+func openPty() (io.ReadWriteCloser, string, error) {
+	return nil, "", errors.New("pseudo-terminals are not supported on this platform")
+}
@@ -0,0 +1,456 @@
+package spectrum
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// Quick-save slots and the autosave.
+//
+// A quick-save slot holds the saved state of the machine (see state.go), the time when it was saved,
+// and a thumbnail of the screen. The slots are kept in memory, and they are also written
+// to 'QuickSavePath()' as "slot<N>.state" and "slot<N>.png", so that they survive a restart
+// of the emulator. The time of a slot read from the disk is the modification time of the file.
+// If possible, the slot is also written as a SNA snapshot "slot<N>.sna", which other programs
+// can preview. Slots saved by older versions of GoSpeccy consist only of the SNA snapshot.
+//
+// When the autosave is enabled, the session is written to 'AutosavePath()' when the emulator exits:
+// the saved state of the machine, the tape in the tape drive, and the file "session.conf" with the position
+// of the tape, the accelerated-load setting and the FPS. A tape which was playing continues
+// from the beginning of the block which was being played.
+const QUICKSAVE_NUM_SLOTS = 9 // The slots are numbered 1 ... QUICKSAVE_NUM_SLOTS
+
+const (
+	autosave_state    = "autosave.state"
+	autosave_snapshot = "autosave.sna" // Written by older versions
+	autosave_tape     = "autosave.tap"
+	autosave_session  = "session.conf"
+)
+
+type QuickSave struct {
+	Slot uint
+	Time time.Time
+
+	// The screen without the border, at half the size
+	Thumbnail *image.RGBA
+
+	// The saved state, or nil if the slot was saved by an older version
+	state_orNil []byte
+
+	// The SNA snapshot, or nil if the state could not be converted to it
+	sna_orNil []byte
+}
+
+// The settings saved by the autosave, besides the snapshot and the tape
+type session struct {
+	fps             float32 // 0 means the frame rate of the model
+	acceleratedLoad bool
+	tapeBlock       int
+	tapePlaying     bool
+}
+
+type Cmd_SetAutosave struct {
+	Enable bool
+}
+
+type Cmd_LoadAutosave struct {
+	ErrChan chan<- error
+}
+
+func checkQuickSaveSlot(slot uint) error {
+	if (slot < 1) || (slot > QUICKSAVE_NUM_SLOTS) {
+		return fmt.Errorf("invalid quick-save slot %d (the slots are 1-%d)", slot, QUICKSAVE_NUM_SLOTS)
+	}
+	return nil
+}
+
+// Renders the screen (bitmap and attributes). The flashing attributes are ignored.
+func screenThumbnail(screen []byte) *image.RGBA {
+	thumbnail := image.NewRGBA(image.Rect(0, 0, ScreenWidth/2, ScreenHeight/2))
+	for y := 0; y < ScreenHeight; y += 2 {
+		for x := 0; x < ScreenWidth; x += 2 {
+			bitmap := screen[xy_to_screenAddr(uint8(x), uint8(y))-SCREEN_BASE_ADDR]
+			attr := screen[0x1800+(y/8)*32+x/8]
+
+			var ink, paper byte = attr & 0x07, (attr >> 3) & 0x07
+			if (attr & 0x40) != 0 {
+				ink, paper = ink+8, paper+8
+			}
+
+			c := paper
+			if (bitmap & (0x80 >> uint(x&7))) != 0 {
+				c = ink
+			}
+			rgb := rgba(Palette[c])
+			thumbnail.Set(x/2, y/2, color.RGBA{rgb.R, rgb.G, rgb.B, 255})
+		}
+	}
+
+	return thumbnail
+}
+
+func quickSaveFile(dir string, slot uint, ext string) string {
+	return path.Join(dir, fmt.Sprintf("slot%d.%s", slot, ext))
+}
+
+// Writes the state, the thumbnail and the SNA snapshot to the specified directory
+func (q *QuickSave) write(dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	var thumbnail bytes.Buffer
+	if err := png.Encode(&thumbnail, q.Thumbnail); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(quickSaveFile(dir, q.Slot, "state"), q.state_orNil, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(quickSaveFile(dir, q.Slot, "png"), thumbnail.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	snaFile := quickSaveFile(dir, q.Slot, "sna")
+	if q.sna_orNil != nil {
+		return ioutil.WriteFile(snaFile, q.sna_orNil, 0644)
+	}
+	if err := os.Remove(snaFile); (err != nil) && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Reads a quick-save slot from the specified directory.
+// Returns nil and no error if the slot is empty.
+func ReadQuickSave(dir string, slot uint) (*QuickSave, error) {
+	if err := checkQuickSaveSlot(slot); err != nil {
+		return nil, err
+	}
+
+	fileName := quickSaveFile(dir, slot, "state")
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return readOldQuickSave(dir, slot)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnailFile := quickSaveFile(dir, slot, "png")
+	data, err := ioutil.ReadFile(thumbnailFile)
+	if err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", thumbnailFile, err)
+	}
+	thumbnail := image.NewRGBA(img.Bounds())
+	draw.Draw(thumbnail, thumbnail.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	sna, err := ioutil.ReadFile(quickSaveFile(dir, slot, "sna"))
+	if err != nil {
+		sna = nil
+	}
+
+	return &QuickSave{
+		Slot:        slot,
+		Time:        info.ModTime(),
+		Thumbnail:   thumbnail,
+		state_orNil: state,
+		sna_orNil:   sna,
+	}, nil
+}
+
+// Reads a quick-save slot which consists only of a SNA snapshot.
+// Returns nil and no error if the slot is empty.
+func readOldQuickSave(dir string, slot uint) (*QuickSave, error) {
+	fileName := quickSaveFile(dir, slot, "sna")
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sna, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := formats.SnapshotData(sna).DecodeSNA(); err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+
+	const header = 27
+	return &QuickSave{
+		Slot:      slot,
+		Time:      info.ModTime(),
+		Thumbnail: screenThumbnail(sna[header : header+0x1b00]),
+		sna_orNil: sna,
+	}, nil
+}
+
+// Returns the quick-save slot kept in memory, or reads it from 'QuickSavePath()'.
+// Returns nil and no error if the slot is empty.
+func (speccy *Spectrum48k) quickSave(slot uint) (*QuickSave, error) {
+	if err := checkQuickSaveSlot(slot); err != nil {
+		return nil, err
+	}
+
+	speccy.quickSaves_mutex.Lock()
+	q := speccy.quickSaves[slot-1]
+	speccy.quickSaves_mutex.Unlock()
+
+	if q != nil {
+		return q, nil
+	}
+	return ReadQuickSave(QuickSavePath(), slot)
+}
+
+// Saves the state of the machine into the specified slot, in memory and in 'QuickSavePath()'
+func (speccy *Spectrum48k) QuickSave(slot uint) (*QuickSave, error) {
+	if err := checkQuickSaveSlot(slot); err != nil {
+		return nil, err
+	}
+
+	state := speccy.SaveState()
+	q := &QuickSave{
+		Slot:        slot,
+		Time:        time.Now(),
+		Thumbnail:   screenThumbnail(state.Screen),
+		state_orNil: state.Data,
+		sna_orNil:   state.SNA_orNil,
+	}
+
+	speccy.quickSaves_mutex.Lock()
+	speccy.quickSaves[slot-1] = q
+	speccy.quickSaves_mutex.Unlock()
+
+	return q, q.write(QuickSavePath())
+}
+
+// Restores the state of the machine saved in the specified slot
+func (speccy *Spectrum48k) QuickLoad(slot uint) error {
+	q, err := speccy.quickSave(slot)
+	if err != nil {
+		return err
+	}
+	if q == nil {
+		return fmt.Errorf("quick-save slot %d is empty", slot)
+	}
+
+	if q.state_orNil != nil {
+		err := speccy.LoadState(&SavedState{Data: q.state_orNil})
+		if err != nil {
+			return fmt.Errorf("quick-save slot %d: %s", slot, err)
+		}
+		return nil
+	}
+
+	snapshot, err := formats.SnapshotData(q.sna_orNil).DecodeSNA()
+	if err != nil {
+		return err
+	}
+
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_LoadSnapshot{"", snapshot, errChan}
+	return <-errChan
+}
+
+// Returns the non-empty quick-save slots, in the order of their numbers
+func (speccy *Spectrum48k) QuickSaves() ([]*QuickSave, error) {
+	var slots []*QuickSave
+	for slot := uint(1); slot <= QUICKSAVE_NUM_SLOTS; slot++ {
+		q, err := speccy.quickSave(slot)
+		if err != nil {
+			return nil, err
+		}
+		if q != nil {
+			slots = append(slots, q)
+		}
+	}
+	return slots, nil
+}
+
+// Enables or disables saving the session when the emulator exits
+func (speccy *Spectrum48k) SetAutosave(enable bool) {
+	speccy.CommandChannel <- Cmd_SetAutosave{enable}
+}
+
+// Restores the session saved in 'AutosavePath()'.
+// The error satisfies 'os.IsNotExist' if there is no saved session.
+func (speccy *Spectrum48k) LoadAutosave() error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_LoadAutosave{errChan}
+	return <-errChan
+}
+
+func (s *session) encode() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "fps = %g\n", s.fps)
+	fmt.Fprintf(&buf, "accelerated-load = %t\n", s.acceleratedLoad)
+	fmt.Fprintf(&buf, "tape-block = %d\n", s.tapeBlock)
+	fmt.Fprintf(&buf, "tape-playing = %t\n", s.tapePlaying)
+	return buf.Bytes()
+}
+
+// Parses lines in the format "key = value". Unknown keys are ignored.
+func decodeSession(data []byte) (*session, error) {
+	s := &session{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected \"key = value\"", lineNumber)
+		}
+		key := strings.TrimSpace(line[0:i])
+		value := strings.TrimSpace(line[i+1:])
+
+		var err error
+		switch key {
+		case "fps":
+			var fps float64
+			fps, err = strconv.ParseFloat(value, 32)
+			s.fps = float32(fps)
+		case "accelerated-load":
+			s.acceleratedLoad, err = strconv.ParseBool(value)
+		case "tape-block":
+			s.tapeBlock, err = strconv.Atoi(value)
+		case "tape-playing":
+			s.tapePlaying, err = strconv.ParseBool(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+	}
+
+	return s, scanner.Err()
+}
+
+// Writes the session to the specified directory. Called in the emulation goroutine.
+func (speccy *Spectrum48k) writeAutosave(dir string) error {
+	state := speccy.encodeState(speccy.captureState(nil))
+
+	tapeDrive := speccy.tapeDrive
+	s := &session{acceleratedLoad: tapeDrive.AcceleratedLoad}
+	s.tapeBlock, s.tapePlaying = tapeDrive.position()
+
+	if tapeDrive.accelerating {
+		tapeDrive.mutex.RLock()
+		s.fps = tapeDrive.fpsBeforeAcceleration
+		tapeDrive.mutex.RUnlock()
+	} else if !speccy.defaultFPS {
+		s.fps = speccy.currentFPS
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, autosave_state), state, 0644); err != nil {
+		return err
+	}
+	if err := os.Remove(path.Join(dir, autosave_snapshot)); (err != nil) && !os.IsNotExist(err) {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, autosave_session), s.encode(), 0644); err != nil {
+		return err
+	}
+
+	tapeFile := path.Join(dir, autosave_tape)
+	if tapeDrive.tape != nil {
+		return ioutil.WriteFile(tapeFile, tapeDrive.tape.tap.Encode(), 0644)
+	}
+	if err := os.Remove(tapeFile); (err != nil) && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Restores the session saved in the specified directory. Called in the emulation goroutine.
+func (speccy *Spectrum48k) loadAutosave(dir string) error {
+	var state_orNil *rewindState
+	var snapshot_orNil formats.Snapshot
+
+	data, err := ioutil.ReadFile(path.Join(dir, autosave_state))
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(path.Join(dir, autosave_snapshot))
+		if err != nil {
+			return err
+		}
+		snapshot_orNil, err = formats.SnapshotData(data).DecodeSNA()
+	} else if err == nil {
+		state_orNil, err = speccy.decodeState(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err = ioutil.ReadFile(path.Join(dir, autosave_session))
+	if err != nil {
+		return err
+	}
+	s, err := decodeSession(data)
+	if err != nil {
+		return errors.New(autosave_session + ": " + err.Error())
+	}
+
+	var tap_orNil *formats.TAP
+	data, err = ioutil.ReadFile(path.Join(dir, autosave_tape))
+	switch {
+	case err == nil:
+		tap_orNil, err = formats.NewTAP(data)
+		if err != nil {
+			return errors.New(autosave_tape + ": " + err.Error())
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if state_orNil != nil {
+		speccy.reset(nil)
+		speccy.restoreState(state_orNil)
+	} else {
+		err = speccy.loadSnapshot(snapshot_orNil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if tap_orNil != nil {
+		speccy.tapeDrive.Insert(NewTape(tap_orNil))
+		speccy.tapeDrive.seek(s.tapeBlock, s.tapePlaying)
+	}
+	speccy.tapeDrive.AcceleratedLoad = s.acceleratedLoad
+	speccy.setFPS(s.fps, nil)
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package spectrum
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// The rewind buffer.
+//
+// Every 'rewind_interval' frames, the state of the machine is captured into a ring buffer:
+// the registers of the Z80 (as in 'MakeSnapshot'), the memory paging, the state of the AY and of
+// the peripherals implementing 'rewindableDevice', and the RAM. The RAM is stored in 1K pages.
+// A page which has not changed since the previous state is shared with that state,
+// so a state usually takes only a few kilobytes.
+//
+// Rewinding restores an older state. The newer states are kept, so that going forward
+// can return to them, until the machine runs for 'rewind_resumeDelay' frames without
+// being rewound: then the newer states are dropped and the capturing resumes.
+const (
+	rewind_interval    = 10  // Frames between two captured states
+	rewind_resumeDelay = 100 // Frames
+	rewind_pageSize    = 0x400
+)
+
+// Implemented by the devices whose state is saved in the rewind buffer
+type rewindableDevice interface {
+	// Returns a copy of the registers of the device
+	saveState() interface{}
+
+	// Restores the registers. A device which was paged over the system ROM pages
+	// its memory in again, because the saved states do not store it (see state.go).
+	restoreState(state interface{})
+
+	// Returns the RAM of the device (or nil), which is saved in 1K pages
+	rewindRAM() [][]byte
+
+	// Convert a state returned by 'saveState' to bytes and back (see 'encodeState')
+	encodeState(state interface{}) []byte
+	decodeState(data []byte) (interface{}, error)
+}
+
+type rewindState struct {
+	cpu     formats.CpuState
+	halted  bool
+	tstates int
+	border  byte
+
+	port7ffd         byte
+	port1ffd         byte
+	romPaged         bool
+	romPages         [2][]byte
+	romPagesWritable [2]bool
+
+	// The states of the devices, in the order of 'speccy.rewindableDevices()'
+	devices []interface{}
+
+	// The RAM of the machine and of the devices, in 1K pages
+	pages [][]byte
+}
+
+type rewindBuffer struct {
+	// A ring buffer of the states, the oldest state is 'states[first]'
+	states []*rewindState
+	first  int
+	count  int
+
+	// The number of frames since the last capture, or since the last rewind
+	frames int
+
+	// Whether a state has been restored, and its position (0 = the oldest state)
+	rewound bool
+	current int
+}
+
+type Cmd_SetRewindBuffer struct {
+	// The length of the buffer. 0 disables the buffer.
+	Seconds uint
+}
+
+type Cmd_Rewind struct {
+	Seconds float32
+	ErrChan chan<- error
+}
+
+type Cmd_Forward struct {
+	Seconds float32
+	ErrChan chan<- error
+}
+
+func newRewindBuffer(size int) *rewindBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &rewindBuffer{states: make([]*rewindState, size)}
+}
+
+func (r *rewindBuffer) state(i int) *rewindState {
+	return r.states[(r.first+i)%len(r.states)]
+}
+
+func (r *rewindBuffer) add(state *rewindState) {
+	if r.count == len(r.states) {
+		r.states[r.first] = nil
+		r.first = (r.first + 1) % len(r.states)
+		r.count--
+	}
+	r.states[(r.first+r.count)%len(r.states)] = state
+	r.count++
+}
+
+// Drops the states newer than the last restored state
+func (r *rewindBuffer) truncate() {
+	for i := r.current + 1; i < r.count; i++ {
+		r.states[(r.first+i)%len(r.states)] = nil
+	}
+	r.count = r.current + 1
+	r.rewound = false
+}
+
+// Converts a time to a number of captured states
+func (r *rewindBuffer) steps(seconds float32, fps float32) int {
+	steps := int(seconds*fps/rewind_interval + 0.5)
+	if steps < 1 {
+		steps = 1
+	}
+	return steps
+}
+
+// Creates the rewind buffer, or disables it. Called in the emulation goroutine.
+func (speccy *Spectrum48k) setRewindBuffer(seconds uint) {
+	if seconds == 0 {
+		speccy.rewind_orNil = nil
+		return
+	}
+	speccy.rewind_orNil = newRewindBuffer(int(float32(seconds) * speccy.timing.FPS / rewind_interval))
+}
+
+// Drops the captured states. This happens when their layout no longer matches the machine
+// (other model, other peripherals) or the ROM changes.
+func (speccy *Spectrum48k) clearRewindBuffer() {
+	if speccy.rewind_orNil != nil {
+		speccy.rewind_orNil = newRewindBuffer(len(speccy.rewind_orNil.states))
+	}
+}
+
+// Called in the emulation goroutine before each frame
+func (speccy *Spectrum48k) rewindFrame() {
+	r := speccy.rewind_orNil
+	if r == nil {
+		return
+	}
+
+	r.frames++
+	if r.rewound {
+		if r.frames < rewind_resumeDelay {
+			return
+		}
+		r.truncate()
+		r.frames = rewind_interval
+	}
+
+	if r.frames >= rewind_interval {
+		r.frames = 0
+
+		var previous *rewindState
+		if r.count > 0 {
+			previous = r.state(r.count - 1)
+		}
+		r.add(speccy.captureState(previous))
+	}
+}
+
+// Returns the devices whose state is saved in the rewind buffer
+func (speccy *Spectrum48k) rewindableDevices() []rewindableDevice {
+	var devices []rewindableDevice
+	if speccy.ay_orNil != nil {
+		devices = append(devices, speccy.ay_orNil)
+	}
+	for _, p := range speccy.peripherals {
+		if device, ok := p.(rewindableDevice); ok {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// Returns the RAM of the machine and of the devices
+func (speccy *Spectrum48k) rewindRAM(devices []rewindableDevice) [][]byte {
+	memory := speccy.Memory
+
+	var ram [][]byte
+	if memory.model.banked() {
+		ram = append(ram, memory.banks...)
+	} else {
+		ram = append(ram, memory.data[0x4000:])
+	}
+	for _, device := range devices {
+		ram = append(ram, device.rewindRAM()...)
+	}
+	return ram
+}
+
+// Captures the state of the machine. The pages which have not changed since the previous state are shared.
+func (speccy *Spectrum48k) captureState(previous_orNil *rewindState) *rewindState {
+	memory := speccy.Memory
+
+	s := &rewindState{
+		cpu:              speccy.cpuState(),
+		halted:           speccy.Cpu.Halted,
+		tstates:          speccy.Cpu.Tstates,
+		border:           speccy.ula.getBorderColor(),
+		port7ffd:         memory.port7ffd,
+		port1ffd:         memory.port1ffd,
+		romPaged:         memory.romPaged,
+		romPages:         memory.romPages,
+		romPagesWritable: memory.romPagesWritable,
+	}
+
+	devices := speccy.rewindableDevices()
+	for _, device := range devices {
+		s.devices = append(s.devices, device.saveState())
+	}
+
+	for _, ram := range speccy.rewindRAM(devices) {
+		for ofs := 0; ofs < len(ram); ofs += rewind_pageSize {
+			page := ram[ofs : ofs+rewind_pageSize]
+
+			i := len(s.pages)
+			if (previous_orNil != nil) && (i < len(previous_orNil.pages)) && bytes.Equal(previous_orNil.pages[i], page) {
+				s.pages = append(s.pages, previous_orNil.pages[i])
+			} else {
+				s.pages = append(s.pages, append([]byte(nil), page...))
+			}
+		}
+	}
+
+	return s
+}
+
+// Restores a captured state. Called between two frames.
+func (speccy *Spectrum48k) restoreState(s *rewindState) {
+	memory := speccy.Memory
+
+	speccy.setCpuState(s.cpu)
+	speccy.Cpu.Halted = s.halted
+	speccy.Cpu.Tstates = s.tstates
+	speccy.Ports.WritePortInternal(0xfe, s.border, false /*contend*/)
+
+	memory.port7ffd = s.port7ffd
+	memory.port1ffd = s.port1ffd
+	memory.updatePaging()
+	memory.romPaged = s.romPaged
+	memory.romPages = s.romPages
+	memory.romPagesWritable = s.romPagesWritable
+
+	devices := speccy.rewindableDevices()
+	for i, device := range devices {
+		device.restoreState(s.devices[i])
+	}
+
+	i := 0
+	for _, ram := range speccy.rewindRAM(devices) {
+		for ofs := 0; ofs < len(ram); ofs += rewind_pageSize {
+			copy(ram[ofs:ofs+rewind_pageSize], s.pages[i])
+			i++
+		}
+	}
+
+	// Repaint the whole screen
+	speccy.ula.screenSwitched()
+}
+
+// Restores the state captured the specified time ago (the older states are kept).
+// A negative time goes forward, to a state captured before the last rewind.
+// Called in the emulation goroutine.
+func (speccy *Spectrum48k) rewind(seconds float32) error {
+	r := speccy.rewind_orNil
+	if r == nil {
+		return errors.New("the rewind buffer is disabled")
+	}
+	if r.count == 0 {
+		return errors.New("the rewind buffer is empty")
+	}
+	if speccy.netplay_orNil != nil {
+		return errors.New("the emulation cannot be rewound during netplay")
+	}
+
+	if seconds < 0 {
+		if !r.rewound || (r.current == r.count-1) {
+			return errors.New("nothing to forward")
+		}
+		r.current += r.steps(-seconds, speccy.timing.FPS)
+		if r.current > r.count-1 {
+			r.current = r.count - 1
+		}
+	} else {
+		if !r.rewound {
+			r.current = r.count
+		}
+		r.current -= r.steps(seconds, speccy.timing.FPS)
+		if r.current < 0 {
+			r.current = 0
+		}
+	}
+
+	r.rewound = true
+	r.frames = 0
+	speccy.restoreState(r.state(r.current))
+	return nil
+}
+
+// Sets the length of the rewind buffer. 0 disables the buffer.
+func (speccy *Spectrum48k) SetRewindBuffer(seconds uint) {
+	speccy.CommandChannel <- Cmd_SetRewindBuffer{seconds}
+}
+
+// Returns the emulation to the state it had the specified time ago
+func (speccy *Spectrum48k) Rewind(seconds float32) error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_Rewind{seconds, errChan}
+	return <-errChan
+}
+
+// Undoes rewinding by the specified time. It is possible only until
+// the emulation captures a new state after the last rewind.
+func (speccy *Spectrum48k) Forward(seconds float32) error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_Forward{seconds, errChan}
+	return <-errChan
+}
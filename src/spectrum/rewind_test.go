@@ -0,0 +1,155 @@
+package spectrum
+
+import (
+	"testing"
+)
+
+// The index of the 1K page holding the address 0x8000, in the states of the 48K
+const rewindTest_page = (0x8000 - 0x4000) / rewind_pageSize
+
+func TestRewindCaptureSharesPages(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	speccy := NewSpectrum48k(app, *rom)
+
+	first := speccy.captureState(nil)
+	speccy.Memory.Write(0x8000, 0x55, false)
+	second := speccy.captureState(first)
+
+	if len(second.pages) != 0xc000/rewind_pageSize {
+		t.Fatalf("expected %d pages, got %d", 0xc000/rewind_pageSize, len(second.pages))
+	}
+	for i := range second.pages {
+		shared := (&second.pages[i][0] == &first.pages[i][0])
+		if shared != (i != rewindTest_page) {
+			t.Errorf("page %d: shared=%v, only the unchanged pages have to be shared", i, shared)
+		}
+	}
+
+	// The pages are copies of the RAM
+	if first.pages[rewindTest_page][0] != 0x00 {
+		t.Error("the first state has been modified")
+	}
+	if second.pages[rewindTest_page][0] != 0x55 {
+		t.Error("the second state does not contain the written byte")
+	}
+	speccy.Memory.Write(0x8000, 0xaa, false)
+	if second.pages[rewindTest_page][0] != 0x55 {
+		t.Error("the second state shares the memory with the RAM")
+	}
+}
+
+func TestRewindForwardTruncate(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	speccy := NewSpectrum48k(app, *rom)
+	speccy.rewind_orNil = newRewindBuffer(4)
+	r := speccy.rewind_orNil
+
+	if err := speccy.rewind(1); err == nil {
+		t.Error("expected an error when rewinding an empty buffer")
+	}
+
+	// Runs the frames until the next capture. The captured state is marked by 'b' at 0x8000.
+	capture := func(b byte) {
+		speccy.Memory.Write(0x8000, b, false)
+		for i := 0; i < rewind_interval; i++ {
+			speccy.rewindFrame()
+		}
+	}
+
+	// Returns the marks of the states in the buffer, the oldest first
+	marks := func() []byte {
+		var m []byte
+		for i := 0; i < r.count; i++ {
+			m = append(m, r.state(i).pages[rewindTest_page][0])
+		}
+		return m
+	}
+
+	checkMarks := func(expected ...byte) {
+		if m := marks(); string(m) != string(expected) {
+			t.Errorf("expected the states % x in the buffer, got % x", expected, m)
+		}
+	}
+
+	// The ring buffer keeps the 4 newest states
+	for b := byte(0); b < 6; b++ {
+		capture(b)
+	}
+	checkMarks(2, 3, 4, 5)
+
+	// The time between two captured states
+	step := rewind_interval / speccy.timing.FPS
+
+	tests := []struct {
+		seconds  float32
+		expected byte // The mark at 0x8000 after rewinding
+	}{
+		{step, 5},       // The newest state
+		{2 * step, 3},   // Two states back
+		{100, 2},        // The oldest state
+		{-step, 3},      // Forward
+		{-100, 5},       // The newest state
+		{2 * step, 3},   // Rewinding continues from the restored state
+		{0.5 * step, 2}, // At least one state
+	}
+	speccy.Memory.Write(0x8000, 0xff, false)
+	for i, test := range tests {
+		if err := speccy.rewind(test.seconds); err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		if b := speccy.Memory.Read(0x8000); b != test.expected {
+			t.Errorf("test %d: expected 0x%02x at 0x8000, got 0x%02x", i, test.expected, b)
+		}
+	}
+	checkMarks(2, 3, 4, 5)
+
+	if err := speccy.rewind(-100); err != nil {
+		t.Fatal(err)
+	}
+	if err := speccy.rewind(-step); err == nil {
+		t.Error("expected an error when going forward from the newest state")
+	}
+
+	// The newer states are dropped after the emulation runs without being rewound,
+	// and the capturing resumes immediately
+	if err := speccy.rewind(2 * step); err != nil {
+		t.Fatal(err)
+	}
+	speccy.Memory.Write(0x8000, 0x77, false)
+	for i := 0; i < rewind_resumeDelay-1; i++ {
+		speccy.rewindFrame()
+	}
+	checkMarks(2, 3, 4, 5)
+	speccy.rewindFrame()
+	checkMarks(2, 3, 0x77)
+
+	if err := speccy.rewind(-step); err == nil {
+		t.Error("expected an error when going forward after the newer states were dropped")
+	}
+
+	// The ring buffer wraps around after the truncation
+	capture(0x88)
+	checkMarks(2, 3, 0x77, 0x88)
+	capture(0x99)
+	checkMarks(3, 0x77, 0x88, 0x99)
+}
@@ -0,0 +1,330 @@
+package spectrum
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// The RS-232 port of the Interface 1.
+//
+// The Interface 1 ROM transmits and receives the serial data bit by bit,
+// by writing bit 0 and reading bit 7 of port 0xF7. The bits are timed by
+// the CPU, so the line is decoded by looking at the T-states.
+// The bytes are exchanged with a host pty or TCP socket (see 'ConnectRS232').
+
+const (
+	IF1_SYSVAR_BAUD = 0x5CC3 // The Interface 1 system variable holding the bit time
+	RS232_BUFFER    = 4096
+)
+
+type rs232 struct {
+	speccy *Spectrum48k
+
+	// The time of the last port access, in T-states since the port was created
+	time        int64
+	lastTstates int
+
+	// Transmitter (Spectrum to host)
+	txSpace bool // The current level of the line (true = space, logical 0)
+	txBusy  bool
+	txStart int64
+	txBit   uint
+	txByte  byte
+
+	// Receiver (host to Spectrum)
+	rxBusy  bool
+	rxStart int64
+	rxByte  byte
+
+	bridge_orNil *serialBridge
+}
+
+func (r *rs232) reset() {
+	r.txSpace = false
+	r.txBusy = false
+	r.rxBusy = false
+}
+
+// Returns the current time in T-states
+func (r *rs232) now() int64 {
+	tstates := r.speccy.Cpu.Tstates
+	if tstates < r.lastTstates {
+		// A new frame has started
+		r.time += int64(r.speccy.timing.TStatesPerFrame - r.lastTstates + tstates)
+	} else {
+		r.time += int64(tstates - r.lastTstates)
+	}
+	r.lastTstates = tstates
+	return r.time
+}
+
+// Returns the duration of a bit in T-states, as configured by the FORMAT "b" command
+func (r *rs232) bitTime() int64 {
+	memory := r.speccy.Memory
+	baud := int64(memory.Read(IF1_SYSVAR_BAUD)) | (int64(memory.Read(IF1_SYSVAR_BAUD+1)) << 8)
+	if baud == 0 {
+		baud = 12 // 9600 baud
+	}
+	return 26 * (baud + 2)
+}
+
+// Returns true if a host is connected to the port
+func (r *rs232) connected() bool {
+	return (r.bridge_orNil != nil) && r.bridge_orNil.connected()
+}
+
+// Called when the Interface 1 writes to port 0xF7
+func (r *rs232) write(b byte) {
+	now := r.now()
+	space := ((b & 0x01) != 0)
+
+	if r.txBusy {
+		// Sample the data bits in the middle of each bit
+		bitTime := r.bitTime()
+		for r.txBit < 8 {
+			sample := r.txStart + bitTime*int64(2*r.txBit+3)/2
+			if sample > now {
+				break
+			}
+			if !r.txSpace {
+				r.txByte |= 1 << r.txBit
+			}
+			r.txBit++
+		}
+
+		if r.txBit == 8 {
+			r.txBusy = false
+			if r.bridge_orNil != nil {
+				r.bridge_orNil.send(r.txByte)
+			}
+		}
+	}
+
+	if !r.txBusy && space && !r.txSpace {
+		// Start bit
+		r.txBusy = true
+		r.txStart = now
+		r.txBit = 0
+		r.txByte = 0
+	}
+
+	r.txSpace = space
+}
+
+// Called when the Interface 1 reads port 0xF7. Bit 7 is set if the line is at space level.
+func (r *rs232) read() byte {
+	now := r.now()
+	bitTime := r.bitTime()
+
+	if r.rxBusy && (now-r.rxStart >= 10*bitTime) {
+		r.rxBusy = false
+	}
+
+	if !r.rxBusy {
+		if r.bridge_orNil == nil {
+			return 0x7f
+		}
+		b, ok := r.bridge_orNil.receive()
+		if !ok {
+			return 0x7f
+		}
+		r.rxBusy = true
+		r.rxStart = now
+		r.rxByte = b
+	}
+
+	var space bool
+	switch bit := (now - r.rxStart) / bitTime; {
+	case bit == 0:
+		space = true
+	case bit <= 8:
+		space = ((r.rxByte >> uint(bit-1)) & 1) == 0
+	default:
+		space = false
+	}
+
+	if space {
+		return 0xff
+	}
+	return 0x7f
+}
+
+// Connects the RS-232 port to a host pty ("pty") or to a TCP socket ("tcp:ADDRESS").
+// Returns a message describing where the port can be reached.
+func (r *rs232) connect(spec string) (string, error) {
+	var bridge *serialBridge
+	var msg string
+
+	switch {
+	case spec == "pty":
+		file, path, err := openPty()
+		if err != nil {
+			return "", err
+		}
+		bridge = newSerialBridge()
+		bridge.setConn(file)
+		msg = "Interface 1 RS-232 connected to " + path
+
+	case strings.HasPrefix(spec, "tcp:"):
+		addr := strings.TrimPrefix(spec, "tcp:")
+		if !strings.Contains(addr, ":") {
+			addr = "localhost:" + addr
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return "", err
+		}
+		bridge = newSerialBridge()
+		bridge.listen(listener)
+		msg = "Interface 1 RS-232 listening on " + listener.Addr().String()
+
+	default:
+		return "", errors.New("invalid RS-232 connection \"" + spec + "\" (expected \"pty\" or \"tcp:ADDRESS\")")
+	}
+
+	r.disconnect()
+	r.bridge_orNil = bridge
+	return msg, nil
+}
+
+func (r *rs232) disconnect() {
+	if r.bridge_orNil != nil {
+		r.bridge_orNil.close()
+		r.bridge_orNil = nil
+	}
+}
+
+// Transfers the bytes between the RS-232 port and a host connection
+type serialBridge struct {
+	mutex      sync.Mutex
+	conn_orNil io.ReadWriteCloser
+	listener   net.Listener
+
+	tx   chan byte
+	rx   chan byte
+	quit chan bool
+}
+
+func newSerialBridge() *serialBridge {
+	b := &serialBridge{
+		tx:   make(chan byte, RS232_BUFFER),
+		rx:   make(chan byte, RS232_BUFFER),
+		quit: make(chan bool),
+	}
+	go b.writeLoop()
+	return b
+}
+
+func (b *serialBridge) connected() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.conn_orNil != nil
+}
+
+// Accepts the clients, one at a time
+func (b *serialBridge) listen(listener net.Listener) {
+	b.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			b.setConn(conn)
+			<-b.readLoop(conn)
+		}
+	}()
+}
+
+func (b *serialBridge) setConn(conn io.ReadWriteCloser) {
+	b.mutex.Lock()
+	b.conn_orNil = conn
+	b.mutex.Unlock()
+
+	if b.listener == nil {
+		b.readLoop(conn)
+	}
+}
+
+// Reads the bytes sent by the host. The returned channel is closed when the connection ends.
+func (b *serialBridge) readLoop(conn io.ReadWriteCloser) <-chan bool {
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			for i := 0; i < n; i++ {
+				select {
+				case b.rx <- buf[i]:
+				case <-b.quit:
+					return
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		b.mutex.Lock()
+		if b.conn_orNil == conn {
+			b.conn_orNil = nil
+		}
+		b.mutex.Unlock()
+		conn.Close()
+	}()
+	return done
+}
+
+func (b *serialBridge) writeLoop() {
+	for {
+		select {
+		case c := <-b.tx:
+			b.mutex.Lock()
+			conn := b.conn_orNil
+			b.mutex.Unlock()
+
+			if conn != nil {
+				conn.Write([]byte{c})
+			}
+
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+// Queues a byte for the host. The byte is dropped if the buffer is full.
+func (b *serialBridge) send(c byte) {
+	select {
+	case b.tx <- c:
+	default:
+	}
+}
+
+// Returns the next byte sent by the host, if any
+func (b *serialBridge) receive() (byte, bool) {
+	select {
+	case c := <-b.rx:
+		return c, true
+	default:
+		return 0, false
+	}
+}
+
+func (b *serialBridge) close() {
+	close(b.quit)
+
+	b.mutex.Lock()
+	if b.listener != nil {
+		b.listener.Close()
+	}
+	if b.conn_orNil != nil {
+		b.conn_orNil.Close()
+	}
+	b.mutex.Unlock()
+}
@@ -0,0 +1,276 @@
+package spectrum
+
+// An SD card (SDHC) in SPI mode, as connected to the DivMMC.
+//
+// Each byte sent by the host is exchanged for a byte sent by the card.
+// The card supports the commands used by esxDOS and the usual card drivers:
+// initialization, reading the CSD/CID/OCR registers, and reading and writing
+// single or multiple blocks. Blocks are addressed by their number (SDHC).
+
+const (
+	SD_R1_READY           = 0x00
+	SD_R1_IDLE            = 0x01
+	SD_R1_ILLEGAL_COMMAND = 0x04
+	SD_R1_ADDRESS_ERROR   = 0x20
+
+	SD_TOKEN_START_BLOCK = 0xfe
+	SD_TOKEN_START_MULTI = 0xfc
+	SD_TOKEN_STOP_MULTI  = 0xfd
+	SD_DATA_ACCEPTED     = 0x05
+	SD_DATA_WRITE_ERROR  = 0x0d
+	SD_DATA_READ_ERROR   = 0x01
+)
+
+const (
+	sd_writeNone = iota
+	sd_writeWaitToken
+	sd_writeData
+)
+
+type sdCard struct {
+	disk *diskImage
+
+	idle   bool
+	appCmd bool
+
+	// The command being received (6 bytes)
+	cmd    [6]byte
+	cmdLen int
+
+	// The bytes to be sent to the host
+	response []byte
+
+	// Multiple block read in progress
+	readMulti bool
+	readBlock uint32
+
+	// Block write in progress
+	writeState int
+	writeMulti bool
+	writeBlock uint32
+	writeBuf   []byte
+}
+
+func newSDCard(disk *diskImage) *sdCard {
+	return &sdCard{disk: disk, idle: true}
+}
+
+func (card *sdCard) reset() {
+	card.idle = true
+	card.appCmd = false
+	card.cmdLen = 0
+	card.response = nil
+	card.readMulti = false
+	card.writeState = sd_writeNone
+}
+
+// Sends a byte to the card, and returns the byte sent by the card
+func (card *sdCard) exchange(in byte) byte {
+	var out byte = 0xff
+	if len(card.response) > 0 {
+		out = card.response[0]
+		card.response = card.response[1:]
+	} else if card.readMulti {
+		card.readMulti = card.queueBlock(card.readBlock)
+		card.readBlock++
+	}
+
+	switch card.writeState {
+	case sd_writeWaitToken:
+		card.receiveToken(in)
+		return out
+	case sd_writeData:
+		card.receiveData(in)
+		return out
+	}
+
+	if card.cmdLen == 0 {
+		if (in & 0xc0) == 0x40 {
+			card.cmd[0] = in
+			card.cmdLen = 1
+		}
+	} else {
+		card.cmd[card.cmdLen] = in
+		card.cmdLen++
+		if card.cmdLen == len(card.cmd) {
+			card.cmdLen = 0
+			card.execute()
+		}
+	}
+
+	return out
+}
+
+// Queues a response, preceded by one byte of delay
+func (card *sdCard) respond(bytes ...byte) {
+	card.response = append([]byte{0xff}, bytes...)
+}
+
+// Queues the data token and the contents of the block. Returns false on error.
+func (card *sdCard) queueBlock(block uint32) bool {
+	buf := make([]byte, DISK_SECTOR_SIZE)
+	if err := card.disk.readSector(block, buf); err != nil {
+		card.response = append(card.response, 0xff, SD_DATA_READ_ERROR)
+		return false
+	}
+
+	card.response = append(card.response, 0xff, SD_TOKEN_START_BLOCK)
+	card.response = append(card.response, buf...)
+	card.response = append(card.response, 0xff, 0xff) // CRC
+	return true
+}
+
+func (card *sdCard) r1() byte {
+	if card.idle {
+		return SD_R1_IDLE
+	}
+	return SD_R1_READY
+}
+
+func (card *sdCard) execute() {
+	index := card.cmd[0] & 0x3f
+	arg := uint32(card.cmd[1])<<24 | uint32(card.cmd[2])<<16 | uint32(card.cmd[3])<<8 | uint32(card.cmd[4])
+
+	appCmd := card.appCmd
+	card.appCmd = false
+
+	switch {
+	case index == 0: // GO_IDLE_STATE
+		card.reset()
+		card.respond(SD_R1_IDLE)
+
+	case index == 1: // SEND_OP_COND
+		card.idle = false
+		card.respond(SD_R1_READY)
+
+	case index == 8: // SEND_IF_COND
+		card.respond(card.r1(), 0x00, 0x00, byte(arg>>8)&0x0f, byte(arg))
+
+	case index == 9: // SEND_CSD
+		card.respond(card.r1(), 0xff, SD_TOKEN_START_BLOCK)
+		card.response = append(card.response, card.csd()...)
+		card.response = append(card.response, 0xff, 0xff)
+
+	case index == 10: // SEND_CID
+		card.respond(card.r1(), 0xff, SD_TOKEN_START_BLOCK)
+		card.response = append(card.response, card.cid()...)
+		card.response = append(card.response, 0xff, 0xff)
+
+	case index == 12: // STOP_TRANSMISSION
+		card.readMulti = false
+		card.respond(0xff, card.r1())
+
+	case index == 16: // SET_BLOCKLEN
+		card.respond(card.r1())
+
+	case (index == 17) || (index == 18): // READ_SINGLE_BLOCK, READ_MULTIPLE_BLOCK
+		if arg >= card.disk.sectors {
+			card.respond(SD_R1_ADDRESS_ERROR)
+			break
+		}
+		card.respond(SD_R1_READY)
+		ok := card.queueBlock(arg)
+		card.readMulti = ok && (index == 18)
+		card.readBlock = arg + 1
+
+	case (index == 24) || (index == 25): // WRITE_BLOCK, WRITE_MULTIPLE_BLOCK
+		if arg >= card.disk.sectors {
+			card.respond(SD_R1_ADDRESS_ERROR)
+			break
+		}
+		card.respond(SD_R1_READY)
+		card.writeState = sd_writeWaitToken
+		card.writeMulti = (index == 25)
+		card.writeBlock = arg
+
+	case index == 55: // APP_CMD
+		card.appCmd = true
+		card.respond(card.r1())
+
+	case appCmd && (index == 41): // SD_SEND_OP_COND
+		card.idle = false
+		card.respond(SD_R1_READY)
+
+	case index == 58: // READ_OCR
+		// Powered up, card capacity status = 1 (block addressing), 2.7-3.6V
+		card.respond(card.r1(), 0xc0, 0xff, 0x80, 0x00)
+
+	case index == 59: // CRC_ON_OFF
+		card.respond(card.r1())
+
+	default:
+		card.respond(card.r1() | SD_R1_ILLEGAL_COMMAND)
+	}
+}
+
+func (card *sdCard) receiveToken(in byte) {
+	switch {
+	case (in == SD_TOKEN_START_BLOCK) && !card.writeMulti:
+		card.writeState = sd_writeData
+		card.writeBuf = card.writeBuf[0:0]
+	case (in == SD_TOKEN_START_MULTI) && card.writeMulti:
+		card.writeState = sd_writeData
+		card.writeBuf = card.writeBuf[0:0]
+	case (in == SD_TOKEN_STOP_MULTI) && card.writeMulti:
+		card.writeState = sd_writeNone
+		card.response = append(card.response, 0xff, 0x00) // Busy
+	}
+}
+
+func (card *sdCard) receiveData(in byte) {
+	card.writeBuf = append(card.writeBuf, in)
+	if len(card.writeBuf) < DISK_SECTOR_SIZE+2 {
+		return
+	}
+
+	if err := card.disk.writeSector(card.writeBlock, card.writeBuf); err == nil {
+		card.response = append(card.response, SD_DATA_ACCEPTED, 0x00) // Busy
+	} else {
+		card.response = append(card.response, SD_DATA_WRITE_ERROR, 0x00)
+	}
+	card.writeBlock++
+
+	if card.writeMulti {
+		card.writeState = sd_writeWaitToken
+	} else {
+		card.writeState = sd_writeNone
+	}
+}
+
+// Returns the CSD register (version 2.0)
+func (card *sdCard) csd() []byte {
+	// C_SIZE is the capacity in units of 512K, minus one
+	cSize := card.disk.sectors / 1024
+	if cSize > 0 {
+		cSize--
+	}
+
+	return []byte{
+		0x40, // CSD_STRUCTURE = 1
+		0x0e, // TAAC
+		0x00, // NSAC
+		0x32, // TRAN_SPEED = 25 MHz
+		0x5b, // CCC
+		0x59, // CCC, READ_BL_LEN = 9
+		0x00, // READ_BL_PARTIAL, ...
+		byte(cSize>>16) & 0x3f,
+		byte(cSize >> 8),
+		byte(cSize),
+		0x7f, 0x80, // ERASE_BLK_EN, SECTOR_SIZE, WP_GRP_SIZE
+		0x0a, 0x40, // R2W_FACTOR, WRITE_BL_LEN = 9
+		0x00, // FILE_FORMAT
+		0x01, // CRC
+	}
+}
+
+// Returns the CID register
+func (card *sdCard) cid() []byte {
+	return []byte{
+		0x00, 'G', 'S', // Manufacturer, OEM
+		'S', 'P', 'E', 'C', 'Y', // Product name
+		0x10,                   // Product revision
+		0x00, 0x00, 0x00, 0x01, // Serial number
+		0x01, 0x4a, // Manufacturing date
+		0x01, // CRC
+	}
+}
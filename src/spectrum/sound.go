@@ -13,7 +13,24 @@ type AudioData struct {
 	// The FPS (frames per second) value that applies to this AudioData object
 	FPS float32
 
+	// The timing of the emulated model. The last beeper event is at 'Timing.TStatesPerFrame'.
+	Timing *Timing
+
 	BeeperEvents []BeeperEvent
+
+	// The mixed output of the sampled sound devices (AY, SpecDrum, Covox, Currah µSpeech),
+	// which is added to the beeper. The list is empty when the devices are silent,
+	// otherwise its first event is at T-state 0 and its last event at 'Timing.TStatesPerFrame'.
+	SampleEvents []SampleEvent
+}
+
+// A change of the output of the sampled sound devices
+type SampleEvent struct {
+	// The number of T-states since the beginning of the frame
+	TState int
+
+	// The output level, as a 16-bit signed value
+	Level int16
 }
 
 const MAX_AUDIO_LEVEL = 3
@@ -58,3 +75,130 @@ type AudioReceiver interface {
 	// Closes the audio device associated with this AudioReceiver
 	Close()
 }
+
+// Implemented by devices producing sound, which is mixed with the beeper
+type soundSource interface {
+	// Called at the end of each frame. Returns the output of the device during the frame
+	// (from T-state 0 to the end of the frame), or nil if the device was silent.
+	frameSound() []SampleEvent
+}
+
+// Returns the mixed output of the AY and of the connected sound devices during the frame
+func (speccy *Spectrum48k) getSampleEvents() []SampleEvent {
+	var outputs [][]SampleEvent
+	if speccy.ay_orNil != nil {
+		if events := speccy.ay_orNil.frameSound(); events != nil {
+			outputs = append(outputs, events)
+		}
+	}
+	for _, p := range speccy.peripherals {
+		if source, ok := p.(soundSource); ok {
+			if events := source.frameSound(); events != nil {
+				outputs = append(outputs, events)
+			}
+		}
+	}
+	return mixSampleEvents(outputs)
+}
+
+// Adds the outputs of several devices together
+func mixSampleEvents(outputs [][]SampleEvent) []SampleEvent {
+	switch len(outputs) {
+	case 0:
+		return nil
+	case 1:
+		return outputs[0]
+	}
+
+	var mixed []SampleEvent
+	next := make([]int, len(outputs))
+	for {
+		// The earliest change of any output
+		tstate := -1
+		for i, events := range outputs {
+			if (next[i] < len(events)) && ((tstate == -1) || (events[next[i]].TState < tstate)) {
+				tstate = events[next[i]].TState
+			}
+		}
+		if tstate == -1 {
+			break
+		}
+
+		level := 0
+		for i, events := range outputs {
+			for (next[i] < len(events)) && (events[next[i]].TState <= tstate) {
+				next[i]++
+			}
+			level += int(events[next[i]-1].Level)
+		}
+		if level > 0x7fff {
+			level = 0x7fff
+		} else if level < -0x8000 {
+			level = -0x8000
+		}
+
+		mixed = append(mixed, SampleEvent{tstate, int16(level)})
+	}
+
+	return mixed
+}
+
+// The output of an 8-bit DAC, recorded during the frame
+type dacOutput struct {
+	level int16
+
+	// The changes of the level since the beginning of the frame.
+	// The T-states of the changes made after the end of the frame are greater than the frame length.
+	events []SampleEvent
+}
+
+// Changes the level at the specified T-state of the frame
+func (dac *dacOutput) set(tstate int, level int16) {
+	if level == dac.level {
+		return
+	}
+	if len(dac.events) == 0 {
+		dac.events = append(dac.events, SampleEvent{0, dac.level})
+	}
+
+	if n := len(dac.events); dac.events[n-1].TState == tstate {
+		dac.events[n-1].Level = level
+	} else {
+		dac.events = append(dac.events, SampleEvent{tstate, level})
+	}
+	dac.level = level
+}
+
+// Returns the output during the frame which has just ended (or nil if it was silent),
+// and moves the changes made after the end of the frame to the next frame
+func (dac *dacOutput) endFrame(tstatesPerFrame int) []SampleEvent {
+	if len(dac.events) == 0 {
+		if dac.level == 0 {
+			return nil
+		}
+		return []SampleEvent{{0, dac.level}, {tstatesPerFrame, dac.level}}
+	}
+
+	n := 0
+	for (n < len(dac.events)) && (dac.events[n].TState < tstatesPerFrame) {
+		n++
+	}
+	endLevel := dac.events[n-1].Level
+
+	frame := make([]SampleEvent, n, n+1)
+	copy(frame, dac.events[0:n])
+	frame = append(frame, SampleEvent{tstatesPerFrame, endLevel})
+
+	var next []SampleEvent
+	if n < len(dac.events) {
+		if dac.events[n].TState > tstatesPerFrame {
+			next = append(next, SampleEvent{0, endLevel})
+		}
+		for _, e := range dac.events[n:] {
+			next = append(next, SampleEvent{e.TState - tstatesPerFrame, e.Level})
+		}
+	}
+	dac.events = next
+
+	return frame
+}
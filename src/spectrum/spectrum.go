@@ -36,10 +36,6 @@ import (
 	"github.com/remogatto/z80"
 )
 
-const TStatesPerFrame = 69888 // Number of T-states per frame
-const InterruptLength = 32    // How long does an interrupt last in T-states
-const DefaultFPS = 50.08
-
 type RomType int
 
 const (
@@ -69,6 +65,8 @@ type Spectrum48k struct {
 	ula       *ULA
 	Keyboard  *Keyboard
 	Joystick  *Joystick
+	Input     *InputMapper
+	Mouse     *Mouse
 	tapeDrive *TapeDrive
 
 	Ports *Ports
@@ -76,12 +74,49 @@ type Spectrum48k struct {
 	rom     [0x4000]byte
 	romType RomType
 
+	// The system ROM while an Interface 2 cartridge replaces it,
+	// and the joystick interfaces of the gamepads before the cartridge was inserted
+	systemROM_orNil    *[0x4000]byte
+	cartridgeJoysticks [2]uint
+
+	// The emulated model, and the floppy disk controller of the +3.
+	// They are modified only in the emulation goroutine, while holding the mutex.
+	model       Model
+	fdc_orNil   *FDC
+	model_mutex sync.Mutex
+
+	// The AY sound chip of the 128K models
+	ay_orNil *AY
+
+	// The recently captured states of the machine
+	rewind_orNil *rewindBuffer
+
+	// The quick-save slots kept in memory (nil = not yet saved or read)
+	quickSaves       [QUICKSAVE_NUM_SLOTS]*QuickSave
+	quickSaves_mutex sync.Mutex
+
+	// Whether to save the session when the emulator exits
+	autosave bool
+
+	// The connection to the other player during netplay
+	netplay_orNil *netplay
+
+	// Whether the Z80 can still accept the interrupt of the current frame
+	// (the interrupts were disabled at the beginning of the frame)
+	interruptPending bool
+
+	// The timing of the emulated model
+	timing *Timing
+
 	// The current display refresh frequency.
-	// The initial value is 'DefaultFPS'.
+	// The initial value is the frame rate of the model.
 	// It is always greater than 0.
 	currentFPS       float32
 	currentFPS_mutex sync.Mutex // To respect the Go memory model
 
+	// Whether 'currentFPS' follows the frame rate of the model
+	defaultFPS bool
+
 	// A value received from this channel sets the display refresh frequency
 	fpsCh chan float32
 
@@ -105,6 +140,21 @@ type Spectrum48k struct {
 
 	app *Application
 
+	// Devices connected to the expansion bus.
+	// The slice is modified only in the emulation goroutine, while holding the mutex.
+	peripherals       []Peripheral
+	peripherals_mutex sync.RWMutex
+
+	// Fetch traps, indexed by address. 'isFetchTrap' makes the check in 'doOpcodes' fast.
+	fetchTraps  map[uint16][]fetchTrap
+	isFetchTrap [0x4000]bool
+
+	// Fetch traps called for opcodes fetched from RAM while memory is paged over the system ROM
+	ramFetchTraps []fetchTrap
+
+	// A non-maskable interrupt has been requested
+	nmiPending bool
+
 	readFromTape bool
 
 	// The value is non-zero if a couple of the most recent frames
@@ -183,6 +233,11 @@ type Cmd_SetAcceleratedLoad struct {
 	Enable bool
 }
 
+type Cmd_SetIssue2 struct {
+	// Whether the keyboard port behaves as on an Issue 2 Spectrum (otherwise Issue 3)
+	Enable bool
+}
+
 // Creates a new speccy object and starts its command-loop goroutine.
 //
 // The returned object's CommandChannel can be used to
@@ -195,6 +250,8 @@ func NewSpectrum48k(app *Application, rom [0x4000]byte) *Spectrum48k {
 	memory := NewMemory()
 	keyboard := NewKeyboard()
 	joystick := NewJoystick()
+	input := NewInputMapper()
+	mouse := NewMouse()
 	ports := NewPorts()
 	z80 := z80.NewZ80(memory, ports)
 	ula := NewULA()
@@ -207,6 +264,8 @@ func NewSpectrum48k(app *Application, rom [0x4000]byte) *Spectrum48k {
 		ula:            ula,
 		Keyboard:       keyboard,
 		Joystick:       joystick,
+		Input:          input,
+		Mouse:          mouse,
 		Ports:          ports,
 		rom:            rom,
 		romType:        ROM_UNKNOWN,
@@ -219,15 +278,19 @@ func NewSpectrum48k(app *Application, rom [0x4000]byte) *Spectrum48k {
 	memory.init(speccy)
 	keyboard.init(speccy)
 	joystick.init(speccy)
+	input.init(speccy)
+	mouse.init(speccy)
 	ula.init(z80, memory, ports)
 	ports.init(speccy)
 	tapeDrive.init(speccy)
 
+	speccy.setTiming(MODEL_48K.Timing())
 	speccy.reset(nil)
 
-	speccy.currentFPS = DefaultFPS
+	speccy.currentFPS = speccy.timing.FPS
+	speccy.defaultFPS = true
 	speccy.fpsCh = make(chan float32, 1)
-	speccy.fpsCh <- DefaultFPS
+	speccy.fpsCh <- speccy.currentFPS
 
 	commandChannel := make(chan interface{})
 	speccy.CommandChannel = commandChannel
@@ -239,6 +302,7 @@ func NewSpectrum48k(app *Application, rom [0x4000]byte) *Spectrum48k {
 
 // Turn off the machine
 func (speccy *Spectrum48k) Close() {
+	speccy.detachAllPeripherals()
 	speccy.close()
 
 	if speccy.app.Verbose {
@@ -265,6 +329,35 @@ func (speccy *Spectrum48k) GetCurrentFPS() float32 {
 	return fps
 }
 
+// Sets the display refresh frequency. A value not greater than 1.0 selects the frame rate of the model.
+// The old value is sent to 'oldFPS_orNil' (0 if it was the frame rate of the model).
+// Called in the emulation goroutine.
+func (speccy *Spectrum48k) setFPS(newFPS float32, oldFPS_orNil chan<- float32) {
+	speccy.currentFPS_mutex.Lock()
+	defer speccy.currentFPS_mutex.Unlock()
+
+	if oldFPS_orNil != nil {
+		if speccy.defaultFPS {
+			oldFPS_orNil <- 0
+		} else {
+			oldFPS_orNil <- speccy.currentFPS
+		}
+	}
+
+	speccy.defaultFPS = (newFPS <= 1.0)
+	if speccy.defaultFPS {
+		newFPS = speccy.timing.FPS
+	}
+
+	if newFPS != speccy.currentFPS {
+		speccy.currentFPS = newFPS
+
+		go func() {
+			speccy.fpsCh <- newFPS
+		}()
+	}
+}
+
 // Load a program (tape or snapshot)
 func (speccy *Spectrum48k) load(program interface{}) error {
 	var err error
@@ -275,6 +368,8 @@ func (speccy *Spectrum48k) load(program interface{}) error {
 		speccy.loadSnapshot(program.(formats.Snapshot))
 	case *formats.TAP:
 		speccy.loadTape(program)
+	case *formats.Cartridge:
+		err = speccy.insertCartridge(program)
 	default:
 		err = errors.New("Invalid program type.")
 		return err
@@ -367,6 +462,14 @@ func commandLoop(speccy *Spectrum48k) {
 				speccy.systemROMLoaded_orNil = nil
 			}
 
+			if speccy.autosave {
+				err := speccy.writeAutosave(AutosavePath())
+				if err != nil {
+					speccy.app.PrintfMsg("autosave: %s", err)
+				}
+			}
+
+			speccy.stopNetplay(nil)
 			speccy.Close()
 			evtLoop.Pause <- 0
 
@@ -381,18 +484,30 @@ func commandLoop(speccy *Spectrum48k) {
 		case untyped_cmd := <-speccy.commandChannel:
 			switch cmd := untyped_cmd.(type) {
 			case Cmd_Reset:
+				speccy.leaveNetplay("a reset")
 				speccy.reset(cmd.SystemROMLoaded_orNil)
 
 			case Cmd_RenderFrame:
 				// Ugly hack to check whenever the system ROM has been loaded after a reset.
 				// I bet this won't work with custom ROMs.
-				if (speccy.Cpu.PC() == 0x10ac) && (speccy.systemROMLoaded_orNil != nil) {
+				// The 128K models are given a fixed time to show their menu.
+				romLoaded := (speccy.Cpu.PC() == 0x10ac)
+				if speccy.model.banked() {
+					romLoaded = (speccy.ula.frame >= plus3_bootFrames)
+				}
+				if romLoaded && (speccy.systemROMLoaded_orNil != nil) {
 					// Note: This is a buffered channel, so the send won't block
 					speccy.systemROMLoaded_orNil <- true
 					speccy.systemROMLoaded_orNil = nil
 				}
 
-				speccy.renderFrame(cmd.CompletionTime_orNil)
+				if speccy.netplayFrame() {
+					speccy.rewindFrame()
+					speccy.renderFrame(cmd.CompletionTime_orNil)
+				} else if cmd.CompletionTime_orNil != nil {
+					// The frame waits for the input of the other player
+					cmd.CompletionTime_orNil <- time.Now()
+				}
 
 			case Cmd_GetNumDisplayReceivers:
 				cmd.N <- uint(len(speccy.displays))
@@ -407,26 +522,7 @@ func commandLoop(speccy *Spectrum48k) {
 				}()
 
 			case Cmd_SetFPS:
-				speccy.currentFPS_mutex.Lock()
-				{
-					if cmd.OldFPS_orNil != nil {
-						cmd.OldFPS_orNil <- speccy.currentFPS
-					}
-
-					newFPS := cmd.NewFPS
-					if newFPS <= 1.0 {
-						newFPS = DefaultFPS
-					}
-
-					if newFPS != speccy.currentFPS {
-						speccy.currentFPS = newFPS
-
-						go func() {
-							speccy.fpsCh <- newFPS
-						}()
-					}
-				}
-				speccy.currentFPS_mutex.Unlock()
+				speccy.setFPS(cmd.NewFPS, cmd.OldFPS_orNil)
 
 			case Cmd_SetUlaEmulationAccuracy:
 				speccy.ula.setEmulationAccuracy(cmd.AccurateEmulation)
@@ -444,6 +540,7 @@ func commandLoop(speccy *Spectrum48k) {
 				}()
 
 			case Cmd_LoadSnapshot:
+				speccy.leaveNetplay("loading a snapshot")
 				if speccy.app.Verbose {
 					if len(cmd.InformalFilename) > 0 {
 						speccy.app.PrintfMsg("loading snapshot \"%s\"", cmd.InformalFilename)
@@ -453,12 +550,16 @@ func commandLoop(speccy *Spectrum48k) {
 				}
 
 				err := speccy.loadSnapshot(cmd.Snapshot)
+				if (err == nil) && (len(cmd.InformalFilename) > 0) {
+					speccy.Input.programLoaded(cmd.InformalFilename)
+				}
 
 				if cmd.ErrChan != nil {
 					cmd.ErrChan <- err
 				}
 
 			case Cmd_Load:
+				speccy.leaveNetplay("loading a program")
 				if speccy.app.Verbose {
 					if len(cmd.InformalFilename) > 0 {
 						speccy.app.PrintfMsg("loading program \"%s\"", cmd.InformalFilename)
@@ -468,20 +569,99 @@ func commandLoop(speccy *Spectrum48k) {
 				}
 
 				err := speccy.load(cmd.Program)
+				if (err == nil) && (len(cmd.InformalFilename) > 0) {
+					speccy.Input.programLoaded(cmd.InformalFilename)
+				}
 
 				if cmd.ErrChan != nil {
 					cmd.ErrChan <- err
 				}
 
+			case Cmd_NMI:
+				speccy.leaveNetplay("the NMI button")
+				speccy.nmiPending = true
+
+			case Cmd_EjectCartridge:
+				speccy.leaveNetplay("ejecting the cartridge")
+				err := speccy.ejectCartridge()
+				if cmd.ErrChan != nil {
+					cmd.ErrChan <- err
+				}
+
 			case Cmd_MakeSnapshot:
 				cmd.Chan <- speccy.MakeSnapshot()
 
+			case Cmd_SaveState:
+				cmd.Chan <- speccy.saveState()
+
+			case Cmd_LoadState:
+				speccy.leaveNetplay("loading a saved state")
+				err := speccy.loadState(cmd.State)
+				if cmd.ErrChan != nil {
+					cmd.ErrChan <- err
+				}
+
+			case Cmd_SetAutosave:
+				speccy.autosave = cmd.Enable
+
+			case Cmd_LoadAutosave:
+				speccy.leaveNetplay("loading the autosave")
+				err := speccy.loadAutosave(AutosavePath())
+				if cmd.ErrChan != nil {
+					cmd.ErrChan <- err
+				}
+
+			case Cmd_StartNetplay:
+				cmd.errChan <- speccy.startNetplay(cmd.netplay, cmd.snapshot)
+
+			case Cmd_StopNetplay:
+				speccy.stopNetplay(nil)
+
 			case Cmd_MakeVideoMemoryDump:
 				cmd.Chan <- speccy.makeVideoMemoryDump()
 
 			case Cmd_SetAcceleratedLoad:
 				speccy.tapeDrive.AcceleratedLoad = cmd.Enable
 
+			case Cmd_SetIssue2:
+				speccy.Ports.issue2 = cmd.Enable
+
+			case Cmd_SetRewindBuffer:
+				speccy.setRewindBuffer(cmd.Seconds)
+
+			case Cmd_Rewind:
+				err := speccy.rewind(cmd.Seconds)
+				if cmd.ErrChan != nil {
+					cmd.ErrChan <- err
+				}
+
+			case Cmd_Forward:
+				err := speccy.rewind(-cmd.Seconds)
+				if cmd.ErrChan != nil {
+					cmd.ErrChan <- err
+				}
+
+			case Cmd_AttachPeripheral:
+				speccy.leaveNetplay("connecting a peripheral")
+				err := speccy.attachPeripheral(cmd.Peripheral)
+				if cmd.ErrChan != nil {
+					cmd.ErrChan <- err
+				}
+
+			case Cmd_DetachPeripheral:
+				speccy.leaveNetplay("disconnecting a peripheral")
+				err := speccy.detachPeripheral(cmd.Name)
+				if cmd.ErrChan != nil {
+					cmd.ErrChan <- err
+				}
+
+			case Cmd_SetModel:
+				speccy.leaveNetplay("changing the model")
+				err := speccy.setModel(cmd.Model, cmd.ROMs)
+				if cmd.ErrChan != nil {
+					cmd.ErrChan <- err
+				}
+
 			}
 		}
 	}
@@ -493,6 +673,9 @@ func (speccy *Spectrum48k) reset(systemROMLoaded_orNil chan<- <-chan bool) error
 	speccy.ula.reset()
 	speccy.Keyboard.reset()
 	speccy.Ports.reset()
+	for _, p := range speccy.peripherals {
+		p.reset()
+	}
 
 	if speccy.systemROMLoaded_orNil != nil {
 		speccy.systemROMLoaded_orNil <- false
@@ -504,12 +687,24 @@ func (speccy *Spectrum48k) reset(systemROMLoaded_orNil chan<- <-chan bool) error
 		systemROMLoaded_orNil <- speccy.systemROMLoaded_orNil
 	}
 
-	// Copy the ROM image into the first 16k of memory
-	copy(speccy.Memory.Data()[0:0x4000], speccy.rom[:])
+	if speccy.fdc_orNil != nil {
+		speccy.fdc_orNil.reset()
+	}
+	if speccy.ay_orNil != nil {
+		speccy.ay_orNil.reset()
+	}
+	speccy.nmiPending = false
+
+	// Copy the ROM image into the first 16k of memory.
+	// The 128K models page their ROMs directly.
+	speccy.romType = ROM_UNKNOWN
+	if !speccy.model.banked() {
+		copy(speccy.Memory.Data()[0:0x4000], speccy.rom[:])
 
-	// ROM type detection
-	if bytes.Contains(speccy.rom[:], []byte("1981 Nine Tiles Networks")) {
-		speccy.romType = ROM_OPENSE
+		// ROM type detection
+		if bytes.Contains(speccy.rom[:], []byte("1981 Nine Tiles Networks")) {
+			speccy.romType = ROM_OPENSE
+		}
 	}
 
 	// OpenSE BASIC initializes almost immediately
@@ -518,6 +713,12 @@ func (speccy *Spectrum48k) reset(systemROMLoaded_orNil chan<- <-chan bool) error
 		speccy.systemROMLoaded_orNil = nil
 	}
 
+	// A ROM cartridge never reaches the BASIC editor
+	if (speccy.systemROMLoaded_orNil != nil) && (speccy.systemROM_orNil != nil) {
+		speccy.systemROMLoaded_orNil <- false
+		speccy.systemROMLoaded_orNil = nil
+	}
+
 	return nil
 }
 
@@ -586,7 +787,42 @@ func (speccy *Spectrum48k) loadSnapshot(s formats.Snapshot) error {
 	ula := s.UlaState()
 	mem := s.Memory()
 
-	// Populate registers
+	speccy.setCpuState(cpu)
+
+	// Border color
+	speccy.Ports.WritePortInternal(0xfe, ula.Border&0x07, false /*contend*/)
+
+	// Populate memory
+	speccy.Memory.load48k(mem[:])
+
+	tstate := int(cpu.Tstate)
+	if z80, isZ80 := s.(*formats.Z80); isZ80 {
+		if quarter, countdown, ok := z80.TstateCounter(); ok {
+			T4 := speccy.timing.TStatesPerFrame / 4
+			tstate = int(quarter)*T4 + (T4 - int(countdown)%T4 - 1)
+		}
+	}
+	speccy.Cpu.Tstates = tstate % speccy.timing.TStatesPerFrame
+
+	return nil
+}
+
+func (speccy *Spectrum48k) MakeSnapshot() *formats.FullSnapshot {
+	var s formats.FullSnapshot
+
+	s.Cpu = speccy.cpuState()
+
+	// Border color
+	s.Ula.Border = speccy.ula.getBorderColor() & 0x07
+
+	// Memory
+	speccy.Memory.dump48k(s.Mem[:])
+
+	return &s
+}
+
+// Sets the registers of the Z80
+func (speccy *Spectrum48k) setCpuState(cpu formats.CpuState) {
 	speccy.Cpu.A = cpu.A
 	speccy.Cpu.F = cpu.F
 	speccy.Cpu.B = cpu.B
@@ -618,58 +854,42 @@ func (speccy *Spectrum48k) loadSnapshot(s formats.Snapshot) error {
 
 	speccy.Cpu.SetPC(cpu.PC)
 	speccy.Cpu.SetSP(cpu.SP)
-
-	// Border color
-	speccy.Ports.WritePortInternal(0xfe, ula.Border&0x07, false /*contend*/)
-
-	// Populate memory
-	copy(speccy.Memory.Data()[0x4000:], mem[:])
-
-	speccy.Cpu.Tstates = int(cpu.Tstate)
-
-	return nil
 }
 
-func (speccy *Spectrum48k) MakeSnapshot() *formats.FullSnapshot {
-	var s formats.FullSnapshot
-
-	// Save registers
-	s.Cpu.A = speccy.Cpu.A
-	s.Cpu.F = speccy.Cpu.F
-	s.Cpu.B = speccy.Cpu.B
-	s.Cpu.C = speccy.Cpu.C
-	s.Cpu.D = speccy.Cpu.D
-	s.Cpu.E = speccy.Cpu.E
-	s.Cpu.H = speccy.Cpu.H
-	s.Cpu.L = speccy.Cpu.L
-	s.Cpu.A_ = speccy.Cpu.A_
-	s.Cpu.F_ = speccy.Cpu.F_
-	s.Cpu.B_ = speccy.Cpu.B_
-	s.Cpu.C_ = speccy.Cpu.C_
-	s.Cpu.D_ = speccy.Cpu.D_
-	s.Cpu.E_ = speccy.Cpu.E_
-	s.Cpu.H_ = speccy.Cpu.H_
-	s.Cpu.L_ = speccy.Cpu.L_
-	s.Cpu.IX = uint16(speccy.Cpu.IXL) | (uint16(speccy.Cpu.IXH) << 8)
-	s.Cpu.IY = uint16(speccy.Cpu.IYL) | (uint16(speccy.Cpu.IYH) << 8)
-
-	s.Cpu.I = speccy.Cpu.I
-	s.Cpu.IFF1 = speccy.Cpu.IFF1
-	s.Cpu.IFF2 = speccy.Cpu.IFF2
-	s.Cpu.IM = speccy.Cpu.IM
-
-	s.Cpu.R = byte(speccy.Cpu.R & 0x7f) | (speccy.Cpu.R7 & 0x80)
-
-	s.Cpu.SP = speccy.Cpu.SP()
-	s.Cpu.PC = speccy.Cpu.PC()
-
-	// Border color
-	s.Ula.Border = speccy.ula.getBorderColor() & 0x07
-
-	// Memory
-	copy(s.Mem[:], speccy.Memory.Data()[0x4000:])
-
-	return &s
+// Returns the registers of the Z80
+func (speccy *Spectrum48k) cpuState() formats.CpuState {
+	var cpu formats.CpuState
+
+	cpu.A = speccy.Cpu.A
+	cpu.F = speccy.Cpu.F
+	cpu.B = speccy.Cpu.B
+	cpu.C = speccy.Cpu.C
+	cpu.D = speccy.Cpu.D
+	cpu.E = speccy.Cpu.E
+	cpu.H = speccy.Cpu.H
+	cpu.L = speccy.Cpu.L
+	cpu.A_ = speccy.Cpu.A_
+	cpu.F_ = speccy.Cpu.F_
+	cpu.B_ = speccy.Cpu.B_
+	cpu.C_ = speccy.Cpu.C_
+	cpu.D_ = speccy.Cpu.D_
+	cpu.E_ = speccy.Cpu.E_
+	cpu.H_ = speccy.Cpu.H_
+	cpu.L_ = speccy.Cpu.L_
+	cpu.IX = uint16(speccy.Cpu.IXL) | (uint16(speccy.Cpu.IXH) << 8)
+	cpu.IY = uint16(speccy.Cpu.IYL) | (uint16(speccy.Cpu.IYH) << 8)
+
+	cpu.I = speccy.Cpu.I
+	cpu.IFF1 = speccy.Cpu.IFF1
+	cpu.IFF2 = speccy.Cpu.IFF2
+	cpu.IM = speccy.Cpu.IM
+
+	cpu.R = byte(speccy.Cpu.R & 0x7f) | (speccy.Cpu.R7 & 0x80)
+
+	cpu.SP = speccy.Cpu.SP()
+	cpu.PC = speccy.Cpu.PC()
+
+	return cpu
 }
 
 func (speccy *Spectrum48k) doOpcodes() {
@@ -690,7 +910,8 @@ func (speccy *Spectrum48k) doOpcodes() {
 
 	// Main instruction emulation loop
 	{
-		var readFromTape bool = (speccy.readFromTape && (speccy.shouldPlayTheTape > 0) && (speccy.tapeDrive != nil))
+		// The tape is not played during netplay, the other machine does not have it
+		var readFromTape bool = (speccy.readFromTape && (speccy.shouldPlayTheTape > 0) && (speccy.tapeDrive != nil) && (speccy.netplay_orNil == nil))
 
 		if speccy.tapeDrive != nil && speccy.tapeDrive.NotifyLoadComplete && speccy.tapeDrive.notifyCpuLoadCompleted {
 			speccy.tapeDrive.notifyCpuLoadCompleted = false
@@ -704,8 +925,33 @@ func (speccy *Spectrum48k) doOpcodes() {
 		}
 
 		for (speccy.Cpu.Tstates < speccy.Cpu.EventNextEvent) && !speccy.Cpu.Halted {
-			speccy.Memory.ContendRead(speccy.Cpu.PC(), 4)
-			opcode := speccy.Memory.ReadByteInternal(speccy.Cpu.PC())
+			pc := speccy.Cpu.PC()
+
+			var traps []fetchTrap
+			if (pc < 0x4000) && speccy.isFetchTrap[pc] {
+				traps = speccy.fetchTraps[pc]
+				for _, trap := range traps {
+					if trap.before != nil {
+						trap.before(pc)
+					}
+				}
+				if speccy.Cpu.PC() != pc {
+					continue
+				}
+			} else if (pc >= 0x4000) && speccy.Memory.romPaged && (len(speccy.ramFetchTraps) > 0) {
+				for _, trap := range speccy.ramFetchTraps {
+					trap.before(pc)
+				}
+			}
+
+			speccy.Memory.ContendRead(pc, 4)
+			opcode := speccy.Memory.ReadByteInternal(pc)
+
+			for _, trap := range traps {
+				if trap.after != nil {
+					trap.after(pc)
+				}
+			}
 
 			speccy.Cpu.R = (speccy.Cpu.R + 1) & 0x7f
 			speccy.Cpu.IncPC(1)
@@ -714,6 +960,10 @@ func (speccy *Spectrum48k) doOpcodes() {
 
 			z80.OpcodesMap[opcode](speccy.Cpu)
 
+			if speccy.interruptPending {
+				speccy.acceptLateInterrupt(opcode)
+			}
+
 			if readFromTape {
 				endOfBlock := speccy.tapeDrive.doPlay()
 				if endOfBlock {
@@ -781,14 +1031,37 @@ func (speccy *Spectrum48k) doOpcodes() {
 
 }
 
+// Returns the number of T-states since the last reset
+func (speccy *Spectrum48k) time() int64 {
+	return int64(speccy.ula.frame)*int64(speccy.timing.TStatesPerFrame) + int64(speccy.Cpu.Tstates)
+}
+
+// The ULA holds the interrupt line active for 'Timing.InterruptLength' T-states.
+// If the interrupts were disabled at the beginning of the frame, the Z80 accepts the interrupt
+// as soon as it enables them during that time (but not right after EI).
+// Emulated only if 'Timing.LateInterrupts' is set.
+func (speccy *Spectrum48k) acceptLateInterrupt(opcode byte) {
+	if speccy.Cpu.Tstates >= speccy.timing.InterruptLength {
+		speccy.interruptPending = false
+	} else if (speccy.Cpu.IFF1 != 0) && (opcode != 0xfb) {
+		speccy.interruptPending = false
+		speccy.Cpu.Interrupt()
+	}
+}
+
 func (speccy *Spectrum48k) renderFrame(completionTime_orNil chan<- time.Time) {
 	speccy.Ports.frame_begin()
 	speccy.ula.frame_begin()
 
 	// Execute instructions corresponding to one screen frame
-	speccy.Cpu.Tstates = (speccy.Cpu.Tstates % TStatesPerFrame)
+	speccy.Cpu.Tstates = (speccy.Cpu.Tstates % speccy.timing.TStatesPerFrame)
+	if speccy.nmiPending {
+		speccy.nmiPending = false
+		speccy.acceptNMI()
+	}
+	speccy.interruptPending = speccy.timing.LateInterrupts && (speccy.Cpu.IFF1 == 0)
 	speccy.Cpu.Interrupt()
-	speccy.Cpu.EventNextEvent = TStatesPerFrame
+	speccy.Cpu.EventNextEvent = speccy.timing.TStatesPerFrame
 	speccy.doOpcodes()
 
 	// Send display data to display backend(s)
@@ -810,11 +1083,16 @@ func (speccy *Spectrum48k) renderFrame(completionTime_orNil chan<- time.Time) {
 		}
 	}
 
+	// The sound devices have to be polled even if there are no audio backends
+	sampleEvents := speccy.getSampleEvents()
+
 	// Send audio data to audio backend(s)
 	if len(speccy.audioReceivers) > 0 {
 		audioData := AudioData{
 			FPS:          speccy.currentFPS,
+			Timing:       speccy.timing,
 			BeeperEvents: speccy.Ports.getBeeperEvents(),
+			SampleEvents: sampleEvents,
 		}
 
 		for _, audioReceiver := range speccy.audioReceivers {
@@ -843,9 +1121,9 @@ func (speccy *Spectrum48k) loadTape(tap *formats.TAP) {
 
 // Send LOAD ""
 func (speccy *Spectrum48k) sendLOADCommand() {
-	speccy.Keyboard.CommandChannel <- Cmd_SendLoad{speccy.romType}
+	speccy.Keyboard.CommandChannel <- Cmd_SendLoad{speccy.romType, speccy.model}
 }
 
 func (speccy *Spectrum48k) makeVideoMemoryDump() []byte {
-	return speccy.Memory.Data()[0x4000 : 0x4000+6912]
+	return speccy.Memory.screen
 }
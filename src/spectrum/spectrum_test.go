@@ -0,0 +1,49 @@
+package spectrum
+
+import (
+	"github.com/remogatto/gospeccy/src/formats"
+	"testing"
+)
+
+func TestLoadZ80TstateCounter(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program, err := formats.ReadProgram("../formats/testdata/fire.z80")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	// The snapshot is in the 4th quarter of the frame, 224 T-states before its end
+	tests := []struct {
+		model  Model
+		tstate int
+	}{
+		{MODEL_48K, 4*(69888/4) - 224},
+		{MODEL_NTSC48K, 4*(59736/4) - 224},
+	}
+	for _, test := range tests {
+		speccy := NewSpectrum48k(app, *rom)
+		if err := speccy.SetModel(test.model, [][]byte{rom[:]}); err != nil {
+			t.Fatal(err)
+		}
+
+		errChan := make(chan error)
+		speccy.CommandChannel <- Cmd_LoadSnapshot{"fire.z80", program.(formats.Snapshot), errChan}
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+
+		if speccy.Cpu.Tstates != test.tstate {
+			t.Errorf("%s: expected T-state %d, got %d", test.model, test.tstate, speccy.Cpu.Tstates)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package spectrum
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// The saved state of the machine, used by the quick-save slots and the autosave.
+//
+// A saved state is a state captured by 'captureState' (see rewind.go), converted to bytes:
+// the name of the model, the registers of the Z80, the memory paging, the states of the devices
+// (each preceded by the type of the device), and the 1K pages of the RAM. The whole state is
+// compressed by gzip. Unlike the SNA format, it covers the 128K models, the AY, and the peripherals.
+//
+// A state can only be loaded into the model which saved it, with the same peripherals connected.
+// The memory paged over the system ROM is not stored, the peripherals page it again
+// when their states are restored.
+const state_magic = "GoSpeccy state 1"
+
+// The fixed-size part of a saved state
+type state_header struct {
+	A, F, B, C, D, E, H, L         byte
+	A_, F_, B_, C_, D_, E_, H_, L_ byte
+	IX, IY                         uint16
+	I, R, IFF1, IFF2, IM           byte
+	SP, PC                         uint16
+
+	Halted  bool
+	Tstates int32
+	Border  byte
+
+	Port7ffd byte
+	Port1ffd byte
+
+	NumDevices uint8
+	NumPages   uint32
+}
+
+type Cmd_SaveState struct {
+	Chan chan<- *SavedState
+}
+
+type Cmd_LoadState struct {
+	State   *SavedState
+	ErrChan chan<- error
+}
+
+type SavedState struct {
+	Data []byte
+
+	// The displayed screen (bitmap and attributes)
+	Screen []byte
+
+	// The registers and the 48K of memory visible to the Z80, in the SNA format.
+	// Nil if the state could not be converted to the SNA format.
+	SNA_orNil []byte
+}
+
+// Encodes boolean values as bytes (0 or 1)
+func encodeFlags(flags ...bool) []byte {
+	data := make([]byte, len(flags))
+	for i, flag := range flags {
+		if flag {
+			data[i] = 1
+		}
+	}
+	return data
+}
+
+// Decodes 'n' boolean values encoded by 'encodeFlags'
+func decodeFlags(data []byte, n int) ([]bool, error) {
+	if len(data) != n {
+		return nil, errors.New("invalid state of a device")
+	}
+	flags := make([]bool, n)
+	for i := range flags {
+		flags[i] = (data[i] != 0)
+	}
+	return flags, nil
+}
+
+func writeStateString(w io.Writer, s string) {
+	binary.Write(w, binary.LittleEndian, uint16(len(s)))
+	io.WriteString(w, s)
+}
+
+func readStateString(r io.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	s := make([]byte, length)
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// Converts a captured state to bytes. Called in the emulation goroutine.
+func (speccy *Spectrum48k) encodeState(s *rewindState) []byte {
+	devices := speccy.rewindableDevices()
+
+	h := state_header{
+		A: s.cpu.A, F: s.cpu.F, B: s.cpu.B, C: s.cpu.C, D: s.cpu.D, E: s.cpu.E, H: s.cpu.H, L: s.cpu.L,
+		A_: s.cpu.A_, F_: s.cpu.F_, B_: s.cpu.B_, C_: s.cpu.C_, D_: s.cpu.D_, E_: s.cpu.E_, H_: s.cpu.H_, L_: s.cpu.L_,
+		IX: s.cpu.IX, IY: s.cpu.IY,
+		I: s.cpu.I, R: s.cpu.R, IFF1: s.cpu.IFF1, IFF2: s.cpu.IFF2, IM: s.cpu.IM,
+		SP: s.cpu.SP, PC: s.cpu.PC,
+
+		Halted:   s.halted,
+		Tstates:  int32(s.tstates),
+		Border:   s.border,
+		Port7ffd: s.port7ffd,
+		Port1ffd: s.port1ffd,
+
+		NumDevices: uint8(len(devices)),
+		NumPages:   uint32(len(s.pages)),
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+
+	io.WriteString(w, state_magic)
+	writeStateString(w, speccy.model.String())
+	binary.Write(w, binary.LittleEndian, &h)
+
+	for i, device := range devices {
+		writeStateString(w, fmt.Sprintf("%T", device))
+		writeStateString(w, string(device.encodeState(s.devices[i])))
+	}
+
+	for _, page := range s.pages {
+		w.Write(page)
+	}
+
+	w.Close()
+	return buf.Bytes()
+}
+
+// Converts bytes created by 'encodeState' to a state which can be restored
+// by 'restoreState'. Called in the emulation goroutine.
+func (speccy *Spectrum48k) decodeState(data []byte) (*rewindState, error) {
+	invalid := errors.New("invalid saved state")
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, invalid
+	}
+	data, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, invalid
+	}
+	if !bytes.HasPrefix(data, []byte(state_magic)) {
+		return nil, invalid
+	}
+	b := bytes.NewReader(data[len(state_magic):])
+
+	model, err := readStateString(b)
+	if err != nil {
+		return nil, invalid
+	}
+	if model != speccy.model.String() {
+		return nil, fmt.Errorf("the state was saved on the %s, not on the %s", model, speccy.model)
+	}
+
+	var h state_header
+	if err := binary.Read(b, binary.LittleEndian, &h); err != nil {
+		return nil, invalid
+	}
+
+	s := &rewindState{
+		cpu: formats.CpuState{
+			A: h.A, F: h.F, B: h.B, C: h.C, D: h.D, E: h.E, H: h.H, L: h.L,
+			A_: h.A_, F_: h.F_, B_: h.B_, C_: h.C_, D_: h.D_, E_: h.E_, H_: h.H_, L_: h.L_,
+			IX: h.IX, IY: h.IY,
+			I: h.I, R: h.R, IFF1: h.IFF1, IFF2: h.IFF2, IM: h.IM,
+			SP: h.SP, PC: h.PC,
+		},
+
+		halted:   h.Halted,
+		tstates:  int(h.Tstates),
+		border:   h.Border & 0x07,
+		port7ffd: h.Port7ffd,
+		port1ffd: h.Port1ffd,
+	}
+
+	devices := speccy.rewindableDevices()
+	if int(h.NumDevices) != len(devices) {
+		return nil, errors.New("the state was saved with other peripherals connected")
+	}
+	for _, device := range devices {
+		name, err := readStateString(b)
+		if err != nil {
+			return nil, invalid
+		}
+		if name != fmt.Sprintf("%T", device) {
+			return nil, errors.New("the state was saved with other peripherals connected")
+		}
+
+		deviceData, err := readStateString(b)
+		if err != nil {
+			return nil, invalid
+		}
+		state, err := device.decodeState([]byte(deviceData))
+		if err != nil {
+			return nil, err
+		}
+		s.devices = append(s.devices, state)
+	}
+
+	numPages := 0
+	for _, ram := range speccy.rewindRAM(devices) {
+		numPages += len(ram) / rewind_pageSize
+	}
+	if (int(h.NumPages) != numPages) || (b.Len() != numPages*rewind_pageSize) {
+		return nil, invalid
+	}
+	for i := 0; i < numPages; i++ {
+		page := make([]byte, rewind_pageSize)
+		b.Read(page)
+		s.pages = append(s.pages, page)
+	}
+
+	return s, nil
+}
+
+// Captures the state of the machine, the screen and a SNA snapshot. Called in the emulation goroutine.
+func (speccy *Spectrum48k) saveState() *SavedState {
+	state := &SavedState{
+		Data:   speccy.encodeState(speccy.captureState(nil)),
+		Screen: append([]byte(nil), speccy.Memory.screen...),
+	}
+
+	sna, err := speccy.MakeSnapshot().EncodeSNA()
+	if err == nil {
+		state.SNA_orNil = sna
+	}
+
+	return state
+}
+
+// Restores a saved state. The machine is reset first. Called in the emulation goroutine.
+func (speccy *Spectrum48k) loadState(state *SavedState) error {
+	s, err := speccy.decodeState(state.Data)
+	if err != nil {
+		return err
+	}
+
+	speccy.reset(nil)
+	speccy.restoreState(s)
+	return nil
+}
+
+// Returns the state of the machine
+func (speccy *Spectrum48k) SaveState() *SavedState {
+	ch := make(chan *SavedState)
+	speccy.CommandChannel <- Cmd_SaveState{ch}
+	return <-ch
+}
+
+// Restores a state returned by 'SaveState'
+func (speccy *Spectrum48k) LoadState(state *SavedState) error {
+	errChan := make(chan error)
+	speccy.CommandChannel <- Cmd_LoadState{state, errChan}
+	return <-errChan
+}
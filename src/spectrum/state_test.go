@@ -0,0 +1,71 @@
+package spectrum
+
+import (
+	"testing"
+)
+
+func TestSaveState(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	speccy := NewSpectrum48k(app, *rom)
+	if err := speccy.SetModel(MODEL_PENTAGON, [][]byte{rom[:], rom[:]}); err != nil {
+		t.Fatal(err)
+	}
+
+	memory := speccy.Memory
+	memory.write7ffd(0x03) // RAM bank 3 at 0xC000
+	memory.Write(0xc000, 0x55, false)
+	speccy.ay_orNil.selectRegister(7)
+	speccy.ay_orNil.writeRegister(0x38)
+	speccy.Cpu.SetPC(0x8000)
+
+	state := speccy.SaveState()
+	if state.SNA_orNil == nil {
+		t.Error("expected a SNA snapshot")
+	}
+
+	memory.write7ffd(0x00) // RAM bank 0 at 0xC000
+	memory.Write(0xc000, 0xaa, false)
+	speccy.ay_orNil.writeRegister(0x00)
+	speccy.Cpu.SetPC(0x0000)
+
+	if err := speccy.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	if memory.port7ffd != 0x03 {
+		t.Errorf("expected port 0x7FFD 0x03, got 0x%02x", memory.port7ffd)
+	}
+	if b := memory.Read(0xc000); b != 0x55 {
+		t.Errorf("expected 0x55 in RAM bank 3, got 0x%02x", b)
+	}
+	if b := memory.banks[0][0]; b != 0x00 {
+		t.Errorf("expected 0x00 in RAM bank 0, got 0x%02x", b)
+	}
+	if r := speccy.ay_orNil.registers[7]; r != 0x38 {
+		t.Errorf("expected AY register 7 = 0x38, got 0x%02x", r)
+	}
+	if pc := speccy.Cpu.PC(); pc != 0x8000 {
+		t.Errorf("expected PC 0x8000, got 0x%04x", pc)
+	}
+
+	// The state of the Pentagon cannot be loaded into the 48K
+	if err := speccy.SetModel(MODEL_48K, [][]byte{rom[:]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := speccy.LoadState(state); err == nil {
+		t.Error("expected an error when loading the state into another model")
+	}
+	if err := speccy.LoadState(&SavedState{Data: []byte("garbage")}); err == nil {
+		t.Error("expected an error when loading invalid data")
+	}
+}
@@ -29,7 +29,8 @@ const (
 	TAPE_PAUSE                = 3500000
 )
 
-const TAPE_ACCELERATION_IN_FPS = DefaultFPS * 20
+// How many times faster than the real machine the emulator runs while loading a tape
+const TAPE_ACCELERATION = 20
 
 type Tape struct {
 	tap *formats.TAP
@@ -112,12 +113,31 @@ func (tapeDrive *TapeDrive) Stop() {
 	tapeDrive.currBlockId = 0
 }
 
+// Returns the block being played (or the next block to play), and whether the tape is playing
+func (tapeDrive *TapeDrive) position() (block int, playing bool) {
+	return tapeDrive.currBlockId, tapeDrive.speccy.readFromTape
+}
+
+// Winds the tape to the beginning of the specified block
+func (tapeDrive *TapeDrive) seek(block int, playing bool) {
+	tapeDrive.Stop()
+	if (tapeDrive.tape == nil) || (block < 0) || (block >= tapeDrive.tape.tap.NumBlocks()) {
+		return
+	}
+
+	tapeDrive.currBlockId = block
+	tapeDrive.pos = tapeDrive.tape.tap.BlockStart(block)
+	tapeDrive.state = TAPE_DRIVE_START
+	tapeDrive.speccy.readFromTape = playing
+}
+
 func (tapeDrive *TapeDrive) accelerate() {
 	if !tapeDrive.accelerating {
 		tapeDrive.accelerating = true
+		fps := tapeDrive.speccy.timing.FPS * TAPE_ACCELERATION
 		go func() {
 			oldFPS_chan := make(chan float32)
-			tapeDrive.speccy.CommandChannel <- Cmd_SetFPS{TAPE_ACCELERATION_IN_FPS, oldFPS_chan}
+			tapeDrive.speccy.CommandChannel <- Cmd_SetFPS{fps, oldFPS_chan}
 			oldFPS := <-oldFPS_chan
 
 			tapeDrive.mutex.Lock()
@@ -141,7 +161,7 @@ func (tapeDrive *TapeDrive) decelerate() {
 }
 
 func (tapeDrive *TapeDrive) doPlay() (endOfBlock bool) {
-	now := int(tapeDrive.speccy.ula.frame)*TStatesPerFrame + tapeDrive.speccy.Cpu.Tstates
+	now := int(tapeDrive.speccy.ula.frame)*tapeDrive.speccy.timing.TStatesPerFrame + tapeDrive.speccy.Cpu.Tstates
 
 	tapeDrive.timeout -= now - tapeDrive.timeLastIn
 	tapeDrive.timeLastIn = now
@@ -0,0 +1,112 @@
+package spectrum
+
+// The timings of the ULA of the models.
+//
+// A frame starts with the interrupt. The ULA paints the lines of the frame one after another:
+// the border above the screen, the 192 lines of the screen (each with 128 T-states of screen bytes,
+// surrounded by the border and followed by the horizontal retrace), then the border below the screen.
+//
+// While the ULA reads the screen, the Z80 is delayed when it accesses contended memory or ports.
+// The delay depends on the T-state within the 8 T-states in which the ULA reads two bitmap bytes
+// and their attributes (the contention pattern).
+type Timing struct {
+	TStatesPerLine  int
+	LinesPerFrame   int
+	TStatesPerFrame int
+
+	// The T-state when the ULA paints the first byte of the screen (0x4000)
+	FirstScreenByte int
+
+	// How long the ULA holds the interrupt line active, in T-states
+	InterruptLength int
+
+	// Whether the Z80 accepts the interrupt if it enables the interrupts while
+	// the interrupt line is still active. If false, the interrupt is accepted
+	// only if the interrupts are enabled at the beginning of the frame.
+	LateInterrupts bool
+
+	// The T-state which corresponds to pixel (0,0) on the host-machine display.
+	// That pixel belongs to the border.
+	DisplayStart int
+
+	// The delays of the contention pattern. The first pattern of each line
+	// starts 'contentionOffset' T-states after the line's first screen byte.
+	contentionOffset  int
+	contentionPattern [8]byte
+
+	// The frame rate of the real machine
+	FPS float32
+
+	// Number of T-states to delay, for each possible T-state within a frame.
+	// The array is extended at the end - this covers the case when the emulator
+	// begins to execute an instruction at Tstate=(TStatesPerFrame-1). Such an
+	// instruction will finish at (TStatesPerFrame-1+4) or later.
+	delayTable []byte
+
+	// Let 'addr' be in range 0x4000 ... 0x5800-1.
+	// Then 'screenlineStart[(addr-0x4000)/BytesPerLine]' is the T-state when the ULA
+	// starts painting the screenline containing 'addr'.
+	screenlineStart [ScreenHeight]int
+}
+
+var (
+	// The 16K and 48K Spectrum (PAL, 3.5 MHz)
+	Timing48K = newTiming(224, 312, 14336, 32, false, -1, [8]byte{6, 5, 4, 3, 2, 1, 0, 0}, 50.08)
+
+	// The NTSC 48K Spectrum (3.5275 MHz, 60 Hz TV standard)
+	TimingNTSC48K = newTiming(228, 262, 8664, 32, false, -1, [8]byte{6, 5, 4, 3, 2, 1, 0, 0}, 59.05)
+
+	// The +2A and +3 (3.5469 MHz)
+	TimingPlus3 = newTiming(228, 311, 14364, 32, false, 1, [8]byte{1, 0, 7, 6, 5, 4, 3, 2}, 50.02)
+
+	// The Pentagon 128 (3.5 MHz). Its memory is not contended.
+	// Demos written for it may enable the interrupts shortly after the interrupt has begun.
+	TimingPentagon = newTiming(224, 320, 17988, 36, true, 0, [8]byte{}, 48.83)
+)
+
+func newTiming(tstatesPerLine, linesPerFrame, firstScreenByte, interruptLength int, lateInterrupts bool, contentionOffset int, contentionPattern [8]byte, fps float32) *Timing {
+	// Some sanity checks
+	Assert(ScreenBorderX <= LINE_RIGHT_BORDER*PIXELS_PER_TSTATE)
+	Assert(ScreenBorderX <= LINE_LEFT_BORDER*PIXELS_PER_TSTATE)
+	Assert(ScreenBorderY*tstatesPerLine <= firstScreenByte)
+	Assert(firstScreenByte+(ScreenHeight+ScreenBorderY)*tstatesPerLine <= tstatesPerLine*linesPerFrame)
+
+	t := &Timing{
+		TStatesPerLine:    tstatesPerLine,
+		LinesPerFrame:     linesPerFrame,
+		TStatesPerFrame:   tstatesPerLine * linesPerFrame,
+		FirstScreenByte:   firstScreenByte,
+		InterruptLength:   interruptLength,
+		LateInterrupts:    lateInterrupts,
+		contentionOffset:  contentionOffset,
+		contentionPattern: contentionPattern,
+		FPS:               fps,
+	}
+	t.DisplayStart = t.FirstScreenByte - t.TStatesPerLine*BORDER_TOP - ScreenBorderX/PIXELS_PER_TSTATE + BORDER_TSTATE_ADJUSTMENT
+
+	t.delayTable = make([]byte, t.TStatesPerFrame+100)
+	for y := 0; y < ScreenHeight; y++ {
+		tstate := t.FirstScreenByte + y*t.TStatesPerLine + t.contentionOffset
+		for x := 0; x < LINE_SCREEN; x++ {
+			t.delayTable[tstate+x] = t.contentionPattern[x%8]
+		}
+	}
+
+	for y := uint8(0); y < ScreenHeight; y++ {
+		addr := xy_to_screenAddr(0, y)
+		t.screenlineStart[(addr-SCREEN_BASE_ADDR)/BytesPerLine] = t.FirstScreenByte + int(y)*t.TStatesPerLine
+	}
+
+	return t
+}
+
+// Switches to the timing of a model. Called in the emulation goroutine.
+func (speccy *Spectrum48k) setTiming(timing *Timing) {
+	speccy.timing = timing
+	speccy.Memory.delayTable = timing.delayTable
+	speccy.ula.timing = timing
+
+	if speccy.defaultFPS {
+		speccy.setFPS(0, nil)
+	}
+}
@@ -41,6 +41,8 @@ type ULA struct {
 	z80    *z80.Z80
 	memory *Memory
 	ports  *Ports
+
+	timing *Timing
 }
 
 func NewULA() *ULA {
@@ -114,6 +116,45 @@ func (ula *ULA) screenAttrTouch(address uint16) {
 	ula.dirtyScreen[address-ATTR_BASE_ADDR] = true
 }
 
+// Called when the 128K models switch the displayed screen
+func (ula *ULA) screenSwitched() {
+	for i := 0; i < ScreenWidth_Attr*ScreenHeight_Attr; i++ {
+		ula.dirtyScreen[i] = true
+	}
+}
+
+// Returns the value floating on the data bus when no device drives it.
+// While the ULA is painting the screen, in each group of 8 T-states it fetches
+// a bitmap byte, its attribute, the next bitmap byte and its attribute, then leaves the bus idle (0xFF).
+func (ula *ULA) floatingBus() byte {
+	tstatesPerLine := ula.timing.TStatesPerLine
+	tstate := ula.z80.Tstates - ula.timing.FirstScreenByte
+	if (tstate < 0) || (tstate >= tstatesPerLine*ScreenHeight) {
+		return 0xff
+	}
+
+	y := tstate / tstatesPerLine
+	x := tstate % tstatesPerLine
+	if x >= LINE_SCREEN {
+		return 0xff
+	}
+
+	column := (x / 8) * 2
+	if (x % 8) >= 2 {
+		column++
+	}
+
+	screen := ula.memory.screen
+	switch x % 8 {
+	case 0, 2:
+		bitmap := ((y & 0xc0) << 5) | ((y & 0x07) << 8) | ((y & 0x38) << 2) | column
+		return screen[bitmap]
+	case 1, 3:
+		return screen[ATTR_BASE_ADDR-SCREEN_BASE_ADDR+(y/8)*BytesPerLine+column]
+	}
+	return 0xff
+}
+
 // Handle a write to an address in range (SCREEN_BASE_ADDR ... SCREEN_BASE_ADDR+0x1800-1)
 func (ula *ULA) screenBitmapWrite(address uint16, oldValue byte, newValue byte) {
 	if oldValue != newValue {
@@ -121,7 +162,7 @@ func (ula *ULA) screenBitmapWrite(address uint16, oldValue byte, newValue byte)
 
 		if ula.accurateEmulation {
 			rel_addr := address - SCREEN_BASE_ADDR
-			ula_lineStart_tstate := screenline_start_tstates[rel_addr>>BytesPerLine_log2]
+			ula_lineStart_tstate := ula.timing.screenlineStart[rel_addr>>BytesPerLine_log2]
 			x, _ := screenAddr_to_xy(address)
 			ula_tstate := ula_lineStart_tstate + int(x>>PIXELS_PER_TSTATE_LOG2)
 			if ula_tstate <= ula.z80.Tstates {
@@ -147,7 +188,7 @@ func (ula *ULA) screenAttrWrite(address uint16, oldValue byte, newValue byte) {
 			y := 8 * attr_y
 
 			ofs := (y << BytesPerLine_log2) + attr_x
-			ula_tstate := int(FIRST_SCREEN_BYTE + y*TSTATES_PER_LINE + (x >> PIXELS_PER_TSTATE_LOG2))
+			ula_tstate := ula.timing.FirstScreenByte + int(y)*ula.timing.TStatesPerLine + int(x>>PIXELS_PER_TSTATE_LOG2)
 
 			for i := 0; i < 8; i++ {
 				if ula_tstate <= CPU.Tstates {
@@ -156,7 +197,7 @@ func (ula *ULA) screenAttrWrite(address uint16, oldValue byte, newValue byte) {
 						*ula_attr = ula_attr_t{true, oldValue, CPU.Tstates}
 					}
 					ofs += BytesPerLine
-					ula_tstate += TSTATES_PER_LINE
+					ula_tstate += ula.timing.TStatesPerLine
 				} else {
 					break
 				}
@@ -188,7 +229,7 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 
 		// Fill screen.bitmap & screen.attr, but only the dirty regions.
 
-		var memory_data = ula.memory.Data()
+		var screen_data = ula.memory.screen
 		ula_bitmap := &ula.bitmap
 		ula_attr := &ula.attr
 		screen_dirty := &screen.Dirty
@@ -207,7 +248,7 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 					for y := 0; y < 8; y++ {
 						var attr byte
 						if !ula_attr[linearY_ofs].valid {
-							attr = memory_data[ATTR_BASE_ADDR+attr_ofs]
+							attr = screen_data[ATTR_BASE_ADDR-SCREEN_BASE_ADDR+attr_ofs]
 						} else {
 							attr = ula_attr[linearY_ofs].value
 						}
@@ -232,7 +273,7 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 
 					for y := 0; y < 8; y++ {
 						if !ula_bitmap[screen_addr-SCREEN_BASE_ADDR].valid {
-							screen_bitmap[linearY_ofs] = memory_data[screen_addr]
+							screen_bitmap[linearY_ofs] = screen_data[screen_addr-SCREEN_BASE_ADDR]
 						} else {
 							screen_bitmap[linearY_ofs] = ula_bitmap[screen_addr-SCREEN_BASE_ADDR].value
 						}
@@ -249,7 +290,7 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 					for y := 0; y < 8; y++ {
 						var attr byte
 						if !ula_attr[linearY_ofs].valid {
-							attr = memory_data[ATTR_BASE_ADDR+attr_ofs]
+							attr = screen_data[ATTR_BASE_ADDR-SCREEN_BASE_ADDR+attr_ofs]
 						} else {
 							attr = ula_attr[linearY_ofs].value
 						}
@@ -272,6 +313,7 @@ func (ula *ULA) prepare(display *DisplayInfo) *DisplayData {
 
 		// screen.borderEvents
 		screen.BorderEvents = ula.ports.getBorderEvents()
+		screen.Timing = ula.timing
 	}
 
 	return &screen
@@ -346,4 +388,5 @@ func (a *DisplayData) add(b *DisplayData) {
 	}
 
 	a.BorderEvents = b.BorderEvents
+	a.Timing = b.Timing
 }
@@ -0,0 +1,720 @@
+package spectrum
+
+import (
+	"fmt"
+	"github.com/remogatto/gospeccy/src/formats"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// The uPD765A floppy disk controller of the Spectrum +3.
+//
+// The main status register is read from port 0x2FFD, commands, data and results
+// are transferred through port 0x3FFD. The controller works in non-DMA mode
+// and without the TC line, so the read and write commands end with
+// "end of cylinder" after the last sector (EOT) has been transferred.
+//
+// The commands complete immediately: the controller is always ready
+// to transfer the next byte, and seeks do not take any time.
+// Only the unit select line US0 is connected, so units 2 and 3 are the drives A: and B:.
+const (
+	FDC_NUM_DRIVES = 2
+
+	// Main status register
+	FDC_MSR_CB  = 0x10 // Busy
+	FDC_MSR_EXM = 0x20 // Execution phase
+	FDC_MSR_DIO = 0x40 // Data direction (1 = to the CPU)
+	FDC_MSR_RQM = 0x80 // Request for master
+
+	// Status register 0
+	FDC_ST0_NR = 0x08 // Not ready
+	FDC_ST0_EC = 0x10 // Equipment check
+	FDC_ST0_SE = 0x20 // Seek end
+	FDC_ST0_AT = 0x40 // Abnormal termination
+	FDC_ST0_IC = 0x80 // Invalid command
+
+	// Status register 1
+	FDC_ST1_MA = 0x01 // Missing address mark
+	FDC_ST1_NW = 0x02 // Not writable
+	FDC_ST1_ND = 0x04 // No data
+	FDC_ST1_DE = 0x20 // Data error
+	FDC_ST1_EN = 0x80 // End of cylinder
+
+	// Status register 2
+	FDC_ST2_DD = 0x20 // Data error in data field
+	FDC_ST2_CM = 0x40 // Control mark (deleted data)
+
+	// Status register 3
+	FDC_ST3_HD = 0x04
+	FDC_ST3_TS = 0x08 // Two side
+	FDC_ST3_T0 = 0x10 // Track 0
+	FDC_ST3_RY = 0x20 // Ready
+	FDC_ST3_WP = 0x40 // Write protected
+
+	FDC_MAX_TRACK = 83
+)
+
+// Commands (the low 5 bits of the first byte)
+const (
+	FDC_CMD_READ_TRACK         = 0x02
+	FDC_CMD_SPECIFY            = 0x03
+	FDC_CMD_SENSE_DRIVE_STATUS = 0x04
+	FDC_CMD_WRITE_DATA         = 0x05
+	FDC_CMD_READ_DATA          = 0x06
+	FDC_CMD_RECALIBRATE        = 0x07
+	FDC_CMD_SENSE_INTERRUPT    = 0x08
+	FDC_CMD_WRITE_DELETED_DATA = 0x09
+	FDC_CMD_READ_ID            = 0x0a
+	FDC_CMD_READ_DELETED_DATA  = 0x0c
+	FDC_CMD_FORMAT_TRACK       = 0x0d
+	FDC_CMD_SEEK               = 0x0f
+)
+
+// Number of bytes of each command, including the first byte
+var fdc_commandLength = map[byte]int{
+	FDC_CMD_READ_TRACK:         9,
+	FDC_CMD_SPECIFY:            3,
+	FDC_CMD_SENSE_DRIVE_STATUS: 2,
+	FDC_CMD_WRITE_DATA:         9,
+	FDC_CMD_READ_DATA:          9,
+	FDC_CMD_RECALIBRATE:        2,
+	FDC_CMD_SENSE_INTERRUPT:    1,
+	FDC_CMD_WRITE_DELETED_DATA: 9,
+	FDC_CMD_READ_ID:            2,
+	FDC_CMD_READ_DELETED_DATA:  9,
+	FDC_CMD_FORMAT_TRACK:       6,
+	FDC_CMD_SEEK:               3,
+}
+
+const (
+	fdc_phaseCommand = iota
+	fdc_phaseRead    // Execution phase, data to the CPU
+	fdc_phaseWrite   // Execution phase, data from the CPU
+	fdc_phaseResult
+)
+
+type floppyDrive struct {
+	disk_orNil     *formats.DSK
+	path           string
+	writeProtected bool
+
+	// The cylinder under the head
+	track int
+
+	// The index of the next sector passing under the head (READ ID)
+	sectorIndex int
+
+	// Pending interrupt after a seek or a recalibration
+	interrupt bool
+	seekST0   byte
+}
+
+type FDC struct {
+	app *Application
+
+	// Protects the drives, which can be accessed from any goroutine by the exported methods
+	mutex sync.Mutex
+
+	drives  [FDC_NUM_DRIVES]floppyDrive
+	motorOn bool
+
+	phase int
+
+	// The command being received, and the command being executed
+	command []byte
+	params  []byte
+	result  []byte
+
+	// Execution phase
+	data    []byte
+	dataPos int
+
+	// The sector being written, and the parameters of the read/write command
+	sector      *formats.DSKSector
+	st0         byte
+	st1, st2    byte
+	c, h, r, n  byte
+	eot         byte
+	multiTrack  bool
+	skipDeleted bool
+}
+
+func newFDC(app *Application) *FDC {
+	fdc := &FDC{app: app}
+	fdc.reset()
+	return fdc
+}
+
+func (fdc *FDC) reset() {
+	fdc.mutex.Lock()
+	defer fdc.mutex.Unlock()
+
+	fdc.phase = fdc_phaseCommand
+	fdc.command = nil
+	fdc.params = nil
+	fdc.result = nil
+	fdc.data = nil
+	fdc.motorOn = false
+	for i := range fdc.drives {
+		fdc.drives[i].interrupt = false
+	}
+}
+
+func (fdc *FDC) setMotor(on bool) {
+	fdc.mutex.Lock()
+	fdc.motorOn = on
+	fdc.mutex.Unlock()
+}
+
+func (fdc *FDC) readStatus() byte {
+	fdc.mutex.Lock()
+	defer fdc.mutex.Unlock()
+
+	switch fdc.phase {
+	case fdc_phaseRead:
+		return FDC_MSR_RQM | FDC_MSR_DIO | FDC_MSR_EXM | FDC_MSR_CB
+	case fdc_phaseWrite:
+		return FDC_MSR_RQM | FDC_MSR_EXM | FDC_MSR_CB
+	case fdc_phaseResult:
+		return FDC_MSR_RQM | FDC_MSR_DIO | FDC_MSR_CB
+	}
+
+	if len(fdc.command) > 0 {
+		return FDC_MSR_RQM | FDC_MSR_CB
+	}
+	return FDC_MSR_RQM
+}
+
+func (fdc *FDC) readData() byte {
+	fdc.mutex.Lock()
+	defer fdc.mutex.Unlock()
+
+	switch fdc.phase {
+	case fdc_phaseRead:
+		var b byte = 0xff
+		if fdc.dataPos < len(fdc.data) {
+			b = fdc.data[fdc.dataPos]
+			fdc.dataPos++
+		}
+		if fdc.dataPos >= len(fdc.data) {
+			fdc.data = nil
+			fdc.phase = fdc_phaseResult
+		}
+		return b
+
+	case fdc_phaseResult:
+		b := fdc.result[0]
+		fdc.result = fdc.result[1:]
+		if len(fdc.result) == 0 {
+			fdc.phase = fdc_phaseCommand
+		}
+		return b
+	}
+
+	return 0xff
+}
+
+func (fdc *FDC) writeData(b byte) {
+	fdc.mutex.Lock()
+	defer fdc.mutex.Unlock()
+
+	switch fdc.phase {
+	case fdc_phaseCommand:
+		fdc.command = append(fdc.command, b)
+		length, ok := fdc_commandLength[fdc.command[0]&0x1f]
+		if !ok || (len(fdc.command) == length) {
+			fdc.params = fdc.command
+			fdc.command = nil
+			fdc.execute()
+		}
+
+	case fdc_phaseWrite:
+		if fdc.dataPos < len(fdc.data) {
+			fdc.data[fdc.dataPos] = b
+			fdc.dataPos++
+		}
+		if fdc.dataPos >= len(fdc.data) {
+			if (fdc.params[0] & 0x1f) == FDC_CMD_FORMAT_TRACK {
+				fdc.formatTrack()
+			} else {
+				fdc.writeSector()
+			}
+		}
+	}
+}
+
+// Returns the drive selected by the second byte of the command
+func (fdc *FDC) selectedDrive() *floppyDrive {
+	return &fdc.drives[fdc.params[1]&0x01]
+}
+
+func (fdc *FDC) ready(drive *floppyDrive) bool {
+	return (drive.disk_orNil != nil) && fdc.motorOn
+}
+
+// Returns the head and unit bits of ST0
+func (fdc *FDC) headUnit() byte {
+	return fdc.params[1] & 0x07
+}
+
+func (fdc *FDC) setResult(result ...byte) {
+	if len(result) == 0 {
+		fdc.phase = fdc_phaseCommand
+		return
+	}
+	fdc.result = result
+	fdc.phase = fdc_phaseResult
+}
+
+// Sets the standard 7-byte result of the read/write commands
+func (fdc *FDC) setRWResult() {
+	fdc.setResult(fdc.st0, fdc.st1, fdc.st2, fdc.c, fdc.h, fdc.r, fdc.n)
+}
+
+func (fdc *FDC) execute() {
+	cmd := fdc.params[0] & 0x1f
+
+	length, ok := fdc_commandLength[cmd]
+	if !ok || (len(fdc.params) != length) {
+		fdc.setResult(FDC_ST0_IC)
+		return
+	}
+
+	switch cmd {
+	case FDC_CMD_SPECIFY:
+		fdc.setResult()
+
+	case FDC_CMD_SENSE_DRIVE_STATUS:
+		drive := fdc.selectedDrive()
+		st3 := fdc.headUnit()
+		if fdc.ready(drive) {
+			st3 |= FDC_ST3_RY
+		}
+		if drive.track == 0 {
+			st3 |= FDC_ST3_T0
+		}
+		if (drive.disk_orNil != nil) && (drive.disk_orNil.Sides == 2) {
+			st3 |= FDC_ST3_TS
+		}
+		if (drive.disk_orNil == nil) || drive.writeProtected {
+			st3 |= FDC_ST3_WP
+		}
+		fdc.setResult(st3)
+
+	case FDC_CMD_RECALIBRATE:
+		fdc.seek(0)
+
+	case FDC_CMD_SEEK:
+		fdc.seek(int(fdc.params[2]))
+
+	case FDC_CMD_SENSE_INTERRUPT:
+		for i := range fdc.drives {
+			drive := &fdc.drives[i]
+			if drive.interrupt {
+				drive.interrupt = false
+				fdc.setResult(drive.seekST0, byte(drive.track))
+				return
+			}
+		}
+		fdc.setResult(FDC_ST0_IC)
+
+	case FDC_CMD_READ_ID:
+		fdc.readID()
+
+	case FDC_CMD_READ_DATA, FDC_CMD_READ_DELETED_DATA, FDC_CMD_READ_TRACK:
+		fdc.startRW()
+		if fdc.phase == fdc_phaseCommand {
+			fdc.readSectors(cmd)
+		}
+
+	case FDC_CMD_WRITE_DATA, FDC_CMD_WRITE_DELETED_DATA:
+		fdc.startRW()
+		if fdc.phase == fdc_phaseCommand {
+			if fdc.selectedDrive().writeProtected {
+				fdc.st0 |= FDC_ST0_AT
+				fdc.st1 |= FDC_ST1_NW
+				fdc.setRWResult()
+				return
+			}
+			fdc.nextWriteSector()
+		}
+
+	case FDC_CMD_FORMAT_TRACK:
+		drive := fdc.selectedDrive()
+		switch {
+		case !fdc.ready(drive):
+			fdc.setResult(FDC_ST0_AT|FDC_ST0_NR|fdc.headUnit(), 0, 0, 0, 0, 0, 0)
+		case drive.writeProtected:
+			fdc.setResult(FDC_ST0_AT|fdc.headUnit(), FDC_ST1_NW, 0, 0, 0, 0, 0)
+		default:
+			// Receive the ID fields (C, H, R, N) of the sectors
+			fdc.data = make([]byte, 4*int(fdc.params[3]))
+			fdc.dataPos = 0
+			if len(fdc.data) == 0 {
+				fdc.formatTrack()
+			} else {
+				fdc.phase = fdc_phaseWrite
+			}
+		}
+	}
+}
+
+func (fdc *FDC) seek(track int) {
+	drive := fdc.selectedDrive()
+	unit := fdc.params[1] & 0x03
+
+	if track > FDC_MAX_TRACK {
+		track = FDC_MAX_TRACK
+	}
+
+	drive.interrupt = true
+	if drive.disk_orNil == nil {
+		drive.seekST0 = FDC_ST0_AT | FDC_ST0_SE | FDC_ST0_NR | unit
+	} else {
+		drive.track = track
+		drive.seekST0 = FDC_ST0_SE | unit
+	}
+	fdc.setResult()
+}
+
+// Returns the track under the head of the selected drive, or nil if the track is not formatted
+func (fdc *FDC) currentTrack() *formats.DSKTrack {
+	drive := fdc.selectedDrive()
+	head := int(fdc.params[1]>>2) & 0x01
+	return drive.disk_orNil.Track(drive.track, head)
+}
+
+func (fdc *FDC) readID() {
+	drive := fdc.selectedDrive()
+	if !fdc.ready(drive) {
+		fdc.setResult(FDC_ST0_AT|FDC_ST0_NR|fdc.headUnit(), 0, 0, 0, 0, 0, 0)
+		return
+	}
+
+	track := fdc.currentTrack()
+	if (track == nil) || (len(track.Sectors) == 0) {
+		fdc.setResult(FDC_ST0_AT|fdc.headUnit(), FDC_ST1_MA, 0, 0, 0, 0, 0)
+		return
+	}
+
+	drive.sectorIndex %= len(track.Sectors)
+	sector := track.Sectors[drive.sectorIndex]
+	drive.sectorIndex++
+
+	fdc.setResult(fdc.headUnit(), 0, 0, sector.C, sector.H, sector.R, sector.N)
+}
+
+// Decodes the parameters of the read/write commands.
+// Sets the result and returns to the command phase if the drive is not ready.
+func (fdc *FDC) startRW() {
+	cmd := fdc.params
+	fdc.multiTrack = (cmd[0] & 0x80) != 0
+	fdc.skipDeleted = (cmd[0] & 0x20) != 0
+	fdc.c, fdc.h, fdc.r, fdc.n = cmd[2], cmd[3], cmd[4], cmd[5]
+	fdc.eot = cmd[6]
+	fdc.st0 = fdc.headUnit()
+	fdc.st1 = 0
+	fdc.st2 = 0
+	fdc.phase = fdc_phaseCommand
+
+	if !fdc.ready(fdc.selectedDrive()) {
+		fdc.st0 |= FDC_ST0_AT | FDC_ST0_NR
+		fdc.setRWResult()
+	}
+}
+
+// Finds the sector with the ID (C, H, R, N) of the command.
+// If the sector does not exist, it sets the error bits and returns nil.
+func (fdc *FDC) findSector() *formats.DSKSector {
+	track := fdc.currentTrack()
+	if (track == nil) || (len(track.Sectors) == 0) {
+		fdc.st0 |= FDC_ST0_AT
+		fdc.st1 |= FDC_ST1_MA
+		return nil
+	}
+
+	for _, sector := range track.Sectors {
+		if (sector.C == fdc.c) && (sector.H == fdc.h) && (sector.R == fdc.r) && (sector.N == fdc.n) {
+			return sector
+		}
+	}
+
+	fdc.st0 |= FDC_ST0_AT
+	fdc.st1 |= FDC_ST1_ND
+	return nil
+}
+
+// Advances to the next sector. Returns false after the last sector (EOT),
+// setting the "end of cylinder" condition.
+func (fdc *FDC) nextSector() bool {
+	if fdc.r != fdc.eot {
+		fdc.r++
+		return true
+	}
+
+	fdc.r = 1
+	if fdc.multiTrack && ((fdc.params[1] & 0x04) == 0) {
+		// Continue on the second side
+		fdc.params[1] |= 0x04
+		fdc.h ^= 1
+		fdc.st0 |= 0x04
+		return true
+	}
+
+	fdc.c++
+	fdc.st0 |= FDC_ST0_AT
+	fdc.st1 |= FDC_ST1_EN
+	return false
+}
+
+// Returns the number of bytes transferred for the sector.
+// If N is 0, DTL specifies the length, up to 128 bytes; DTL=0 is treated as 128.
+func (fdc *FDC) sectorLength() int {
+	if fdc.n == 0 {
+		dtl := int(fdc.params[8])
+		if (dtl == 0) || (dtl > 0x80) {
+			return 0x80
+		}
+		return dtl
+	}
+	return formats.DSKSectorSize(fdc.n)
+}
+
+// Collects the data of the sectors R..EOT, and starts transferring them to the CPU
+func (fdc *FDC) readSectors(cmd byte) {
+	var data []byte
+
+	if cmd == FDC_CMD_READ_TRACK {
+		// The sectors are read in the physical order, regardless of their IDs
+		track := fdc.currentTrack()
+		if (track == nil) || (len(track.Sectors) == 0) {
+			fdc.st0 |= FDC_ST0_AT
+			fdc.st1 |= FDC_ST1_MA
+			fdc.setRWResult()
+			return
+		}
+		for i := 0; i < int(fdc.eot); i++ {
+			sector := track.Sectors[i%len(track.Sectors)]
+			data = append(data, sectorData(sector, fdc.sectorLength())...)
+			fdc.st1 |= sector.ST1 & FDC_ST1_DE
+			fdc.st2 |= sector.ST2 & FDC_ST2_DD
+		}
+		fdc.st0 |= FDC_ST0_AT
+		fdc.st1 |= FDC_ST1_EN
+	} else {
+		for {
+			sector := fdc.findSector()
+			if sector == nil {
+				break
+			}
+
+			// The data of a sector marked as deleted (or not deleted, for READ DELETED DATA)
+			// is transferred only if SK is not set, and the command ends after the sector
+			deleted := (sector.ST2 & FDC_ST2_CM) != 0
+			controlMark := (deleted != (cmd == FDC_CMD_READ_DELETED_DATA))
+			if controlMark {
+				fdc.st2 |= FDC_ST2_CM
+			}
+			if !controlMark || !fdc.skipDeleted {
+				data = append(data, sectorData(sector, fdc.sectorLength())...)
+			}
+
+			// Errors recorded in the image
+			if (sector.ST1&FDC_ST1_DE) != 0 || (sector.ST2&FDC_ST2_DD) != 0 {
+				fdc.st0 |= FDC_ST0_AT
+				fdc.st1 |= sector.ST1 & FDC_ST1_DE
+				fdc.st2 |= sector.ST2 & FDC_ST2_DD
+				break
+			}
+
+			if controlMark && !fdc.skipDeleted {
+				break
+			}
+			if !fdc.nextSector() {
+				break
+			}
+		}
+	}
+
+	if len(data) == 0 {
+		fdc.setRWResult()
+		return
+	}
+
+	fdc.data = data
+	fdc.dataPos = 0
+	fdc.phase = fdc_phaseRead
+	fdc.result = []byte{fdc.st0, fdc.st1, fdc.st2, fdc.c, fdc.h, fdc.r, fdc.n}
+}
+
+// Returns 'length' bytes of the sector data, padded if the sector is shorter
+func sectorData(sector *formats.DSKSector, length int) []byte {
+	data := make([]byte, length)
+	n := copy(data, sector.Data)
+	for i := n; i < length; i++ {
+		data[i] = formats.DSK_FILLER
+	}
+	return data
+}
+
+// Starts receiving the data of the sector R, or ends the command
+func (fdc *FDC) nextWriteSector() {
+	fdc.sector = fdc.findSector()
+	if fdc.sector == nil {
+		fdc.finishWrite()
+		return
+	}
+
+	fdc.data = make([]byte, fdc.sectorLength())
+	fdc.dataPos = 0
+	fdc.phase = fdc_phaseWrite
+}
+
+func (fdc *FDC) writeSector() {
+	copy(fdc.sector.Data, fdc.data)
+	if len(fdc.data) > len(fdc.sector.Data) {
+		fdc.sector.Data = append(fdc.sector.Data, fdc.data[len(fdc.sector.Data):]...)
+	}
+
+	fdc.sector.ST1 &^= FDC_ST1_DE
+	fdc.sector.ST2 &^= FDC_ST2_DD | FDC_ST2_CM
+	if (fdc.params[0] & 0x1f) == FDC_CMD_WRITE_DELETED_DATA {
+		fdc.sector.ST2 |= FDC_ST2_CM
+	}
+
+	if fdc.nextSector() {
+		fdc.nextWriteSector()
+	} else {
+		fdc.finishWrite()
+	}
+}
+
+func (fdc *FDC) finishWrite() {
+	fdc.data = nil
+	fdc.sector = nil
+	fdc.selectedDrive().save(fdc.app)
+	fdc.setRWResult()
+}
+
+func (fdc *FDC) formatTrack() {
+	drive := fdc.selectedDrive()
+	head := int(fdc.params[1]>>2) & 0x01
+	n := fdc.params[2]
+
+	track := &formats.DSKTrack{
+		Track:          byte(drive.track),
+		Side:           byte(head),
+		SectorSizeCode: n,
+		Gap3:           fdc.params[4],
+		Filler:         fdc.params[5],
+	}
+	for i := 0; i+4 <= len(fdc.data); i += 4 {
+		id := fdc.data[i : i+4]
+		data := make([]byte, formats.DSKSectorSize(n))
+		for j := range data {
+			data[j] = fdc.params[5]
+		}
+		track.Sectors = append(track.Sectors, &formats.DSKSector{C: id[0], H: id[1], R: id[2], N: id[3], Data: data})
+	}
+
+	var last [4]byte
+	if len(fdc.data) >= 4 {
+		copy(last[:], fdc.data[len(fdc.data)-4:])
+	}
+	fdc.data = nil
+
+	if err := drive.disk_orNil.SetTrack(drive.track, head, track); err != nil {
+		fdc.setResult(FDC_ST0_AT|fdc.headUnit(), FDC_ST1_ND, 0, last[0], last[1], last[2], last[3])
+		return
+	}
+	drive.save(fdc.app)
+
+	fdc.setResult(fdc.headUnit(), 0, 0, last[0], last[1], last[2], last[3])
+}
+
+// Writes the disk back to its file
+func (drive *floppyDrive) save(app *Application) {
+	if (drive.disk_orNil == nil) || drive.writeProtected {
+		return
+	}
+	err := ioutil.WriteFile(drive.path, drive.disk_orNil.Encode(), 0644)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+	}
+}
+
+func checkDiskDrive(drive uint) error {
+	if drive >= FDC_NUM_DRIVES {
+		return fmt.Errorf("invalid disk drive number %d", drive)
+	}
+	return nil
+}
+
+// Inserts the disk image stored in the specified file into the drive (0 = A:, 1 = B:).
+// If the file does not exist, an unformatted disk is inserted.
+// A file that cannot be written is inserted as a write-protected disk.
+func (fdc *FDC) InsertDisk(drive uint, path string) error {
+	if err := checkDiskDrive(drive); err != nil {
+		return err
+	}
+
+	var dsk *formats.DSK
+	writeProtected := false
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		dsk = formats.NewBlankDSK(40, 1)
+	} else {
+		dsk, err = formats.NewDSK(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			writeProtected = true
+		} else {
+			file.Close()
+		}
+	}
+
+	fdc.mutex.Lock()
+	defer fdc.mutex.Unlock()
+
+	d := &fdc.drives[drive]
+	d.disk_orNil = dsk
+	d.path = path
+	d.writeProtected = writeProtected
+	d.sectorIndex = 0
+	return nil
+}
+
+// Ejects the disk from the drive (0 = A:, 1 = B:)
+func (fdc *FDC) EjectDisk(drive uint) error {
+	if err := checkDiskDrive(drive); err != nil {
+		return err
+	}
+
+	fdc.mutex.Lock()
+	defer fdc.mutex.Unlock()
+
+	d := &fdc.drives[drive]
+	if d.disk_orNil == nil {
+		return fmt.Errorf("drive %c: is empty", 'A'+drive)
+	}
+	d.disk_orNil = nil
+	return nil
+}
+
+func (fdc *FDC) ejectAll() {
+	fdc.mutex.Lock()
+	defer fdc.mutex.Unlock()
+
+	for i := range fdc.drives {
+		fdc.drives[i].disk_orNil = nil
+	}
+}
@@ -0,0 +1,121 @@
+package spectrum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+// Sends a command to the FDC
+func fdcCommand(fdc *FDC, command ...byte) {
+	for _, b := range command {
+		fdc.writeData(b)
+	}
+}
+
+// Reads the result phase of a command
+func fdcResult(fdc *FDC) []byte {
+	var result []byte
+	for fdc.readStatus() == (FDC_MSR_RQM | FDC_MSR_DIO | FDC_MSR_CB) {
+		result = append(result, fdc.readData())
+	}
+	return result
+}
+
+func TestFDCReadWriteSectors(t *testing.T) {
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	dir, err := ioutil.TempDir("", "gospeccy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "disk.dsk")
+
+	// One formatted track, with 9 sectors of 512 bytes
+	dsk := formats.NewBlankDSK(40, 1)
+	track := &formats.DSKTrack{SectorSizeCode: 2, Gap3: 0x52, Filler: formats.DSK_FILLER}
+	for r := byte(1); r <= 9; r++ {
+		data := bytes.Repeat([]byte{formats.DSK_FILLER}, 512)
+		track.Sectors = append(track.Sectors, &formats.DSKSector{C: 0, H: 0, R: r, N: 2, Data: data})
+	}
+	if err := dsk.SetTrack(0, 0, track); err != nil {
+		t.Fatal(err)
+	}
+
+	fdc := newFDC(app)
+	fdc.drives[0] = floppyDrive{disk_orNil: dsk, path: path}
+	fdc.setMotor(true)
+
+	data := make([]byte, 2*512)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	// The transfer ends after the sector EOT, with the "end of cylinder" condition
+	expectedResult := []byte{FDC_ST0_AT, FDC_ST1_EN, 0, 1, 0, 1, 2}
+
+	// WRITE DATA, sectors 2-3
+	fdcCommand(fdc, 0x40|FDC_CMD_WRITE_DATA, 0x00, 0, 0, 2, 2, 3, 0x2a, 0xff)
+	for i, b := range data {
+		if status := fdc.readStatus(); status != (FDC_MSR_RQM | FDC_MSR_EXM | FDC_MSR_CB) {
+			t.Fatalf("write, byte %d: unexpected status 0x%02x", i, status)
+		}
+		fdc.writeData(b)
+	}
+	if result := fdcResult(fdc); !bytes.Equal(result, expectedResult) {
+		t.Errorf("write: expected the result % x, got % x", expectedResult, result)
+	}
+
+	if !bytes.Equal(track.Sector(2).Data, data[:512]) || !bytes.Equal(track.Sector(3).Data, data[512:]) {
+		t.Error("the sectors 2-3 do not contain the written data")
+	}
+	if !bytes.Equal(track.Sector(4).Data, bytes.Repeat([]byte{formats.DSK_FILLER}, 512)) {
+		t.Error("the sector 4 has been modified")
+	}
+
+	// The disk has been written back to its file
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := formats.NewDSK(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved.Track(0, 0).Sector(3).Data, data[512:]) {
+		t.Error("the sector 3 has not been saved")
+	}
+
+	// READ DATA, sectors 2-3
+	fdcCommand(fdc, 0x40|FDC_CMD_READ_DATA, 0x00, 0, 0, 2, 2, 3, 0x2a, 0xff)
+	var read []byte
+	for fdc.readStatus() == (FDC_MSR_RQM | FDC_MSR_DIO | FDC_MSR_EXM | FDC_MSR_CB) {
+		read = append(read, fdc.readData())
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("read %d bytes, which differ from the %d bytes written", len(read), len(data))
+	}
+	if result := fdcResult(fdc); !bytes.Equal(result, expectedResult) {
+		t.Errorf("read: expected the result % x, got % x", expectedResult, result)
+	}
+
+	// A sector which does not exist
+	fdcCommand(fdc, 0x40|FDC_CMD_READ_DATA, 0x00, 0, 0, 10, 2, 10, 0x2a, 0xff)
+	expectedResult = []byte{FDC_ST0_AT, FDC_ST1_ND, 0, 0, 0, 10, 2}
+	if result := fdcResult(fdc); !bytes.Equal(result, expectedResult) {
+		t.Errorf("missing sector: expected the result % x, got % x", expectedResult, result)
+	}
+
+	if status := fdc.readStatus(); status != FDC_MSR_RQM {
+		t.Errorf("expected the FDC to wait for a command, the status is 0x%02x", status)
+	}
+}
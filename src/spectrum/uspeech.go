@@ -0,0 +1,276 @@
+package spectrum
+
+import (
+	"errors"
+	"io/ioutil"
+)
+
+// The Currah µSpeech.
+//
+// The µSpeech has a 2K ROM and an SP0256-AL2 speech synthesiser. Reading from address 0x0038
+// turns the µSpeech on or off (fetching the opcode at 0x0038, as the IM 1 interrupt does,
+// has no effect). While the µSpeech is on:
+//
+//   - its ROM replaces the first 2K of the system ROM (if the ROM is available)
+//   - writing to 0x1000 sends an allophone (bits 0-5) to the synthesiser
+//   - reading from 0x1000 returns bit 0 = 1 while the synthesiser cannot accept an allophone
+//   - writing to 0x3000 selects the low intonation, writing to 0x3001 the high intonation
+//
+// The synthesiser buffers one allophone: it cannot accept another allophone
+// until it starts speaking the buffered one.
+const USPEECH_ROM_SIZE = 0x800
+
+type USpeech struct {
+	rom_orNil []byte
+	speccy    *Spectrum48k
+
+	// Whether the µSpeech is on
+	active bool
+
+	// Set while the Z80 fetches the opcode at 0x0038
+	fetching bool
+
+	highIntonation bool
+
+	// The samples of the allophones, at the low and the high intonation
+	lowSamples  [][]int16
+	highSamples [][]int16
+
+	// The allophones being spoken or waiting to be spoken, in order
+	queue []spokenAllophone
+
+	// The output level at the end of the last frame
+	level int16
+}
+
+type spokenAllophone struct {
+	start   int64 // The time (T-states since the last reset) when the synthesiser starts speaking the allophone
+	samples []int16
+}
+
+func (a *spokenAllophone) end() int64 {
+	return a.start + int64(len(a.samples))*allophone_sampleTime
+}
+
+// Reads the µSpeech ROM from the specified file
+func ReadUSpeechROM(path string) ([]byte, error) {
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rom) != USPEECH_ROM_SIZE {
+		return nil, errors.New(path + ": invalid µSpeech ROM file")
+	}
+	return rom, nil
+}
+
+// Creates a µSpeech with the specified ROM. Without the ROM (nil),
+// only the programs driving the synthesiser directly can speak.
+func NewUSpeech(rom_orNil []byte) (*USpeech, error) {
+	if (rom_orNil != nil) && (len(rom_orNil) != USPEECH_ROM_SIZE) {
+		return nil, errors.New("invalid µSpeech ROM size")
+	}
+
+	us := &USpeech{
+		rom_orNil:   rom_orNil,
+		lowSamples:  synthesiseAllophones(allophone_lowPitch),
+		highSamples: synthesiseAllophones(allophone_highPitch),
+	}
+	return us, nil
+}
+
+func (us *USpeech) Name() string {
+	return "uspeech"
+}
+
+func (us *USpeech) attach(speccy *Spectrum48k) error {
+	if speccy.Memory.mappedDevice_orNil != nil {
+		return errors.New("the µSpeech cannot be connected together with another memory-mapped device")
+	}
+
+	us.speccy = speccy
+	us.active = false
+	us.queue = nil
+
+	speccy.addFetchTrap(us, 0x0038, us.beforeFetch, us.afterFetch)
+	speccy.Memory.mappedDevice_orNil = us
+
+	return nil
+}
+
+func (us *USpeech) detach() {
+	us.speccy.Memory.mappedDevice_orNil = nil
+}
+
+func (us *USpeech) reset() {
+	us.active = false
+	us.fetching = false
+	us.highIntonation = false
+	us.queue = nil
+}
+
+func (us *USpeech) beforeFetch(pc uint16) {
+	us.fetching = true
+}
+
+func (us *USpeech) afterFetch(pc uint16) {
+	us.fetching = false
+}
+
+func (us *USpeech) readMemory(address uint16, b byte) byte {
+	switch {
+	case address == 0x0038:
+		if us.fetching {
+			us.fetching = false
+		} else {
+			us.active = !us.active
+		}
+
+	case !us.active:
+		return b
+
+	case address == 0x1000:
+		b &= 0xfe
+		if us.busy() {
+			b |= 0x01
+		}
+		return b
+	}
+
+	if us.active && (us.rom_orNil != nil) && (address < USPEECH_ROM_SIZE) {
+		return us.rom_orNil[address]
+	}
+	return b
+}
+
+func (us *USpeech) writeMemory(address uint16, b byte) {
+	if !us.active {
+		return
+	}
+
+	switch address {
+	case 0x1000:
+		us.speak(b & 0x3f)
+	case 0x3000:
+		us.highIntonation = false
+	case 0x3001:
+		us.highIntonation = true
+	}
+}
+
+// Returns true if the synthesiser has not yet started speaking the last allophone
+func (us *USpeech) busy() bool {
+	n := len(us.queue)
+	return (n > 0) && (us.queue[n-1].start > us.speccy.time())
+}
+
+func (us *USpeech) speak(code byte) {
+	if us.busy() {
+		return
+	}
+
+	samples := us.lowSamples[code]
+	if us.highIntonation {
+		samples = us.highSamples[code]
+	}
+
+	start := us.speccy.time()
+	if n := len(us.queue); (n > 0) && (us.queue[n-1].end() > start) {
+		start = us.queue[n-1].end()
+	}
+	us.queue = append(us.queue, spokenAllophone{start, samples})
+}
+
+type uspeech_state struct {
+	active         bool
+	highIntonation bool
+}
+
+func (us *USpeech) saveState() interface{} {
+	return uspeech_state{us.active, us.highIntonation}
+}
+
+// The allophones being spoken are not restored
+func (us *USpeech) restoreState(state interface{}) {
+	s := state.(uspeech_state)
+	us.active = s.active
+	us.highIntonation = s.highIntonation
+	us.queue = nil
+}
+
+func (us *USpeech) rewindRAM() [][]byte {
+	return nil
+}
+
+func (us *USpeech) encodeState(state interface{}) []byte {
+	s := state.(uspeech_state)
+	return encodeFlags(s.active, s.highIntonation)
+}
+
+func (us *USpeech) decodeState(data []byte) (interface{}, error) {
+	flags, err := decodeFlags(data, 2)
+	if err != nil {
+		return nil, err
+	}
+	return uspeech_state{flags[0], flags[1]}, nil
+}
+
+func (us *USpeech) readPort(address uint16) (byte, bool) {
+	return 0, false
+}
+
+func (us *USpeech) writePort(address uint16, b byte) {
+}
+
+func (us *USpeech) frameSound() []SampleEvent {
+	tstatesPerFrame := us.speccy.timing.TStatesPerFrame
+	frameStart := int64(us.speccy.ula.frame) * int64(tstatesPerFrame)
+	frameEnd := frameStart + int64(tstatesPerFrame)
+
+	events := []SampleEvent{{0, us.level}}
+	add := func(time int64, level int16) {
+		if level == us.level {
+			return
+		}
+		tstate := int(time - frameStart)
+		if n := len(events); events[n-1].TState == tstate {
+			events[n-1].Level = level
+		} else {
+			events = append(events, SampleEvent{tstate, level})
+		}
+		us.level = level
+	}
+
+	var remaining []spokenAllophone
+	for _, a := range us.queue {
+		if a.start >= frameEnd {
+			remaining = append(remaining, a)
+			continue
+		}
+
+		// The first sample starting in this frame
+		i := 0
+		if a.start < frameStart {
+			i = int((frameStart - a.start + allophone_sampleTime - 1) / allophone_sampleTime)
+		}
+		for ; i < len(a.samples); i++ {
+			time := a.start + int64(i)*allophone_sampleTime
+			if time >= frameEnd {
+				break
+			}
+			add(time, a.samples[i])
+		}
+
+		if a.end() < frameEnd {
+			add(a.end(), 0)
+		} else {
+			remaining = append(remaining, a)
+		}
+	}
+	us.queue = remaining
+
+	if (len(events) == 1) && (us.level == 0) {
+		return nil
+	}
+	return append(events, SampleEvent{tstatesPerFrame, us.level})
+}
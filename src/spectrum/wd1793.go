@@ -0,0 +1,584 @@
+package spectrum
+
+import (
+	"fmt"
+	"github.com/remogatto/gospeccy/src/formats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The WD1793 floppy disk controller of the Beta 128 interface.
+//
+// Port 0x1F: command (write) and status (read), port 0x3F: track register,
+// port 0x5F: sector register, port 0x7F: data register.
+// Port 0xFF is the system register of the interface. Write: bits 0-1 select the drive,
+// bit 2 = 0 resets the controller, bit 4 selects the side (0 = side 1).
+// Read: bit 7 = INTRQ, bit 6 = DRQ.
+//
+// The commands complete immediately: the data register is always ready to transfer
+// the next byte, and seeks do not take any time. Only the index pulse follows the
+// rotation of the disk (5 revolutions per second), because TR-DOS uses it to detect the disk.
+const (
+	BETA_NUM_DRIVES = 4
+
+	// Status register
+	WD_STATUS_BUSY          = 0x01
+	WD_STATUS_INDEX         = 0x02 // Type I commands
+	WD_STATUS_DRQ           = 0x02 // Type II and III commands
+	WD_STATUS_TRACK0        = 0x04 // Type I commands
+	WD_STATUS_LOST_DATA     = 0x04 // Type II and III commands
+	WD_STATUS_CRC_ERROR     = 0x08
+	WD_STATUS_SEEK_ERROR    = 0x10 // Type I commands
+	WD_STATUS_RNF           = 0x10 // Record not found
+	WD_STATUS_HEAD_LOADED   = 0x20 // Type I commands
+	WD_STATUS_WRITE_PROTECT = 0x40
+	WD_STATUS_NOT_READY     = 0x80
+
+	WD_MAX_TRACK = 85
+
+	wd_trackLength     = 6250   // Bytes transferred by READ TRACK and WRITE TRACK
+	wd_revolutionTime  = 700000 // T-states per revolution of the disk
+	wd_indexPulseTime  = 14000  // T-states
+	wd_transferNone    = 0
+	wd_transferRead    = 1
+	wd_transferWrite   = 2
+	wd_transferFormat  = 3
+	wd_idAddressMark   = 0xfe
+	wd_dataAddressMark = 0xfb
+	wd_syncMark        = 0xf5 // Written as 0xA1 with a missing clock
+)
+
+type betaDrive struct {
+	disk_orNil     *formats.TRD
+	path           string
+	writeProtected bool
+
+	// The disk was converted from an SCL archive, it is not written back to its file
+	converted bool
+
+	// The track under the head
+	track int
+
+	// The index of the next sector passing under the head (READ ADDRESS)
+	sectorIndex int
+}
+
+type wd1793 struct {
+	speccy *Spectrum48k
+
+	// Protects the drives, which can be accessed from any goroutine by the exported methods
+	mutex sync.Mutex
+
+	drives [BETA_NUM_DRIVES]betaDrive
+	drive  int // The selected drive
+	side   int
+
+	status  byte
+	track   byte
+	sector  byte
+	data    byte
+	typeI   bool // The status register reports the status of a type I command
+	stepOut bool // The direction of the last step
+
+	intrq bool
+	drq   bool
+
+	// The data being transferred by a type II or III command
+	transfer  int
+	multiple  bool
+	buffer    []byte
+	bufferPos int
+}
+
+func (wd *wd1793) reset() {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+	wd.resetController()
+}
+
+func (wd *wd1793) resetController() {
+	wd.status = 0
+	wd.track = 0
+	wd.sector = 1
+	wd.data = 0
+	wd.typeI = true
+	wd.intrq = false
+	wd.drq = false
+	wd.transfer = wd_transferNone
+	wd.buffer = nil
+}
+
+func (wd *wd1793) selectedDrive() *betaDrive {
+	return &wd.drives[wd.drive]
+}
+
+// Returns true while the index hole of the disk in the selected drive passes the sensor
+func (wd *wd1793) indexPulse() bool {
+	if wd.selectedDrive().disk_orNil == nil {
+		return false
+	}
+	return (wd.speccy.time() % wd_revolutionTime) < wd_indexPulseTime
+}
+
+func (wd *wd1793) readStatus() byte {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	status := wd.status
+	if wd.typeI {
+		drive := wd.selectedDrive()
+		status |= WD_STATUS_HEAD_LOADED
+		if drive.disk_orNil == nil {
+			status |= WD_STATUS_NOT_READY
+		} else if drive.writeProtected {
+			status |= WD_STATUS_WRITE_PROTECT
+		}
+		if drive.track == 0 {
+			status |= WD_STATUS_TRACK0
+		}
+		if wd.indexPulse() {
+			status |= WD_STATUS_INDEX
+		}
+	}
+
+	wd.intrq = false
+	return status
+}
+
+func (wd *wd1793) readTrackReg() byte {
+	return wd.track
+}
+
+func (wd *wd1793) writeTrackReg(b byte) {
+	if (wd.status & WD_STATUS_BUSY) == 0 {
+		wd.track = b
+	}
+}
+
+func (wd *wd1793) readSectorReg() byte {
+	return wd.sector
+}
+
+func (wd *wd1793) writeSectorReg(b byte) {
+	if (wd.status & WD_STATUS_BUSY) == 0 {
+		wd.sector = b
+	}
+}
+
+// Returns the value of the system register
+func (wd *wd1793) readSystem() byte {
+	b := byte(0x3f)
+	if wd.intrq {
+		b |= 0x80
+	}
+	if wd.drq {
+		b |= 0x40
+	}
+	return b
+}
+
+func (wd *wd1793) writeSystem(b byte) {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	wd.drive = int(b & 0x03)
+	if (b & 0x10) == 0 {
+		wd.side = 1
+	} else {
+		wd.side = 0
+	}
+	if (b & 0x04) == 0 {
+		wd.resetController()
+	}
+}
+
+func (wd *wd1793) readData() byte {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	if (wd.transfer == wd_transferRead) && wd.drq {
+		wd.data = wd.buffer[wd.bufferPos]
+		wd.bufferPos++
+		if wd.bufferPos == len(wd.buffer) {
+			wd.drq = false
+			wd.status &^= WD_STATUS_DRQ
+			if wd.multiple {
+				wd.sector++
+				wd.readSectors()
+			} else {
+				wd.finish(0)
+			}
+		}
+	}
+	return wd.data
+}
+
+func (wd *wd1793) writeData(b byte) {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	wd.data = b
+	if ((wd.transfer == wd_transferWrite) || (wd.transfer == wd_transferFormat)) && wd.drq {
+		wd.buffer[wd.bufferPos] = b
+		wd.bufferPos++
+		if wd.bufferPos == len(wd.buffer) {
+			wd.drq = false
+			wd.status &^= WD_STATUS_DRQ
+			if wd.transfer == wd_transferWrite {
+				wd.finishWriteSector()
+			} else {
+				wd.finishFormat()
+			}
+		}
+	}
+}
+
+// Ends the current command, and requests an interrupt
+func (wd *wd1793) finish(status byte) {
+	wd.status = status
+	wd.transfer = wd_transferNone
+	wd.buffer = nil
+	wd.drq = false
+	wd.intrq = true
+}
+
+// Starts transferring the buffer
+func (wd *wd1793) startTransfer(transfer int, buffer []byte) {
+	wd.transfer = transfer
+	wd.buffer = buffer
+	wd.bufferPos = 0
+	wd.drq = true
+	wd.status = WD_STATUS_BUSY | WD_STATUS_DRQ
+}
+
+func (wd *wd1793) writeCommand(cmd byte) {
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	// FORCE INTERRUPT
+	if (cmd & 0xf0) == 0xd0 {
+		if (wd.status & WD_STATUS_BUSY) == 0 {
+			wd.typeI = true
+		}
+		wd.status &^= WD_STATUS_BUSY | WD_STATUS_DRQ
+		wd.transfer = wd_transferNone
+		wd.buffer = nil
+		wd.drq = false
+		wd.intrq = ((cmd & 0x0f) != 0)
+		return
+	}
+
+	if (wd.status & WD_STATUS_BUSY) != 0 {
+		return
+	}
+	wd.intrq = false
+
+	if (cmd & 0x80) == 0 {
+		wd.typeI = true
+		wd.seek(cmd)
+		return
+	}
+
+	wd.typeI = false
+	drive := wd.selectedDrive()
+	if drive.disk_orNil == nil {
+		wd.finish(WD_STATUS_NOT_READY)
+		return
+	}
+
+	switch cmd & 0xe0 {
+	case 0x80: // READ SECTOR
+		wd.multiple = ((cmd & 0x10) != 0)
+		wd.readSectors()
+
+	case 0xa0: // WRITE SECTOR
+		if drive.writeProtected {
+			wd.finish(WD_STATUS_WRITE_PROTECT)
+			return
+		}
+		wd.multiple = ((cmd & 0x10) != 0)
+		wd.writeSectors()
+
+	case 0xc0: // READ ADDRESS
+		wd.readAddress()
+
+	case 0xe0:
+		if (cmd & 0x10) == 0 {
+			// READ TRACK
+			wd.startTransfer(wd_transferRead, wd.encodeTrack())
+			wd.multiple = false
+		} else {
+			// WRITE TRACK
+			if drive.writeProtected {
+				wd.finish(WD_STATUS_WRITE_PROTECT)
+				return
+			}
+			wd.startTransfer(wd_transferFormat, make([]byte, wd_trackLength))
+		}
+	}
+}
+
+// Executes a type I command: RESTORE, SEEK, STEP, STEP IN or STEP OUT
+func (wd *wd1793) seek(cmd byte) {
+	drive := wd.selectedDrive()
+
+	step := func(delta int) {
+		drive.track += delta
+		if drive.track < 0 {
+			drive.track = 0
+		}
+		if drive.track > WD_MAX_TRACK {
+			drive.track = WD_MAX_TRACK
+		}
+	}
+
+	switch cmd & 0xf0 {
+	case 0x00: // RESTORE
+		drive.track = 0
+		wd.track = 0
+
+	case 0x10: // SEEK
+		step(int(wd.data) - int(wd.track))
+		wd.track = wd.data
+
+	default:
+		switch cmd & 0xe0 {
+		case 0x40:
+			wd.stepOut = false
+		case 0x60:
+			wd.stepOut = true
+		}
+
+		delta := 1
+		if wd.stepOut {
+			delta = -1
+		}
+		step(delta)
+
+		// Update the track register
+		if (cmd & 0x10) != 0 {
+			wd.track = byte(int(wd.track) + delta)
+		}
+	}
+
+	var status byte = 0
+
+	// Verify the track number
+	if (cmd & 0x04) != 0 {
+		if (drive.disk_orNil == nil) || (drive.track >= drive.disk_orNil.Tracks) || (int(wd.track) != drive.track) {
+			status |= WD_STATUS_SEEK_ERROR
+		}
+	}
+
+	wd.finish(status)
+}
+
+// Returns the sector addressed by the track and sector registers, or nil
+func (wd *wd1793) findSector() []byte {
+	drive := wd.selectedDrive()
+	if (int(wd.track) != drive.track) || (wd.sector < 1) {
+		return nil
+	}
+	return drive.disk_orNil.Sector(drive.track, wd.side, int(wd.sector)-1)
+}
+
+func (wd *wd1793) readSectors() {
+	sector := wd.findSector()
+	if sector == nil {
+		wd.finish(WD_STATUS_RNF)
+		return
+	}
+	buffer := make([]byte, len(sector))
+	copy(buffer, sector)
+	wd.startTransfer(wd_transferRead, buffer)
+}
+
+func (wd *wd1793) writeSectors() {
+	if wd.findSector() == nil {
+		wd.finish(WD_STATUS_RNF)
+		return
+	}
+	wd.startTransfer(wd_transferWrite, make([]byte, formats.TRD_SECTOR_SIZE))
+}
+
+func (wd *wd1793) finishWriteSector() {
+	copy(wd.findSector(), wd.buffer)
+	wd.selectedDrive().save(wd.speccy.app)
+
+	if wd.multiple {
+		wd.sector++
+		wd.writeSectors()
+	} else {
+		wd.finish(0)
+	}
+}
+
+func (wd *wd1793) readAddress() {
+	drive := wd.selectedDrive()
+	if (drive.track >= drive.disk_orNil.Tracks) || (wd.side >= drive.disk_orNil.Sides) {
+		wd.finish(WD_STATUS_RNF)
+		return
+	}
+
+	r := byte(drive.sectorIndex + 1)
+	drive.sectorIndex = (drive.sectorIndex + 1) % formats.TRD_SECTORS_PER_TRACK
+
+	// The track address is copied to the sector register
+	wd.sector = byte(drive.track)
+
+	wd.startTransfer(wd_transferRead, []byte{byte(drive.track), byte(wd.side), r, 1, 0, 0})
+	wd.multiple = false
+}
+
+// Returns the raw contents of the current track, as written by TR-DOS when formatting a disk
+func (wd *wd1793) encodeTrack() []byte {
+	drive := wd.selectedDrive()
+	track := make([]byte, 0, wd_trackLength)
+
+	gap := func(n int, b byte) {
+		for i := 0; i < n; i++ {
+			track = append(track, b)
+		}
+	}
+
+	gap(80, 0x4e)
+	for r := 1; r <= formats.TRD_SECTORS_PER_TRACK; r++ {
+		sector := drive.disk_orNil.Sector(drive.track, wd.side, r-1)
+		if sector == nil {
+			break
+		}
+		gap(12, 0x00)
+		gap(3, wd_syncMark)
+		track = append(track, wd_idAddressMark, byte(drive.track), byte(wd.side), byte(r), 1, 0xf7)
+		gap(22, 0x4e)
+		gap(12, 0x00)
+		gap(3, wd_syncMark)
+		track = append(track, wd_dataAddressMark)
+		track = append(track, sector...)
+		track = append(track, 0xf7)
+		gap(54, 0x4e)
+	}
+	gap(wd_trackLength-len(track), 0x4e)
+
+	return track
+}
+
+// Stores the sectors found in the data written by WRITE TRACK
+func (wd *wd1793) finishFormat() {
+	drive := wd.selectedDrive()
+	track := wd.buffer
+
+	r, n := -1, -1
+	for i := 1; i < len(track); i++ {
+		if track[i-1] != wd_syncMark {
+			continue
+		}
+
+		switch track[i] {
+		case wd_idAddressMark:
+			if i+4 < len(track) {
+				r, n = int(track[i+3]), int(track[i+4])
+			}
+
+		case wd_dataAddressMark:
+			length := 128 << uint(n&0x03)
+			if (r >= 1) && (n == 1) && (i+length < len(track)) {
+				sector := drive.disk_orNil.Sector(drive.track, wd.side, r-1)
+				if sector != nil {
+					copy(sector, track[i+1:i+1+length])
+				}
+			}
+			r, n = -1, -1
+		}
+	}
+
+	drive.save(wd.speccy.app)
+	wd.finish(0)
+}
+
+// Writes the disk back to its file
+func (drive *betaDrive) save(app *Application) {
+	if (drive.disk_orNil == nil) || drive.writeProtected || drive.converted {
+		return
+	}
+	err := ioutil.WriteFile(drive.path, drive.disk_orNil.Encode(), 0644)
+	if err != nil {
+		app.PrintfMsg("%s", err)
+	}
+}
+
+func checkBetaDrive(drive uint) error {
+	if drive >= BETA_NUM_DRIVES {
+		return fmt.Errorf("invalid disk drive number %d", drive)
+	}
+	return nil
+}
+
+func (wd *wd1793) insertDisk(drive uint, path string) error {
+	if err := checkBetaDrive(drive); err != nil {
+		return err
+	}
+
+	var trd *formats.TRD
+	writeProtected := false
+	converted := false
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case (err != nil) && os.IsNotExist(err) && (strings.ToLower(filepath.Ext(path)) != ".scl"):
+		trd = formats.NewBlankTRD()
+
+	case err != nil:
+		return err
+
+	case strings.ToLower(filepath.Ext(path)) == ".scl":
+		trd, err = formats.NewTRDFromSCL(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		converted = true
+
+	default:
+		trd, err = formats.NewTRD(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			writeProtected = true
+		} else {
+			file.Close()
+		}
+	}
+
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	d := &wd.drives[drive]
+	d.disk_orNil = trd
+	d.path = path
+	d.writeProtected = writeProtected
+	d.converted = converted
+	d.sectorIndex = 0
+	return nil
+}
+
+func (wd *wd1793) ejectDisk(drive uint) error {
+	if err := checkBetaDrive(drive); err != nil {
+		return err
+	}
+
+	wd.mutex.Lock()
+	defer wd.mutex.Unlock()
+
+	d := &wd.drives[drive]
+	if d.disk_orNil == nil {
+		return fmt.Errorf("drive %c: is empty", 'A'+drive)
+	}
+	d.disk_orNil = nil
+	return nil
+}
@@ -0,0 +1,146 @@
+package spectrum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/remogatto/gospeccy/src/formats"
+)
+
+func TestWD1793ReadWriteSectors(t *testing.T) {
+	rom, err := ReadROM("../../roms/48.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication()
+	defer func() {
+		app.RequestExit()
+		<-app.HasTerminated
+	}()
+
+	dir, err := ioutil.TempDir("", "gospeccy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "disk.trd")
+
+	trd := formats.NewBlankTRD()
+	wd := &wd1793{speccy: NewSpectrum48k(app, *rom)}
+	wd.resetController()
+	wd.drives[0] = betaDrive{disk_orNil: trd, path: path}
+
+	// Selects the drive 0 and the side; bit 2 keeps the controller running
+	selectSide := func(side int) {
+		if side == 0 {
+			wd.writeSystem(0x14)
+		} else {
+			wd.writeSystem(0x04)
+		}
+	}
+
+	// SEEK to track 2, verifying the track
+	selectSide(0)
+	wd.writeData(2)
+	wd.writeCommand(0x14)
+	if status := wd.readStatus(); (status & (WD_STATUS_BUSY | WD_STATUS_SEEK_ERROR)) != 0 {
+		t.Fatalf("seek: unexpected status 0x%02x", status)
+	}
+	if (wd.readTrackReg() != 2) || (wd.drives[0].track != 2) {
+		t.Fatalf("seek: expected track 2, the register is %d, the head is on %d", wd.readTrackReg(), wd.drives[0].track)
+	}
+
+	sectorData := func(side int) []byte {
+		data := make([]byte, formats.TRD_SECTOR_SIZE)
+		for i := range data {
+			data[i] = byte(i*3 + side)
+		}
+		return data
+	}
+
+	// WRITE SECTOR 3 on both sides
+	for side := 0; side < 2; side++ {
+		selectSide(side)
+		wd.writeSectorReg(3)
+		wd.writeCommand(0xa0)
+		for i, b := range sectorData(side) {
+			if (wd.readSystem() & 0x40) == 0 {
+				t.Fatalf("write, side %d, byte %d: DRQ is not set", side, i)
+			}
+			wd.writeData(b)
+		}
+		if (wd.readSystem() & 0xc0) != 0x80 {
+			t.Errorf("write, side %d: expected an interrupt at the end of the command", side)
+		}
+		if status := wd.readStatus(); status != 0 {
+			t.Errorf("write, side %d: unexpected status 0x%02x", side, status)
+		}
+
+		if !bytes.Equal(trd.Sector(2, side, 2), sectorData(side)) {
+			t.Errorf("write, side %d: the sector does not contain the written data", side)
+		}
+	}
+	if !bytes.Equal(trd.Sector(2, 0, 3), make([]byte, formats.TRD_SECTOR_SIZE)) {
+		t.Error("the sector 4 has been modified")
+	}
+
+	// The disk has been written back to its file
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(file, trd.Data) {
+		t.Error("the disk has not been saved")
+	}
+
+	// READ SECTOR 3 on both sides
+	for side := 0; side < 2; side++ {
+		selectSide(side)
+		wd.writeSectorReg(3)
+		wd.writeCommand(0x80)
+		var read []byte
+		for (wd.readSystem() & 0x40) != 0 {
+			read = append(read, wd.readData())
+		}
+		if !bytes.Equal(read, sectorData(side)) {
+			t.Errorf("read, side %d: the data differs from the data written", side)
+		}
+		if status := wd.readStatus(); status != 0 {
+			t.Errorf("read, side %d: unexpected status 0x%02x", side, status)
+		}
+	}
+
+	// READ SECTOR with the multiple flag reads until the end of the track
+	selectSide(0)
+	wd.writeSectorReg(15)
+	wd.writeCommand(0x90)
+	n := 0
+	for (wd.readSystem() & 0x40) != 0 {
+		wd.readData()
+		n++
+	}
+	if n != 2*formats.TRD_SECTOR_SIZE {
+		t.Errorf("multiple read: expected the sectors 15-16, read %d bytes", n)
+	}
+	if status := wd.readStatus(); status != WD_STATUS_RNF {
+		t.Errorf("multiple read: expected the status 0x%02x, got 0x%02x", WD_STATUS_RNF, status)
+	}
+
+	// A sector which does not exist, and a track register which does not match the head
+	for _, test := range []struct{ track, sector byte }{{2, 17}, {2, 0}, {5, 1}} {
+		wd.writeTrackReg(test.track)
+		wd.writeSectorReg(test.sector)
+		wd.writeCommand(0x80)
+		if (wd.readSystem() & 0xc0) != 0x80 {
+			t.Errorf("track %d, sector %d: expected the command to end", test.track, test.sector)
+		}
+		if status := wd.readStatus(); status != WD_STATUS_RNF {
+			t.Errorf("track %d, sector %d: expected the status 0x%02x, got 0x%02x",
+				test.track, test.sector, WD_STATUS_RNF, status)
+		}
+	}
+}
@@ -54,6 +54,15 @@ func (t *testSuite) Should_respond_to_keypress_sequence() {
 	t.True(screenEqualTo("testdata/key_press_sequence_1_ok.sna"))
 }
 
+func (t *testSuite) Should_type_text() {
+	// The same keys as in 'Should_respond_to_keypress_sequence'
+	n, done := speccy.Keyboard.TypeText("PRINT \"hello\"\n", true /*basic*/)
+	for i := 0; i < n; i++ {
+		<-done
+	}
+	t.True(screenEqualTo("testdata/key_press_sequence_1_ok.sna"))
+}
+
 // // Tapedrive
 
 func (t *testSuite) Should_load_tapes_using_ROM_routine() {
@@ -149,6 +158,58 @@ func (t *testSuite) Should_support_TAP_format() {
 	t.True(screenEqualTo("testdata/hello_tape_loaded.sna"))
 }
 
+// Interrupts
+
+// On the Pentagon, a program which enables the interrupts shortly after the beginning
+// of the frame still gets the interrupt.
+func (t *testSuite) Should_accept_interrupt_enabled_during_interrupt_window() {
+	rom, err := spectrum.ReadROM("testdata/48.rom")
+	t.Nil(err)
+	t.Nil(speccy.SetModel(spectrum.MODEL_PENTAGON, [][]byte{rom[:], rom[:]}))
+	defer speccy.SetModel(spectrum.MODEL_48K, [][]byte{rom[:]})
+
+	const marker = 0x8100
+
+	var s formats.FullSnapshot
+	s.Cpu.PC = 0x8000
+	s.Cpu.SP = 0xff00
+	s.Cpu.I = 0x90
+	s.Cpu.IM = 2
+	s.Cpu.IFF1, s.Cpu.IFF2 = 0, 0
+	s.Cpu.Tstate = uint(spectrum.TimingPentagon.TStatesPerFrame - 10*4)
+
+	code := s.Mem[0x8000-0x4000:]
+	for i := 0; i < 10; i++ {
+		code[i] = 0x00 // NOP (until the end of the frame)
+	}
+	copy(code[10:], []byte{
+		0xfb,       // EI
+		0x00,       // NOP (the interrupt is accepted here)
+		0xf3,       // DI
+		0x18, 0xfe, // JR $
+	})
+
+	// The interrupt vector table and the handler, which sets the marker
+	for i := 0x9000; i <= 0x9100; i++ {
+		s.Mem[i-0x4000] = 0x92
+	}
+	copy(s.Mem[0x9292-0x4000:], []byte{
+		0x3e, 0x01, // LD A,1
+		0x32, marker & 0xff, marker >> 8, // LD (marker),A
+		0x18, 0xfe, // JR $
+	})
+
+	errChan := make(chan error)
+	speccy.CommandChannel <- spectrum.Cmd_Load{"late_interrupt", &s, errChan}
+	t.Nil(<-errChan)
+
+	time.Sleep(200 * time.Millisecond)
+
+	snapshot := make(chan *formats.FullSnapshot)
+	speccy.CommandChannel <- spectrum.Cmd_MakeSnapshot{snapshot}
+	t.Equal(byte(1), (<-snapshot).Mem[marker-0x4000])
+}
+
 func TestEmulator(t *testing.T) {
 	prettytest.RunWithFormatter(
 		t,